@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/jchv/zanbato/kaitai/bundle"
+	"github.com/jchv/zanbato/kaitai/ksy"
+	"github.com/jchv/zanbato/kaitai/resolve"
+)
+
+func main() {
+	out := flag.String("o", "", "Output file (default stdout)")
+	importPaths := resolve.RegisterImportPathsFlag(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalln("Wrong number of arguments; pass your root .ksy path.")
+	}
+	resolver, err := resolve.NewImportPathsResolver(*importPaths)
+	if err != nil {
+		log.Fatalf("error opening import paths: %v", err)
+	}
+	spec, err := bundle.Bundle(resolver, flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error bundling %s: %v", flag.Arg(0), err)
+	}
+	data, err := ksy.Marshal(spec)
+	if err != nil {
+		log.Fatalf("Error encoding bundle: %v", err)
+	}
+	if *out == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatalf("Error writing %s: %v", *out, err)
+	}
+}
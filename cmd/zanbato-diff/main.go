@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jchv/zanbato/kaitai/diff"
+	"github.com/jchv/zanbato/kaitai/resolve"
+)
+
+// changeJSON is the JSON representation of a diff.Change.
+type changeJSON struct {
+	Impact  string   `json:"impact"`
+	Path    string   `json:"path"`
+	Message string   `json:"message"`
+	Old     string   `json:"old,omitempty"`
+	New     string   `json:"new,omitempty"`
+	Go      []string `json:"go,omitempty"`
+	C       []string `json:"c,omitempty"`
+}
+
+func identStrings(idents []diff.IdentChange) []string {
+	var result []string
+	for _, i := range idents {
+		result = append(result, i.String())
+	}
+	return result
+}
+
+func changeToJSON(c diff.Change) changeJSON {
+	result := changeJSON{
+		Impact:  c.Impact.String(),
+		Path:    c.Path,
+		Message: c.Message,
+		Go:      identStrings(c.Go),
+		C:       identStrings(c.C),
+	}
+	if c.Old.IsValid() {
+		result.Old = c.Old.String()
+	}
+	if c.New.IsValid() {
+		result.New = c.New.String()
+	}
+	return result
+}
+
+func main() {
+	importPaths := resolve.RegisterImportPathsFlag(flag.CommandLine)
+	jsonOutput := flag.Bool("json", false, "Print changes as JSON")
+	failOn := flag.String("fail-on", "api", "Exit with status 1 on changes at least this severe: wire, api or none")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalln("Wrong number of arguments; pass the old and new root .ksy paths.")
+	}
+
+	var threshold diff.Impact
+	switch *failOn {
+	case "wire":
+		threshold = diff.WireBreaking
+	case "api":
+		threshold = diff.APIBreaking
+	case "none":
+		threshold = diff.WireBreaking + 1
+	default:
+		log.Fatalf("Invalid -fail-on value %q", *failOn)
+	}
+
+	resolver, err := resolve.NewImportPathsResolver(*importPaths)
+	if err != nil {
+		log.Fatalf("error opening import paths: %v", err)
+	}
+	var graphs [2]*resolve.Graph
+	for i, name := range flag.Args() {
+		graph, err := resolve.LoadGraph(resolver, name)
+		if err != nil {
+			log.Fatalf("Error resolving %s: %v", name, err)
+		}
+		if err := graph.Err(); err != nil {
+			log.Fatalf("Error resolving imports of %s: %v", name, err)
+		}
+		graphs[i] = graph
+	}
+
+	changes := diff.Diff(graphs[0], graphs[1])
+	if *jsonOutput {
+		out := make([]changeJSON, 0, len(changes))
+		for _, c := range changes {
+			out = append(out, changeToJSON(c))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(out); err != nil {
+			log.Fatalf("error encoding json: %v", err)
+		}
+	} else {
+		for _, c := range changes {
+			fmt.Println(c)
+			for _, i := range c.Go {
+				fmt.Printf("\tgo: %s\n", i)
+			}
+			for _, i := range c.C {
+				fmt.Printf("\tc: %s\n", i)
+			}
+		}
+	}
+
+	if len(changes) > 0 && diff.Worst(changes) >= threshold {
+		os.Exit(1)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"log"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/jchv/zanbato/kaitai/expr"
@@ -12,7 +14,14 @@ func main() {
 	s := spew.NewDefaultConfig()
 	s.ContinueOnMethod = true
 	for _, arg := range flag.Args() {
-		e := expr.MustParseExpr(arg)
+		e, err := expr.ParseExpr(arg)
+		if err != nil {
+			var syntaxErr *expr.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				log.Fatalf("%v\n%s", err, syntaxErr.Caret())
+			}
+			log.Fatal(err)
+		}
 		s.Dump(e)
 	}
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 
@@ -18,6 +20,7 @@ type treeJSON struct {
 	Value    any         `json:"value,omitempty"`
 	Range    *eval.Range `json:"range,omitempty"`
 	Error    string      `json:"error,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
 	Children []*treeJSON `json:"children,omitempty"`
 }
 
@@ -29,6 +32,15 @@ func nodeToJSON(n *eval.Node) *treeJSON {
 
 	if err := n.Resolve(); err != nil {
 		j.Error = err.Error()
+		// A node that failed may still know its bytes and hold the fields
+		// or elements read before the failure; see eval.Tree.Recover.
+		if r, _ := n.ByteRange(); r.StartIndex != r.EndIndex {
+			j.Range = &r
+		}
+		for _, child := range n.Fields() {
+			j.Children = append(j.Children, nodeToJSON(child))
+		}
+		j.Children = append(j.Children, itemsToJSON(n)...)
 		return j
 	}
 
@@ -56,6 +68,9 @@ func nodeToJSON(n *eval.Node) *treeJSON {
 	if r.StartIndex != r.EndIndex {
 		j.Range = &r
 	}
+	for _, w := range n.Warnings() {
+		j.Warnings = append(j.Warnings, w.Error())
+	}
 
 	// Expand children for structs
 	if v.Kind == eval.KindStruct {
@@ -66,24 +81,126 @@ func nodeToJSON(n *eval.Node) *treeJSON {
 
 	// Expand items for arrays
 	if v.Kind == eval.KindArray {
-		items, _ := n.Items()
-		for _, item := range items {
-			j.Children = append(j.Children, nodeToJSON(item))
-		}
+		j.Children = append(j.Children, itemsToJSON(n)...)
 	}
 
 	return j
 }
 
+// maxItems is the most elements of an array to output, or zero for all.
+var maxItems int
+
+// itemsToJSON converts the elements of an array, up to maxItems of them,
+// followed by a placeholder counting the rest.
+func itemsToJSON(n *eval.Node) []*treeJSON {
+	count, _ := n.Len()
+	shown := count
+	if maxItems > 0 {
+		shown = min(count, maxItems)
+	}
+	var result []*treeJSON
+	for i := range shown {
+		item, _ := n.ItemAt(i)
+		if item == nil {
+			// The element couldn't even be started; the array's error
+			// says why.
+			break
+		}
+		result = append(result, nodeToJSON(item))
+	}
+	if shown < count {
+		result = append(result, &treeJSON{
+			Name: fmt.Sprintf("…%d more", count-shown),
+			Path: fmt.Sprintf("%s[%d…]", n.Path(), shown),
+		})
+	}
+	return result
+}
+
+// previewBytes is how many bytes of each gap -coverage shows.
+const previewBytes = 16
+
+// printCoverage prints a report of the bytes the tree's fields account for.
+func printCoverage(tree *eval.Tree, data io.ReaderAt) {
+	c, err := tree.Coverage()
+	if c == nil {
+		log.Fatalf("error computing coverage: %v", err)
+	}
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+	percent := 100.0
+	if c.Size > 0 {
+		percent = float64(c.CoveredBytes()) / float64(c.Size) * 100
+	}
+	fmt.Printf("covered %d of %d bytes (%.1f%%)\n", c.CoveredBytes(), c.Size, percent)
+	for _, r := range c.Uncovered {
+		size := r.EndIndex - r.StartIndex
+		preview := make([]byte, min(size, previewBytes))
+		n, _ := data.ReadAt(preview, int64(r.StartIndex))
+		more := ""
+		if size > uint64(n) {
+			more = " ..."
+		}
+		fmt.Printf("gap %#x-%#x (%d bytes): % x%s\n", r.StartIndex, r.EndIndex, size, preview[:n], more)
+	}
+	for _, o := range c.Overlaps {
+		fmt.Printf("overlap %#x-%#x: %s and %s\n", o.Range.StartIndex, o.Range.EndIndex, o.A.Path(), o.B.Path())
+	}
+}
+
+// annotationJSON is an annotation as -annotations prints it. Fields that
+// failed to resolve carry their error.
+type annotationJSON struct {
+	eval.Annotation
+	Error string `json:",omitempty"`
+}
+
+// printAnnotations prints the tree's annotations as JSON lines, as they are
+// found.
+func printAnnotations(tree *eval.Tree) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for ann, err := range tree.Annotations(eval.AnnotationOptions{Instances: true}) {
+		out := annotationJSON{Annotation: ann}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		if err := enc.Encode(out); err != nil {
+			log.Fatalf("error encoding json: %v", err)
+		}
+	}
+}
+
 func main() {
 	importPaths := resolve.RegisterImportPathsFlag(flag.CommandLine)
+	validationWarnings := flag.Bool("validation-warnings", false, "report contents/valid failures as node warnings instead of errors")
+	optimize := flag.Bool("optimize", false, "fold constants and simplify expressions before evaluating them")
+	recoverErrors := flag.Bool("recover", false, "keep reading after fields that fail, where their size is known, and output a partial tree")
+	coverage := flag.Bool("coverage", false, "print the bytes no field reads, with a hex preview, and the bytes read by more than one field")
+	annotations := flag.Bool("annotations", false, "stream the leaf fields, including instances, as JSON lines of byte ranges and labels in order of offset")
+	flag.IntVar(&maxItems, "max-items", 0, "output at most this many elements of each array, followed by a count of the rest; 0 outputs all of them")
+	queryText := flag.String("q", "", "output only the nodes matching a query, such as entries[*].name, ..header or entries[?(_.size > 100)]")
 	flag.Parse()
 	if flag.NArg() != 2 {
 		log.Fatalln("Wrong number of arguments; pass your root .ksy path and a binary file to read.")
 	}
 	rootname := flag.Arg(0)
 	filename := flag.Arg(1)
-	resolver := resolve.NewOSResolverWithPaths(*importPaths)
+	if *coverage && *annotations || (*coverage || *annotations) && *queryText != "" {
+		log.Fatalln("Only one of -coverage, -annotations and -q can be used.")
+	}
+	var query *eval.Query
+	if *queryText != "" {
+		var err error
+		if query, err = eval.ParseQuery(*queryText); err != nil {
+			log.Fatalf("error parsing query: %v", err)
+		}
+	}
+	resolver, err := resolve.NewImportPathsResolver(*importPaths)
+	if err != nil {
+		log.Fatalf("error opening import paths: %v", err)
+	}
 	basename, struc, err := resolver.Resolve("", rootname)
 	if err != nil {
 		log.Fatalf("error resolving root struct: %v", err)
@@ -102,8 +219,35 @@ func main() {
 	if err != nil {
 		log.Fatalf("error creating tree: %v", err)
 	}
+	tree.ValidationWarnings = *validationWarnings
+	tree.Optimize = *optimize
+	tree.Recover = *recoverErrors
+
+	if *coverage {
+		printCoverage(tree, f)
+		return
+	}
+	if *annotations {
+		printAnnotations(tree)
+		return
+	}
 
-	result := nodeToJSON(tree.Root())
+	var result any
+	if query != nil {
+		// Only the nodes the query passes through are resolved, beyond the
+		// matches themselves.
+		matches, err := query.Select(tree.Root())
+		if err != nil {
+			log.Printf("warning: %v", err)
+		}
+		results := []*treeJSON{}
+		for _, match := range matches {
+			results = append(results, nodeToJSON(match))
+		}
+		result = results
+	} else {
+		result = nodeToJSON(tree.Root())
+	}
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "\t")
 	enc.SetEscapeHTML(false)
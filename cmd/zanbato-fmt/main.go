@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jchv/zanbato/kaitai/ksy"
+)
+
+func main() {
+	write := flag.Bool("w", false, "Write result to (source) file instead of stdout")
+	check := flag.Bool("check", false, "List files whose formatting differs and exit with status 1 if there are any")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalln("Wrong number of arguments; pass one or more .ksy paths.")
+	}
+
+	unformatted := false
+	for _, name := range flag.Args() {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", name, err)
+		}
+		out, err := ksy.Format(src)
+		if err != nil {
+			log.Fatalf("Error formatting %s: %v", name, err)
+		}
+		switch {
+		case *check:
+			if !bytes.Equal(src, out) {
+				fmt.Println(name)
+				unformatted = true
+			}
+		case *write:
+			if bytes.Equal(src, out) {
+				continue
+			}
+			if err := os.WriteFile(name, out, 0o644); err != nil {
+				log.Fatalf("Error writing %s: %v", name, err)
+			}
+		default:
+			if _, err := os.Stdout.Write(out); err != nil {
+				log.Fatalf("Error writing output: %v", err)
+			}
+		}
+	}
+	if unformatted {
+		os.Exit(1)
+	}
+}
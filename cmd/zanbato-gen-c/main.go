@@ -17,16 +17,21 @@ func main() {
 	out := flag.String("out", "", "Output directory")
 	debug := flag.Bool("debug", false, "Enable debug features in generated code")
 	flag.Var(&compat, "compat", "Compatibility mode: native (default) or 0.11")
+	optimize := flag.Bool("optimize", false, "Fold constants and simplify expressions in generated code")
 	importPaths := resolve.RegisterImportPathsFlag(flag.CommandLine)
 	flag.Parse()
 	if flag.NArg() != 1 {
 		log.Fatalln("Wrong number of arguments; pass your root .ksy path.")
 	}
 	rootname := flag.Arg(0)
-	resolver := resolve.NewOSResolverWithPaths(*importPaths)
+	resolver, err := resolve.NewImportPathsResolver(*importPaths)
+	if err != nil {
+		log.Fatalf("error opening import paths: %v", err)
+	}
 	emitter := c.NewEmitter(resolver)
 	emitter.SetDebug(*debug)
 	emitter.SetCompat(compat)
+	emitter.SetOptimize(*optimize)
 	basename, struc, err := resolver.Resolve("", rootname)
 	if err != nil {
 		log.Fatalf("Error resolving root struct: %v", err)
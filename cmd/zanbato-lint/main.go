@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai/check"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+)
+
+// diagnosticJSON is the JSON representation of a check.Diagnostic.
+type diagnosticJSON struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func diagnosticToJSON(d check.Diagnostic) diagnosticJSON {
+	return diagnosticJSON{
+		File:     d.Pos.File,
+		Line:     d.Pos.Line,
+		Column:   d.Pos.Column,
+		Severity: d.Severity.String(),
+		Code:     d.Code,
+		Message:  d.Message,
+	}
+}
+
+// loadError turns an error loading a schema into a diagnostic.
+func loadError(name string, err error) check.Diagnostic {
+	d := check.Diagnostic{
+		Pos:      srcpos.Pos{File: name},
+		Severity: check.SeverityError,
+		Code:     "load",
+		Message:  err.Error(),
+	}
+	var posErr *srcpos.Error
+	if errors.As(err, &posErr) {
+		d.Pos = posErr.Pos
+		d.Message = posErr.Err.Error()
+	}
+	return d
+}
+
+func main() {
+	importPaths := resolve.RegisterImportPathsFlag(flag.CommandLine)
+	jsonOutput := flag.Bool("json", false, "Print diagnostics as JSON")
+	werror := flag.Bool("Werror", false, "Treat warnings as errors")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalln("Wrong number of arguments; pass one or more .ksy paths.")
+	}
+
+	resolver, err := resolve.NewImportPathsResolver(*importPaths)
+	if err != nil {
+		log.Fatalf("error opening import paths: %v", err)
+	}
+	var diags []check.Diagnostic
+	// carets holds the caret rendering of expression syntax errors, by
+	// diagnostic index.
+	carets := map[int]string{}
+	for _, name := range flag.Args() {
+		basename, struc, err := resolver.Resolve("", name)
+		if err != nil {
+			var syntaxErr *expr.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				carets[len(diags)] = syntaxErr.Caret()
+			}
+			diags = append(diags, loadError(name, err))
+			continue
+		}
+		diags = append(diags, check.Check(resolver, basename, struc)...)
+	}
+
+	if *jsonOutput {
+		out := make([]diagnosticJSON, 0, len(diags))
+		for _, d := range diags {
+			out = append(out, diagnosticToJSON(d))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(out); err != nil {
+			log.Fatalf("error encoding json: %v", err)
+		}
+	} else {
+		for i, d := range diags {
+			fmt.Println(d)
+			if caret, ok := carets[i]; ok {
+				for line := range strings.SplitSeq(caret, "\n") {
+					fmt.Printf("\t%s\n", line)
+				}
+			}
+		}
+	}
+
+	if check.HasErrors(diags) || (*werror && len(diags) > 0) {
+		os.Exit(1)
+	}
+}
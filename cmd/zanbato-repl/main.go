@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jchv/zanbato/kaitai/eval"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
+	"github.com/jchv/zanbato/kaitai/resolve"
+)
+
+const help = `Enter a KS expression to evaluate it in the scope of the current node,
+where _ is the node itself, or:
+
+  :cd PATH     change the current node; PATH is relative (a.b[3]), absolute
+               (/a.b[3]), .. for the parent or / for the root
+  :ls [PATH]   list the fields or items of a node
+  :pwd         print the path of the current node
+  :reload      reload the .ksy and binary files, keeping the current path
+  :help        show this help
+  :quit        exit (as does end of input)
+`
+
+// maxPreview is the number of bytes, characters or array items shown before
+// a value is truncated.
+const maxPreview = 16
+
+// session is the state of a REPL session.
+type session struct {
+	ksyPath            string
+	binPath            string
+	importPaths        []string
+	validationWarnings bool
+	recoverErrors      bool
+
+	tree *eval.Tree
+	cwd  *eval.Node
+	out  io.Writer
+}
+
+// load (re)loads the schema and binary, returning to the current path if it
+// still exists.
+func (s *session) load() error {
+	resolver, err := resolve.NewImportPathsResolver(s.importPaths)
+	if err != nil {
+		return fmt.Errorf("opening import paths: %w", err)
+	}
+	basename, struc, err := resolver.Resolve("", s.ksyPath)
+	if err != nil {
+		return fmt.Errorf("resolving root struct: %w", err)
+	}
+	data, err := os.ReadFile(s.binPath)
+	if err != nil {
+		return err
+	}
+	tree, err := eval.NewTree(resolver, basename, struc, eval.NewStream(bytes.NewReader(data)))
+	if err != nil {
+		return fmt.Errorf("creating tree: %w", err)
+	}
+	tree.ValidationWarnings = s.validationWarnings
+	tree.Recover = s.recoverErrors
+
+	cwd := tree.Root()
+	if s.cwd != nil {
+		if node, err := cwd.Lookup(s.cwd.Path()); err == nil {
+			cwd = node
+		} else {
+			fmt.Fprintf(s.out, "%s no longer exists: %v\n", pathString(s.cwd), err)
+		}
+	}
+	s.tree, s.cwd = tree, cwd
+	return nil
+}
+
+// resolvePath finds the node named by a :cd or :ls argument.
+func (s *session) resolvePath(arg string) (*eval.Node, error) {
+	switch arg {
+	case "":
+		return s.cwd, nil
+	case "/":
+		return s.tree.Root(), nil
+	case "..":
+		if s.cwd.Parent() == nil {
+			return s.cwd, nil
+		}
+		return s.cwd.Parent(), nil
+	}
+	start := s.cwd
+	if strings.HasPrefix(arg, "/") {
+		start = s.tree.Root()
+		arg = arg[1:]
+	}
+	var path eval.Path
+	if err := path.UnmarshalText([]byte(arg)); err != nil {
+		return nil, err
+	}
+	return start.Lookup(path)
+}
+
+func pathString(n *eval.Node) string {
+	return "/" + n.Path().String()
+}
+
+// command runs a line of input, returning false once the session is over.
+func (s *session) command(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+	if !strings.HasPrefix(line, ":") {
+		s.evaluate(line)
+		return true
+	}
+	name, arg, _ := strings.Cut(line[1:], " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "cd":
+		node, err := s.resolvePath(arg)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			break
+		}
+		s.cwd = node
+	case "ls":
+		node, err := s.resolvePath(arg)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			break
+		}
+		s.list(node)
+	case "pwd":
+		fmt.Fprintln(s.out, pathString(s.cwd))
+	case "reload":
+		if err := s.load(); err != nil {
+			fmt.Fprintln(s.out, err)
+		}
+	case "help":
+		fmt.Fprint(s.out, help)
+	case "quit", "q":
+		return false
+	default:
+		fmt.Fprintf(s.out, "unknown command :%s; try :help\n", name)
+	}
+	return true
+}
+
+// evaluate evaluates an expression in the scope of the current node and
+// prints its value and static type.
+func (s *session) evaluate(src string) {
+	e, err := expr.ParseExpr(src)
+	if err != nil {
+		var syntaxErr *expr.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			fmt.Fprintf(s.out, "%v\n%s\n", err, syntaxErr.Caret())
+			return
+		}
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	typ, typeErrs := s.cwd.TypeOfExpr(e)
+	val, err := s.cwd.EvalExpr(e)
+	if err != nil {
+		for _, typeErr := range typeErrs {
+			fmt.Fprintln(s.out, typeErr.Message)
+		}
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	if !typ.IsKnown() {
+		fmt.Fprintf(s.out, "%s\n", formatExprValue(val, typ))
+		return
+	}
+	fmt.Fprintf(s.out, "%s : %s\n", formatExprValue(val, typ), typ)
+}
+
+// list prints the fields of a struct node or the items of an array node,
+// with their types, byte ranges and values.
+func (s *session) list(node *eval.Node) {
+	v, err := node.Value()
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	w := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	switch v.Kind {
+	case eval.KindStruct:
+		for _, child := range node.Fields() {
+			typ, _ := node.TypeOfExpr(&expr.Expr{Root: expr.IdentNode{Identifier: child.Name()}})
+			listNode(w, child.Name(), typ, child)
+		}
+	case eval.KindArray:
+		typ, _ := node.TypeOfExpr(&expr.Expr{Root: expr.IdentNode{Identifier: node.Name()}})
+		items, _ := node.Items()
+		for i, item := range items {
+			listNode(w, fmt.Sprintf("[%d]", i), typ.Elem(), item)
+		}
+	default:
+		listNode(w, node.Name(), engine.InferredType{}, node)
+	}
+}
+
+func listNode(w io.Writer, name string, typ engine.InferredType, n *eval.Node) {
+	kind := typ.String()
+	if !typ.IsKnown() {
+		kind = ""
+	}
+	v, err := n.Value()
+	if err != nil {
+		fmt.Fprintf(w, "%s\t%s\t\terror: %v\n", name, kind, err)
+		return
+	}
+	span := ""
+	if r, _ := n.ByteRange(); r.StartIndex != r.EndIndex {
+		span = fmt.Sprintf("[%#x, %#x)", r.StartIndex, r.EndIndex)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, kind, span, formatValue(v, n))
+}
+
+// formatValue previews a node's value for :ls.
+func formatValue(v eval.Value, n *eval.Node) string {
+	switch v.Kind {
+	case eval.KindNone:
+		return "(none)"
+	case eval.KindInt:
+		return fmt.Sprint(v.Int)
+	case eval.KindUint:
+		return fmt.Sprint(v.Uint)
+	case eval.KindFloat:
+		return fmt.Sprint(v.Float)
+	case eval.KindBool:
+		return fmt.Sprint(v.Bool)
+	case eval.KindBytes:
+		return formatBytes(v.Bytes)
+	case eval.KindStr:
+		return formatString(v.Str)
+	case eval.KindEnum:
+		if v.EnumLabel == "" {
+			return fmt.Sprintf("%d (%s)", v.Int, v.EnumName)
+		}
+		return fmt.Sprintf("%s::%s (%d)", v.EnumName, v.EnumLabel, v.Int)
+	case eval.KindStruct:
+		return "{...}"
+	case eval.KindArray:
+		items, _ := n.Items()
+		return fmt.Sprintf("[%d items]", len(items))
+	}
+	return v.Kind.String()
+}
+
+// formatExprValue formats the result of an expression. typ is its static
+// type, used to label enum values.
+func formatExprValue(v *engine.ExprValue, typ engine.InferredType) string {
+	if v == nil {
+		return "null"
+	}
+	switch v.Kind {
+	case engine.IntegerKind, engine.EnumValueKind:
+		if v.Integer == nil {
+			break
+		}
+		if typ.Enum != nil && !typ.IsArray() {
+			for _, ev := range typ.Enum.Values {
+				if ev.Value.Cmp(v.Integer.Value) == 0 {
+					return fmt.Sprintf("%s::%s (%s)", typ.Enum.ID, ev.ID, v.Integer.Value)
+				}
+			}
+		}
+		return v.Integer.Value.String()
+	case engine.FloatKind:
+		if v.Float != nil {
+			return v.Float.Value.Text('g', -1)
+		}
+	case engine.BooleanKind:
+		if v.Boolean != nil {
+			return fmt.Sprint(v.Boolean.Value)
+		}
+	case engine.ByteArrayKind:
+		if v.ByteArray != nil {
+			return formatBytes(v.ByteArray.Value)
+		}
+	case engine.StringKind:
+		if v.String != nil {
+			return formatString(v.String.Value)
+		}
+	case engine.ArrayKind:
+		parts := []string{}
+		for i, item := range v.Items {
+			if i == maxPreview {
+				parts = append(parts, fmt.Sprintf("... %d more", len(v.Items)-i))
+				break
+			}
+			parts = append(parts, formatExprValue(item, typ.Elem()))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case engine.StructKind:
+		if v.Struct != nil && v.Struct.Type != nil {
+			return fmt.Sprintf("{%s}", v.Struct.Type.ID)
+		}
+	}
+	return fmt.Sprintf("<%s>", v.Kind)
+}
+
+func formatBytes(b []byte) string {
+	if len(b) > maxPreview {
+		return fmt.Sprintf("[% x ...] (%d bytes)", b[:maxPreview], len(b))
+	}
+	return fmt.Sprintf("[% x]", b)
+}
+
+func formatString(s string) string {
+	if r := []rune(s); len(r) > maxPreview*4 {
+		return fmt.Sprintf("%q... (%d characters)", string(r[:maxPreview*4]), len(r))
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+func main() {
+	importPaths := resolve.RegisterImportPathsFlag(flag.CommandLine)
+	validationWarnings := flag.Bool("validation-warnings", false, "report contents/valid failures as node warnings instead of errors")
+	recoverErrors := flag.Bool("recover", false, "keep reading after fields that fail, where their size is known")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalln("Wrong number of arguments; pass your root .ksy path and a binary file to read.")
+	}
+	s := &session{
+		ksyPath:            flag.Arg(0),
+		binPath:            flag.Arg(1),
+		importPaths:        *importPaths,
+		validationWarnings: *validationWarnings,
+		recoverErrors:      *recoverErrors,
+		out:                os.Stdout,
+	}
+	if err := s.load(); err != nil {
+		log.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprintf(s.out, "%s> ", pathString(s.cwd))
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+			break
+		}
+		if !s.command(scanner.Text()) {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
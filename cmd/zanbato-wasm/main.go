@@ -11,6 +11,18 @@
 //	    -> {ok: true} | {ok: false, error: string}
 //	zanbato.parse(rootName: string, data: Uint8Array)
 //	    -> {ok: true, tree: string (JSON)} | {ok: false, error: string}
+//	zanbato.nodesAt(offset: number)
+//	    -> {ok: true, paths: string (JSON)} | {ok: false, error: string}
+//	zanbato.items(path: string, start: number, count: number)
+//	    -> {ok: true, items: string (JSON)} | {ok: false, error: string}
+//
+// nodesAt returns the paths of the fields covering a byte offset in the
+// buffer last passed to parse, outermost first.
+//
+// parse returns the first thousand elements of each array as its children,
+// and the number of elements as its length; items returns the tree nodes of
+// up to count elements of the array at path from start, to page through the
+// rest.
 //
 // loadKsys atomically replaces the in-memory VFS with the supplied set of
 // files; each entry's `name` becomes a VFS path with `.ksy` appended.
@@ -32,10 +44,16 @@ import (
 // VFS paths like "main.ksy" or "subdir/helpers.ksy".
 var vfs = fstest.MapFS{}
 
+// lastTree is the tree built by the last successful parse, for nodesAt and
+// items.
+var lastTree *eval.Tree
+
 func main() {
 	zanbato := js.Global().Get("Object").New()
 	zanbato.Set("loadKsys", js.FuncOf(loadKsys))
 	zanbato.Set("parse", js.FuncOf(parse))
+	zanbato.Set("nodesAt", js.FuncOf(nodesAt))
+	zanbato.Set("items", js.FuncOf(items))
 	js.Global().Set("zanbato", zanbato)
 
 	// Keep the runtime alive so the registered functions remain callable.
@@ -76,6 +94,7 @@ func parse(_ js.Value, args []js.Value) (ret any) {
 	if len(args) != 2 {
 		return errResult("parse: expected (rootName, data)")
 	}
+	lastTree = nil
 	rootName := args[0].String()
 	dataJS := args[1]
 
@@ -90,10 +109,15 @@ func parse(_ js.Value, args []js.Value) (ret any) {
 	}
 
 	stream := eval.NewStream(bytes.NewReader(data))
-	tree, err := eval.NewTree(resolver, basename, struc, stream)
+	// Uploaded files are untrusted.
+	tree, err := eval.NewTree(resolver, basename, struc, stream, eval.WithLimits(eval.DefaultLimits))
 	if err != nil {
 		return errResult(err.Error())
 	}
+	// The editor shows validation failures and unreadable fields inline
+	// rather than refusing to display the rest of the file.
+	tree.ValidationWarnings = true
+	tree.Recover = true
 
 	root := nodeToJSON(tree.Root())
 	var buf bytes.Buffer
@@ -103,12 +127,82 @@ func parse(_ js.Value, args []js.Value) (ret any) {
 		return errResult(err.Error())
 	}
 
+	lastTree = tree
+
 	out := js.Global().Get("Object").New()
 	out.Set("ok", true)
 	out.Set("tree", buf.String())
 	return out
 }
 
+func nodesAt(_ js.Value, args []js.Value) (ret any) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret = errResult(fmt.Sprintf("panic: %v", r))
+		}
+	}()
+	if len(args) != 1 {
+		return errResult("nodesAt: expected (offset)")
+	}
+	if lastTree == nil {
+		return errResult("nodesAt: nothing has been parsed")
+	}
+	paths := []string{}
+	for _, n := range lastTree.NodesAt(int64(args[0].Int())) {
+		paths = append(paths, n.Path().String())
+	}
+	b, err := json.Marshal(paths)
+	if err != nil {
+		return errResult(err.Error())
+	}
+
+	out := js.Global().Get("Object").New()
+	out.Set("ok", true)
+	out.Set("paths", string(b))
+	return out
+}
+
+func items(_ js.Value, args []js.Value) (ret any) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret = errResult(fmt.Sprintf("panic: %v", r))
+		}
+	}()
+	if len(args) != 3 {
+		return errResult("items: expected (path, start, count)")
+	}
+	if lastTree == nil {
+		return errResult("items: nothing has been parsed")
+	}
+	var path eval.Path
+	if err := path.UnmarshalText([]byte(args[0].String())); err != nil {
+		return errResult(err.Error())
+	}
+	n, err := lastTree.Lookup(path)
+	if err != nil {
+		return errResult(err.Error())
+	}
+	start, count := args[1].Int(), args[2].Int()
+	if start < 0 || count < 0 {
+		return errResult("items: start and count must not be negative")
+	}
+	result := itemsToJSON(n, start, count)
+	if result == nil {
+		result = []*treeJSON{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(result); err != nil {
+		return errResult(err.Error())
+	}
+
+	out := js.Global().Get("Object").New()
+	out.Set("ok", true)
+	out.Set("items", buf.String())
+	return out
+}
+
 type treeJSON struct {
 	Name     string      `json:"name"`
 	Path     string      `json:"path"`
@@ -117,6 +211,13 @@ type treeJSON struct {
 	Value    any         `json:"value,omitempty"`
 	Range    *eval.Range `json:"range,omitempty"`
 	Error    string      `json:"error,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+	// Extensions carries the schema's vendor keys, e.g.
+	// -webide-representation, for the frontend to interpret.
+	Extensions map[string]any `json:"extensions,omitempty"`
+	// Length is the number of elements of an array, of which Children
+	// holds only the first maxItems.
+	Length   *int        `json:"length,omitempty"`
 	Children []*treeJSON `json:"children,omitempty"`
 }
 
@@ -127,6 +228,17 @@ func nodeToJSON(n *eval.Node) *treeJSON {
 	}
 	if err := n.Resolve(); err != nil {
 		j.Error = err.Error()
+		// A node that failed may still know its bytes and hold the fields
+		// or elements read before the failure; see eval.Tree.Recover.
+		if r, _ := n.ByteRange(); r.StartIndex != r.EndIndex {
+			j.Range = &r
+		}
+		for _, child := range n.Fields() {
+			j.Children = append(j.Children, nodeToJSON(child))
+		}
+		if length, _ := n.Len(); length > 0 {
+			arrayToJSON(j, n)
+		}
 		return j
 	}
 	v, _ := n.Value()
@@ -167,20 +279,49 @@ func nodeToJSON(n *eval.Node) *treeJSON {
 	if r.StartIndex != r.EndIndex {
 		j.Range = &r
 	}
+	for _, w := range n.Warnings() {
+		j.Warnings = append(j.Warnings, w.Error())
+	}
+	j.Extensions = n.Extensions()
 	if v.Kind == eval.KindStruct {
 		for _, child := range n.Fields() {
 			j.Children = append(j.Children, nodeToJSON(child))
 		}
 	}
 	if v.Kind == eval.KindArray {
-		items, _ := n.Items()
-		for _, item := range items {
-			j.Children = append(j.Children, nodeToJSON(item))
-		}
+		arrayToJSON(j, n)
 	}
 	return j
 }
 
+// maxItems is the most elements of an array that parse returns; items pages
+// through the rest.
+const maxItems = 1000
+
+// arrayToJSON sets the length of the array n and its first elements on j.
+func arrayToJSON(j *treeJSON, n *eval.Node) {
+	length, _ := n.Len()
+	j.Length = &length
+	j.Children = append(j.Children, itemsToJSON(n, 0, maxItems)...)
+}
+
+// itemsToJSON converts up to count elements of an array from start.
+func itemsToJSON(n *eval.Node, start, count int) []*treeJSON {
+	total, _ := n.Len()
+	end := min(total, start+count)
+	var result []*treeJSON
+	for i := start; i < end; i++ {
+		item, _ := n.ItemAt(i)
+		if item == nil {
+			// The element couldn't even be started; the array's error
+			// says why.
+			break
+		}
+		result = append(result, nodeToJSON(item))
+	}
+	return result
+}
+
 func okResult(value any) js.Value {
 	o := js.Global().Get("Object").New()
 	o.Set("ok", true)
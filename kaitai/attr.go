@@ -3,6 +3,7 @@ package kaitai
 import (
 	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/ksy"
+	"github.com/jchv/zanbato/kaitai/srcpos"
 	"github.com/jchv/zanbato/kaitai/types"
 )
 
@@ -10,6 +11,7 @@ import (
 type Attr struct {
 	ID       Identifier
 	Doc      string
+	DocRef   []string
 	Contents []byte
 	Type     types.Type
 	Repeat   types.RepeatType
@@ -37,4 +39,11 @@ type Attr struct {
 	Consume    *bool
 	Include    *bool
 	EosError   *bool
+
+	// Extensions holds the attr's vendor extension keys, such as `-orig-id`,
+	// keyed with their leading dash.
+	Extensions map[string]any
+
+	// Source is where the attr is declared in the .ksy file.
+	Source srcpos.Pos
 }
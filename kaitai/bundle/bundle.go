@@ -0,0 +1,433 @@
+// Package bundle flattens a .ksy file and everything it imports into a
+// single, self-contained spec.
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai/ksy"
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"gopkg.in/yaml.v3"
+)
+
+// Bundle loads the .ksy file name and its transitive imports with resolver,
+// which must implement resolve.SourceReader, and returns a single spec with
+// every imported module inlined as a top-level type of the root.
+//
+// Inlined modules keep their meta/id as their type name unless it collides
+// with one of the root's own types or another module, in which case a
+// numeric suffix is added. Type, enum and expression references to renamed
+// modules are rewritten to match. The imported modules' parsing metadata
+// (endianness, bit endianness, encoding) is kept on their types; descriptive
+// metadata such as their titles and licenses is dropped. Imported modules
+// that refer to _root can't be bundled, since it would then refer to the
+// bundle's root.
+//
+// Inlined types inherit the root's meta where their module sets none. A
+// module without an encoding is given the default, UTF-8, explicitly. A
+// module without an endianness or bit endianness inherits it from its
+// importers, so Bundle reports a conflict if it has fields that depend on it
+// and one of its importers would give it a different value than the root.
+func Bundle(resolver resolve.Resolver, name string) (ksy.TypeSpec, error) {
+	sources, ok := resolver.(resolve.SourceReader)
+	if !ok {
+		return ksy.TypeSpec{}, errors.New("bundle: resolver can't read sources")
+	}
+	graph, err := resolve.LoadGraph(resolver, name)
+	if err != nil {
+		return ksy.TypeSpec{}, err
+	}
+	if err := graph.Err(); err != nil {
+		return ksy.TypeSpec{}, err
+	}
+
+	specs := make(map[*resolve.Module]*ksy.TypeSpec)
+	byID := make(map[string]*resolve.Module)
+	for _, m := range graph.Modules() {
+		src, err := sources.ReadSource(m.Struct.Source.File)
+		if err != nil {
+			return ksy.TypeSpec{}, err
+		}
+		spec := &ksy.TypeSpec{}
+		if err := yaml.Unmarshal(src, spec); err != nil {
+			return ksy.TypeSpec{}, fmt.Errorf("%s: %w", m.Struct.Source.File, err)
+		}
+		id := string(spec.Meta.ID)
+		if other, ok := byID[id]; ok {
+			return ksy.TypeSpec{}, fmt.Errorf("bundle: %s and %s both have id %q", other.Name, m.Name, id)
+		}
+		byID[id] = m
+		specs[m] = spec
+	}
+
+	root := specs[graph.Root]
+	for _, key := range inheritedKeys {
+		if err := checkInherited(graph, specs, key); err != nil {
+			return ksy.TypeSpec{}, err
+		}
+	}
+	names := bundledNames(graph, root, specs)
+	for _, m := range graph.Modules() {
+		r := rewriter{names: names}
+		r.typeSpec(specs[m])
+		if r.usesRoot && m != graph.Root {
+			// Once inlined, _root would be the bundle's root rather than
+			// the module's own.
+			return ksy.TypeSpec{}, fmt.Errorf("bundle: %s refers to _root, which can't be bundled", m.Name)
+		}
+	}
+
+	result := *root
+	result.Meta.Imports = nil
+	result.Types = append(ksy.TypesSpec(nil), root.Types...)
+	for _, m := range graph.Modules() {
+		if m == graph.Root {
+			continue
+		}
+		spec := *specs[m]
+		if spec.Meta.Encoding == "" && root.Meta.Encoding != "" {
+			spec.Meta.Encoding = "UTF-8"
+		}
+		spec.Meta = ksy.MetaSpec{
+			ID:            ksy.Identifier(names[string(spec.Meta.ID)]),
+			KSDebug:       spec.Meta.KSDebug,
+			KSOpaqueTypes: spec.Meta.KSOpaqueTypes,
+			Encoding:      spec.Meta.Encoding,
+			Endian:        spec.Meta.Endian,
+			BitEndian:     spec.Meta.BitEndian,
+		}
+		result.Types = append(result.Types, spec)
+	}
+	return result, nil
+}
+
+// inheritedKey is a meta key that types without their own value inherit
+// from the type they are nested in, or, for modules, from their importer.
+type inheritedKey struct {
+	name string
+	// value returns the key as set in a spec, or "" if unset.
+	value func(t *ksy.TypeSpec) string
+	// uses reports whether a field type depends on the key.
+	uses func(typ string) bool
+}
+
+var inheritedKeys = []inheritedKey{
+	{
+		name: "endian",
+		value: func(t *ksy.TypeSpec) string {
+			if t.Meta.Endian.SwitchOn != "" {
+				return fmt.Sprint(t.Meta.Endian)
+			}
+			return t.Meta.Endian.Value
+		},
+		uses: func(typ string) bool {
+			switch typ {
+			case "u2", "u4", "u8", "s2", "s4", "s8", "f4", "f8":
+				return true
+			}
+			return false
+		},
+	},
+	{
+		name: "bit-endian",
+		value: func(t *ksy.TypeSpec) string {
+			return t.Meta.BitEndian.Value
+		},
+		uses: func(typ string) bool {
+			n, ok := strings.CutPrefix(typ, "b")
+			return ok && n != "" && strings.Trim(n, "0123456789") == ""
+		},
+	},
+}
+
+// checkInherited makes sure that inlining doesn't change a key that modules
+// inherit when they don't set it themselves. Such a module gets the key from
+// the modules that import it; once inlined, it gets the root's. Modules with
+// no fields that depend on the key are not affected.
+func checkInherited(graph *resolve.Graph, specs map[*resolve.Module]*ksy.TypeSpec, key inheritedKey) error {
+	importers := make(map[*resolve.Module][]*resolve.Module)
+	for _, m := range graph.Modules() {
+		for _, imp := range m.Imports {
+			importers[imp] = append(importers[imp], m)
+		}
+	}
+
+	// inherited collects the values m can pass on, walking up through
+	// importers that don't set the key either.
+	visited := make(map[*resolve.Module]bool)
+	var inherited func(m *resolve.Module, values map[string]*resolve.Module)
+	inherited = func(m *resolve.Module, values map[string]*resolve.Module) {
+		if visited[m] {
+			return
+		}
+		visited[m] = true
+		if v := key.value(specs[m]); v != "" || m == graph.Root {
+			if _, ok := values[v]; !ok {
+				values[v] = m
+			}
+			return
+		}
+		for _, importer := range importers[m] {
+			inherited(importer, values)
+		}
+	}
+
+	rootValue := key.value(specs[graph.Root])
+	for _, m := range graph.Modules() {
+		if m == graph.Root || !usesInherited(specs[m], key) {
+			continue
+		}
+		clear(visited)
+		values := make(map[string]*resolve.Module)
+		for _, importer := range importers[m] {
+			inherited(importer, values)
+		}
+		for _, v := range slices.Sorted(maps.Keys(values)) {
+			if v != rootValue {
+				return fmt.Errorf("bundle: %s has no %s of its own and inherits %q from %s, but would inherit %q from %s", m.Name, key.name, v, values[v].Name, rootValue, graph.Root.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// usesInherited reports whether t, or one of its nested types that inherits
+// key from it, has a field whose type depends on key.
+func usesInherited(t *ksy.TypeSpec, key inheritedKey) bool {
+	if key.value(t) != "" {
+		return false
+	}
+	uses := func(a ksy.AttributeSpec) bool {
+		if key.uses(strings.TrimSpace(a.Type.Value)) {
+			return true
+		}
+		for _, typ := range a.Type.Cases {
+			if key.uses(strings.TrimSpace(typ)) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, a := range t.Seq {
+		if uses(a) {
+			return true
+		}
+	}
+	for _, item := range t.Instances.Instances {
+		if uses(ksy.AttributeSpec(item.Value)) {
+			return true
+		}
+	}
+	for i := range t.Types {
+		if usesInherited(&t.Types[i], key) {
+			return true
+		}
+	}
+	return false
+}
+
+// bundledNames picks the type name of each module, keyed by its meta/id.
+// The root keeps its name; imported modules are renamed if their id is taken
+// by one of the root's top-level types or an earlier module.
+func bundledNames(graph *resolve.Graph, root *ksy.TypeSpec, specs map[*resolve.Module]*ksy.TypeSpec) map[string]string {
+	names := map[string]string{string(root.Meta.ID): string(root.Meta.ID)}
+	taken := map[string]bool{string(root.Meta.ID): true}
+	for _, t := range root.Types {
+		taken[string(t.Meta.ID)] = true
+	}
+	for _, m := range graph.Modules() {
+		if m == graph.Root {
+			continue
+		}
+		id := string(specs[m].Meta.ID)
+		name := id
+		for i := 2; taken[name]; i++ {
+			name = fmt.Sprintf("%s_%d", id, i)
+		}
+		taken[name] = true
+		names[id] = name
+	}
+	return names
+}
+
+// rewriter renames references to modules within one module's spec. A name
+// only refers to a module if no type or enum of that name is in scope, so
+// the walk keeps track of the enclosing types.
+type rewriter struct {
+	names  map[string]string
+	scopes []*ksy.TypeSpec
+
+	// usesRoot is set if an expression of the module refers to _root.
+	usesRoot bool
+}
+
+func (r *rewriter) typeSpec(t *ksy.TypeSpec) {
+	r.scopes = append(r.scopes, t)
+	defer func() { r.scopes = r.scopes[:len(r.scopes)-1] }()
+
+	t.Meta.Imports = nil
+	r.endian(&t.Meta.Endian)
+	for i := range t.Params {
+		t.Params[i].Type = r.typeRef(t.Params[i].Type)
+		t.Params[i].Enum = r.typeRef(t.Params[i].Enum)
+	}
+	for i := range t.Seq {
+		r.attr(&t.Seq[i])
+	}
+	for i := range t.Instances.Instances {
+		r.attr((*ksy.AttributeSpec)(&t.Instances.Instances[i].Value))
+	}
+	t.ToString = r.expr(t.ToString)
+	for i := range t.Types {
+		r.typeSpec(&t.Types[i])
+	}
+}
+
+func (r *rewriter) endian(e *ksy.EndianSpec) {
+	if e.SwitchOn == "" {
+		return
+	}
+	e.SwitchOn = r.expr(e.SwitchOn)
+	cases := make(ksy.EndianCaseMapSpec, len(e.Cases))
+	for key, value := range e.Cases {
+		cases[r.expr(key)] = value
+	}
+	e.Cases = cases
+}
+
+func (r *rewriter) attr(a *ksy.AttributeSpec) {
+	a.Type.Value = r.typeRef(a.Type.Value)
+	if a.Type.SwitchOn != "" {
+		a.Type.SwitchOn = r.expr(a.Type.SwitchOn)
+		cases := make(ksy.TypeCaseMapSpec, len(a.Type.Cases))
+		for key, value := range a.Type.Cases {
+			cases[r.expr(key)] = r.typeRef(value)
+		}
+		a.Type.Cases = cases
+	}
+	a.Enum = r.typeRef(a.Enum)
+	a.RepeatExpr = r.expr(a.RepeatExpr)
+	a.RepeatUntil = r.expr(a.RepeatUntil)
+	a.If = r.expr(a.If)
+	a.Size = r.expr(a.Size)
+	a.Process = r.expr(a.Process)
+	a.Pos = r.expr(a.Pos)
+	a.IO = r.expr(a.IO)
+	a.Value = r.expr(a.Value)
+	if a.Valid != nil {
+		a.Valid.Eq = r.expr(a.Valid.Eq)
+		a.Valid.Min = r.expr(a.Valid.Min)
+		a.Valid.Max = r.expr(a.Valid.Max)
+		a.Valid.Expr = r.expr(a.Valid.Expr)
+		for i := range a.Valid.AnyOf {
+			a.Valid.AnyOf[i] = r.expr(a.Valid.AnyOf[i])
+		}
+	}
+	if a.Parent != nil {
+		a.Parent.Expr = r.expr(a.Parent.Expr)
+	}
+}
+
+// rename returns the new name for the first component of a type or enum
+// path.
+func (r *rewriter) rename(name string) string {
+	for _, scope := range r.scopes {
+		for _, t := range scope.Types {
+			if string(t.Meta.ID) == name {
+				return name
+			}
+		}
+		for _, e := range scope.Enums {
+			if string(e.ID) == name {
+				return name
+			}
+		}
+	}
+	if renamed, ok := r.names[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// typeRef rewrites a type or enum reference such as `foo::bar(1, 2)`.
+func (r *rewriter) typeRef(ref string) string {
+	end := strings.IndexAny(ref, ":([")
+	if end < 0 {
+		end = len(ref)
+	}
+	head, rest := ref[:end], ref[end:]
+	if args, ok := strings.CutPrefix(rest, "("); ok {
+		// Arguments are expressions; rewriting the rest as one is harmless,
+		// since only identifiers followed by :: are touched.
+		rest = "(" + r.expr(args)
+	}
+	return r.rename(head) + rest
+}
+
+// expr rewrites the module references in an expression: the first
+// component of `a::b` paths and the type in `as<a>`, `sizeof<a>` and
+// `bitsizeof<a>`. It works on the source text so the expression keeps its
+// formatting.
+func (r *rewriter) expr(src string) string {
+	var b strings.Builder
+	prevIdent := ""
+	prev := rune(0)
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && rune(src[j]) != c {
+				if src[j] == '\\' && c == '"' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(src))
+			b.WriteString(src[i:j])
+			i = j
+			prev, prevIdent = c, ""
+		case isIdentStart(c):
+			j := i
+			for j < len(src) && isIdentPart(rune(src[j])) {
+				j++
+			}
+			ident := src[i:j]
+			if ident == "_root" {
+				r.usesRoot = true
+			}
+			rest := strings.TrimLeft(src[j:], " ")
+			isPath := strings.HasPrefix(rest, "::") && prev != '.' && prev != ':'
+			isTypeArg := prev == '<' && (prevIdent == "as" || prevIdent == "sizeof" || prevIdent == "bitsizeof")
+			if isPath || isTypeArg {
+				b.WriteString(r.rename(ident))
+			} else {
+				b.WriteString(ident)
+			}
+			i = j
+			prev, prevIdent = 'a', ident
+		default:
+			b.WriteByte(src[i])
+			i++
+			if c != ' ' {
+				if c != '<' {
+					prevIdent = ""
+				}
+				prev = c
+			}
+		}
+	}
+	return b.String()
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isIdentPart(c rune) bool {
+	return isIdentStart(c) || '0' <= c && c <= '9'
+}
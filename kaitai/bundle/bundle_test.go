@@ -0,0 +1,219 @@
+package bundle
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/check"
+	"github.com/jchv/zanbato/kaitai/ksy"
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFiles = fstest.MapFS{
+	"main.ksy": {Data: []byte(`
+meta:
+  id: main
+  title: Main format
+  endian: le
+  imports:
+    - lib/dep
+    - common
+seq:
+  - id: hdr
+    type: dep
+  - id: kind
+    type: u1
+    enum: dep::kinds
+  - id: local
+    type: common
+types:
+  common:
+    seq:
+      - id: y
+        type: u1
+`)},
+	"lib/dep.ksy": {Data: []byte(`
+meta:
+  id: dep
+  title: Dependency
+  endian: be
+  imports:
+    - ../common
+seq:
+  - id: magic
+    type: u2
+  - id: c
+    type: common
+  - id: padding
+    size: sizeof<common>
+instances:
+  is_a:
+    value: c.tag == common::tags::a
+  self:
+    value: _parent.hdr.as<dep>
+enums:
+  kinds:
+    1: one
+`)},
+	"common.ksy": {Data: []byte(`
+meta:
+  id: common
+  license: CC0-1.0
+seq:
+  - id: tag
+    type: u1
+    enum: tags
+enums:
+  tags:
+    1: a
+`)},
+}
+
+// stripSources zeroes every source position reachable from v, so that structs
+// parsed from different files can be compared.
+func stripSources(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			stripSources(v.Elem())
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			stripSources(v.Index(i))
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeFor[srcpos.Pos]() {
+			if v.CanSet() {
+				v.SetZero()
+			}
+			return
+		}
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				stripSources(v.Field(i))
+			}
+		}
+	}
+}
+
+func parse(t *testing.T, src []byte) *kaitai.Struct {
+	t.Helper()
+	s, err := kaitai.ParseStructFile(bytes.NewReader(src), "")
+	require.NoError(t, err)
+	stripSources(reflect.ValueOf(s))
+	s.Meta.ImportSources = nil
+	return s
+}
+
+func findStruct(structs []*kaitai.Struct, id string) *kaitai.Struct {
+	for _, s := range structs {
+		if string(s.ID) == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestBundle(t *testing.T) {
+	spec, err := Bundle(resolve.NewFSResolver(testFiles), "main")
+	require.NoError(t, err)
+	out, err := ksy.Marshal(spec)
+	require.NoError(t, err)
+	t.Logf("bundle:\n%s", out)
+
+	assert.Empty(t, spec.Meta.Imports)
+	assert.Equal(t, "Main format", spec.Meta.Title)
+	ids := []string{}
+	for _, typ := range spec.Types {
+		ids = append(ids, string(typ.Meta.ID))
+	}
+	assert.Equal(t, []string{"common", "common_2", "dep"}, ids)
+
+	// The root's own common type keeps its name, so only references to the
+	// imported module are renamed.
+	bundled := parse(t, out)
+	assert.Equal(t, "common", bundled.Seq[2].Type.TypeRef.User.Name)
+	dep := findStruct(bundled.Structs, "dep")
+	require.NotNil(t, dep)
+	assert.Equal(t, "common_2", dep.Seq[1].Type.TypeRef.User.Name)
+	assert.Equal(t, "c.tag == common_2::tags::a", spec.Types[2].Instances.Instances[0].Value.Value)
+	assert.Equal(t, "sizeof<common_2>", spec.Types[2].Seq[2].Size)
+	assert.Equal(t, "_parent.hdr.as<dep>", spec.Types[2].Instances.Instances[1].Value.Value)
+	assert.Equal(t, "be", spec.Types[2].Meta.Endian.Value)
+	assert.Empty(t, spec.Types[1].Meta.License)
+
+	// Modules parse to the same structs as their original files, give or
+	// take the renames and descriptive metadata.
+	common := parse(t, testFiles["common.ksy"].Data)
+	common.ID = "common_2"
+	common.Meta.License = ""
+	assert.Equal(t, common, findStruct(bundled.Structs, "common_2"))
+
+	main := parse(t, testFiles["main.ksy"].Data)
+	assert.Equal(t, main.Seq, bundled.Seq)
+	assert.Equal(t, main.Structs[0], bundled.Structs[0])
+
+	// The bundle stands on its own.
+	resolver := resolve.NewFSResolver(fstest.MapFS{"bundle.ksy": {Data: out}})
+	name, struc, err := resolver.Resolve("", "bundle")
+	require.NoError(t, err)
+	for _, d := range check.Check(resolver, name, struc) {
+		assert.NotEqual(t, check.SeverityError, d.Severity, "%s", d)
+	}
+}
+
+func TestBundleDuplicateID(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.ksy": {Data: []byte("meta:\n  id: main\n  imports:\n    - a\n    - b\n")},
+		"a.ksy":    {Data: []byte("meta:\n  id: same\n")},
+		"b.ksy":    {Data: []byte("meta:\n  id: same\n")},
+	}
+	_, err := Bundle(resolve.NewFSResolver(fsys), "main")
+	assert.ErrorContains(t, err, `both have id "same"`)
+}
+
+func TestBundleRoot(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.ksy": {Data: []byte("meta:\n  id: main\n  imports:\n    - dep\nseq:\n  - id: d\n    type: dep\n")},
+		"dep.ksy":  {Data: []byte("meta:\n  id: dep\nseq:\n  - id: a\n    type: u1\n  - id: b\n    type: u1\n    if: _root.a == 1\n")},
+	}
+	_, err := Bundle(resolve.NewFSResolver(fsys), "main")
+	assert.EqualError(t, err, "bundle: dep refers to _root, which can't be bundled")
+
+	// The root's own _root is unaffected.
+	fsys["main.ksy"] = &fstest.MapFile{Data: []byte("meta:\n  id: main\nseq:\n  - id: a\n    type: u1\n  - id: b\n    type: u1\n    if: _root.a == 1\n")}
+	_, err = Bundle(resolve.NewFSResolver(fsys), "main")
+	assert.NoError(t, err)
+}
+
+func TestBundleInheritedMeta(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.ksy": {Data: []byte("meta:\n  id: main\n  encoding: ASCII\n  imports:\n    - text\nseq:\n  - id: t\n    type: text\n")},
+		"text.ksy": {Data: []byte("meta:\n  id: text\nseq:\n  - id: s\n    type: strz\n")},
+	}
+	spec, err := Bundle(resolve.NewFSResolver(fsys), "main")
+	require.NoError(t, err)
+	assert.Equal(t, "ASCII", spec.Meta.Encoding)
+	assert.Equal(t, "UTF-8", spec.Types[0].Meta.Encoding)
+}
+
+func TestBundleInheritedEndianConflict(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.ksy": {Data: []byte("meta:\n  id: main\n  endian: le\n  imports:\n    - dep\n")},
+		"dep.ksy":  {Data: []byte("meta:\n  id: dep\n  endian: be\n  imports:\n    - num\n")},
+		"num.ksy":  {Data: []byte("meta:\n  id: num\nseq:\n  - id: n\n    type: u4\n")},
+	}
+	_, err := Bundle(resolve.NewFSResolver(fsys), "main")
+	assert.EqualError(t, err, `bundle: num has no endian of its own and inherits "be" from dep, but would inherit "le" from main`)
+
+	// Modules that don't read multi-byte numbers are unaffected.
+	fsys["num.ksy"] = &fstest.MapFile{Data: []byte("meta:\n  id: num\nseq:\n  - id: n\n    type: u1\n")}
+	_, err = Bundle(resolve.NewFSResolver(fsys), "main")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,273 @@
+// Package charset maps the character encoding names used in `encoding:` keys
+// to text encodings, for decoding strings as they are read and encoding them
+// as they are written.
+package charset
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+// Charset is a character encoding that strings can be stored in.
+type Charset struct {
+	// Name is the canonical name of the encoding, as IANA registers it.
+	Name string
+
+	// Aliases are the other names the encoding is known by. Names are
+	// matched ignoring case, hyphens, underscores and spaces.
+	Aliases []string
+
+	// Encoding converts to and from the encoding. It is nil for UTF-8 and
+	// ASCII, which Go strings can hold as-is once they are checked to be
+	// valid.
+	Encoding encoding.Encoding
+
+	// GoPackage is the import path of the package that defines Encoding,
+	// and GoExpr is a Go expression for it, referring to the package by
+	// its base name. Both are empty when Encoding is nil.
+	GoPackage string
+	GoExpr    string
+
+	// Unit is the size in bytes of a code unit, which is also the size of
+	// a terminator: 2 for UTF-16, 4 for UTF-32 and 1 otherwise.
+	Unit int
+}
+
+const (
+	charmapPkg = "golang.org/x/text/encoding/charmap"
+	unicodePkg = "golang.org/x/text/encoding/unicode"
+	utf32Pkg   = "golang.org/x/text/encoding/unicode/utf32"
+)
+
+func cm(name string, enc encoding.Encoding, goName string, aliases ...string) *Charset {
+	return &Charset{Name: name, Aliases: aliases, Encoding: enc, GoPackage: charmapPkg, GoExpr: "charmap." + goName, Unit: 1}
+}
+
+// charsets lists every supported encoding.
+var charsets = []*Charset{
+	{Name: "UTF-8", Unit: 1},
+	{Name: "ASCII", Aliases: []string{"US-ASCII"}, Unit: 1},
+
+	{
+		// Without a byte order mark, UTF-16 and UTF-32 are big-endian.
+		Name: "UTF-16", Encoding: unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+		GoPackage: unicodePkg, GoExpr: "unicode.UTF16(unicode.BigEndian, unicode.UseBOM)", Unit: 2,
+	},
+	{
+		Name: "UTF-16LE", Encoding: unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+		GoPackage: unicodePkg, GoExpr: "unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)", Unit: 2,
+	},
+	{
+		Name: "UTF-16BE", Encoding: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+		GoPackage: unicodePkg, GoExpr: "unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)", Unit: 2,
+	},
+	{
+		Name: "UTF-32", Encoding: utf32.UTF32(utf32.BigEndian, utf32.UseBOM),
+		GoPackage: utf32Pkg, GoExpr: "utf32.UTF32(utf32.BigEndian, utf32.UseBOM)", Unit: 4,
+	},
+	{
+		Name: "UTF-32LE", Encoding: utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM),
+		GoPackage: utf32Pkg, GoExpr: "utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM)", Unit: 4,
+	},
+	{
+		Name: "UTF-32BE", Encoding: utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM),
+		GoPackage: utf32Pkg, GoExpr: "utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM)", Unit: 4,
+	},
+
+	{
+		Name: "Shift_JIS", Aliases: []string{"SJIS", "MS_Kanji", "CP932", "Windows-31J"}, Encoding: japanese.ShiftJIS,
+		GoPackage: "golang.org/x/text/encoding/japanese", GoExpr: "japanese.ShiftJIS", Unit: 1,
+	},
+	{
+		Name: "EUC-JP", Encoding: japanese.EUCJP,
+		GoPackage: "golang.org/x/text/encoding/japanese", GoExpr: "japanese.EUCJP", Unit: 1,
+	},
+	{
+		Name: "ISO-2022-JP", Encoding: japanese.ISO2022JP,
+		GoPackage: "golang.org/x/text/encoding/japanese", GoExpr: "japanese.ISO2022JP", Unit: 1,
+	},
+	{
+		Name: "EUC-KR", Aliases: []string{"CP949", "UHC"}, Encoding: korean.EUCKR,
+		GoPackage: "golang.org/x/text/encoding/korean", GoExpr: "korean.EUCKR", Unit: 1,
+	},
+	{
+		// GBK is a superset of GB2312, so it decodes GB2312 text too.
+		Name: "GBK", Aliases: []string{"GB2312", "CP936", "EUC-CN"}, Encoding: simplifiedchinese.GBK,
+		GoPackage: "golang.org/x/text/encoding/simplifiedchinese", GoExpr: "simplifiedchinese.GBK", Unit: 1,
+	},
+	{
+		Name: "GB18030", Encoding: simplifiedchinese.GB18030,
+		GoPackage: "golang.org/x/text/encoding/simplifiedchinese", GoExpr: "simplifiedchinese.GB18030", Unit: 1,
+	},
+	{
+		Name: "HZ-GB-2312", Encoding: simplifiedchinese.HZGB2312,
+		GoPackage: "golang.org/x/text/encoding/simplifiedchinese", GoExpr: "simplifiedchinese.HZGB2312", Unit: 1,
+	},
+	{
+		Name: "Big5", Aliases: []string{"CP950"}, Encoding: traditionalchinese.Big5,
+		GoPackage: "golang.org/x/text/encoding/traditionalchinese", GoExpr: "traditionalchinese.Big5", Unit: 1,
+	},
+
+	cm("KOI8-R", charmap.KOI8R, "KOI8R"),
+	cm("KOI8-U", charmap.KOI8U, "KOI8U"),
+	cm("IBM037", charmap.CodePage037, "CodePage037", "CP037"),
+	cm("IBM437", charmap.CodePage437, "CodePage437", "CP437"),
+	cm("IBM850", charmap.CodePage850, "CodePage850", "CP850"),
+	cm("IBM852", charmap.CodePage852, "CodePage852", "CP852"),
+	cm("IBM855", charmap.CodePage855, "CodePage855", "CP855"),
+	cm("IBM00858", charmap.CodePage858, "CodePage858", "IBM858", "CP858"),
+	cm("IBM860", charmap.CodePage860, "CodePage860", "CP860"),
+	cm("IBM862", charmap.CodePage862, "CodePage862", "CP862"),
+	cm("IBM863", charmap.CodePage863, "CodePage863", "CP863"),
+	cm("IBM865", charmap.CodePage865, "CodePage865", "CP865"),
+	cm("IBM866", charmap.CodePage866, "CodePage866", "CP866"),
+	cm("IBM1047", charmap.CodePage1047, "CodePage1047", "CP1047"),
+	cm("IBM01140", charmap.CodePage1140, "CodePage1140", "IBM1140", "CP1140"),
+	cm("ISO-8859-1", charmap.ISO8859_1, "ISO8859_1", "Latin1"),
+	cm("ISO-8859-2", charmap.ISO8859_2, "ISO8859_2", "Latin2"),
+	cm("ISO-8859-3", charmap.ISO8859_3, "ISO8859_3", "Latin3"),
+	cm("ISO-8859-4", charmap.ISO8859_4, "ISO8859_4", "Latin4"),
+	cm("ISO-8859-5", charmap.ISO8859_5, "ISO8859_5"),
+	cm("ISO-8859-6", charmap.ISO8859_6, "ISO8859_6"),
+	cm("ISO-8859-7", charmap.ISO8859_7, "ISO8859_7"),
+	cm("ISO-8859-8", charmap.ISO8859_8, "ISO8859_8"),
+	cm("ISO-8859-9", charmap.ISO8859_9, "ISO8859_9", "Latin5"),
+	cm("ISO-8859-10", charmap.ISO8859_10, "ISO8859_10", "Latin6"),
+	cm("ISO-8859-13", charmap.ISO8859_13, "ISO8859_13"),
+	cm("ISO-8859-14", charmap.ISO8859_14, "ISO8859_14"),
+	cm("ISO-8859-15", charmap.ISO8859_15, "ISO8859_15", "Latin-9"),
+	cm("ISO-8859-16", charmap.ISO8859_16, "ISO8859_16", "Latin10"),
+	cm("windows-874", charmap.Windows874, "Windows874", "CP874"),
+	cm("windows-1250", charmap.Windows1250, "Windows1250", "CP1250"),
+	cm("windows-1251", charmap.Windows1251, "Windows1251", "CP1251"),
+	cm("windows-1252", charmap.Windows1252, "Windows1252", "CP1252"),
+	cm("windows-1253", charmap.Windows1253, "Windows1253", "CP1253"),
+	cm("windows-1254", charmap.Windows1254, "Windows1254", "CP1254"),
+	cm("windows-1255", charmap.Windows1255, "Windows1255", "CP1255"),
+	cm("windows-1256", charmap.Windows1256, "Windows1256", "CP1256"),
+	cm("windows-1257", charmap.Windows1257, "Windows1257", "CP1257"),
+	cm("windows-1258", charmap.Windows1258, "Windows1258", "CP1258"),
+	cm("macintosh", charmap.Macintosh, "Macintosh", "MacRoman"),
+	cm("x-mac-cyrillic", charmap.MacintoshCyrillic, "MacintoshCyrillic", "MacCyrillic"),
+}
+
+// byName indexes charsets by their normalized names and aliases.
+var byName = func() map[string]*Charset {
+	m := make(map[string]*Charset)
+	for _, cs := range charsets {
+		for _, name := range append([]string{cs.Name}, cs.Aliases...) {
+			key := Normalize(name)
+			if m[key] != nil {
+				panic("charset: duplicate name " + name)
+			}
+			m[key] = cs
+		}
+	}
+	return m
+}()
+
+// Normalize returns the form of an encoding name used to compare names:
+// upper case, without hyphens, underscores or spaces. For example, "utf_8",
+// "UTF-8" and "utf8" all normalize to "UTF8".
+func Normalize(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", "_", "", " ", "").Replace(name))
+}
+
+// Lookup returns the charset with the given name or alias. An empty name is
+// UTF-8. It is an error to name an encoding that isn't supported.
+func Lookup(name string) (*Charset, error) {
+	if name == "" {
+		return byName["UTF8"], nil
+	}
+	cs, ok := byName[Normalize(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return cs, nil
+}
+
+// Charsets returns every supported charset, sorted by name.
+func Charsets() []*Charset {
+	result := slices.Clone(charsets)
+	slices.SortFunc(result, func(a, b *Charset) int {
+		return strings.Compare(Normalize(a.Name), Normalize(b.Name))
+	})
+	return result
+}
+
+// NeedsConversion reports whether strings in the named encoding need to be
+// converted to and from Go (UTF-8) strings. Unknown encodings need
+// conversion, which will fail.
+func NeedsConversion(name string) bool {
+	cs, err := Lookup(name)
+	return err != nil || cs.Encoding != nil
+}
+
+// Decode decodes data from the named encoding. It is an error if data isn't
+// valid in the encoding, such as bytes above 0x7F in ASCII.
+func Decode(data []byte, name string) (string, error) {
+	cs, err := Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if cs.Encoding == nil {
+		if err := cs.checkRaw(string(data)); err != nil {
+			return "", fmt.Errorf("decoding %s: %w", cs.Name, err)
+		}
+		return string(data), nil
+	}
+	decoded, err := cs.Encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", cs.Name, err)
+	}
+	return string(decoded), nil
+}
+
+// Encode encodes s in the named encoding. It is an error if s contains
+// characters the encoding can't represent.
+func Encode(s string, name string) ([]byte, error) {
+	cs, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Encoding == nil {
+		if err := cs.checkRaw(s); err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", cs.Name, err)
+		}
+		return []byte(s), nil
+	}
+	encoded, err := cs.Encoding.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", cs.Name, err)
+	}
+	return encoded, nil
+}
+
+// checkRaw checks that s is valid in cs, an encoding without an Encoding
+// whose bytes are used as-is: ASCII must be 7-bit and UTF-8 must be valid.
+func (cs *Charset) checkRaw(s string) error {
+	if Normalize(cs.Name) == "ASCII" {
+		for i := 0; i < len(s); i++ {
+			if s[i] >= utf8.RuneSelf {
+				return fmt.Errorf("byte 0x%02x at offset %d is not ASCII", s[i], i)
+			}
+		}
+		return nil
+	}
+	if !utf8.ValidString(s) {
+		return errors.New("invalid UTF-8")
+	}
+	return nil
+}
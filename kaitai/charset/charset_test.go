@@ -0,0 +1,100 @@
+package charset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	for name, want := range map[string]string{
+		"":             "UTF-8",
+		"utf8":         "UTF-8",
+		"us-ascii":     "ASCII",
+		"SJIS":         "Shift_JIS",
+		"windows-31j":  "Shift_JIS",
+		"cp949":        "EUC-KR",
+		"gb2312":       "GBK",
+		"BIG5":         "Big5",
+		"utf_32le":     "UTF-32LE",
+		"Latin1":       "ISO-8859-1",
+		"ISO 8859-15":  "ISO-8859-15",
+		"CP1251":       "windows-1251",
+		"ibm437":       "IBM437",
+		"x-mac-roman":  "",
+		"X-UNKNOWN-42": "",
+	} {
+		cs, err := Lookup(name)
+		if want == "" {
+			assert.Error(t, err, name)
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.Equal(t, want, cs.Name, name)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		data []byte
+	}{
+		{"EUC-KR", "한국", []byte{0xc7, 0xd1, 0xb1, 0xb9}},
+		{"GBK", "中文", []byte{0xd6, 0xd0, 0xce, 0xc4}},
+		{"Big5", "中文", []byte{0xa4, 0xa4, 0xa4, 0xe5}},
+		{"Shift_JIS", "日本", []byte{0x93, 0xfa, 0x96, 0x7b}},
+		{"UTF-16LE", "hi", []byte{'h', 0, 'i', 0}},
+		{"UTF-32LE", "hi", []byte{'h', 0, 0, 0, 'i', 0, 0, 0}},
+		{"UTF-32BE", "é", []byte{0, 0, 0, 0xe9}},
+		{"KOI8-R", "Мир", []byte{0xed, 0xc9, 0xd2}},
+		{"windows-1251", "Мир", []byte{0xcc, 0xe8, 0xf0}},
+		{"ISO-8859-7", "Ωμ", []byte{0xd9, 0xec}},
+		{"ASCII", "plain", []byte("plain")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Decode(tc.data, tc.name)
+			require.NoError(t, err)
+			assert.Equal(t, tc.text, s)
+
+			data, err := Encode(tc.text, tc.name)
+			require.NoError(t, err)
+			assert.Equal(t, tc.data, data)
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Decode([]byte("x"), "EBCDIC-XYZ")
+	assert.EqualError(t, err, `unknown encoding "EBCDIC-XYZ"`)
+
+	_, err = Encode("Мир", "ISO-8859-1")
+	assert.ErrorContains(t, err, "encoding ISO-8859-1")
+
+	_, err = Encode("é", "ASCII")
+	assert.EqualError(t, err, "encoding ASCII: byte 0xc3 at offset 0 is not ASCII")
+	_, err = Decode([]byte{'a', 0x80}, "ASCII")
+	assert.EqualError(t, err, "decoding ASCII: byte 0x80 at offset 1 is not ASCII")
+
+	_, err = Encode("\xff", "UTF-8")
+	assert.EqualError(t, err, "encoding UTF-8: invalid UTF-8")
+	_, err = Decode([]byte{0xc3}, "")
+	assert.EqualError(t, err, "decoding UTF-8: invalid UTF-8")
+}
+
+func TestUnits(t *testing.T) {
+	for _, cs := range Charsets() {
+		switch Normalize(cs.Name) {
+		case "UTF16", "UTF16LE", "UTF16BE":
+			assert.Equal(t, 2, cs.Unit, cs.Name)
+		case "UTF32", "UTF32LE", "UTF32BE":
+			assert.Equal(t, 4, cs.Unit, cs.Name)
+		default:
+			assert.Equal(t, 1, cs.Unit, cs.Name)
+		}
+		assert.Equal(t, cs.Encoding != nil, NeedsConversion(cs.Name), cs.Name)
+		assert.Equal(t, cs.Encoding != nil, cs.GoExpr != "", cs.Name)
+	}
+	assert.True(t, NeedsConversion("X-UNKNOWN"))
+}
@@ -0,0 +1,779 @@
+// Package check implements static semantic checks for Kaitai Struct schemas.
+//
+// The checker walks a resolved kaitai.Struct graph (the root schema and
+// everything it imports) using the same expression engine and parent-type
+// inference as the emitters, and reports problems that would otherwise only
+// surface as an emitter panic or a runtime evaluation error.
+package check
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/charset"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+const (
+	// SeverityError marks a problem that will make code generation or
+	// evaluation fail.
+	SeverityError Severity = iota
+
+	// SeverityWarning marks something suspicious that is still accepted.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic codes. These are stable identifiers intended for tooling; the
+// accompanying message is meant for humans.
+const (
+	CodeUnresolvedImport = "unresolved-import"
+	CodeImportCycle      = "import-cycle"
+	CodeUnresolvedType   = "unresolved-type"
+	CodeUnresolvedEnum   = "unresolved-enum"
+	CodeUnresolvedName   = "unresolved-name"
+	CodeParamCount       = "param-count"
+	CodeTypeMismatch     = "type-mismatch"
+	CodeSwitchCase       = "switch-case-type"
+	CodeUnusedType       = "unused-type"
+	CodeUnusedEnum       = "unused-enum"
+	CodeUnprovenParent   = "unproven-parent"
+	CodeUnknownEncoding  = "unknown-encoding"
+)
+
+// Diagnostic is a single problem found in a schema.
+type Diagnostic struct {
+	Pos      srcpos.Pos
+	Severity Severity
+	Code     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", d.Pos, d.Severity, d.Message, d.Code)
+}
+
+// HasErrors returns true if any diagnostic has error severity.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs all checks on the schema root (loaded as inputName) and the
+// schemas it imports, using resolver to load imports. Diagnostics are sorted
+// by position.
+func Check(resolver resolve.Resolver, inputName string, root *kaitai.Struct) []Diagnostic {
+	c := &checker{
+		ctx:       engine.NewContext(),
+		values:    make(map[*kaitai.Struct]*engine.ExprValue),
+		roots:     make(map[*kaitai.Struct]*engine.ExprValue),
+		parents:   make(map[*kaitai.Struct]*engine.ExprValue),
+		usedTypes: make(map[*kaitai.Struct]bool),
+		usedEnums: make(map[*kaitai.Enum]bool),
+	}
+	c.loadGraph(resolve.NewGraph(resolver, inputName, root))
+	for _, m := range c.modules {
+		c.buildValues(m)
+	}
+	for _, m := range c.modules {
+		c.checkStruct(m, c.roots[m])
+	}
+	for _, m := range c.modules {
+		c.checkUnused(m, true)
+	}
+	slices.SortStableFunc(c.diags, func(a, b Diagnostic) int {
+		return cmp.Or(
+			cmp.Compare(a.Pos.File, b.Pos.File),
+			cmp.Compare(a.Pos.Line, b.Pos.Line),
+			cmp.Compare(a.Pos.Column, b.Pos.Column),
+		)
+	})
+	return c.diags
+}
+
+// The types checkExpr expects of an expression. anyType accepts any value.
+var (
+	anyType    engine.InferredType
+	intType    = engine.InferredType{ValueType: engine.IntegerValueType}
+	boolType   = engine.InferredType{ValueType: engine.BooleanValueType}
+	streamType = engine.InferredType{ValueType: engine.ValueType{Type: types.Type{TypeRef: &types.TypeRef{Kind: types.User, User: &types.UserType{Name: "io"}}}}}
+)
+
+type checker struct {
+	ctx *engine.Context
+
+	// modules holds every schema root, in dependency order.
+	modules []*kaitai.Struct
+
+	// roots maps each schema root to its root value symbol; values maps every
+	// struct in the graph to the value symbol used as its local scope.
+	roots  map[*kaitai.Struct]*engine.ExprValue
+	values map[*kaitai.Struct]*engine.ExprValue
+
+	// parents merges the inferred parent types of every module.
+	parents map[*kaitai.Struct]*engine.ExprValue
+
+	usedTypes map[*kaitai.Struct]bool
+	usedEnums map[*kaitai.Enum]bool
+
+	diags []Diagnostic
+}
+
+func (c *checker) report(pos srcpos.Pos, sev Severity, code string, format string, args ...any) {
+	d := Diagnostic{
+		Pos:      pos,
+		Severity: sev,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	// The same expression can be reachable from more than one place (e.g. an
+	// attr's size: is also stored on its bytes or str type).
+	if slices.Contains(c.diags, d) {
+		return
+	}
+	c.diags = append(c.diags, d)
+}
+
+// loadGraph registers every schema root in the import graph and reports
+// failed imports and import cycles.
+func (c *checker) loadGraph(graph *resolve.Graph) {
+	for _, failed := range graph.Failed {
+		c.report(failed.Pos, SeverityError, CodeUnresolvedImport, "cannot import %q: %v", failed.Import, failed.Err)
+	}
+	for _, cycle := range graph.Cycles {
+		// Report the cycle at the import that closes it.
+		last := cycle[len(cycle)-1]
+		pos := last.Struct.Source
+		for i, name := range last.Struct.Meta.Imports {
+			if m, err := graph.Import(last.Name, name); err == nil && m == cycle[0] && i < len(last.Struct.Meta.ImportSources) {
+				pos = last.Struct.Meta.ImportSources[i]
+				break
+			}
+		}
+		c.report(pos, SeverityWarning, CodeImportCycle, "import cycle: %s", cycle)
+	}
+	for _, m := range graph.Modules() {
+		s := m.Struct
+		if _, ok := c.roots[s]; ok {
+			continue
+		}
+		typeSym := engine.NewStructSymbol(s, nil)
+		c.ctx.AddGlobalType(string(s.ID), typeSym)
+		c.ctx.AddModuleType(string(s.ID), typeSym)
+		c.roots[s] = typeSym
+		c.modules = append(c.modules, s)
+	}
+}
+
+// buildValues creates value symbols for a module's structs and links each
+// one to its inferred parent, mirroring how the emitters set up scopes.
+func (c *checker) buildValues(root *kaitai.Struct) {
+	rootType := c.roots[root]
+	pt := engine.BuildParentTypeMap(c.ctx, rootType)
+	for ks, parent := range pt.Inferred {
+		if existing, ok := c.parents[ks]; ok && existing != parent {
+			c.parents[ks] = nil
+			continue
+		}
+		c.parents[ks] = parent
+	}
+
+	var walk func(typ, parent *engine.ExprValue)
+	walk = func(typ, parent *engine.ExprValue) {
+		val := engine.NewStructValueSymbol(typ, parent)
+		c.values[typ.Struct.Type] = val
+		for _, child := range typ.Struct.Structs {
+			walk(child, val)
+		}
+	}
+	walk(rootType, nil)
+	c.roots[root] = c.values[root]
+
+	var link func(typ *engine.ExprValue)
+	link = func(typ *engine.ExprValue) {
+		for _, child := range typ.Struct.Structs {
+			if inferred := c.parents[child.Struct.Type]; inferred != nil {
+				if pv, ok := c.values[inferred.Struct.Type]; ok {
+					c.values[child.Struct.Type].Parent = pv
+				}
+			}
+			link(child)
+		}
+	}
+	link(rootType)
+}
+
+// scope is the checking state for a single struct.
+type scope struct {
+	module *kaitai.Struct
+	struc  *kaitai.Struct
+	val    *engine.ExprValue
+	ctx    *engine.Context
+}
+
+func (c *checker) checkStruct(module *kaitai.Struct, val *engine.ExprValue) {
+	ks := val.Struct.Type
+	s := &scope{
+		module: module,
+		struc:  ks,
+		val:    val,
+		ctx:    c.ctx.WithModuleRoot(c.roots[module]).WithLocalRoot(val),
+	}
+
+	for _, param := range ks.Params {
+		c.checkParam(s, param)
+	}
+	for _, attr := range ks.Seq {
+		c.checkAttr(s, attr)
+	}
+	for _, inst := range ks.Instances {
+		c.checkAttr(s, inst)
+	}
+	c.checkTypes(s)
+	for _, child := range ks.Structs {
+		c.checkStruct(module, c.values[child])
+	}
+}
+
+// checkTypes reports the type errors found by the engine's type inference,
+// such as arithmetic on strings or a non-boolean ternary condition.
+func (c *checker) checkTypes(s *scope) {
+	for _, err := range engine.InferStruct(s.ctx, s.val).Errors {
+		if c.dependsOnUnprovenParent(s, err.Expr.Root) {
+			continue
+		}
+		pos := offsetPos(err.Expr, expr.SpanOf(err.Node).Start, s.struc.Source)
+		c.report(pos, SeverityError, CodeTypeMismatch, "%s", err.Message)
+	}
+}
+
+func (c *checker) checkParam(s *scope, param *kaitai.Param) {
+	if param.Type.Kind == types.User {
+		switch param.Type.User.Name {
+		case "bool", "struct", "io", "any":
+		default:
+			c.resolveStructType(s, param.Source, param.Type.User.Name)
+		}
+	}
+	if param.Enum != "" {
+		c.resolveEnum(s, param.Source, param.Enum)
+	}
+}
+
+func (c *checker) checkAttr(s *scope, attr *kaitai.Attr) {
+	pos := attr.Source
+
+	if ref := attr.Type.TypeRef; ref != nil {
+		c.checkTypeRef(s, pos, ref)
+	}
+	if sw := attr.Type.TypeSwitch; sw != nil {
+		c.checkSwitch(s, pos, sw)
+	}
+	if attr.Enum != "" {
+		c.resolveEnum(s, pos, attr.Enum)
+	}
+
+	c.checkExpr(s, pos, attr.If, boolType, "if")
+	c.checkExpr(s, pos, attr.Size, intType, "size")
+	c.checkExpr(s, pos, attr.Pos, intType, "pos")
+	c.checkExpr(s, pos, attr.IO, streamType, "io")
+	c.checkExpr(s, pos, attr.Value, anyType, "value")
+	if attr.Process != nil {
+		c.checkProcess(s, pos, attr.Process)
+	}
+
+	switch r := attr.Repeat.(type) {
+	case types.RepeatExpr:
+		c.checkExpr(s, pos, r.CountExpr, intType, "repeat-expr")
+	case types.RepeatUntil:
+		elem := &engine.ExprValue{Kind: engine.AttrKind, Parent: s.val, Attr: &kaitai.Attr{ID: attr.ID, Type: attr.Type, Enum: attr.Enum}}
+		until := &scope{module: s.module, struc: s.struc, val: s.val, ctx: s.ctx.WithTemporary(elem)}
+		c.checkExpr(until, pos, r.UntilExpr, boolType, "repeat-until")
+	}
+}
+
+// checkProcess checks the arguments of a process: call. The algorithm name
+// itself (xor, zlib, a custom processor) isn't a symbol, so it is skipped.
+func (c *checker) checkProcess(s *scope, pos srcpos.Pos, e *expr.Expr) {
+	call, ok := e.Root.(expr.CallNode)
+	if !ok {
+		return
+	}
+	for _, arg := range call.Args {
+		c.checkNode(s, e, exprPos(e, pos), arg)
+	}
+}
+
+// checkTypeRef checks a (non-switch) type reference of an attribute.
+func (c *checker) checkTypeRef(s *scope, pos srcpos.Pos, ref *types.TypeRef) {
+	switch ref.Kind {
+	case types.Bytes:
+		c.checkExpr(s, pos, ref.Bytes.Size, intType, "size")
+	case types.String:
+		c.checkExpr(s, pos, ref.String.Size, intType, "size")
+		if _, err := charset.Lookup(ref.String.Encoding); err != nil {
+			c.report(pos, SeverityError, CodeUnknownEncoding, "%v", err)
+		}
+	case types.User:
+		c.checkExpr(s, pos, ref.User.Size, intType, "size")
+		target := c.resolveStructType(s, pos, ref.User.Name)
+		for _, arg := range ref.User.Params {
+			c.checkExpr(s, pos, arg, anyType, "parameter")
+		}
+		if target != nil && target.Struct != nil && !target.Struct.Opaque {
+			if want, got := len(target.Struct.Type.Params), len(ref.User.Params); want != got {
+				c.report(pos, SeverityError, CodeParamCount, "type %s takes %d parameter(s), got %d", ref.User.Name, want, got)
+			}
+		}
+	}
+}
+
+// checkSwitch checks a switch-on type: the switch expression, each case type,
+// and that each case value has the same type as the switch value.
+func (c *checker) checkSwitch(s *scope, pos srcpos.Pos, sw *types.TypeSwitch) {
+	if sw.SwitchOn != nil {
+		pos = exprPos(sw.SwitchOn, pos)
+	}
+	c.checkExpr(s, pos, sw.SwitchOn, anyType, "switch-on")
+	var on engine.InferredType
+	if sw.SwitchOn != nil && !c.dependsOnUnprovenParent(s, sw.SwitchOn.Root) {
+		on = c.inferType(s, sw.SwitchOn)
+	}
+
+	for _, key := range sortedKeys(sw.Cases) {
+		ref := sw.Cases[key]
+		c.checkTypeRef(s, pos, &ref)
+		if key == "_" {
+			continue
+		}
+		e, err := expr.ParseExpr(key)
+		if err != nil {
+			c.report(pos, SeverityError, CodeUnresolvedName, "parsing case %q: %v", key, err)
+			continue
+		}
+		if !c.checkNode(s, e, pos, e.Root) {
+			continue
+		}
+		// Case keys aren't expressions of the struct, so checkTypes doesn't
+		// see their type errors.
+		typed, errs := engine.InferExpr(s.ctx, e)
+		for _, err := range errs {
+			c.report(pos, SeverityError, CodeTypeMismatch, "case %s: %s", key, err.Message)
+		}
+		if got := typed.Type(); !engine.Comparable(on, got) {
+			c.report(pos, SeverityError, CodeSwitchCase, "case %s is %s, but switch-on value %s is %s", key, got, sw.SwitchOn.Root, on)
+		}
+	}
+}
+
+// checkExpr checks that every name in e resolves and, if want is known, that
+// e evaluates to a value assignable to want.
+func (c *checker) checkExpr(s *scope, pos srcpos.Pos, e *expr.Expr, want engine.InferredType, what string) {
+	if e == nil {
+		return
+	}
+	pos = exprPos(e, pos)
+	if !c.checkNode(s, e, pos, e.Root) {
+		return
+	}
+	if !want.IsKnown() || c.dependsOnUnprovenParent(s, e.Root) {
+		return
+	}
+	if got := c.inferType(s, e); !got.AssignableTo(want) {
+		c.report(pos, SeverityError, CodeTypeMismatch, "%s expression %s is %s, expected %s", what, e.Root, got, want)
+	}
+}
+
+// inferType returns the inferred type of e in scope s. Type errors within e
+// are left to checkTypes, which reports them once per struct.
+func (c *checker) inferType(s *scope, e *expr.Expr) engine.InferredType {
+	typed, _ := engine.InferExpr(s.ctx, e)
+	return typed.Type()
+}
+
+func exprPos(e *expr.Expr, fallback srcpos.Pos) srcpos.Pos {
+	if e.Source.IsValid() {
+		return e.Source
+	}
+	return fallback
+}
+
+// offsetPos returns the position of a byte offset into e's text, or fallback
+// if e's position is unknown.
+func offsetPos(e *expr.Expr, off int, fallback srcpos.Pos) srcpos.Pos {
+	if e.Source.IsValid() {
+		return e.OffsetPos(off)
+	}
+	return fallback
+}
+
+// checkNode reports unresolvable names in node, a node of e, at the node's
+// position in e (or at fallback, if e's position is unknown). It returns
+// false if anything was reported, so callers can skip type checks that would
+// only repeat the same problem.
+func (c *checker) checkNode(s *scope, e *expr.Expr, fallback srcpos.Pos, node expr.Node) bool {
+	pos := offsetPos(e, expr.SpanOf(node).Start, fallback)
+	switch node := node.(type) {
+	case expr.IdentNode:
+		if node.Identifier == "_parent" {
+			return c.checkParentChain(s, pos, 1)
+		}
+		if v, _ := s.ctx.Resolve(node.Identifier); v == nil {
+			c.report(pos, SeverityError, CodeUnresolvedName, "unknown identifier %q in %s", node.Identifier, s.struc.ID)
+			return false
+		}
+		return true
+
+	case expr.MemberNode:
+		if depth, ok := parentDepth(node); ok {
+			return c.checkParentChain(s, pos, depth)
+		}
+		if !c.checkNode(s, e, fallback, node.Operand) {
+			return false
+		}
+		if hasParentMember(node.Operand) {
+			// x._parent.y: the parent of a child value isn't tracked well
+			// enough to check y.
+			return true
+		}
+		switch node.Property {
+		case "_parent", "_root", "_io", "_sizeof":
+			return true
+		}
+		op := engine.ResultTypeOfNode(s.ctx, node.Operand)
+		if op == nil || op.Child(node.Property) != nil {
+			return true
+		}
+		val := engine.NewValueOf(s.ctx, op)
+		if val == nil || val.Child(node.Property) != nil {
+			return true
+		}
+		if val.Kind == engine.StructKind && val.Struct != nil && !val.Struct.Opaque {
+			// Point at the property rather than the start of the chain.
+			pos = offsetPos(e, node.PropertySpan().Start, fallback)
+			c.report(pos, SeverityError, CodeUnresolvedName, "type %s has no member %q", val.Struct.Type.ID, node.Property)
+			return false
+		}
+		return true
+
+	case expr.ScopeNode:
+		return c.resolveScope(s, pos, node) != nil
+
+	case expr.CastNode:
+		ok := c.checkNode(s, e, fallback, node.Operand)
+		return c.checkTypeName(s, pos, node.TypeName) && ok
+
+	case expr.SizeofNode:
+		return c.checkTypeName(s, pos, node.TypeName)
+
+	case expr.BitSizeofNode:
+		return c.checkTypeName(s, pos, node.TypeName)
+
+	case expr.CallNode:
+		ok := c.checkNode(s, e, fallback, node.Object)
+		for _, arg := range node.Args {
+			ok = c.checkNode(s, e, fallback, arg) && ok
+		}
+		return ok
+
+	case expr.UnaryNode:
+		return c.checkNode(s, e, fallback, node.Operand)
+
+	case expr.BinaryNode:
+		ok := c.checkNode(s, e, fallback, node.A)
+		return c.checkNode(s, e, fallback, node.B) && ok
+
+	case expr.TernaryNode:
+		ok := c.checkNode(s, e, fallback, node.A)
+		ok = c.checkNode(s, e, fallback, node.B) && ok
+		return c.checkNode(s, e, fallback, node.C) && ok
+
+	case expr.SubscriptNode:
+		ok := c.checkNode(s, e, fallback, node.A)
+		return c.checkNode(s, e, fallback, node.B) && ok
+
+	case expr.ArrayNode:
+		ok := true
+		for _, item := range node.Items {
+			ok = c.checkNode(s, e, fallback, item) && ok
+		}
+		return ok
+
+	case expr.FStringNode:
+		ok := true
+		for _, part := range node.Parts {
+			if part.Expr != nil {
+				ok = c.checkNode(s, e, fallback, part.Expr) && ok
+			}
+		}
+		return ok
+	}
+	return true
+}
+
+// checkTypeName checks a type name used in .as<>, sizeof<> or bitsizeof<>.
+func (c *checker) checkTypeName(s *scope, pos srcpos.Pos, name string) bool {
+	ref, err := types.ParseTypeRef(name)
+	if err == nil && ref.Kind != types.User {
+		return true
+	}
+	if err == nil {
+		name = ref.User.Name
+	}
+	return c.resolveType(s, pos, name) != nil
+}
+
+// checkParentChain checks an expression that starts with depth levels of
+// _parent, warning if the parent-type inference can't tell what they are.
+// It returns false if the chain can't be proven, so the rest of the
+// expression isn't checked against a guessed type.
+func (c *checker) checkParentChain(s *scope, pos srcpos.Pos, depth int) bool {
+	cur := s.struc
+	for range depth {
+		parent, ok := c.parents[cur]
+		switch {
+		case !ok:
+			c.report(pos, SeverityWarning, CodeUnprovenParent, "type %s is never used as a field type, so the type of its _parent is unknown", cur.ID)
+			return false
+		case parent == nil:
+			c.report(pos, SeverityWarning, CodeUnprovenParent, "type %s is used from more than one parent type, so the type of its _parent is ambiguous", cur.ID)
+			return false
+		}
+		cur = parent.Struct.Type
+	}
+	return true
+}
+
+// dependsOnUnprovenParent returns true if node goes through a _parent whose
+// type can't be inferred, in which case its static type is unreliable.
+func (c *checker) dependsOnUnprovenParent(s *scope, node expr.Node) bool {
+	unproven := false
+	walkNodes(node, func(n expr.Node) {
+		depth := 0
+		switch n := n.(type) {
+		case expr.IdentNode:
+			if n.Identifier == "_parent" {
+				depth = 1
+			}
+		case expr.MemberNode:
+			depth, _ = parentDepth(n)
+		}
+		cur := s.struc
+		for range depth {
+			parent := c.parents[cur]
+			if parent == nil {
+				unproven = true
+				return
+			}
+			cur = parent.Struct.Type
+		}
+	})
+	return unproven
+}
+
+// parentDepth returns the number of _parent accesses in a chain like
+// _parent._parent, if node is exactly such a chain.
+func parentDepth(node expr.Node) (int, bool) {
+	switch node := node.(type) {
+	case expr.IdentNode:
+		return 1, node.Identifier == "_parent"
+	case expr.MemberNode:
+		if node.Property != "_parent" {
+			return 0, false
+		}
+		depth, ok := parentDepth(node.Operand)
+		return depth + 1, ok
+	}
+	return 0, false
+}
+
+// hasParentMember returns true if node is a member chain that contains a
+// ._parent access.
+func hasParentMember(node expr.Node) bool {
+	for {
+		switch n := node.(type) {
+		case expr.MemberNode:
+			if n.Property == "_parent" {
+				return true
+			}
+			node = n.Operand
+		case expr.CallNode:
+			node = n.Object
+		case expr.SubscriptNode:
+			node = n.A
+		default:
+			return false
+		}
+	}
+}
+
+func walkNodes(node expr.Node, fn func(expr.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+	switch node := node.(type) {
+	case expr.MemberNode:
+		walkNodes(node.Operand, fn)
+	case expr.CastNode:
+		walkNodes(node.Operand, fn)
+	case expr.CallNode:
+		walkNodes(node.Object, fn)
+		for _, arg := range node.Args {
+			walkNodes(arg, fn)
+		}
+	case expr.UnaryNode:
+		walkNodes(node.Operand, fn)
+	case expr.BinaryNode:
+		walkNodes(node.A, fn)
+		walkNodes(node.B, fn)
+	case expr.TernaryNode:
+		walkNodes(node.A, fn)
+		walkNodes(node.B, fn)
+		walkNodes(node.C, fn)
+	case expr.SubscriptNode:
+		walkNodes(node.A, fn)
+		walkNodes(node.B, fn)
+	case expr.ArrayNode:
+		for _, item := range node.Items {
+			walkNodes(item, fn)
+		}
+	case expr.FStringNode:
+		for _, part := range node.Parts {
+			if part.Expr != nil {
+				walkNodes(part.Expr, fn)
+			}
+		}
+	}
+}
+
+// resolveScope resolves a scoped name like enum_name::value, reporting an
+// error if it doesn't exist.
+func (c *checker) resolveScope(s *scope, pos srcpos.Pos, node expr.ScopeNode) *engine.ExprValue {
+	name := scopeName(node.Operand)
+	if name == "" {
+		return nil
+	}
+	owner := c.resolveType(s, pos, name)
+	if owner == nil {
+		return nil
+	}
+	typ := owner.TypeChild(node.Type)
+	if typ == nil {
+		c.report(pos, SeverityError, CodeUnresolvedName, "%s has no member %q", name, node.Type)
+		return nil
+	}
+	return typ
+}
+
+// scopeName converts a chain of identifiers and scopes back into a
+// qualified name like a::b.
+func scopeName(node expr.Node) string {
+	switch node := node.(type) {
+	case expr.IdentNode:
+		return node.Identifier
+	case expr.ScopeNode:
+		if op := scopeName(node.Operand); op != "" {
+			return op + "::" + node.Type
+		}
+	}
+	return ""
+}
+
+// lookupType resolves a (possibly qualified) type or enum name lexically
+// from s, then in the module and global scopes.
+func (c *checker) lookupType(s *scope, name string) *engine.ExprValue {
+	typ := s.ctx.ResolveQualifiedTypeInScope(name, s.val)
+	if typ == nil {
+		if e, err := expr.ParseExpr(name); err == nil {
+			typ = engine.ResolveTypeOfExpr(s.ctx, e)
+		}
+	}
+	switch {
+	case typ == nil:
+	case typ.Kind == engine.StructKind && typ.Struct != nil:
+		c.usedTypes[typ.Struct.Type] = true
+	case typ.Kind == engine.EnumKind:
+		c.usedEnums[typ.Enum] = true
+	}
+	return typ
+}
+
+func (c *checker) resolveType(s *scope, pos srcpos.Pos, name string) *engine.ExprValue {
+	typ := c.lookupType(s, name)
+	if typ == nil {
+		c.report(pos, SeverityError, CodeUnresolvedType, "unresolved type %q in %s", name, s.struc.ID)
+	}
+	return typ
+}
+
+func (c *checker) resolveStructType(s *scope, pos srcpos.Pos, name string) *engine.ExprValue {
+	if s.module.Meta.OpaqueTypes {
+		if typ := c.lookupType(s, name); typ != nil {
+			return typ
+		}
+		return nil
+	}
+	typ := c.resolveType(s, pos, name)
+	if typ != nil && typ.Kind != engine.StructKind {
+		c.report(pos, SeverityError, CodeUnresolvedType, "%s is not a type", name)
+		return nil
+	}
+	return typ
+}
+
+func (c *checker) resolveEnum(s *scope, pos srcpos.Pos, name string) {
+	typ := c.lookupType(s, name)
+	switch {
+	case typ == nil:
+		c.report(pos, SeverityError, CodeUnresolvedEnum, "unresolved enum %q in %s", name, s.struc.ID)
+	case typ.Kind != engine.EnumKind:
+		c.report(pos, SeverityError, CodeUnresolvedEnum, "%s is not an enum", name)
+	}
+}
+
+// checkUnused reports nested types and enums of ks that are never referenced.
+func (c *checker) checkUnused(ks *kaitai.Struct, isRoot bool) {
+	if !isRoot && !c.usedTypes[ks] {
+		c.report(ks.Source, SeverityWarning, CodeUnusedType, "type %s is never used", ks.ID)
+	}
+	for _, enum := range ks.Enums {
+		if !c.usedEnums[enum] {
+			c.report(enum.Source, SeverityWarning, CodeUnusedEnum, "enum %s is never used", enum.ID)
+		}
+	}
+	for _, child := range ks.Structs {
+		c.checkUnused(child, false)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,336 @@
+package check
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkSource(t *testing.T, files map[string]string) []Diagnostic {
+	t.Helper()
+	fsys := fstest.MapFS{}
+	for name, src := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(src)}
+	}
+	resolver := resolve.NewFSResolver(fsys)
+	basename, struc, err := resolver.Resolve("", "main.ksy")
+	require.NoError(t, err)
+	return Check(resolver, basename, struc)
+}
+
+func codes(diags []Diagnostic) []string {
+	result := []string{}
+	for _, d := range diags {
+		result = append(result, d.Code)
+	}
+	return result
+}
+
+func TestCheckClean(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: len
+    type: u1
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: body
+    size: len
+    type: child
+    if: kind == kinds::one
+instances:
+  count:
+    value: body.items.size
+types:
+  child:
+    seq:
+      - id: items
+        type: u1
+        repeat: expr
+        repeat-expr: _parent.len
+enums:
+  kinds:
+    1: one
+`})
+	assert.Empty(t, diags)
+}
+
+func TestCheckDiagnostics(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: len
+    type: u1
+  - id: name
+    type: str
+    size: name_len
+    encoding: ASCII
+  - id: flag
+    type: u1
+    if: len
+  - id: body
+    type: missing_type
+  - id: sw
+    type:
+      switch-on: len
+      cases:
+        '"a"': child
+  - id: child2
+    type: child(1)
+  - id: e
+    type: u1
+    enum: nope
+types:
+  child:
+    seq:
+      - id: x
+        size: _parent.len
+  orphan:
+    seq:
+      - id: y
+        size: _parent.len
+enums:
+  unused_enum:
+    1: one
+`})
+	for _, d := range diags {
+		t.Log(d)
+	}
+	assert.Equal(t, []string{
+		CodeUnresolvedName,
+		CodeTypeMismatch,
+		CodeUnresolvedType,
+		CodeSwitchCase,
+		CodeParamCount,
+		CodeUnresolvedEnum,
+		CodeUnusedType,
+		CodeUnprovenParent,
+		CodeUnusedEnum,
+	}, codes(diags))
+	assert.True(t, HasErrors(diags))
+
+	d := diags[0]
+	assert.Equal(t, "main.ksy", d.Pos.File)
+	assert.Equal(t, 10, d.Pos.Line)
+	assert.Equal(t, SeverityError, d.Severity)
+	assert.Contains(t, d.Message, "name_len")
+}
+
+func TestCheckSubexpressionPositions(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+seq:
+  - id: hdr
+    type: header
+  - id: body
+    size: hdr.len + hdr.nope.x
+    if: "1 + missing > 0"
+types:
+  header:
+    seq:
+      - id: len
+        type: u1
+`})
+	for _, d := range diags {
+		t.Log(d)
+	}
+	require.Len(t, diags, 2)
+	assert.Contains(t, diags[0].Message, `no member "nope"`)
+	assert.Equal(t, 8, diags[0].Pos.Line)
+	assert.Equal(t, 25, diags[0].Pos.Column)
+	assert.Contains(t, diags[1].Message, `"missing"`)
+	assert.Equal(t, 9, diags[1].Pos.Line)
+	assert.Equal(t, 14, diags[1].Pos.Column)
+}
+
+func TestCheckOperatorTypes(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+seq:
+  - id: name
+    type: strz
+    encoding: ASCII
+  - id: len
+    type: u1
+instances:
+  total:
+    value: len + name
+  half:
+    value: 'len > 1 ? len / 2 : 0'
+`})
+	for _, d := range diags {
+		t.Log(d)
+	}
+	require.Len(t, diags, 1)
+	assert.Equal(t, CodeTypeMismatch, diags[0].Code)
+	assert.Equal(t, "invalid operation: len + name (mismatched types u1 and str)", diags[0].Message)
+	assert.Equal(t, 12, diags[0].Pos.Line)
+	assert.Equal(t, 12, diags[0].Pos.Column)
+}
+
+func TestCheckInferredTypes(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+seq:
+  - id: len
+    type: u1
+  - id: body
+    size: is_long
+  - id: tail
+    type:
+      switch-on: is_long
+      cases:
+        1: u1
+instances:
+  is_long:
+    value: len > 3
+`})
+	for _, d := range diags {
+		t.Log(d)
+	}
+	require.Len(t, diags, 2)
+	assert.Equal(t, CodeTypeMismatch, diags[0].Code)
+	assert.Equal(t, "size expression is_long is bool, expected int", diags[0].Message)
+	assert.Equal(t, CodeSwitchCase, diags[1].Code)
+	assert.Equal(t, "case 1 is int, but switch-on value is_long is bool", diags[1].Message)
+}
+
+func TestCheckAmbiguousParent(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+seq:
+  - id: a
+    type: holder_a
+  - id: b
+    type: holder_b
+types:
+  holder_a:
+    seq:
+      - id: n
+        type: u1
+      - id: c
+        type: shared
+  holder_b:
+    seq:
+      - id: c
+        type: shared
+  shared:
+    seq:
+      - id: x
+        size: _parent.n
+`})
+	require.Len(t, diags, 1)
+	assert.Equal(t, CodeUnprovenParent, diags[0].Code)
+	assert.Equal(t, SeverityWarning, diags[0].Severity)
+	assert.False(t, HasErrors(diags))
+}
+
+func TestCheckEncodings(t *testing.T) {
+	diags := checkSource(t, map[string]string{"main.ksy": `
+meta:
+  id: main
+  encoding: koi8-r
+seq:
+  - id: a
+    type: strz
+  - id: b
+    type: strz
+    encoding: UTF-32LE
+  - id: c
+    type: strz
+    encoding: EBCDIC-XYZ
+`})
+	require.Len(t, diags, 1)
+	assert.Equal(t, CodeUnknownEncoding, diags[0].Code)
+	assert.Equal(t, SeverityError, diags[0].Severity)
+	assert.Contains(t, diags[0].Message, "EBCDIC-XYZ")
+}
+
+func TestCheckImports(t *testing.T) {
+	diags := checkSource(t, map[string]string{
+		"main.ksy": `
+meta:
+  id: main
+  imports:
+    - dep
+    - missing
+seq:
+  - id: d
+    type: dep
+`,
+		"dep.ksy": `
+meta:
+  id: dep
+seq:
+  - id: x
+    type: u1
+    enum: nope
+`,
+	})
+	assert.Equal(t, []string{CodeUnresolvedEnum, CodeUnresolvedImport}, codes(diags))
+	assert.Equal(t, "dep.ksy", diags[0].Pos.File)
+}
+
+func TestCheckImportCycle(t *testing.T) {
+	diags := checkSource(t, map[string]string{
+		"main.ksy": `
+meta:
+  id: main
+  imports:
+    - dep
+seq:
+  - id: d
+    type: dep
+`,
+		"dep.ksy": `
+meta:
+  id: dep
+  imports:
+    - main
+seq:
+  - id: x
+    type: u1
+`,
+	})
+	require.Len(t, diags, 1)
+	assert.Equal(t, CodeImportCycle, diags[0].Code)
+	assert.Equal(t, SeverityWarning, diags[0].Severity)
+	assert.Equal(t, "dep.ksy", diags[0].Pos.File)
+	assert.Equal(t, 5, diags[0].Pos.Line)
+}
+
+// TestCheckFormats makes sure the checker doesn't report errors for any of
+// the known-good test formats.
+func TestCheckFormats(t *testing.T) {
+	dir := "../../testdata/formats"
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	resolver := resolve.NewOSResolverWithPaths([]string{dir})
+	for _, ent := range entries {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".ksy") {
+			continue
+		}
+		t.Run(ent.Name(), func(t *testing.T) {
+			basename, struc, err := resolver.Resolve("", filepath.Join(dir, ent.Name()))
+			require.NoError(t, err)
+			for _, d := range Check(resolver, basename, struc) {
+				assert.NotEqual(t, SeverityError, d.Severity, "%s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,603 @@
+// Package diff compares two versions of a .ksy schema and classifies each
+// difference by whether it breaks existing data, generated code, or neither.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/emitter/c"
+	"github.com/jchv/zanbato/kaitai/emitter/golang"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// Impact classifies a change.
+type Impact int
+
+// Impacts, from least to most severe.
+const (
+	// Compatible changes keep both the binary layout and the generated API.
+	Compatible Impact = iota
+	// APIBreaking changes keep the binary layout, but rename or remove
+	// generated identifiers or change their types.
+	APIBreaking
+	// WireBreaking changes alter how existing data is parsed.
+	WireBreaking
+)
+
+func (i Impact) String() string {
+	switch i {
+	case Compatible:
+		return "compatible"
+	case APIBreaking:
+		return "api-breaking"
+	case WireBreaking:
+		return "wire-breaking"
+	}
+	return fmt.Sprintf("Impact(%d)", int(i))
+}
+
+// IdentChange is a generated identifier that a change adds, removes or
+// renames. Old is empty for added identifiers and New for removed ones.
+type IdentChange struct {
+	Old, New string
+}
+
+func (i IdentChange) String() string {
+	switch {
+	case i.Old == "":
+		return "+" + i.New
+	case i.New == "":
+		return "-" + i.Old
+	}
+	return i.Old + " -> " + i.New
+}
+
+// Change is a single difference between two schemas.
+type Change struct {
+	Impact Impact
+	// Path names the changed element, such as main::header.magic for a field
+	// or main::kinds::one for an enum value.
+	Path    string
+	Message string
+	// Old and New are where the element is declared in each version, if it
+	// exists there.
+	Old, New srcpos.Pos
+
+	// Go and C list the generated identifiers that the change affects.
+	Go []IdentChange
+	C  []IdentChange
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s: %s", c.Impact, c.Path, c.Message)
+}
+
+// Worst returns the most severe impact among changes, or Compatible if there
+// are none.
+func Worst(changes []Change) Impact {
+	worst := Compatible
+	for _, c := range changes {
+		worst = max(worst, c.Impact)
+	}
+	return worst
+}
+
+// Diff compares the old and new versions of a schema. Modules are matched by
+// their meta/id: the roots are compared with each other, and so are imported
+// modules present in both graphs.
+func Diff(oldGraph, newGraph *resolve.Graph) []Change {
+	d := &differ{
+		old:      oldGraph,
+		new:      newGraph,
+		compared: make(map[[2]*kaitai.Struct]bool),
+	}
+	d.structs(&frame{s: oldGraph.Root.Struct}, &frame{s: newGraph.Root.Struct})
+
+	newModules := make(map[kaitai.Identifier]*kaitai.Struct)
+	for _, m := range newGraph.Modules() {
+		newModules[m.Struct.ID] = m.Struct
+	}
+	for _, m := range oldGraph.Modules() {
+		if m == oldGraph.Root {
+			continue
+		}
+		if n, ok := newModules[m.Struct.ID]; ok && n != newGraph.Root.Struct {
+			d.structs(&frame{s: m.Struct}, &frame{s: n})
+		}
+	}
+	return d.changes
+}
+
+// frame is a struct along with the structs it is nested in, which it
+// inherits endianness and type names from.
+type frame struct {
+	s      *kaitai.Struct
+	parent *frame
+}
+
+func (f *frame) path() []kaitai.Identifier {
+	if f == nil {
+		return nil
+	}
+	return append(f.parent.path(), f.s.ID)
+}
+
+func (f *frame) name() string {
+	ids := f.path()
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = string(id)
+	}
+	return strings.Join(names, "::")
+}
+
+func (f *frame) child(s *kaitai.Struct) *frame {
+	return &frame{s: s, parent: f}
+}
+
+func (f *frame) endian() types.EndianKind {
+	for ; f != nil; f = f.parent {
+		if f.s.Meta.Endian.Kind != types.UnspecifiedOrder {
+			return f.s.Meta.Endian.Kind
+		}
+	}
+	return types.UnspecifiedOrder
+}
+
+func (f *frame) bitEndian() types.BitEndianKind {
+	for ; f != nil; f = f.parent {
+		if f.s.Meta.BitEndian.Kind != types.UnspecifiedBitOrder {
+			return f.s.Meta.BitEndian.Kind
+		}
+	}
+	return types.UnspecifiedBitOrder
+}
+
+type differ struct {
+	old, new *resolve.Graph
+	changes  []Change
+
+	// compared records the pairs of structs that have been or are being
+	// compared by sameLayout.
+	compared map[[2]*kaitai.Struct]bool
+}
+
+func (d *differ) report(c Change) {
+	d.changes = append(d.changes, c)
+}
+
+func (d *differ) structs(o, n *frame) {
+	path := o.name()
+
+	d.params(path, o, n)
+	d.seq(path, o, n)
+	d.instances(path, o, n)
+
+	for _, oe := range o.s.Enums {
+		ne := findEnum(n.s.Enums, oe.ID)
+		if ne == nil {
+			d.report(Change{
+				Impact:  APIBreaking,
+				Path:    path + "::" + string(oe.ID),
+				Message: "enum removed",
+				Old:     oe.Source,
+				Go:      []IdentChange{{Old: golang.TypeName(append(o.path(), oe.ID)...)}},
+			})
+			continue
+		}
+		d.enum(path+"::"+string(oe.ID), append(o.path(), oe.ID), append(n.path(), ne.ID), oe, ne)
+	}
+	for _, ne := range n.s.Enums {
+		if findEnum(o.s.Enums, ne.ID) == nil {
+			d.report(Change{
+				Impact:  Compatible,
+				Path:    path + "::" + string(ne.ID),
+				Message: "enum added",
+				New:     ne.Source,
+				Go:      []IdentChange{{New: golang.TypeName(append(n.path(), ne.ID)...)}},
+			})
+		}
+	}
+
+	for _, oChild := range o.s.Structs {
+		nChild := findStruct(n.s.Structs, oChild.ID)
+		if nChild == nil {
+			d.report(Change{
+				Impact:  APIBreaking,
+				Path:    path + "::" + string(oChild.ID),
+				Message: "type removed",
+				Old:     oChild.Source,
+				Go:      []IdentChange{{Old: golang.TypeName(append(o.path(), oChild.ID)...)}},
+				C:       []IdentChange{{Old: c.TypeName(append(o.path(), oChild.ID)...)}},
+			})
+			continue
+		}
+		d.structs(o.child(oChild), n.child(nChild))
+	}
+	for _, nChild := range n.s.Structs {
+		if findStruct(o.s.Structs, nChild.ID) == nil {
+			d.report(Change{
+				Impact:  Compatible,
+				Path:    path + "::" + string(nChild.ID),
+				Message: "type added",
+				New:     nChild.Source,
+				Go:      []IdentChange{{New: golang.TypeName(append(n.path(), nChild.ID)...)}},
+				C:       []IdentChange{{New: c.TypeName(append(n.path(), nChild.ID)...)}},
+			})
+		}
+	}
+}
+
+// fieldIdents returns the generated identifiers of the field id of the
+// struct at path.
+func fieldIdents(path []kaitai.Identifier, id kaitai.Identifier) (goName, cName string) {
+	return golang.TypeName(path...) + "." + golang.FieldName(id), c.TypeName(path...) + "." + c.FieldName(id)
+}
+
+func addedField(path []kaitai.Identifier, id kaitai.Identifier) ([]IdentChange, []IdentChange) {
+	goName, cName := fieldIdents(path, id)
+	return []IdentChange{{New: goName}}, []IdentChange{{New: cName}}
+}
+
+func removedField(path []kaitai.Identifier, id kaitai.Identifier) ([]IdentChange, []IdentChange) {
+	goName, cName := fieldIdents(path, id)
+	return []IdentChange{{Old: goName}}, []IdentChange{{Old: cName}}
+}
+
+func renamedField(oPath []kaitai.Identifier, oID kaitai.Identifier, nPath []kaitai.Identifier, nID kaitai.Identifier) ([]IdentChange, []IdentChange) {
+	oGo, oC := fieldIdents(oPath, oID)
+	nGo, nC := fieldIdents(nPath, nID)
+	return []IdentChange{{Old: oGo, New: nGo}}, []IdentChange{{Old: oC, New: nC}}
+}
+
+func (d *differ) params(path string, o, n *frame) {
+	for i, op := range o.s.Params {
+		if i >= len(n.s.Params) {
+			goIdents, cIdents := removedField(o.path(), op.ID)
+			d.report(Change{Impact: APIBreaking, Path: path + "." + string(op.ID), Message: "param removed", Old: op.Source, Go: goIdents, C: cIdents})
+			continue
+		}
+		np := n.s.Params[i]
+		if op.ID != np.ID {
+			goIdents, cIdents := renamedField(o.path(), op.ID, n.path(), np.ID)
+			d.report(Change{Impact: APIBreaking, Path: path + "." + string(op.ID), Message: fmt.Sprintf("param renamed to %s", np.ID), Old: op.Source, New: np.Source, Go: goIdents, C: cIdents})
+		}
+		if ot, nt := typeRefString(op.Type), typeRefString(np.Type); ot != nt || op.Enum != np.Enum {
+			d.report(Change{Impact: APIBreaking, Path: path + "." + string(np.ID), Message: fmt.Sprintf("param type changed from %s to %s", withEnum(ot, op.Enum), withEnum(nt, np.Enum)), Old: op.Source, New: np.Source})
+		}
+	}
+	for _, np := range n.s.Params[min(len(o.s.Params), len(n.s.Params)):] {
+		goIdents, cIdents := addedField(n.path(), np.ID)
+		d.report(Change{Impact: APIBreaking, Path: path + "." + string(np.ID), Message: "param added", New: np.Source, Go: goIdents, C: cIdents})
+	}
+}
+
+func (d *differ) seq(path string, o, n *frame) {
+	removed := map[kaitai.Identifier]bool{}
+	for _, oa := range o.s.Seq {
+		if findAttr(n.s.Seq, oa.ID) == nil {
+			removed[oa.ID] = true
+		}
+	}
+	added := map[kaitai.Identifier]bool{}
+	for _, na := range n.s.Seq {
+		if findAttr(o.s.Seq, na.ID) == nil {
+			added[na.ID] = true
+		}
+	}
+
+	// A field removed from the same place another one with the same layout
+	// was added at is a rename.
+	for i, oa := range o.s.Seq {
+		if i >= len(n.s.Seq) {
+			break
+		}
+		na := n.s.Seq[i]
+		if !removed[oa.ID] || !added[na.ID] || d.attrLayout(oa, o) != d.attrLayout(na, n) {
+			continue
+		}
+		delete(removed, oa.ID)
+		delete(added, na.ID)
+		goIdents, cIdents := renamedField(o.path(), oa.ID, n.path(), na.ID)
+		d.report(Change{
+			Impact:  APIBreaking,
+			Path:    path + "." + string(oa.ID),
+			Message: fmt.Sprintf("seq field renamed to %s", na.ID),
+			Old:     oa.Source,
+			New:     na.Source,
+			Go:      goIdents,
+			C:       cIdents,
+		})
+		d.attr(path+"."+string(na.ID), oa, na, o, n, true)
+	}
+
+	for _, oa := range o.s.Seq {
+		if removed[oa.ID] {
+			goIdents, cIdents := removedField(o.path(), oa.ID)
+			d.report(Change{Impact: WireBreaking, Path: path + "." + string(oa.ID), Message: "seq field removed", Old: oa.Source, Go: goIdents, C: cIdents})
+		}
+	}
+	for _, na := range n.s.Seq {
+		if added[na.ID] {
+			goIdents, cIdents := addedField(n.path(), na.ID)
+			d.report(Change{Impact: WireBreaking, Path: path + "." + string(na.ID), Message: "seq field added", New: na.Source, Go: goIdents, C: cIdents})
+		}
+	}
+
+	var oOrder, nOrder []string
+	for _, oa := range o.s.Seq {
+		if findAttr(n.s.Seq, oa.ID) != nil {
+			oOrder = append(oOrder, string(oa.ID))
+		}
+	}
+	for _, na := range n.s.Seq {
+		if findAttr(o.s.Seq, na.ID) != nil {
+			nOrder = append(nOrder, string(na.ID))
+		}
+	}
+	if !slices.Equal(oOrder, nOrder) {
+		d.report(Change{
+			Impact:  WireBreaking,
+			Path:    path,
+			Message: fmt.Sprintf("seq fields reordered from [%s] to [%s]", strings.Join(oOrder, ", "), strings.Join(nOrder, ", ")),
+			Old:     o.s.Source,
+			New:     n.s.Source,
+		})
+	}
+
+	for _, oa := range o.s.Seq {
+		if na := findAttr(n.s.Seq, oa.ID); na != nil {
+			d.attr(path+"."+string(oa.ID), oa, na, o, n, true)
+		}
+	}
+}
+
+func (d *differ) instances(path string, o, n *frame) {
+	for _, oi := range o.s.Instances {
+		ni := findAttr(n.s.Instances, oi.ID)
+		if ni == nil {
+			goIdents, cIdents := removedField(o.path(), oi.ID)
+			d.report(Change{Impact: APIBreaking, Path: path + "." + string(oi.ID), Message: "instance removed", Old: oi.Source, Go: goIdents, C: cIdents})
+			continue
+		}
+		d.attr(path+"."+string(oi.ID), oi, ni, o, n, oi.Value == nil && ni.Value == nil)
+	}
+	for _, ni := range n.s.Instances {
+		if findAttr(o.s.Instances, ni.ID) == nil {
+			goIdents, cIdents := addedField(n.path(), ni.ID)
+			d.report(Change{Impact: Compatible, Path: path + "." + string(ni.ID), Message: "instance added", New: ni.Source, Go: goIdents, C: cIdents})
+		}
+	}
+}
+
+// attr compares two versions of a seq field or instance. parsed is false for
+// value instances, whose changes can't affect the wire format.
+func (d *differ) attr(path string, oa, na *kaitai.Attr, o, n *frame, parsed bool) {
+	wire := WireBreaking
+	if !parsed {
+		wire = APIBreaking
+	}
+	report := func(impact Impact, format string, args ...any) {
+		d.report(Change{Impact: impact, Path: path, Message: fmt.Sprintf(format, args...), Old: oa.Source, New: na.Source})
+	}
+
+	if (oa.Value == nil) != (na.Value == nil) {
+		report(WireBreaking, "changed between a value and a parsed instance")
+		return
+	}
+	if oe, ne := exprString(oa.Value), exprString(na.Value); oe != ne {
+		report(Compatible, "value changed from %s to %s", oe, ne)
+	}
+
+	ot, nt := d.typeString(oa.Type, o), d.typeString(na.Type, n)
+	switch {
+	case ot == nt:
+		if oa.Type.TypeRef != nil && oa.Type.TypeRef.Kind == types.User && !d.sameLayout(oa.Type.TypeRef.User.Name, na.Type.TypeRef.User.Name, o, n) {
+			report(wire, "layout of type %s changed", nt)
+		}
+	case endianOnly(oa.Type, na.Type, o, n):
+		report(wire, "endianness changed from %s to %s", ot, nt)
+	case sameWidth(oa.Type, na.Type, o, n):
+		report(APIBreaking, "type changed from %s to %s", ot, nt)
+	case oa.Type.TypeRef != nil && na.Type.TypeRef != nil &&
+		oa.Type.TypeRef.Kind == types.User && na.Type.TypeRef.Kind == types.User &&
+		d.sameLayout(oa.Type.TypeRef.User.Name, na.Type.TypeRef.User.Name, o, n):
+		report(APIBreaking, "type changed from %s to %s", ot, nt)
+	default:
+		report(wire, "type changed from %s to %s", ot, nt)
+	}
+
+	if oSize, nSize := sizeString(oa), sizeString(na); oSize != nSize {
+		report(wire, "size changed from %s to %s", oSize, nSize)
+	}
+	if oRepeat, nRepeat := repeatString(oa.Repeat), repeatString(na.Repeat); oRepeat != nRepeat {
+		report(wire, "repeat changed from %s to %s", oRepeat, nRepeat)
+	}
+	if !bytes.Equal(oa.Contents, na.Contents) {
+		report(wire, "contents changed from %x to %x", oa.Contents, na.Contents)
+	}
+	if oe, ne := encoding(oa.Type), encoding(na.Type); oe != ne {
+		report(wire, "encoding changed from %s to %s", oe, ne)
+	}
+	for _, e := range []struct {
+		what   string
+		o, n   *expr.Expr
+		impact Impact
+	}{
+		{"if", oa.If, na.If, wire},
+		{"process", oa.Process, na.Process, wire},
+		{"pos", oa.Pos, na.Pos, wire},
+		{"io", oa.IO, na.IO, wire},
+	} {
+		if oe, ne := exprString(e.o), exprString(e.n); oe != ne {
+			report(e.impact, "%s changed from %s to %s", e.what, orNone(oe), orNone(ne))
+		}
+	}
+	if oa.Enum != na.Enum {
+		report(APIBreaking, "enum changed from %s to %s", orNone(oa.Enum), orNone(na.Enum))
+	}
+}
+
+// attrLayout summarizes what an attr reads, for matching up renamed fields.
+func (d *differ) attrLayout(a *kaitai.Attr, f *frame) string {
+	return strings.Join([]string{d.typeString(a.Type, f), sizeString(a), repeatString(a.Repeat), fmt.Sprintf("%x", a.Contents), exprString(a.If)}, "|")
+}
+
+// sameLayout reports whether the user types named oName and nName, as seen
+// from o and n, parse the same data the same way.
+func (d *differ) sameLayout(oName, nName string, o, n *frame) bool {
+	of, nf := d.findType(d.old, o, oName), d.findType(d.new, n, nName)
+	if of == nil || nf == nil {
+		return of == nil && nf == nil && oName == nName
+	}
+	key := [2]*kaitai.Struct{of.s, nf.s}
+	if same, ok := d.compared[key]; ok {
+		return same
+	}
+	// Assume recursive references match while comparing.
+	d.compared[key] = true
+	sub := &differ{old: d.old, new: d.new, compared: d.compared}
+	sub.structs(of, nf)
+	same := true
+	for _, c := range sub.changes {
+		if c.Impact == WireBreaking {
+			same = false
+		}
+	}
+	d.compared[key] = same
+	return same
+}
+
+// findType looks up a user type name such as foo::bar as seen from f.
+func (d *differ) findType(graph *resolve.Graph, f *frame, name string) *frame {
+	parts := strings.Split(name, "::")
+	var found *frame
+	for scope := f; scope != nil && found == nil; scope = scope.parent {
+		if s := findStruct(scope.s.Structs, kaitai.Identifier(parts[0])); s != nil {
+			found = scope.child(s)
+		} else if scope.parent == nil && scope.s.ID == kaitai.Identifier(parts[0]) {
+			found = scope
+		}
+	}
+	if found == nil {
+		for _, m := range graph.Modules() {
+			if m.Struct.ID == kaitai.Identifier(parts[0]) {
+				found = &frame{s: m.Struct}
+				break
+			}
+		}
+	}
+	for _, part := range parts[1:] {
+		if found == nil {
+			return nil
+		}
+		s := findStruct(found.s.Structs, kaitai.Identifier(part))
+		if s == nil {
+			return nil
+		}
+		found = found.child(s)
+	}
+	return found
+}
+
+func (d *differ) enum(path string, oPath, nPath []kaitai.Identifier, oe, ne *kaitai.Enum) {
+	oByID := map[kaitai.Identifier]kaitai.EnumValue{}
+	for _, v := range oe.Values {
+		oByID[v.ID] = v
+	}
+	nByID := map[kaitai.Identifier]kaitai.EnumValue{}
+	for _, v := range ne.Values {
+		nByID[v.ID] = v
+	}
+	renamed := map[kaitai.Identifier]bool{}
+
+	for _, ov := range oe.Values {
+		nv, ok := nByID[ov.ID]
+		if ok {
+			if ov.Value.Cmp(nv.Value) != 0 {
+				d.report(Change{
+					Impact:  WireBreaking,
+					Path:    path + "::" + string(ov.ID),
+					Message: fmt.Sprintf("enum value renumbered from %s to %s", ov.Value, nv.Value),
+					Old:     oe.Source,
+					New:     ne.Source,
+				})
+			}
+			continue
+		}
+		// A value whose number now has a new name was renamed.
+		idx := slices.IndexFunc(ne.Values, func(v kaitai.EnumValue) bool {
+			_, existed := oByID[v.ID]
+			return !existed && v.Value.Cmp(ov.Value) == 0
+		})
+		if idx >= 0 {
+			nv := ne.Values[idx]
+			renamed[nv.ID] = true
+			d.report(Change{
+				Impact:  APIBreaking,
+				Path:    path + "::" + string(ov.ID),
+				Message: fmt.Sprintf("enum value %s renamed to %s", ov.Value, nv.ID),
+				Old:     oe.Source,
+				New:     ne.Source,
+				Go:      []IdentChange{{Old: golang.EnumValueName(ov.ID, oPath...), New: golang.EnumValueName(nv.ID, nPath...)}},
+				C:       []IdentChange{{Old: c.EnumValueName(ov.ID, oPath...), New: c.EnumValueName(nv.ID, nPath...)}},
+			})
+			continue
+		}
+		d.report(Change{
+			Impact:  APIBreaking,
+			Path:    path + "::" + string(ov.ID),
+			Message: fmt.Sprintf("enum value %s removed", ov.Value),
+			Old:     oe.Source,
+			Go:      []IdentChange{{Old: golang.EnumValueName(ov.ID, oPath...)}},
+			C:       []IdentChange{{Old: c.EnumValueName(ov.ID, oPath...)}},
+		})
+	}
+	for _, nv := range ne.Values {
+		if _, ok := oByID[nv.ID]; ok || renamed[nv.ID] {
+			continue
+		}
+		d.report(Change{
+			Impact:  Compatible,
+			Path:    path + "::" + string(nv.ID),
+			Message: fmt.Sprintf("enum value %s added", nv.Value),
+			New:     ne.Source,
+			Go:      []IdentChange{{New: golang.EnumValueName(nv.ID, nPath...)}},
+			C:       []IdentChange{{New: c.EnumValueName(nv.ID, nPath...)}},
+		})
+	}
+}
+
+func findAttr(attrs []*kaitai.Attr, id kaitai.Identifier) *kaitai.Attr {
+	for _, a := range attrs {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func findStruct(structs []*kaitai.Struct, id kaitai.Identifier) *kaitai.Struct {
+	for _, s := range structs {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func findEnum(enums []*kaitai.Enum, id kaitai.Identifier) *kaitai.Enum {
+	for _, e := range enums {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
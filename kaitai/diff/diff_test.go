@@ -0,0 +1,174 @@
+package diff
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadGraph(t *testing.T, src string) *resolve.Graph {
+	t.Helper()
+	fsys := fstest.MapFS{"main.ksy": {Data: []byte(src)}}
+	graph, err := resolve.LoadGraph(resolve.NewFSResolver(fsys), "main")
+	require.NoError(t, err)
+	require.NoError(t, graph.Err())
+	return graph
+}
+
+func diffSources(t *testing.T, oldSrc, newSrc string) []Change {
+	t.Helper()
+	changes := Diff(loadGraph(t, oldSrc), loadGraph(t, newSrc))
+	for _, c := range changes {
+		t.Log(c, c.Go, c.C)
+	}
+	return changes
+}
+
+func findChange(t *testing.T, changes []Change, path, message string) Change {
+	t.Helper()
+	for _, c := range changes {
+		if c.Path == path && c.Message == message {
+			return c
+		}
+	}
+	t.Fatalf("no change %s: %s", path, message)
+	return Change{}
+}
+
+const oldSpec = `
+meta:
+  id: fmt
+  endian: le
+seq:
+  - id: magic
+    contents: [0x7f]
+  - id: len
+    type: u2
+  - id: flags
+    type: u1
+  - id: body
+    size: len
+  - id: count
+    type: u4
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: header
+    type: hdr
+enums:
+  kinds:
+    1: one
+    2: two
+    3: three
+types:
+  hdr:
+    seq:
+      - id: a
+        type: u2
+  old_type:
+    seq:
+      - id: b
+        type: u1
+`
+
+const newSpec = `
+meta:
+  id: fmt
+  endian: le
+seq:
+  - id: magic
+    contents: [0x7f]
+  - id: len
+    type: u4
+  - id: flag_bits
+    type: u1
+  - id: body
+    size: len + 1
+  - id: count
+    type: s4
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: header
+    type: hdr
+  - id: extra
+    type: u1
+instances:
+  total:
+    value: len + 1
+enums:
+  kinds:
+    1: one
+    2: deux
+    4: three
+    5: five
+types:
+  hdr:
+    meta:
+      endian: be
+    seq:
+      - id: a
+        type: u2
+`
+
+func TestDiff(t *testing.T) {
+	changes := diffSources(t, oldSpec, newSpec)
+	assert.Equal(t, WireBreaking, Worst(changes))
+
+	c := findChange(t, changes, "fmt.len", "type changed from u2le to u4le")
+	assert.Equal(t, WireBreaking, c.Impact)
+	assert.Equal(t, 8, c.Old.Line)
+
+	c = findChange(t, changes, "fmt.flags", "seq field renamed to flag_bits")
+	assert.Equal(t, APIBreaking, c.Impact)
+	assert.Equal(t, []IdentChange{{Old: "Fmt.Flags", New: "Fmt.FlagBits"}}, c.Go)
+	assert.Equal(t, []IdentChange{{Old: "fmt.flags", New: "fmt.flag_bits"}}, c.C)
+
+	assert.Equal(t, WireBreaking, findChange(t, changes, "fmt.body", "size changed from size len to size (len) + (1)").Impact)
+	assert.Equal(t, APIBreaking, findChange(t, changes, "fmt.count", "type changed from u4le to s4le").Impact)
+	assert.Equal(t, WireBreaking, findChange(t, changes, "fmt.extra", "seq field added").Impact)
+	assert.Equal(t, Compatible, findChange(t, changes, "fmt.total", "instance added").Impact)
+	assert.Equal(t, WireBreaking, findChange(t, changes, "fmt::hdr.a", "endianness changed from u2le to u2be").Impact)
+	assert.Equal(t, WireBreaking, findChange(t, changes, "fmt.header", "layout of type hdr changed").Impact)
+
+	c = findChange(t, changes, "fmt::kinds::two", "enum value 2 renamed to deux")
+	assert.Equal(t, APIBreaking, c.Impact)
+	assert.Equal(t, []IdentChange{{Old: "Fmt_Kinds__Two", New: "Fmt_Kinds__Deux"}}, c.Go)
+	assert.Equal(t, []IdentChange{{Old: "fmt_kinds__two", New: "fmt_kinds__deux"}}, c.C)
+	assert.Equal(t, WireBreaking, findChange(t, changes, "fmt::kinds::three", "enum value renumbered from 3 to 4").Impact)
+	assert.Equal(t, Compatible, findChange(t, changes, "fmt::kinds::five", "enum value 5 added").Impact)
+
+	c = findChange(t, changes, "fmt::old_type", "type removed")
+	assert.Equal(t, APIBreaking, c.Impact)
+	assert.Equal(t, []IdentChange{{Old: "Fmt_OldType"}}, c.Go)
+}
+
+func TestDiffReorder(t *testing.T) {
+	changes := diffSources(t, `
+meta:
+  id: fmt
+seq:
+  - id: a
+    type: u1
+  - id: b
+    type: u1
+`, `
+meta:
+  id: fmt
+seq:
+  - id: b
+    type: u1
+  - id: a
+    type: u1
+`)
+	require.Len(t, changes, 1)
+	assert.Equal(t, WireBreaking, changes[0].Impact)
+	assert.Equal(t, "seq fields reordered from [a, b] to [b, a]", changes[0].Message)
+}
+
+func TestDiffIdentical(t *testing.T) {
+	assert.Empty(t, diffSources(t, oldSpec, oldSpec))
+}
@@ -0,0 +1,206 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+func exprString(e *expr.Expr) string {
+	if e == nil || e.Root == nil {
+		return ""
+	}
+	return e.Root.String()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func withEnum(typ, enum string) string {
+	if enum == "" {
+		return typ
+	}
+	return typ + " (enum " + enum + ")"
+}
+
+// typeRefString describes a type the way it is written in a .ksy file, with
+// the endianness it ends up with.
+func typeRefString(t types.TypeRef) string {
+	var s string
+	switch t.Kind {
+	case types.Bits:
+		s = fmt.Sprintf("b%d", t.Bits.Width)
+		switch t.Bits.Endian.Kind {
+		case types.LittleBitEndian:
+			s += "le"
+		case types.BigBitEndian:
+			s += "be"
+		}
+	case types.Bytes:
+		s = "bytes"
+	case types.String:
+		s = "str"
+	case types.User:
+		s = t.User.Name
+		if len(t.User.Params) > 0 {
+			args := make([]string, len(t.User.Params))
+			for i, p := range t.User.Params {
+				args[i] = exprString(p)
+			}
+			s += "(" + strings.Join(args, ", ") + ")"
+		}
+	default:
+		s = strings.ToLower(t.Kind.String())
+	}
+	if t.IsArray {
+		s += "[]"
+	}
+	return s
+}
+
+// foldType applies the endianness f's struct inherits to t.
+func foldType(t types.Type, f *frame) types.Type {
+	return t.FoldEndian(f.endian()).FoldBitEndian(f.bitEndian())
+}
+
+func (d *differ) typeString(t types.Type, f *frame) string {
+	t = foldType(t, f)
+	if t.TypeSwitch != nil {
+		keys := make([]string, 0, len(t.TypeSwitch.Cases))
+		for key := range t.TypeSwitch.Cases {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		cases := make([]string, len(keys))
+		for i, key := range keys {
+			cases[i] = key + ": " + typeRefString(t.TypeSwitch.Cases[key])
+		}
+		return fmt.Sprintf("switch-on %s {%s}", exprString(t.TypeSwitch.SwitchOn), strings.Join(cases, ", "))
+	}
+	if t.TypeRef == nil {
+		return "bytes"
+	}
+	return typeRefString(*t.TypeRef)
+}
+
+// numericKinds maps each fixed-size numeric kind to its width in bytes.
+var numericKinds = map[types.Kind]int{
+	types.U1: 1, types.S1: 1,
+	types.U2: 2, types.U2le: 2, types.U2be: 2, types.S2: 2, types.S2le: 2, types.S2be: 2,
+	types.U4: 4, types.U4le: 4, types.U4be: 4, types.S4: 4, types.S4le: 4, types.S4be: 4,
+	types.F4: 4, types.F4le: 4, types.F4be: 4,
+	types.U8: 8, types.U8le: 8, types.U8be: 8, types.S8: 8, types.S8le: 8, types.S8be: 8,
+	types.F8: 8, types.F8le: 8, types.F8be: 8,
+}
+
+// kindEndian returns the byte order of a numeric kind, or Unspecified for
+// single bytes and kinds whose order depends on the struct.
+func kindEndian(k types.Kind) types.EndianKind {
+	name := k.String()
+	switch {
+	case strings.HasSuffix(name, "le"):
+		return types.LittleEndian
+	case strings.HasSuffix(name, "be"):
+		return types.BigEndian
+	}
+	return types.UnspecifiedOrder
+}
+
+// foldedRefs returns the type refs of two attrs' types after applying the
+// endianness of their structs, if both are plain (non-switch) types.
+func foldedRefs(oType, nType types.Type, o, n *frame) (*types.TypeRef, *types.TypeRef) {
+	ot, nt := foldType(oType, o), foldType(nType, n)
+	if ot.TypeRef == nil || nt.TypeRef == nil || ot.TypeRef.IsArray != nt.TypeRef.IsArray {
+		return nil, nil
+	}
+	return ot.TypeRef, nt.TypeRef
+}
+
+// endianOnly reports whether two types only differ in endianness.
+func endianOnly(oType, nType types.Type, o, n *frame) bool {
+	oRef, nRef := foldedRefs(oType, nType, o, n)
+	if oRef == nil {
+		return false
+	}
+	if oRef.Kind == types.Bits && nRef.Kind == types.Bits {
+		return oRef.Bits.Width == nRef.Bits.Width && oRef.Bits.Endian.Kind != nRef.Bits.Endian.Kind
+	}
+	ow, ok1 := numericKinds[oRef.Kind]
+	nw, ok2 := numericKinds[nRef.Kind]
+	if !ok1 || !ok2 || ow != nw {
+		return false
+	}
+	le, be := oRef.Kind, oRef.Kind
+	for _, k := range []types.Kind{types.U2, types.U4, types.U8, types.S2, types.S4, types.S8, types.F4, types.F8} {
+		kle, kbe := k.SplitEndian()
+		if oRef.Kind == kle || oRef.Kind == kbe {
+			le, be = kle, kbe
+		}
+	}
+	return oRef.Kind != nRef.Kind && (nRef.Kind == le || nRef.Kind == be)
+}
+
+// sameWidth reports whether two numeric types occupy the same bytes in the
+// same order, such as u4le and s4le.
+func sameWidth(oType, nType types.Type, o, n *frame) bool {
+	oRef, nRef := foldedRefs(oType, nType, o, n)
+	if oRef == nil {
+		return false
+	}
+	ow, ok1 := numericKinds[oRef.Kind]
+	nw, ok2 := numericKinds[nRef.Kind]
+	return ok1 && ok2 && ow == nw && kindEndian(oRef.Kind) == kindEndian(nRef.Kind)
+}
+
+// sizeString describes how much an attr reads.
+func sizeString(a *kaitai.Attr) string {
+	var parts []string
+	if s := exprString(a.Size); s != "" {
+		parts = append(parts, "size "+s)
+	}
+	if a.SizeEos {
+		parts = append(parts, "size-eos")
+	}
+	if t := a.Type.TypeRef; t != nil {
+		switch {
+		case t.Bytes != nil && t.Bytes.Terminator >= 0:
+			parts = append(parts, fmt.Sprintf("terminator %d", t.Bytes.Terminator))
+		case t.String != nil && t.String.Terminator >= 0:
+			parts = append(parts, fmt.Sprintf("terminator %d", t.String.Terminator))
+		}
+	}
+	if a.PadRight != nil {
+		parts = append(parts, fmt.Sprintf("pad-right %d", *a.PadRight))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func repeatString(r types.RepeatType) string {
+	switch r := r.(type) {
+	case types.RepeatEOS:
+		return "eos"
+	case types.RepeatExpr:
+		return "expr " + exprString(r.CountExpr)
+	case types.RepeatUntil:
+		return "until " + exprString(r.UntilExpr)
+	}
+	return "none"
+}
+
+func encoding(t types.Type) string {
+	if t.TypeRef != nil && t.TypeRef.String != nil {
+		return t.TypeRef.String.Encoding
+	}
+	return ""
+}
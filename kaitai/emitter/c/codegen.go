@@ -34,33 +34,44 @@ func (b *buf) blank() *buf { b.sb.WriteByte('\n'); return b }
 
 func (b *buf) raw(s string) *buf { b.sb.WriteString(s); return b }
 
+// doc writes lines as a Doxygen comment block. Nothing is written if there
+// are no lines.
+func (b *buf) doc(lines []string) *buf {
+	if len(lines) == 0 {
+		return b
+	}
+	b.p("/**")
+	for _, line := range lines {
+		if line == "" {
+			b.p(" *")
+		} else {
+			b.pf(" * %s", line)
+		}
+	}
+	return b.p(" */")
+}
+
 func (b *buf) String() string { return b.sb.String() }
 
 type cField struct {
-	typ     string
-	name    string
-	suffix  string
-	comment string
+	typ    string
+	name   string
+	suffix string
+	doc    []string
 }
 
 type cStruct struct {
 	name   string
 	fields []cField
-	doc    string
+	doc    []string
 }
 
 func (s *cStruct) emit(b *buf) {
-	if s.doc != "" {
-		for line := range strings.SplitSeq(strings.TrimSpace(s.doc), "\n") {
-			b.pf("/* %s */", line)
-		}
-	}
+	b.doc(s.doc)
 	b.pf("typedef struct %s {", s.name)
 	b.indent()
 	for _, f := range s.fields {
-		if f.comment != "" {
-			b.pf("/* %s */", f.comment)
-		}
+		b.doc(f.doc)
 		b.pf("%s %s%s;", f.typ, f.name, f.suffix)
 	}
 	b.unindent()
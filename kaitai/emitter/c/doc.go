@@ -0,0 +1,31 @@
+package c
+
+import (
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai/emitter"
+)
+
+// cDoc converts a .ksy doc and doc-ref into Doxygen comment lines, with
+// each doc-ref as a @see entry. URL references become links.
+func cDoc(doc string, refs []string) []string {
+	lines := emitter.DocLines(doc)
+	for i, line := range lines {
+		lines[i] = strings.ReplaceAll(line, "*/", "* /")
+	}
+	for i, r := range refs {
+		if i == 0 && len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		ref := emitter.ParseDocRef(strings.ReplaceAll(r, "*/", "* /"))
+		switch {
+		case ref.URL != "" && ref.Text != "":
+			lines = append(lines, `@see <a href="`+ref.URL+`">`+ref.Text+`</a>`)
+		case ref.URL != "":
+			lines = append(lines, "@see "+ref.URL)
+		default:
+			lines = append(lines, "@see "+ref.Text)
+		}
+	}
+	return lines
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/charset"
 	"github.com/jchv/zanbato/kaitai/emitter"
 	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/expr/engine"
@@ -31,6 +32,9 @@ type fileScope struct {
 	customProcessSigs  map[string]string
 	customProcessOrder []string
 
+	hostFuncSigs  map[string]string
+	hostFuncOrder []string
+
 	opaqueIncludes []string
 
 	auxLitCounter int
@@ -44,6 +48,7 @@ func newFileScope() *fileScope {
 		arrayTypes:        map[string]string{},
 		arrayTypesEmitted: map[string]bool{},
 		customProcessSigs: map[string]string{},
+		hostFuncSigs:      map[string]string{},
 	}
 }
 
@@ -60,10 +65,15 @@ type exprMode struct {
 // Emitter produces C source/header pairs for kaitai structs.
 type Emitter struct {
 	resolver  resolve.Resolver
+	graph     *resolve.Graph
 	context   *engine.Context
 	endian    types.EndianKind
 	bitEndian types.BitEndianKind
 	compat    kaitai.Compatibility
+	optimize  bool
+
+	funcs       *engine.FuncRegistry
+	funcSymbols map[string]string
 
 	artifacts []emitter.Artifact
 	visited   map[*kaitai.Struct]struct{}
@@ -71,6 +81,9 @@ type Emitter struct {
 	file *fileScope
 	mode exprMode
 
+	// typed holds the inferred types of the expression expr is translating.
+	typed *engine.TypedExpr
+
 	currentStruct     *engine.ExprValue
 	currentStructName string
 
@@ -140,6 +153,7 @@ func NewEmitter(resolver resolve.Resolver) *Emitter {
 		resolver: resolver,
 		context:  engine.NewContext(),
 		compat:   engine.DefaultCompat,
+		funcs:    engine.DefaultFuncs,
 		visited:  map[*kaitai.Struct]struct{}{},
 	}
 }
@@ -147,18 +161,41 @@ func NewEmitter(resolver resolve.Resolver) *Emitter {
 // SetCompat sets the compatibility mode for the emitter.
 func (e *Emitter) SetCompat(c kaitai.Compatibility) { e.compat = c }
 
+// SetOptimize controls whether expressions are simplified with
+// engine.Optimize before they are emitted.
+func (e *Emitter) SetOptimize(enabled bool) { e.optimize = enabled }
+
 // SetDebug controls whether debug features are unconditionally enabled for
 // all generated code.
 func (e *Emitter) SetDebug(enabled bool) { e.debugAlways = enabled }
 
+// SetFuncs sets the registry of host functions that expressions may call.
+// By default, engine.DefaultFuncs is used.
+func (e *Emitter) SetFuncs(funcs *engine.FuncRegistry) { e.funcs = funcs }
+
+// MapFunc sets the C function that generated code calls for the host
+// function name. Unmapped host functions are called by their name.
+//
+// Host functions are called with the struct's arena, then the receiver of
+// methods, then the arguments. Integer receivers are passed as int64_t. A
+// prototype is declared in the generated header.
+func (e *Emitter) MapFunc(name, symbol string) {
+	if e.funcSymbols == nil {
+		e.funcSymbols = map[string]string{}
+	}
+	e.funcSymbols[name] = symbol
+}
+
 // Emit emits C code for the given kaitai struct.
 func (e *Emitter) Emit(inputname string, s *kaitai.Struct) []emitter.Artifact {
 	e.endian = types.UnspecifiedOrder
 	e.bitEndian = types.UnspecifiedBitOrder
 	e.context = engine.NewContext()
 	e.context.Compat = e.compat
+	e.context.Funcs = e.funcs
 	e.artifacts = nil
 	e.visited = map[*kaitai.Struct]struct{}{}
+	e.graph = resolve.NewGraph(e.resolver, inputname, s)
 	e.root(inputname, s)
 	return e.artifacts
 }
@@ -215,6 +252,9 @@ func (e *Emitter) root(inputname string, s *kaitai.Struct) {
 	sourceName := e.filename(s.ID) + ".c"
 
 	header.pf("/* Generated by Zanbato. Do not edit! */")
+	if s.Meta.License != "" {
+		header.pf("/* SPDX-License-Identifier: %s */", s.Meta.License)
+	}
 	header.blank()
 	header.pf("#ifndef %s", guard)
 	header.pf("#define %s", guard)
@@ -227,6 +267,9 @@ func (e *Emitter) root(inputname string, s *kaitai.Struct) {
 	header.blank()
 
 	source.pf("/* Generated by Zanbato. Do not edit! */")
+	if s.Meta.License != "" {
+		source.pf("/* SPDX-License-Identifier: %s */", s.Meta.License)
+	}
 	source.blank()
 	source.pf("#include \"%s\"", headerName)
 	source.pf("#include <string.h>")
@@ -243,11 +286,11 @@ func (e *Emitter) root(inputname string, s *kaitai.Struct) {
 	header.blank()
 
 	for _, n := range s.Meta.Imports {
-		impName, _, err := e.resolver.Resolve(inputname, n)
+		imp, err := e.graph.Import(inputname, n)
 		if err != nil {
-			panic(fmt.Errorf("resolving import %q: %w", n, err))
+			panic(err)
 		}
-		header.pf("#include \"%s.h\"", strings.ToLower(filepathBase(impName)))
+		header.pf("#include \"%s.h\"", strings.ToLower(filepathBase(imp.Name)))
 	}
 	if len(e.file.opaqueIncludes) > 0 {
 		sort.Strings(e.file.opaqueIncludes)
@@ -267,6 +310,12 @@ func (e *Emitter) root(inputname string, s *kaitai.Struct) {
 		}
 		header.blank()
 	}
+	if len(e.file.hostFuncOrder) > 0 {
+		for _, name := range e.file.hostFuncOrder {
+			header.pf("%s", e.file.hostFuncSigs[name])
+		}
+		header.blank()
+	}
 
 	header.pf("#ifdef __cplusplus")
 	header.pf("} /* extern \"C\" */")
@@ -325,15 +374,22 @@ func (e *Emitter) arrayTagFor(elem string) string {
 
 func (e *Emitter) struc(inputname string, val *engine.ExprValue) {
 	ks := val.Struct.Type
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(emitter.AnnotatePanic(r, ks.Source, "struct %s", ks.ID))
+		}
+	}()
+
 	defer e.enterLocal(val)()
 	defer e.pushMetaScope(ks)()
 
 	for _, n := range ks.Meta.Imports {
-		impName, impStruc, err := e.resolver.Resolve(inputname, n)
+		imp, err := e.graph.Import(inputname, n)
 		if err != nil {
-			panic(fmt.Errorf("resolving import %q: %w", n, err))
+			panic(err)
 		}
-		e.root(impName, impStruc)
+		e.root(imp.Name, imp.Struct)
 	}
 
 	for _, en := range val.Struct.Enums {
@@ -378,6 +434,7 @@ func (e *Emitter) emitInstanceGetters(val *engine.ExprValue) {
 func (e *Emitter) emitValueInstanceGetter(val *engine.ExprValue, typeName string, a *kaitai.Attr) {
 	retType := e.inferValueType(a.Value)
 	decl := fmt.Sprintf("%s %s_get_%s(struct %s *this_)", retType, typeName, e.fieldName(a.ID), typeName)
+	e.file.header.doc(cDoc(a.Doc, a.DocRef))
 	e.file.header.pf("%s;", decl)
 	e.file.source.pf("%s {", decl)
 	e.file.source.indent()
@@ -504,6 +561,7 @@ func (e *Emitter) emitPosInstanceGetter(val *engine.ExprValue, typeName string,
 	e.file.source.blank()
 
 	decl := fmt.Sprintf("%s %s(struct %s *this_)", retType, getterName, typeName)
+	e.file.header.doc(cDoc(a.Doc, a.DocRef))
 	e.file.header.pf("%s;", decl)
 	e.file.source.pf("%s {", decl)
 	e.file.source.indent()
@@ -521,11 +579,12 @@ func (e *Emitter) emitPosInstanceGetter(val *engine.ExprValue, typeName string,
 }
 
 func (e *Emitter) emitEnum(parent *engine.ExprValue, en *kaitai.Enum) {
-	enumPrefix := e.prefix(parent) + e.typeName(en.ID) + "__"
+	path := append(e.typePath(parent), en.ID)
 	e.file.header.pf("enum {")
 	e.file.header.indent()
 	for _, v := range en.Values {
-		e.file.header.pf("%s%s = %s,", enumPrefix, e.typeName(v.ID), v.Value.String())
+		e.file.header.doc(cDoc(v.Doc, v.DocRef))
+		e.file.header.pf("%s = %s,", EnumValueName(v.ID, path...), v.Value.String())
 	}
 	e.file.header.unindent()
 	e.file.header.pf("};")
@@ -537,12 +596,13 @@ func (e *Emitter) emitStructDecl(val *engine.ExprValue) {
 	name := e.prefix(val.DefParent) + e.typeName(ks.ID)
 	defer e.enterStruct(val, name)()
 
-	gs := &cStruct{name: name, doc: ks.Doc}
+	gs := &cStruct{name: name, doc: cDoc(ks.Doc, ks.DocRef)}
 
 	for _, p := range ks.Params {
 		gs.fields = append(gs.fields, cField{
 			typ:  e.declTypeRefForParam(&p.Type),
 			name: e.fieldName(p.ID),
+			doc:  cDoc(p.Doc, p.DocRef),
 		})
 	}
 
@@ -1214,6 +1274,12 @@ func (e *Emitter) buildReadParams(name string, ks *kaitai.Struct) string {
 }
 
 func (e *Emitter) emitAttrRead(src *buf, parent *engine.ExprValue, a *kaitai.Attr) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(emitter.AnnotatePanic(r, a.Source, "attr: %s", a.ID))
+		}
+	}()
+
 	if a.If != nil {
 		src.pf("this_->_have_%s = (%s) ? 1 : 0;", e.fieldName(a.ID), e.expr(a.If))
 		src.pf("if (this_->_have_%s) {", e.fieldName(a.ID))
@@ -1505,14 +1571,13 @@ func (e *Emitter) emitValidate(src *buf, a *kaitai.Attr, field string, t *types.
 		en := e.lookupEnum(a.Enum)
 		if en != nil {
 			parts := make([]string, 0, len(en.Values))
-			enumPrefix := ""
+			var path []kaitai.Identifier
 			if ev := e.tryResolveType(a.Enum); ev != nil && ev.Kind == engine.EnumKind {
-				enumPrefix = e.prefix(ev.DefParent) + e.typeName(en.ID) + "__"
-			} else {
-				enumPrefix = e.typeName(en.ID) + "__"
+				path = e.typePath(ev.DefParent)
 			}
+			path = append(path, en.ID)
 			for _, ev := range en.Values {
-				parts = append(parts, fmt.Sprintf("(%s) == (%s%s)", fieldExpr, enumPrefix, e.typeName(ev.ID)))
+				parts = append(parts, fmt.Sprintf("(%s) == (%s)", fieldExpr, EnumValueName(ev.ID, path...)))
 			}
 			if len(parts) > 0 {
 				src.pf("if (!(%s)) return ZB_ERR_VALIDATION;", strings.Join(parts, " || "))
@@ -1891,21 +1956,12 @@ func (e *Emitter) maybeAppendDecode(src *buf, encoding, lhs string) {
 }
 
 func (e *Emitter) encodingNeedsConversion(enc string) bool {
-	norm := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(enc, "-", ""), "_", ""))
-	switch norm {
-	case "", "UTF8", "ASCII":
-		return false
-	}
-	return true
+	return charset.NeedsConversion(enc)
 }
 
 func multiByteUnit(enc string) int {
-	norm := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(enc, "-", ""), "_", ""))
-	switch norm {
-	case "UTF16", "UTF16LE", "UTF16BE":
-		return 2
-	case "UTF32", "UTF32LE", "UTF32BE":
-		return 4
+	if cs, err := charset.Lookup(enc); err == nil {
+		return cs.Unit
 	}
 	return 1
 }
@@ -2274,11 +2330,11 @@ func (e *Emitter) emitStripBytes(src *buf, lhs string, term, pad int, include bo
 }
 
 func (e *Emitter) typeName(id kaitai.Identifier) string {
-	return ksToCName(string(id))
+	return TypeName(id)
 }
 
 func (e *Emitter) fieldName(id kaitai.Identifier) string {
-	return ksToCName(string(id))
+	return FieldName(id)
 }
 
 func (e *Emitter) filename(id kaitai.Identifier) string {
@@ -2286,10 +2342,20 @@ func (e *Emitter) filename(id kaitai.Identifier) string {
 }
 
 func (e *Emitter) prefix(typ *engine.ExprValue) string {
-	if typ == nil || typ.Struct == nil {
+	path := e.typePath(typ)
+	if len(path) == 0 {
 		return ""
 	}
-	return e.prefix(typ.DefParent) + e.typeName(typ.Struct.Type.ID) + "_"
+	return TypeName(path...) + "_"
+}
+
+// typePath returns the IDs of typ and the structs it is defined in, outermost
+// first.
+func (e *Emitter) typePath(typ *engine.ExprValue) []kaitai.Identifier {
+	if typ == nil || typ.Struct == nil {
+		return nil
+	}
+	return append(e.typePath(typ.DefParent), typ.Struct.Type.ID)
 }
 
 func (e *Emitter) appendSeqAttrFields(gs *cStruct, a *kaitai.Attr) {
@@ -2298,12 +2364,14 @@ func (e *Emitter) appendSeqAttrFields(gs *cStruct, a *kaitai.Attr) {
 		gs.fields = append(gs.fields, cField{
 			typ:  "void *",
 			name: e.fieldName(a.ID),
+			doc:  cDoc(a.Doc, a.DocRef),
 		})
 		return
 	}
 	gs.fields = append(gs.fields, cField{
 		typ:  typ,
 		name: e.fieldName(a.ID),
+		doc:  cDoc(a.Doc, a.DocRef),
 	})
 	if a.If != nil {
 		gs.fields = append(gs.fields, cField{
@@ -2331,6 +2399,7 @@ func (e *Emitter) appendInstanceFields(gs *cStruct, a *kaitai.Attr) {
 	gs.fields = append(gs.fields, cField{
 		typ:  typ,
 		name: e.fieldName(a.ID),
+		doc:  cDoc(a.Doc, a.DocRef),
 	})
 	gs.fields = append(gs.fields, cField{
 		typ:  "int",
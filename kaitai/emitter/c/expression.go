@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/jchv/zanbato/kaitai/emitter"
 	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/types"
@@ -73,6 +74,13 @@ func (e *Emitter) staticSizeOfNode(n expr.Node) (int64, bool) {
 
 func (e *Emitter) exprIs64Bit(n expr.Node) (out bool) {
 	defer func() { _ = recover() }()
+	if t, ok := e.typeOf(n); ok {
+		switch t.TypeKind() {
+		case types.U8, types.U8le, types.U8be, types.S8, types.S8le, types.S8be:
+			return true
+		}
+		return false
+	}
 	r := engine.ResultTypeOfNode(e.context, n)
 	if r == nil {
 		return false
@@ -92,6 +100,13 @@ func (e *Emitter) exprIs64Bit(n expr.Node) (out bool) {
 
 func (e *Emitter) exprIsFloat(n expr.Node) (out bool) {
 	defer func() { _ = recover() }()
+	if t, ok := e.typeOf(n); ok {
+		switch t.TypeKind() {
+		case types.F4, types.F4le, types.F4be, types.F8, types.F8le, types.F8be, types.UntypedFloat:
+			return true
+		}
+		return false
+	}
 	switch t := n.(type) {
 	case expr.FloatNode:
 		return true
@@ -191,10 +206,39 @@ func (e *Emitter) expr(ex *expr.Expr) (out string) {
 	if ex == nil {
 		return "0"
 	}
-	e.withParentBinding(func() { out = e.exprNode(ex.Root) })
+	defer emitter.RecoverExpr(ex)
+	if e.optimize {
+		ex = engine.Optimize(e.context, ex)
+	}
+	// Type errors are reported by the linter; translation uses whatever
+	// types could be inferred.
+	defer func(prev *engine.TypedExpr) { e.typed = prev }(e.typed)
+	e.withParentBinding(func() {
+		e.typed, _ = engine.InferExpr(e.context, ex)
+		out = e.exprNode(ex.Root)
+	})
 	return
 }
 
+// typeOf returns the inferred type of n, a node of the expression being
+// translated, if it is known.
+func (e *Emitter) typeOf(n expr.Node) (engine.InferredType, bool) {
+	if expr.SpanOf(n) == (expr.Span{}) {
+		// Synthesized nodes aren't part of the typed expression.
+		return engine.InferredType{}, false
+	}
+	t, ok := e.typed.TypeOf(n)
+	return t, ok && t.IsKnown()
+}
+
+// typeKindOf returns the primitive kind of n's type, or 0 if it has none.
+func (e *Emitter) typeKindOf(n expr.Node) types.Kind {
+	if t, ok := e.typeOf(n); ok {
+		return t.TypeKind()
+	}
+	return engine.ResultTypeOfNode(e.context, n).TypeKind()
+}
+
 func (e *Emitter) withParentBinding(fn func()) {
 	if e.file.parents.Inferred == nil || e.currentStruct == nil || e.currentStruct.Struct == nil {
 		fn()
@@ -219,6 +263,7 @@ func (e *Emitter) withParentBinding(fn func()) {
 }
 
 func (e *Emitter) exprNode(node expr.Node) string {
+	defer emitter.RecoverNode(node)
 	switch t := node.(type) {
 	case expr.IntNode:
 		s := t.Integer.String()
@@ -491,6 +536,9 @@ func (e *Emitter) exprMember(t expr.MemberNode) string {
 }
 
 func (e *Emitter) exprMethod(t expr.MemberNode, v *engine.ExprValue) string {
+	if v.Method.Host != nil {
+		return e.exprHostCall(v.Method.Host, t.Operand, nil)
+	}
 	operand := e.exprNode(t.Operand)
 	m := v.Method
 	switch m.Method {
@@ -725,7 +773,7 @@ func (e *Emitter) exprScope(t expr.ScopeNode) string {
 		structParent := v.NearestStruct()
 		enumVal := v.NearestEnum()
 		if enumVal != nil && enumVal.Enum != nil {
-			return e.prefix(structParent) + e.typeName(enumVal.Enum.ID) + "__" + e.typeName(v.Parent.EnumValue.ID)
+			return EnumValueName(v.Parent.EnumValue.ID, append(e.typePath(structParent), enumVal.Enum.ID)...)
 		}
 	}
 	panic(fmt.Errorf("unresolved scope: %s::%s", t.Operand, t.Type))
@@ -862,6 +910,13 @@ func (e *Emitter) exprBitSizeof(t expr.BitSizeofNode) string {
 }
 
 func (e *Emitter) exprCall(t expr.CallNode) string {
+	if v := engine.ResultTypeOfNode(e.context, t.Object); v != nil && v.Kind == engine.MethodKind && v.Method.Host != nil {
+		var recv expr.Node
+		if mn, ok := t.Object.(expr.MemberNode); ok {
+			recv = mn.Operand
+		}
+		return e.exprHostCall(v.Method.Host, recv, t.Args)
+	}
 	if mn, ok := t.Object.(expr.MemberNode); ok {
 		v := engine.ResultTypeOfNode(e.context, t.Object)
 		if v != nil && v.Kind == engine.MethodKind {
@@ -1015,8 +1070,7 @@ func (e *Emitter) isByteArrayBinary(a, b expr.Node) bool {
 }
 
 func (e *Emitter) needsInt64Widening(a, b expr.Node) bool {
-	ka := engine.ResultTypeOfNode(e.context, a).TypeKind()
-	kb := engine.ResultTypeOfNode(e.context, b).TypeKind()
+	ka, kb := e.typeKindOf(a), e.typeKindOf(b)
 	hasSigned := isSignedKind(ka) || isSignedKind(kb)
 	hasUnsigned := isUnsignedKind(ka) || isUnsignedKind(kb)
 	return hasSigned && hasUnsigned
@@ -1091,6 +1145,10 @@ func (e *Emitter) isNodeByteArray(n expr.Node) bool {
 	if id, ok := n.(expr.IdentNode); ok && id.Identifier == "_" {
 		return e.mode.repeatElemIsBytes
 	}
+	if t, ok := e.typeOf(n); ok {
+		k := t.TypeKind()
+		return k == types.Bytes || k == types.String
+	}
 	result := engine.ResultTypeOfNode(e.context, n)
 	if result == nil {
 		return false
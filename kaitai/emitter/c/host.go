@@ -0,0 +1,114 @@
+package c
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// exprHostCall emits a call to a host function. recv is the receiver of a
+// method, or nil for a free function.
+func (e *Emitter) exprHostCall(fn *engine.HostFunc, recv expr.Node, args []expr.Node) string {
+	if len(args) != len(fn.Arguments) {
+		panic(fmt.Errorf("%s expects %d arguments, got %d", fn.Name, len(fn.Arguments), len(args)))
+	}
+	symbol := e.funcSymbol(fn)
+	callArgs := []string{e.thisExpr() + "->_arena"}
+	if recv != nil {
+		operand := e.exprNode(recv)
+		if fn.Receiver == engine.IntReceiver {
+			operand = "(int64_t)(" + operand + ")"
+		}
+		callArgs = append(callArgs, operand)
+	}
+	for i, arg := range args {
+		s := e.exprNode(arg)
+		// Structs can't be cast, even to their own type.
+		if typ := hostCType(fn.Arguments[i]); typ != "zb_bytes_t" && typ != "" {
+			s = "(" + typ + ")(" + s + ")"
+		}
+		callArgs = append(callArgs, s)
+	}
+	return symbol + "(" + strings.Join(callArgs, ", ") + ")"
+}
+
+// funcSymbol returns the C function called for a host function, declaring
+// it in the current header.
+func (e *Emitter) funcSymbol(fn *engine.HostFunc) string {
+	symbol, ok := e.funcSymbols[fn.Name]
+	if !ok {
+		symbol = fn.Name
+	}
+	if _, ok := e.file.hostFuncSigs[symbol]; ok {
+		return symbol
+	}
+	ret := hostCType(fn.ReturnType)
+	if ret == "" {
+		panic(fmt.Errorf("host function %s: unsupported return type", fn.Name))
+	}
+	params := []string{"zb_arena_t *arena"}
+	switch fn.Receiver {
+	case engine.IntReceiver:
+		params = append(params, "int64_t value")
+	case engine.BytesReceiver, engine.StringReceiver:
+		params = append(params, "zb_bytes_t value")
+	case engine.ArrayReceiver:
+		// Arrays of each element type are distinct structs, so there is
+		// no single parameter type to declare.
+		params = nil
+	}
+	for i, arg := range fn.Arguments {
+		typ := hostCType(arg)
+		if typ == "" || params == nil {
+			params = nil
+			break
+		}
+		params = append(params, fmt.Sprintf("%s arg%d", typ, i))
+	}
+	sig := ret + " " + symbol + "();"
+	if params != nil {
+		sig = ret + " " + symbol + "(" + strings.Join(params, ", ") + ");"
+	}
+	e.file.hostFuncSigs[symbol] = sig
+	e.file.hostFuncOrder = append(e.file.hostFuncOrder, symbol)
+	return symbol
+}
+
+// hostCType returns the C type used for a value in host function calls, or
+// an empty string if it has none.
+func hostCType(vt engine.ValueType) string {
+	ref := vt.Type.TypeRef
+	if ref == nil || vt.Repeat != nil || ref.IsArray {
+		return ""
+	}
+	switch ref.Kind {
+	case types.U1:
+		return "uint8_t"
+	case types.U2, types.U2le, types.U2be:
+		return "uint16_t"
+	case types.U4, types.U4le, types.U4be:
+		return "uint32_t"
+	case types.U8, types.U8le, types.U8be:
+		return "uint64_t"
+	case types.S1:
+		return "int8_t"
+	case types.S2, types.S2le, types.S2be:
+		return "int16_t"
+	case types.S4, types.S4le, types.S4be:
+		return "int32_t"
+	case types.S8, types.S8le, types.S8be, types.UntypedInt:
+		return "int64_t"
+	case types.F4, types.F4le, types.F4be:
+		return "float"
+	case types.F8, types.F8le, types.F8be, types.UntypedFloat:
+		return "double"
+	case types.UntypedBool:
+		return "int"
+	case types.Bytes, types.String:
+		return "zb_bytes_t"
+	}
+	return ""
+}
@@ -2,6 +2,8 @@ package c
 
 import (
 	"strings"
+
+	"github.com/jchv/zanbato/kaitai"
 )
 
 func ksToCName(name string) string {
@@ -26,3 +28,26 @@ func mangleKeyword(name string) string {
 	}
 	return name
 }
+
+// TypeName returns the C struct name generated for the struct or enum at
+// path, the chain of identifiers from its module's root type down to it.
+// For example, the struct main::header is named main_header.
+func TypeName(path ...kaitai.Identifier) string {
+	names := make([]string, len(path))
+	for i, id := range path {
+		names[i] = ksToCName(string(id))
+	}
+	return strings.Join(names, "_")
+}
+
+// FieldName returns the C member name generated for a param, seq attr or
+// instance.
+func FieldName(id kaitai.Identifier) string {
+	return ksToCName(string(id))
+}
+
+// EnumValueName returns the C enumerator name generated for the value id of
+// the enum at path.
+func EnumValueName(id kaitai.Identifier, path ...kaitai.Identifier) string {
+	return TypeName(path...) + "__" + ksToCName(string(id))
+}
@@ -57,7 +57,7 @@ func (e *Emitter) emitProcess(src *buf, process *expr.Expr, varName string) {
 		src.pf("%s = %s_decode(arena, %s);", varName, id.Identifier, varName)
 		return
 	}
-	panic(fmt.Errorf("unsupported process expression: %s", process))
+	panic(fmt.Errorf("unsupported process expression: %s", process.Root))
 }
 
 func (e *Emitter) emitUnprocess(src *buf, process *expr.Expr, varName string) {
@@ -114,7 +114,7 @@ func (e *Emitter) emitUnprocess(src *buf, process *expr.Expr, varName string) {
 		src.pf("%s = %s_encode(this_->_arena, %s);", varName, id.Identifier, varName)
 		return
 	}
-	panic(fmt.Errorf("unsupported unprocess expression: %s", process))
+	panic(fmt.Errorf("unsupported unprocess expression: %s", process.Root))
 }
 
 func (e *Emitter) xorKeyBytes(n expr.Node) string {
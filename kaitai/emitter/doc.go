@@ -0,0 +1,34 @@
+package emitter
+
+import "strings"
+
+// DocLines splits a .ksy `doc:` string into lines for a comment, trimming
+// trailing whitespace and leading/trailing blank lines.
+func DocLines(doc string) []string {
+	var lines []string
+	for line := range strings.SplitSeq(strings.TrimSpace(doc), "\n") {
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	return lines
+}
+
+// DocRef is a parsed `doc-ref:` entry. Per the Kaitai Struct spec, an entry
+// is either a URL optionally followed by a description, or free-form text
+// (e.g. a reference to a section of a standard).
+type DocRef struct {
+	URL  string
+	Text string
+}
+
+// ParseDocRef parses a single `doc-ref:` entry.
+func ParseDocRef(ref string) DocRef {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		url, text, _ := strings.Cut(ref, " ")
+		return DocRef{URL: url, Text: strings.TrimSpace(text)}
+	}
+	return DocRef{Text: ref}
+}
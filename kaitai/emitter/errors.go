@@ -0,0 +1,70 @@
+package emitter
+
+import (
+	"fmt"
+
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+)
+
+// AnnotatePanic converts a value recovered from an emitter panic into an
+// error that describes what was being emitted and where it was declared.
+// When panics are re-raised through nested scopes, the innermost (most
+// precise) source position wins and is kept at the front of the message.
+func AnnotatePanic(r any, pos srcpos.Pos, format string, args ...any) error {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	if inner, ok := err.(*srcpos.Error); ok {
+		pos, err = inner.Pos, inner.Err
+	}
+	return srcpos.Wrap(pos, fmt.Errorf(format+": %w", append(args, err)...))
+}
+
+// nodePanic is a panic raised while emitting an expression node.
+type nodePanic struct {
+	node expr.Node
+	err  error
+}
+
+func (p *nodePanic) Error() string { return p.err.Error() }
+
+func (p *nodePanic) Unwrap() error { return p.err }
+
+// RecoverNode is deferred by code that emits an expression node. If emitting
+// the node panics, it records the node, unless a node inside it was already
+// recorded, so that RecoverExpr can point at it.
+func RecoverNode(node expr.Node) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if p, ok := r.(*nodePanic); ok {
+		panic(p)
+	}
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	panic(&nodePanic{node: node, err: err})
+}
+
+// RecoverExpr is deferred by code that emits a whole expression. It turns a
+// panic recorded by RecoverNode into an error at the position of the node
+// within ex.
+func RecoverExpr(ex *expr.Expr) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	p, ok := r.(*nodePanic)
+	if !ok {
+		panic(r)
+	}
+	pos := ex.Pos(p.node)
+	if member, ok := p.node.(expr.MemberNode); ok {
+		pos = ex.OffsetPos(member.PropertySpan().Start)
+	}
+	panic(srcpos.Wrap(pos, p.err))
+}
@@ -12,6 +12,7 @@ const indentStr = "\t"
 type goVar struct {
 	name string
 	typ  string
+	doc  []string
 }
 
 func (v goVar) String() string {
@@ -33,18 +34,33 @@ type goFields []goVar
 func (v goFields) String() string {
 	vstrs := []string{}
 	for _, n := range v {
-		vstrs = append(vstrs, n.String())
+		vstrs = append(vstrs, docComment(n.doc, "\t")+n.String())
 	}
 	return strings.Join(vstrs, "\n\t")
 }
 
+// docComment formats lines as a Go comment, each line followed by a newline
+// and indent. It returns an empty string if there are no lines.
+func docComment(lines []string, indent string) string {
+	var sb strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			sb.WriteString("//\n" + indent)
+		} else {
+			sb.WriteString("// " + line + "\n" + indent)
+		}
+	}
+	return sb.String()
+}
+
 type goStruct struct {
 	name   string
 	fields goFields
+	doc    []string
 }
 
 func (g *goStruct) emit(buf io.Writer) {
-	_, _ = fmt.Fprintf(buf, "type %s struct {\n\t%s\n}\n\n", g.name, g.fields)
+	_, _ = fmt.Fprintf(buf, "%stype %s struct {\n\t%s\n}\n\n", docComment(g.doc, ""), g.name, g.fields)
 }
 
 type goMethod struct {
@@ -77,6 +93,7 @@ func (g *goInterface) emit(buf io.Writer) {
 }
 
 type goFunc struct {
+	doc    []string
 	recv   goVar
 	name   string
 	tmp    int
@@ -91,7 +108,7 @@ func (g *goFunc) indent() *goFunc { g.pfx += indentStr; return g }
 func (g *goFunc) unindent() *goFunc { g.pfx = g.pfx[:len(g.pfx)-len(indentStr)]; return g }
 
 func (g *goFunc) emit(buf io.Writer) {
-	_, _ = fmt.Fprintf(buf, "func (%s) %s(%s) (%s) {\n%s}\n\n", g.recv.String(), g.name, g.in.String(), g.out.String(), g.source)
+	_, _ = fmt.Fprintf(buf, "%sfunc (%s) %s(%s) (%s) {\n%s}\n\n", docComment(g.doc, ""), g.recv.String(), g.name, g.in.String(), g.out.String(), g.source)
 }
 
 func (g *goFunc) ppf(format string, args ...any) *goFunc {
@@ -107,6 +124,7 @@ func (g *goFunc) pf(format string, args ...any) *goFunc {
 type goEnumValue struct {
 	name  string
 	value int
+	doc   []string
 }
 
 type goEnum struct {
@@ -119,13 +137,14 @@ func (g *goEnum) emit(buf io.Writer) {
 	_, _ = fmt.Fprintf(buf, "type %s %s\n", g.name, g.decltype)
 	_, _ = fmt.Fprintf(buf, "const (\n")
 	for _, v := range g.values {
-		_, _ = fmt.Fprintf(buf, "\t%s %s = %d\n", v.name, g.name, v.value)
+		_, _ = fmt.Fprintf(buf, "\t%s%s %s = %d\n", docComment(v.doc, "\t"), v.name, g.name, v.value)
 	}
 	_, _ = fmt.Fprintf(buf, ")\n\n")
 }
 
 type goUnit struct {
 	pkgname    string
+	license    string // SPDX license identifier from meta/license, if any
 	imports    map[string]string
 	enums      []goEnum
 	interfaces []goInterface
@@ -136,6 +155,9 @@ type goUnit struct {
 
 func (g *goUnit) emit(buf io.Writer) {
 	_, _ = fmt.Fprint(buf, "// Generated by Zanbato. Do not edit!\n\n")
+	if g.license != "" {
+		_, _ = fmt.Fprintf(buf, "// SPDX-License-Identifier: %s\n\n", g.license)
+	}
 	_, _ = fmt.Fprintf(buf, "package %s\n\n", g.pkgname)
 	if len(g.imports) > 0 {
 		var keys []string
@@ -0,0 +1,41 @@
+package golang
+
+import "github.com/jchv/zanbato/kaitai/emitter"
+
+// goDoc converts a .ksy doc and doc-ref into godoc comment lines. URL
+// references with a description become doc links.
+func goDoc(doc string, refs []string) []string {
+	lines := emitter.DocLines(doc)
+	if len(refs) == 0 {
+		return lines
+	}
+	if len(lines) > 0 {
+		lines = append(lines, "")
+	}
+	var items, links []string
+	for _, r := range refs {
+		ref := emitter.ParseDocRef(r)
+		switch {
+		case ref.URL != "" && ref.Text != "":
+			items = append(items, "["+ref.Text+"]")
+			links = append(links, "["+ref.Text+"]: "+ref.URL)
+		case ref.URL != "":
+			items = append(items, ref.URL)
+		default:
+			items = append(items, ref.Text)
+		}
+	}
+	if len(items) == 1 {
+		lines = append(lines, "See "+items[0]+".")
+	} else {
+		lines = append(lines, "See also:")
+		for _, item := range items {
+			lines = append(lines, "  - "+item)
+		}
+	}
+	if len(links) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, links...)
+	}
+	return lines
+}
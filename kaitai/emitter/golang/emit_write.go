@@ -410,7 +410,7 @@ func (e *Emitter) writePrimitive(unit *goUnit, fn *goFunc, typ *engine.ExprValue
 				} else if rt.TypeRef.String.Terminator >= 0 && !rt.TypeRef.String.Include && rt.TypeRef.String.Consume {
 					fn.pf("if err = wstream.WriteBytes(_enc_bytes); err != nil { return err }")
 					if isMultiByteEncoding(enc) {
-						fn.pf("if err = wstream.WriteBytes([]byte{%d, %d}); err != nil { return err }", rt.TypeRef.String.Terminator, rt.TypeRef.String.Terminator)
+						fn.pf("if err = wstream.WriteBytes(%s); err != nil { return err }", multiByteTerminator(enc, rt.TypeRef.String.Terminator))
 					} else {
 						fn.pf("if err = wstream.WriteU1(%d); err != nil { return err }", rt.TypeRef.String.Terminator)
 					}
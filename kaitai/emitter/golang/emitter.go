@@ -58,6 +58,7 @@ type Emitter struct {
 	pkgname     string
 	pkgpath     string
 	resolver    resolve.Resolver
+	graph       *resolve.Graph
 	endian      types.EndianKind
 	bitEndian   types.BitEndianKind
 	context     *engine.Context
@@ -65,10 +66,19 @@ type Emitter struct {
 	visited     map[*kaitai.Struct]struct{}
 	debugAlways bool
 	compat      kaitai.Compatibility
+	optimize    bool
 	debug       bool
 
+	// funcs holds the host functions expressions may call, and
+	// funcSymbols the Go functions generated code calls for them.
+	funcs       *engine.FuncRegistry
+	funcSymbols map[string]string
+
 	mode exprMode
 
+	// typed holds the inferred types of the expression expr is translating.
+	typed *engine.TypedExpr
+
 	// file holds the currently-active per-file state. nil outside any
 	// pushFileScope-bounded region.
 	file *fileScope
@@ -140,6 +150,7 @@ func NewEmitter(pkgpath string, resolver resolve.Resolver) *Emitter {
 		context:  engine.NewContext(),
 		visited:  make(map[*kaitai.Struct]struct{}),
 		compat:   engine.DefaultCompat,
+		funcs:    engine.DefaultFuncs,
 	}
 }
 
@@ -154,17 +165,46 @@ func (e *Emitter) SetCompat(c kaitai.Compatibility) {
 	e.compat = c
 }
 
+// SetOptimize controls whether expressions are simplified with
+// engine.Optimize before they are emitted.
+func (e *Emitter) SetOptimize(enabled bool) {
+	e.optimize = enabled
+}
+
+// SetFuncs sets the registry of host functions that expressions may call.
+// By default, engine.DefaultFuncs is used.
+func (e *Emitter) SetFuncs(funcs *engine.FuncRegistry) {
+	e.funcs = funcs
+}
+
+// MapFunc sets the Go function that generated code calls for the host
+// function name. The symbol may be qualified by an import path, as in
+// "example.com/checksum.CRC32"; otherwise it names a function in the
+// generated package. Unmapped host functions are called by their name.
+//
+// Host methods are called with their receiver as the first argument. Integer
+// receivers are passed as int, and arguments are converted to the Go types of
+// the declared argument types.
+func (e *Emitter) MapFunc(name, symbol string) {
+	if e.funcSymbols == nil {
+		e.funcSymbols = make(map[string]string)
+	}
+	e.funcSymbols[name] = symbol
+}
+
 // Emit emits Go code for the given kaitai struct.
 func (e *Emitter) Emit(inputname string, s *kaitai.Struct) []emitter.Artifact {
 	e.endian = types.UnspecifiedOrder
 	e.bitEndian = types.UnspecifiedBitOrder
 	e.context = engine.NewContext()
 	e.context.Compat = e.compat
+	e.context.Funcs = e.funcs
 	e.artifacts = nil
 	e.visited = make(map[*kaitai.Struct]struct{})
 	e.mode = exprMode{}
 	e.debug = e.debugAlways
 	e.file = nil
+	e.graph = resolve.NewGraph(e.resolver, inputname, s)
 
 	e.root(inputname, s)
 	return e.artifacts
@@ -175,7 +215,7 @@ func (e *Emitter) filename(n kaitai.Identifier) string {
 }
 
 func (e *Emitter) typeName(n kaitai.Identifier) string {
-	return ksToGoName(string(n))
+	return TypeName(n)
 }
 
 func (e *Emitter) typeSwitchName(n kaitai.Identifier) string {
@@ -183,13 +223,30 @@ func (e *Emitter) typeSwitchName(n kaitai.Identifier) string {
 }
 
 func (e *Emitter) fieldName(n kaitai.Identifier) string {
-	return e.typeName(n)
+	return FieldName(n)
 }
 
 func (e *Emitter) setImport(unit *goUnit, pkg string, as string) {
 	unit.imports[pkg] = as
 }
 
+// funcSymbol returns the Go expression that calls the host function name,
+// importing its package if needed.
+func (e *Emitter) funcSymbol(name string) string {
+	symbol, ok := e.funcSymbols[name]
+	if !ok {
+		return name
+	}
+	dot := strings.LastIndex(symbol, ".")
+	if dot <= strings.LastIndex(symbol, "/") {
+		return symbol
+	}
+	pkg := symbol[:dot]
+	as := path.Base(pkg)
+	e.setImport(e.file.unit, pkg, as)
+	return as + symbol[dot:]
+}
+
 func (e *Emitter) mustResolveType(ex string) *engine.ExprValue {
 	// Handle Kaitai built-in parameter types
 	switch ex {
@@ -476,8 +533,8 @@ func (e *Emitter) readCallRefOn(sv string, n *types.TypeRef) string {
 					if padRight >= 0 {
 						stripPad = fmt.Sprintf("; _result = kaitai.BytesStripRight(_result, %d)", padByte)
 					}
-					return fmt.Sprintf("(func() ([]byte, error) { _raw, err := %s.ReadBytes(int(%s)); if err != nil { return nil, err }; _result := kaitai.BytesTerminateMulti(_raw, []byte{%d, %d}, %v)%s; return _result, nil }())",
-						sv, e.expr(n.String.Size), termByte, termByte, n.String.Include, stripPad)
+					return fmt.Sprintf("(func() ([]byte, error) { _raw, err := %s.ReadBytes(int(%s)); if err != nil { return nil, err }; _result := kaitai.BytesTerminateMulti(_raw, %s, %v)%s; return _result, nil }())",
+						sv, e.expr(n.String.Size), multiByteTerminator(n.String.Encoding, termByte), n.String.Include, stripPad)
 				}
 				return fmt.Sprintf("%s.ReadBytesPadTerm(int(%s), %d, %d, %v)", sv, e.expr(n.String.Size), termByte, padByte, n.String.Include)
 			}
@@ -488,9 +545,10 @@ func (e *Emitter) readCallRefOn(sv string, n *types.TypeRef) string {
 				return sv + ".ReadBytesFull()"
 			}
 			if multiByte {
-				// Multi-byte encoding (UTF-16): use ReadBytesTermMulti with 2-byte null
-				return fmt.Sprintf("%s.ReadBytesTermMulti([]byte{%d, %d}, %v, %v, %v)",
-					sv, n.String.Terminator, n.String.Terminator, n.String.Include, n.String.Consume, n.String.EosError)
+				// Multi-byte encoding (UTF-16, UTF-32): use ReadBytesTermMulti with a
+				// code unit sized null
+				return fmt.Sprintf("%s.ReadBytesTermMulti(%s, %v, %v, %v)",
+					sv, multiByteTerminator(n.String.Encoding, n.String.Terminator), n.String.Include, n.String.Consume, n.String.EosError)
 			}
 			if !n.String.EosError {
 				seekBack := ""
@@ -595,8 +653,8 @@ func (e *Emitter) writeCallRefOn(sv string, n *types.TypeRef, valExpr string) st
 		if n.String != nil && n.String.Terminator >= 0 && !n.String.Include && n.String.Consume {
 			// Terminated string, consume=true: write string + terminator byte(s)
 			if isMultiByteEncoding(n.String.Encoding) {
-				// Multi-byte encoding (UTF-16): write 2-byte null terminator
-				return fmt.Sprintf("func() error { if err := %s.WriteBytes([]byte(%s)); err != nil { return err }; return %s.WriteBytes([]byte{%d, %d}) }()", sv, valExpr, sv, n.String.Terminator, n.String.Terminator)
+				// Multi-byte encoding (UTF-16, UTF-32): write a code unit sized null
+				return fmt.Sprintf("func() error { if err := %s.WriteBytes([]byte(%s)); err != nil { return err }; return %s.WriteBytes(%s) }()", sv, valExpr, sv, multiByteTerminator(n.String.Encoding, n.String.Terminator))
 			}
 			return fmt.Sprintf("func() error { if err := %s.WriteBytes([]byte(%s)); err != nil { return err }; return %s.WriteU1(%d) }()", sv, valExpr, sv, n.String.Terminator)
 		}
@@ -730,6 +788,7 @@ func (e *Emitter) root(inputname string, s *kaitai.Struct) {
 	defer e.pushFileScope(s.ID)()
 	e.file.parents = engine.BuildParentTypeMap(e.context, rootType)
 	e.file.opaqueTypes = s.Meta.OpaqueTypes
+	e.file.unit.license = s.Meta.License
 
 	e.struc(inputname, e.file.unit, root)
 
@@ -763,17 +822,21 @@ func (e *Emitter) enterLocal(val *engine.ExprValue) func() {
 }
 
 func (e *Emitter) enumTypeName(parent *engine.ExprValue, enum *kaitai.Enum) string {
-	return e.prefix(parent) + e.typeName(enum.ID)
+	return TypeName(append(e.typePath(parent), enum.ID)...)
 }
 
 func (e *Emitter) enumValueName(parent *engine.ExprValue, enum *kaitai.Enum, id kaitai.Identifier) string {
-	return e.prefix(parent) + e.typeName(enum.ID) + "__" + e.typeName(id)
+	return EnumValueName(id, append(e.typePath(parent), enum.ID)...)
 }
 
 func (e *Emitter) enum(unit *goUnit, enum *engine.ExprValue) {
 	g := goEnum{name: e.enumTypeName(enum.Parent, enum.Enum), decltype: "int"}
 	for _, v := range enum.Enum.Values {
-		g.values = append(g.values, goEnumValue{name: e.enumValueName(enum.Parent, enum.Enum, v.ID), value: int(v.Value.Int64())})
+		g.values = append(g.values, goEnumValue{
+			name:  e.enumValueName(enum.Parent, enum.Enum, v.ID),
+			value: int(v.Value.Int64()),
+			doc:   goDoc(v.Doc, v.DocRef),
+		})
 	}
 	unit.enums = append(unit.enums, g)
 }
@@ -989,7 +1052,7 @@ func (e *Emitter) emitAttrRead(unit *goUnit, fn *goFunc, typ *engine.ExprValue,
 
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("attr: %s: %s", a.ID, r))
+			panic(emitter.AnnotatePanic(r, a.Source, "attr: %s", a.ID))
 		}
 	}()
 
@@ -1393,7 +1456,7 @@ func (e *Emitter) emitAttrRead(unit *goUnit, fn *goFunc, typ *engine.ExprValue,
 					if termByte >= 0 && isMultiByte {
 						// Multi-byte terminator search + optional pad stripping
 						e.setImport(unit, kaitaiRuntimePackagePath, kaitaiRuntimePackageName)
-						fn.pf("tmp%d = kaitai.BytesTerminateMulti(_raw_%d, []byte{%d, %d}, %v)", fn.tmp, fn.tmp, termByte, termByte, include)
+						fn.pf("tmp%d = kaitai.BytesTerminateMulti(_raw_%d, %s, %v)", fn.tmp, fn.tmp, multiByteTerminator(rt.TypeRef.String.Encoding, termByte), include)
 						if padRight >= 0 {
 							// Strip pad from terminated result, capture everything after stripped data as tail
 							fn.pf("tmp%d = kaitai.BytesStripRight(tmp%d, %d)", fn.tmp, fn.tmp, padRight)
@@ -1584,11 +1647,21 @@ func (e *Emitter) emitAttrRead(unit *goUnit, fn *goFunc, typ *engine.ExprValue,
 }
 
 func (e *Emitter) prefix(typ *engine.ExprValue) string {
-	if typ == nil || typ.Struct == nil {
+	path := e.typePath(typ)
+	if len(path) == 0 {
 		return ""
 	}
-	// Build full prefix by walking up the definition-site parent chain (never re-parented)
-	return e.prefix(typ.DefParent) + e.typeName(typ.Struct.Type.ID) + "_"
+	return TypeName(path...) + "_"
+}
+
+// typePath returns the IDs of typ and the structs it is defined in, outermost
+// first. It walks the definition-site parent chain, which is never
+// re-parented.
+func (e *Emitter) typePath(typ *engine.ExprValue) []kaitai.Identifier {
+	if typ == nil || typ.Struct == nil {
+		return nil
+	}
+	return append(e.typePath(typ.DefParent), typ.Struct.Type.ID)
 }
 
 // Determines if endian switching may be necessary for a type.
@@ -1857,12 +1930,36 @@ func (e *Emitter) endianSwitch(unit *goUnit, gs *goStruct, ks *kaitai.Struct, va
 	unit.methods = append(unit.methods, fn)
 }
 
+// attrFieldType returns the Go type of the struct field backing a seq attr.
+func (e *Emitter) attrFieldType(attr *engine.ExprValue) string {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(emitter.AnnotatePanic(r, attr.Attr.Source, "attr: %s", attr.Attr.ID))
+		}
+	}()
+
+	fieldType := e.declType(attr)
+	if attr.Attr.Enum != "" {
+		enumType := e.mustResolveType(attr.Attr.Enum)
+		fieldType = e.declType(enumType)
+		if attr.Attr.Repeat != nil {
+			fieldType = "[]" + fieldType
+		}
+	}
+	// For conditional seq attrs (if: expr), use 'any' for primitives
+	// so nil can be returned when condition is false
+	if attr.Attr.If != nil && needsPointerForNil(fieldType) {
+		fieldType = "any"
+	}
+	return fieldType
+}
+
 func (e *Emitter) struc(inputname string, unit *goUnit, val *engine.ExprValue) {
 	ks := val.Struct.Type
 
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("struct %s: %s", ks.ID, r))
+			panic(emitter.AnnotatePanic(r, ks.Source, "struct %s", ks.ID))
 		}
 	}()
 
@@ -1871,17 +1968,17 @@ func (e *Emitter) struc(inputname string, unit *goUnit, val *engine.ExprValue) {
 	name := e.typeName(ks.ID)
 	prefix := e.prefix(val.DefParent)
 
-	gs := goStruct{name: prefix + name}
+	gs := goStruct{name: prefix + name, doc: goDoc(ks.Doc, ks.DocRef)}
 
 	defer e.enterLocal(val)()
 
 	// Handle imports before anything else...
 	for _, n := range ks.Meta.Imports {
-		inputname, s, err := e.resolver.Resolve(inputname, n)
+		imp, err := e.graph.Import(inputname, n)
 		if err != nil {
 			panic(err)
 		}
-		e.root(inputname, s)
+		e.root(imp.Name, imp.Struct)
 	}
 
 	// Then handle nested structures.
@@ -1915,27 +2012,16 @@ func (e *Emitter) struc(inputname string, unit *goUnit, val *engine.ExprValue) {
 		gs.fields = append(gs.fields, goVar{
 			name: e.fieldName(param.ID),
 			typ:  e.declTypeRef(&param.Type, nil),
+			doc:  goDoc(param.Doc, param.DocRef),
 		})
 	}
 
 	// Attribute fields
 	for _, attr := range val.Struct.Attrs {
-		fieldType := e.declType(attr)
-		if attr.Attr.Enum != "" {
-			enumType := e.mustResolveType(attr.Attr.Enum)
-			fieldType = e.declType(enumType)
-			if attr.Attr.Repeat != nil {
-				fieldType = "[]" + fieldType
-			}
-		}
-		// For conditional seq attrs (if: expr), use 'any' for primitives
-		// so nil can be returned when condition is false
-		if attr.Attr.If != nil && needsPointerForNil(fieldType) {
-			fieldType = "any"
-		}
 		gs.fields = append(gs.fields, goVar{
 			name: e.fieldName(attr.Attr.ID),
-			typ:  fieldType,
+			typ:  e.attrFieldType(attr),
+			doc:  goDoc(attr.Attr.Doc, attr.Attr.DocRef),
 		})
 	}
 	// Add alignment fields for bit->byte transitions
@@ -2272,6 +2358,7 @@ func (e *Emitter) instanceGetter(unit *goUnit, gs *goStruct, val *engine.ExprVal
 	e.mode.needParent = false
 
 	fn := goFunc{
+		doc:  goDoc(instAttr.Doc, instAttr.DocRef),
 		recv: goVar{name: "this", typ: "*" + gs.name},
 		name: fieldName,
 		out:  []goVar{{name: "v", typ: retType}, {name: "err", typ: "error"}},
@@ -3,10 +3,14 @@ package golang
 import (
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/jchv/zanbato/kaitai"
 	"github.com/jchv/zanbato/kaitai/emitter"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+	"github.com/jchv/zanbato/kaitai/types"
 )
 
 func TestEmitterReuseResetsState(t *testing.T) {
@@ -66,16 +70,16 @@ func TestUnsupportedEncodingGeneratesRuntimeErrorExpression(t *testing.T) {
 	e := NewEmitter("test_formats", resolve.NewOSResolver())
 	unit := &goUnit{imports: map[string]string{}}
 
-	decoder := e.encodingDecoder(unit, "KOI8-R")
+	decoder := e.encodingDecoder(unit, "X-UNKNOWN")
 	if !strings.Contains(decoder, "unsupported string encoding: %s") ||
-		!strings.Contains(decoder, `"KOI8R"`) ||
+		!strings.Contains(decoder, `"XUNKNOWN"`) ||
 		!strings.Contains(decoder, "*encoding.Decoder") {
 		t.Fatalf("unexpected unsupported decoder expression: %s", decoder)
 	}
 
-	encoder := e.encodingEncoder(unit, "KOI8_R")
+	encoder := e.encodingEncoder(unit, "X_UNKNOWN")
 	if !strings.Contains(encoder, "unsupported string encoding: %s") ||
-		!strings.Contains(encoder, `"KOI8R"`) ||
+		!strings.Contains(encoder, `"XUNKNOWN"`) ||
 		!strings.Contains(encoder, "*encoding.Encoder") {
 		t.Fatalf("unexpected unsupported encoder expression: %s", encoder)
 	}
@@ -88,6 +92,25 @@ func TestUnsupportedEncodingGeneratesRuntimeErrorExpression(t *testing.T) {
 	}
 }
 
+func TestEncodingExpressions(t *testing.T) {
+	e := NewEmitter("test_formats", resolve.NewOSResolver())
+	unit := &goUnit{imports: map[string]string{}}
+
+	if got := e.encodingDecoder(unit, "koi8-r"); got != "charmap.KOI8R.NewDecoder()" {
+		t.Fatalf("unexpected KOI8-R decoder expression: %s", got)
+	}
+	if got := e.encodingEncoder(unit, "UTF-32LE"); got != "utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM).NewEncoder()" {
+		t.Fatalf("unexpected UTF-32LE encoder expression: %s", got)
+	}
+	if unit.imports["golang.org/x/text/encoding/charmap"] != "charmap" ||
+		unit.imports["golang.org/x/text/encoding/unicode/utf32"] != "utf32" {
+		t.Fatalf("encoding expressions should import their packages, got imports %v", unit.imports)
+	}
+	if got := multiByteTerminator("UTF-32BE", 0); got != "[]byte{0, 0, 0, 0}" {
+		t.Fatalf("unexpected UTF-32 terminator: %s", got)
+	}
+}
+
 func requireArtifact(t *testing.T, artifacts []emitter.Artifact, filename string) {
 	t.Helper()
 	if !hasArtifact(artifacts, filename) {
@@ -118,3 +141,126 @@ func artifactNames(artifacts []emitter.Artifact) []string {
 	}
 	return names
 }
+
+func TestLicenseHeader(t *testing.T) {
+	e := NewEmitter("test_formats", resolve.NewOSResolver())
+
+	licensed := &kaitai.Struct{ID: "licensed", Meta: kaitai.Meta{License: "MIT"}}
+	artifacts := e.Emit("licensed.ksy", licensed)
+	requireArtifact(t, artifacts, "licensed.go")
+	body := string(artifacts[0].Body)
+	if !strings.Contains(body, "// SPDX-License-Identifier: MIT\n\npackage test_formats") {
+		t.Fatalf("expected license header before package clause, got:\n%s", body)
+	}
+
+	unlicensed := &kaitai.Struct{ID: "unlicensed"}
+	artifacts = e.Emit("unlicensed.ksy", unlicensed)
+	if strings.Contains(string(artifacts[0].Body), "SPDX") {
+		t.Fatalf("unexpected license header:\n%s", artifacts[0].Body)
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	e := NewEmitter("test_formats", resolve.NewOSResolver())
+
+	documented := &kaitai.Struct{
+		ID:     "documented",
+		Doc:    "A documented type.\n",
+		DocRef: []string{"https://example.com/spec The spec", "Section 4"},
+		Seq: []*kaitai.Attr{
+			{
+				ID:     "magic",
+				Type:   types.Type{TypeRef: &types.TypeRef{Kind: types.U1}},
+				Doc:    "Magic byte.",
+				DocRef: []string{"https://example.com/magic"},
+			},
+		},
+	}
+	artifacts := e.Emit("documented.ksy", documented)
+	requireArtifact(t, artifacts, "documented.go")
+	body := string(artifacts[0].Body)
+	for _, want := range []string{
+		"// A documented type.\n//\n// See also:\n//   - [The spec]\n//   - Section 4\n//\n// [The spec]: https://example.com/spec\ntype Documented struct",
+		"\t// Magic byte.\n\t//\n\t// See https://example.com/magic.\n\tMagic ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in output, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestExprPanicPosition(t *testing.T) {
+	resolver := resolve.NewFSResolver(fstest.MapFS{"main.ksy": &fstest.MapFile{Data: []byte(`
+meta:
+  id: main
+seq:
+  - id: len
+    type: u1
+instances:
+  bad:
+    value: len + nope * 2
+`)}})
+	basename, struc, err := resolver.Resolve("", "main.ksy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		r := recover()
+		err, ok := r.(error)
+		if !ok {
+			t.Fatalf("expected an error panic, got %v", r)
+		}
+		pos, _ := srcpos.Of(err)
+		if want := (srcpos.Pos{File: "main.ksy", Line: 9, Column: 18}); pos != want {
+			t.Fatalf("panic at %v, want %v: %v", pos, want, err)
+		}
+	}()
+	NewEmitter("test_formats", resolver).Emit(basename, struc)
+}
+
+func TestHostFuncs(t *testing.T) {
+	funcs := engine.NewFuncRegistry()
+	for _, fn := range []engine.HostFunc{
+		{Name: "sum8", Receiver: engine.BytesReceiver, ReturnType: engine.IntegerValueType},
+		{Name: "scale", Arguments: []engine.ValueType{engine.IntegerValueType, engine.IntegerValueType}, ReturnType: engine.IntegerValueType},
+	} {
+		if err := funcs.Register(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolver := resolve.NewFSResolver(fstest.MapFS{"main.ksy": &fstest.MapFile{Data: []byte(`
+meta:
+  id: main
+seq:
+  - id: data
+    size: 4
+  - id: sum
+    type: u1
+    valid:
+      expr: _ == data.sum8
+instances:
+  scaled:
+    value: scale(sum, 3)
+`)}})
+	basename, struc, err := resolver.Resolve("", "main.ksy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEmitter("test_formats", resolver)
+	e.SetFuncs(funcs)
+	e.MapFunc("sum8", "example.com/checksum.Sum8")
+	artifacts := e.Emit(basename, struc)
+	requireArtifact(t, artifacts, "main.go")
+	body := string(artifacts[0].Body)
+	for _, want := range []string{
+		`"example.com/checksum"`,
+		"checksum.Sum8(",
+		"scale(",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in output, got:\n%s", want, body)
+		}
+	}
+}
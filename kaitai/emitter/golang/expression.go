@@ -3,10 +3,13 @@ package golang
 import (
 	"fmt"
 	"math/big"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/charset"
+	"github.com/jchv/zanbato/kaitai/emitter"
 	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/types"
@@ -16,9 +19,28 @@ func (e *Emitter) expr(ex *expr.Expr) string {
 	if ex == nil {
 		panic("expr called with nil expression")
 	}
+	defer emitter.RecoverExpr(ex)
+	if e.optimize {
+		ex = engine.Optimize(e.context, ex)
+	}
+	// Type errors are reported by the linter; translation uses whatever
+	// types could be inferred.
+	defer func(prev *engine.TypedExpr) { e.typed = prev }(e.typed)
+	e.typed, _ = engine.InferExpr(e.context, ex)
 	return e.exprNode(ex.Root)
 }
 
+// typeOf returns the inferred type of n, a node of the expression being
+// translated, if it is known.
+func (e *Emitter) typeOf(n expr.Node) (engine.InferredType, bool) {
+	if expr.SpanOf(n) == (expr.Span{}) {
+		// Synthesized nodes aren't part of the typed expression.
+		return engine.InferredType{}, false
+	}
+	t, ok := e.typed.TypeOf(n)
+	return t, ok && t.IsKnown()
+}
+
 func (e *Emitter) calcPromotionTypeKind(a types.Kind, b types.Kind) string {
 	if a == b {
 		return "" // Same types, no promotion needed
@@ -120,6 +142,14 @@ func (e *Emitter) calcPromotionExprValue(a *engine.ExprValue, b *engine.ExprValu
 }
 
 func (e *Emitter) calcPromotionNode(a expr.Node, b expr.Node) string {
+	if ta, ok := e.typeOf(a); ok {
+		if tb, ok := e.typeOf(b); ok {
+			if ta.TypeKind() == 0 || tb.TypeKind() == 0 {
+				return ""
+			}
+			return e.calcPromotionTypeKind(ta.TypeKind(), tb.TypeKind())
+		}
+	}
 	av := engine.ResultTypeOfNode(e.context, a)
 	if av == nil {
 		return ""
@@ -150,9 +180,9 @@ func (e *Emitter) exprPromotionBinaryNode(n expr.BinaryNode) string {
 	}
 }
 
-// needsEncodingConversion returns whether the encoding needs explicit conversion
+// normalizeEncoding returns the normalized form of an encoding name.
 func (e *Emitter) normalizeEncoding(enc string) string {
-	return strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(enc, "-", ""), "_", ""))
+	return charset.Normalize(enc)
 }
 
 func stringLiteralValue(node expr.Node) (string, bool) {
@@ -164,41 +194,19 @@ func stringLiteralValue(node expr.Node) (string, bool) {
 	}
 }
 
+// needsEncodingConversion returns whether the encoding needs explicit conversion
 func (e *Emitter) needsEncodingConversion(enc string) bool {
-	enc = e.normalizeEncoding(enc)
-	switch enc {
-	case "", "UTF8", "ASCII":
-		return false
-	default:
-		return true
-	}
+	return charset.NeedsConversion(enc)
 }
 
 // encodingDecoder returns Go code to create a decoder for the given encoding
 func (e *Emitter) encodingDecoder(unit *goUnit, enc string) string {
-	enc = e.normalizeEncoding(enc)
-	switch enc {
-	case "UTF16LE":
-		e.setImport(unit, "golang.org/x/text/encoding/unicode", "unicode")
-		return "unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()"
-	case "UTF16BE":
-		e.setImport(unit, "golang.org/x/text/encoding/unicode", "unicode")
-		return "unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()"
-	case "SHIFTJIS", "SJIS":
-		e.setImport(unit, "golang.org/x/text/encoding/japanese", "japanese")
-		return "japanese.ShiftJIS.NewDecoder()"
-	case "IBM437", "CP437":
-		e.setImport(unit, "golang.org/x/text/encoding/charmap", "charmap")
-		return "charmap.CodePage437.NewDecoder()"
-	case "ISO88591", "LATIN1":
-		e.setImport(unit, "golang.org/x/text/encoding/charmap", "charmap")
-		return "charmap.ISO8859_1.NewDecoder()"
-	case "WINDOWS1252", "CP1252":
-		e.setImport(unit, "golang.org/x/text/encoding/charmap", "charmap")
-		return "charmap.Windows1252.NewDecoder()"
-	default:
-		return e.unsupportedEncodingDecoder(unit, enc)
+	cs, err := charset.Lookup(enc)
+	if err != nil || cs.Encoding == nil {
+		return e.unsupportedEncodingDecoder(unit, e.normalizeEncoding(enc))
 	}
+	e.setImport(unit, cs.GoPackage, path.Base(cs.GoPackage))
+	return cs.GoExpr + ".NewDecoder()"
 }
 
 func (e *Emitter) unsupportedEncodingDecoder(unit *goUnit, enc string) string {
@@ -216,29 +224,12 @@ func (e *Emitter) unsupportedEncodingEncoder(unit *goUnit, enc string) string {
 // encodingEncoder returns Go code to create an encoder for the given encoding.
 // This is the inverse of encodingDecoder - used for writing strings back.
 func (e *Emitter) encodingEncoder(unit *goUnit, enc string) string {
-	enc = e.normalizeEncoding(enc)
-	switch enc {
-	case "UTF16LE":
-		e.setImport(unit, "golang.org/x/text/encoding/unicode", "unicode")
-		return "unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()"
-	case "UTF16BE":
-		e.setImport(unit, "golang.org/x/text/encoding/unicode", "unicode")
-		return "unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder()"
-	case "SHIFTJIS", "SJIS":
-		e.setImport(unit, "golang.org/x/text/encoding/japanese", "japanese")
-		return "japanese.ShiftJIS.NewEncoder()"
-	case "IBM437", "CP437":
-		e.setImport(unit, "golang.org/x/text/encoding/charmap", "charmap")
-		return "charmap.CodePage437.NewEncoder()"
-	case "ISO88591", "LATIN1":
-		e.setImport(unit, "golang.org/x/text/encoding/charmap", "charmap")
-		return "charmap.ISO8859_1.NewEncoder()"
-	case "WINDOWS1252", "CP1252":
-		e.setImport(unit, "golang.org/x/text/encoding/charmap", "charmap")
-		return "charmap.Windows1252.NewEncoder()"
-	default:
-		return e.unsupportedEncodingEncoder(unit, enc)
+	cs, err := charset.Lookup(enc)
+	if err != nil || cs.Encoding == nil {
+		return e.unsupportedEncodingEncoder(unit, e.normalizeEncoding(enc))
 	}
+	e.setImport(unit, cs.GoPackage, path.Base(cs.GoPackage))
+	return cs.GoExpr + ".NewEncoder()"
 }
 
 // inferInstanceType determines the Go type for an instance, using the same logic
@@ -537,6 +528,9 @@ applyModifiers:
 }
 
 func (e *Emitter) exprMethod(t expr.MemberNode, v *engine.ExprValue) string {
+	if v.Method.Host != nil {
+		return e.exprHostCall(v.Method.Host, t.Operand, nil)
+	}
 	operand := e.exprNode(t.Operand)
 	method := v.Method
 	switch method.Method {
@@ -599,6 +593,30 @@ func (e *Emitter) exprMethod(t expr.MemberNode, v *engine.ExprValue) string {
 	}
 }
 
+// exprHostCall emits a call to a host function. recv is the receiver of a
+// method, or nil for a free function.
+func (e *Emitter) exprHostCall(fn *engine.HostFunc, recv expr.Node, args []expr.Node) string {
+	if len(args) != len(fn.Arguments) {
+		panic(fmt.Errorf("%s expects %d arguments, got %d", fn.Name, len(fn.Arguments), len(args)))
+	}
+	var callArgs []string
+	if recv != nil {
+		operand := e.exprNode(recv)
+		if fn.Receiver == engine.IntReceiver {
+			operand = fmt.Sprintf("int(%s)", operand)
+		}
+		callArgs = append(callArgs, operand)
+	}
+	for i, arg := range args {
+		s := e.exprNode(arg)
+		if ref := fn.Arguments[i].Type.TypeRef; ref != nil && ref.Kind != types.User && fn.Arguments[i].Repeat == nil {
+			s = fmt.Sprintf("(%s)(%s)", e.declTypeRef(ref, nil), s)
+		}
+		callArgs = append(callArgs, s)
+	}
+	return fmt.Sprintf("%s(%s)", e.funcSymbol(fn.Name), strings.Join(callArgs, ", "))
+}
+
 // isByteArrayComparison checks if a binary comparison involves byte arrays
 // (which require bytes.Equal in Go instead of == or !=)
 func (e *Emitter) isByteArrayComparison(a, b expr.Node) bool {
@@ -628,6 +646,9 @@ func (e *Emitter) isNodeByteArray(n expr.Node) bool {
 	if _, ok := n.(expr.ArrayNode); ok {
 		return true
 	}
+	if t, ok := e.typeOf(n); ok {
+		return t.TypeKind() == types.Bytes
+	}
 	result := engine.ResultTypeOfNode(e.context, n)
 	if result != nil {
 		// For instances, use inferInstanceType which does expression-based inference
@@ -818,6 +839,7 @@ func (e *Emitter) exprBinaryNode(t expr.BinaryNode) string {
 }
 
 func (e *Emitter) exprNode(node expr.Node) string {
+	defer emitter.RecoverNode(node)
 	switch t := node.(type) {
 	case expr.UnaryNode:
 		switch t.Op {
@@ -1083,6 +1105,22 @@ func (e *Emitter) exprNode(node expr.Node) string {
 	case expr.SubscriptNode:
 		return fmt.Sprintf("(%s)[%s]", e.exprNode(t.A), e.exprNode(t.B))
 	case expr.CallNode:
+		if v := engine.ResultTypeOfNode(e.context, t.Object); v != nil && v.Kind == engine.MethodKind && v.Method.Host != nil {
+			var recv expr.Node
+			if mn, ok := t.Object.(expr.MemberNode); ok {
+				recv = mn.Operand
+			}
+			call := e.exprHostCall(v.Method.Host, recv, t.Args)
+			// Calls are typed by their return value, which uses int and
+			// float64 for numbers whatever the declared width.
+			if ret := v.Method.ReturnType; ret.Type.TypeRef != nil && ret.Repeat == nil {
+				switch goType := e.declType(engine.NewValueOfType(e.context, *ret.Type.TypeRef)); goType {
+				case "int", "float64":
+					call = fmt.Sprintf("%s(%s)", goType, call)
+				}
+			}
+			return call
+		}
 		// Method call: the object is typically a MemberNode
 		// Check if the object is a MemberNode for method dispatch
 		if mn, ok := t.Object.(expr.MemberNode); ok {
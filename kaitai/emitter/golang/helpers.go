@@ -2,21 +2,36 @@ package golang
 
 import (
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/jchv/zanbato/kaitai/charset"
 	"github.com/jchv/zanbato/kaitai/expr"
 )
 
 // isMultiByteEncoding returns true if the encoding uses multi-byte code units
 // (e.g., UTF-16), meaning terminators need to be multi-byte too.
 func isMultiByteEncoding(enc string) bool {
-	enc = strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(enc, "-", ""), "_", ""))
-	switch enc {
-	case "UTF16LE", "UTF16BE", "UTF16":
-		return true
-	default:
-		return false
+	return encodingUnit(enc) > 1
+}
+
+// encodingUnit returns the size of a code unit of the encoding in bytes.
+func encodingUnit(enc string) int {
+	if cs, err := charset.Lookup(enc); err == nil {
+		return cs.Unit
+	}
+	return 1
+}
+
+// multiByteTerminator returns a Go []byte literal for the terminator of a
+// string in a multi-byte encoding: the terminator byte repeated for each
+// byte of a code unit.
+func multiByteTerminator(enc string, term int) string {
+	parts := make([]string, encodingUnit(enc))
+	for i := range parts {
+		parts[i] = strconv.Itoa(term)
 	}
+	return "[]byte{" + strings.Join(parts, ", ") + "}"
 }
 
 // needsPointerForNil returns true if a Go type needs pointer wrapping to be nilable.
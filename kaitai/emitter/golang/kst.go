@@ -817,13 +817,11 @@ func (g *kstGen) translateEnumScope(node expr.ScopeNode) string {
 
 	// Convert to Go constant name: TypeName_EnumName__ValueName
 	if len(parts) >= 3 {
-		var goName strings.Builder
-		goName.WriteString(ksToGoName(parts[0]))
-		for _, part := range parts[1 : len(parts)-1] {
-			goName.WriteString("_" + ksToGoName(part))
+		path := make([]kaitai.Identifier, len(parts)-1)
+		for i, part := range parts[:len(parts)-1] {
+			path[i] = kaitai.Identifier(part)
 		}
-		goName.WriteString("__" + ksToGoName(parts[len(parts)-1]))
-		return goName.String()
+		return EnumValueName(kaitai.Identifier(parts[len(parts)-1]), path...)
 	}
 
 	// Fallback
@@ -3,6 +3,8 @@ package golang
 import (
 	"strings"
 	"unicode"
+
+	"github.com/jchv/zanbato/kaitai"
 )
 
 // ksToGoName converts a Kaitai Struct identifier (e.g. "my_type_name") to a
@@ -45,3 +47,25 @@ func isSeparator(r rune) bool {
 	}
 	return unicode.IsSpace(r)
 }
+
+// TypeName returns the Go type name generated for the struct or enum at
+// path, the chain of identifiers from its module's root type down to it.
+// For example, the enum main::header::kinds is named Main_Header_Kinds.
+func TypeName(path ...kaitai.Identifier) string {
+	names := make([]string, len(path))
+	for i, id := range path {
+		names[i] = ksToGoName(string(id))
+	}
+	return strings.Join(names, "_")
+}
+
+// FieldName returns the Go name generated for a param, seq attr or instance.
+func FieldName(id kaitai.Identifier) string {
+	return ksToGoName(string(id))
+}
+
+// EnumValueName returns the Go constant name generated for the value id of
+// the enum at path.
+func EnumValueName(id kaitai.Identifier, path ...kaitai.Identifier) string {
+	return TypeName(path...) + "__" + ksToGoName(string(id))
+}
@@ -126,7 +126,7 @@ func (e *Emitter) emitProcess(fn *goFunc, unit *goUnit, process *expr.Expr, varN
 			return
 		}
 	}
-	panic(fmt.Errorf("unsupported process expression: %s", process))
+	panic(fmt.Errorf("unsupported process expression: %s", process.Root))
 }
 
 // emitUnprocess applies the inverse of a process transformation.
@@ -233,5 +233,5 @@ func (e *Emitter) emitUnprocess(fn *goFunc, unit *goUnit, process *expr.Expr, va
 			return
 		}
 	}
-	panic(fmt.Errorf("unsupported unprocess expression: %s", process))
+	panic(fmt.Errorf("unsupported unprocess expression: %s", process.Root))
 }
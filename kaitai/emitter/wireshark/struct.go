@@ -22,6 +22,8 @@ import (
 type Emitter struct {
 	outpath   string
 	resolver  resolve.Resolver
+	graph     *resolve.Graph
+	visited   map[*kaitai.Struct]struct{}
 	endian    types.EndianKind
 	bitEndian types.BitEndianKind
 	context   *engine.Context
@@ -39,11 +41,19 @@ func NewEmitter(outpath string, resolver resolve.Resolver) *Emitter {
 
 // Emit emits Go code for the given kaitai struct.
 func (e *Emitter) Emit(inputname string, s *kaitai.Struct) []emitter.Artifact {
+	e.graph = resolve.NewGraph(e.resolver, inputname, s)
+	e.visited = make(map[*kaitai.Struct]struct{})
 	e.root(inputname, s)
 	return e.artifacts
 }
 
 func (e *Emitter) root(inputname string, s *kaitai.Struct) {
+	// Modules can import each other.
+	if _, ok := e.visited[s]; ok {
+		return
+	}
+	e.visited[s] = struct{}{}
+
 	oldEndian := e.endian
 	oldBitEndian := e.bitEndian
 
@@ -87,7 +97,7 @@ func (e *Emitter) struc(inputname string, mod *module, val *engine.ExprValue) {
 
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("struct %s: %s", ks.ID, r))
+			panic(emitter.AnnotatePanic(r, ks.Source, "struct %s", ks.ID))
 		}
 	}()
 
@@ -102,11 +112,11 @@ func (e *Emitter) struc(inputname string, mod *module, val *engine.ExprValue) {
 
 	// Handle imports before anything else...
 	for _, n := range ks.Meta.Imports {
-		inputname, s, err := e.resolver.Resolve(inputname, n)
+		imp, err := e.graph.Import(inputname, n)
 		if err != nil {
 			panic(err)
 		}
-		e.root(inputname, s)
+		e.root(imp.Name, imp.Struct)
 	}
 
 	// Then handle nested structures
@@ -1,15 +1,28 @@
 package kaitai
 
-import "math/big"
+import (
+	"math/big"
+
+	"github.com/jchv/zanbato/kaitai/srcpos"
+)
 
 // EnumValue contains a single enum value.
 type EnumValue struct {
-	Value *big.Int
-	ID    Identifier
+	Value  *big.Int
+	ID     Identifier
+	Doc    string
+	DocRef []string
+
+	// Extensions holds the value's vendor extension keys, keyed with their
+	// leading dash.
+	Extensions map[string]any
 }
 
 // Enum contains the definition of an enumeration.
 type Enum struct {
 	ID     Identifier
 	Values []EnumValue
+
+	// Source is where the enum is declared in the .ksy file.
+	Source srcpos.Pos
 }
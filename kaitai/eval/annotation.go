@@ -1,6 +1,10 @@
 package eval
 
 import (
+	"iter"
+	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -40,13 +44,13 @@ func (p Path) MarshalText() (text []byte, err error) {
 func (p *Path) UnmarshalText(b []byte) error {
 	*p = Path{}
 	text := string(b)
-	more := true
+	more := text != ""
 	for more {
 		var element string
 		element, text, more = strings.Cut(text, ".")
 		name, subscript, hasSubscript := strings.Cut(element, "[")
 		if hasSubscript && len(subscript) > 0 && subscript[len(subscript)-1] == ']' {
-			if index, err := strconv.Atoi(string(subscript)); err == nil {
+			if index, err := strconv.Atoi(subscript[:len(subscript)-1]); err == nil {
 				*p = append(*p, PathItem{
 					Name:  name,
 					Index: &index,
@@ -64,9 +68,178 @@ func (p *Path) UnmarshalText(b []byte) error {
 type Label struct {
 	Attr  Path
 	Value any
+
+	// TypeName is the KSY type of the field; see Node.TypeName.
+	TypeName string `json:",omitempty"`
+
+	// EnumLabel is the symbolic name of an enum value, if it has one.
+	EnumLabel string `json:",omitempty"`
 }
 
 type Annotation struct {
 	Range Range
 	Label Label
+
+	// Depth is how deeply the field is nested, starting at 1 for the fields
+	// of the root struct. Array elements have the depth of their array.
+	Depth int
+}
+
+// AnnotationOptions configures Tree.Annotations.
+type AnnotationOptions struct {
+	// MaxDepth is the deepest fields are annotated; a struct or array at
+	// this depth is annotated as a whole. Zero means no limit.
+	MaxDepth int
+
+	// Instances resolves instances that haven't been resolved yet. Without
+	// it, only instances that something else has already resolved are
+	// annotated. Value instances never are, having no bytes.
+	Instances bool
+}
+
+// Annotations resolves the tree and returns its leaf fields as annotations
+// of the root buffer, in order of offset. Structs and arrays aren't
+// annotated themselves, except at AnnotationOptions.MaxDepth; neither are
+// fields that read no bytes. The contents of a field read through
+// `process:` aren't in the root buffer, so such fields are annotated as a
+// whole.
+//
+// A field that fails to resolve is yielded along with its error, with its
+// range if it is known (see Tree.Recover), and iteration goes on.
+func (t *Tree) Annotations(opts AnnotationOptions) iter.Seq2[Annotation, error] {
+	return func(yield func(Annotation, error) bool) {
+		a := &annotator{opts: opts, yield: yield, visiting: make(map[structAt]bool)}
+		a.visitStruct(t.root)
+		a.flush(math.MaxUint64)
+	}
+}
+
+type annotator struct {
+	opts    AnnotationOptions
+	yield   func(Annotation, error) bool
+	stopped bool
+
+	// pending holds the annotations found under instances, sorted by
+	// offset, until the seq fields reach them. collecting is non-zero while
+	// instances are being walked.
+	pending    []pendingAnnotation
+	collecting int
+
+	// visiting guards against instances that reread their own struct, as
+	// in NodesInRange.
+	visiting map[structAt]bool
+}
+
+// pendingAnnotation is an annotation waiting to be yielded, with its error.
+type pendingAnnotation struct {
+	ann Annotation
+	err error
+}
+
+// emit yields an annotation once every pending one before it has been
+// yielded, or adds it to the pending ones while walking instances. An
+// annotation for an error whose range isn't known starts at 0, so it is
+// yielded before the pending ones.
+func (a *annotator) emit(ann Annotation, err error) {
+	if a.stopped {
+		return
+	}
+	if a.collecting > 0 {
+		i := sort.Search(len(a.pending), func(i int) bool {
+			return a.pending[i].ann.Range.StartIndex > ann.Range.StartIndex
+		})
+		a.pending = slices.Insert(a.pending, i, pendingAnnotation{ann, err})
+		return
+	}
+	a.flush(ann.Range.StartIndex)
+	if !a.stopped {
+		a.stopped = !a.yield(ann, err)
+	}
+}
+
+// flush yields the pending annotations that start before offset.
+func (a *annotator) flush(offset uint64) {
+	for len(a.pending) > 0 && a.pending[0].ann.Range.StartIndex < offset && !a.stopped {
+		p := a.pending[0]
+		a.pending = a.pending[1:]
+		a.stopped = !a.yield(p.ann, p.err)
+	}
+}
+
+func (a *annotator) visitStruct(n *Node) {
+	if err := n.Resolve(); err != nil && len(n.children) == 0 {
+		a.emit(annotationOf(n), err)
+		return
+	}
+	// Instances go first so that their annotations are pending by the time
+	// the seq fields reach them.
+	a.collecting++
+	for _, inst := range n.instances {
+		if inst.attr != nil && inst.attr.Value != nil {
+			continue
+		}
+		if a.opts.Instances || inst.state == stateResolved {
+			a.visit(inst)
+		}
+	}
+	a.collecting--
+	for _, child := range n.children {
+		a.visit(child)
+		if !n.tree.canSkip(child) {
+			// The fields after it can't be read.
+			break
+		}
+	}
+}
+
+func (a *annotator) visit(n *Node) {
+	if a.stopped {
+		return
+	}
+	err := n.Resolve()
+	atLimit := a.opts.MaxDepth > 0 && len(n.path) >= a.opts.MaxDepth
+	processed := n.attr != nil && n.attr.Process != nil
+	switch {
+	case isArrayNode(n) && !atLimit && !processed:
+		var last *Node
+		itemsErr := n.eachItem(func(item *Node) {
+			a.visit(item)
+			last = item
+		})
+		// A failed element reports its own error.
+		if itemsErr != nil && (last == nil || last.state != stateError) {
+			a.emit(annotationOf(n), itemsErr)
+		}
+	case n.schema != nil && !atLimit && !processed:
+		key := structAt{schema: n.schema, start: n.absRange().StartIndex}
+		if a.visiting[key] {
+			return
+		}
+		a.visiting[key] = true
+		a.visitStruct(n)
+		delete(a.visiting, key)
+	case err != nil:
+		a.emit(annotationOf(n), err)
+	default:
+		if ann := annotationOf(n); ann.Range.EndIndex > ann.Range.StartIndex {
+			a.emit(ann, nil)
+		}
+	}
+}
+
+// annotationOf describes a node that has been resolved, or failed to.
+func annotationOf(n *Node) Annotation {
+	ann := Annotation{
+		Label: Label{
+			Attr:      n.path,
+			Value:     n.value.goValue(),
+			TypeName:  n.TypeName(),
+			EnumLabel: n.value.EnumLabel,
+		},
+		Depth: len(n.path),
+	}
+	if n.state != stateError || n.spanKnown {
+		ann.Range = n.absRange()
+	}
+	return ann
 }
@@ -0,0 +1,157 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const annotationSource = `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: hdr
+    type: hdr
+  - id: name
+    type: strz
+    encoding: ASCII
+instances:
+  trailer:
+    pos: 1
+    type: u1
+  double_kind:
+    value: kind.to_i * 2
+types:
+  hdr:
+    seq:
+      - id: size
+        type: u2
+      - id: flags
+        type: b4
+enums:
+  kinds:
+    1: one
+`
+
+func collectAnnotations(t *testing.T, tree *Tree, opts AnnotationOptions) []Annotation {
+	t.Helper()
+	var result []Annotation
+	for ann, err := range tree.Annotations(opts) {
+		require.NoError(t, err)
+		result = append(result, ann)
+	}
+	return result
+}
+
+func annotationPaths(anns []Annotation) []string {
+	paths := []string{}
+	for _, ann := range anns {
+		paths = append(paths, ann.Label.Attr.String())
+	}
+	return paths
+}
+
+func TestAnnotations(t *testing.T) {
+	data := []byte{1, 0x10, 0x00, 0xf0, 'h', 'i', 0}
+
+	tree := openSourceBytes(t, annotationSource, data)
+	anns := collectAnnotations(t, tree, AnnotationOptions{})
+	assert.Equal(t, []string{"kind", "hdr.size", "hdr.flags", "name"}, annotationPaths(anns))
+	assert.Equal(t, Annotation{
+		Range: Range{StartIndex: 0, EndIndex: 1},
+		Label: Label{Attr: anns[0].Label.Attr, Value: int64(1), TypeName: "u1", EnumLabel: "one"},
+		Depth: 1,
+	}, anns[0])
+	assert.Equal(t, Range{StartIndex: 1, EndIndex: 3}, anns[1].Range)
+	assert.Equal(t, uint64(16), anns[1].Label.Value)
+	assert.Equal(t, "u2le", anns[1].Label.TypeName)
+	assert.Equal(t, 2, anns[1].Depth)
+	assert.Equal(t, "b4", anns[2].Label.TypeName)
+	assert.Equal(t, "hi", anns[3].Label.Value)
+	assert.Equal(t, "strz", anns[3].Label.TypeName)
+
+	// Forced instances are merged in by offset, after seq fields at the same
+	// offset.
+	anns = collectAnnotations(t, tree, AnnotationOptions{Instances: true})
+	assert.Equal(t, []string{"kind", "hdr.size", "trailer", "hdr.flags", "name"}, annotationPaths(anns))
+
+	// Once resolved, instances are included without forcing them.
+	tree = openSourceBytes(t, annotationSource, data)
+	trailer, err := tree.Root().Child("trailer")
+	require.NoError(t, err)
+	require.NoError(t, trailer.Resolve())
+	anns = collectAnnotations(t, tree, AnnotationOptions{})
+	assert.Equal(t, []string{"kind", "hdr.size", "trailer", "hdr.flags", "name"}, annotationPaths(anns))
+
+	anns = collectAnnotations(t, tree, AnnotationOptions{MaxDepth: 1})
+	assert.Equal(t, []string{"kind", "hdr", "trailer", "name"}, annotationPaths(anns))
+	assert.Equal(t, Range{StartIndex: 1, EndIndex: 4}, anns[1].Range)
+	assert.Equal(t, "hdr", anns[1].Label.TypeName)
+	assert.Nil(t, anns[1].Label.Value)
+}
+
+func TestAnnotationsErrors(t *testing.T) {
+	src := `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+  - id: b
+    contents: [1, 2]
+  - id: c
+    type: u1
+`
+	tree := openSourceBytes(t, src, []byte{0, 9, 9, 0})
+	var paths []string
+	var errs int
+	for ann, err := range tree.Annotations(AnnotationOptions{}) {
+		paths = append(paths, ann.Label.Attr.String())
+		if err != nil {
+			errs++
+		}
+	}
+	assert.Equal(t, []string{"a", "b"}, paths)
+	assert.Equal(t, 1, errs)
+
+	// Stopping early is fine.
+	for range tree.Annotations(AnnotationOptions{}) {
+		break
+	}
+}
+
+func TestAnnotationsErrorOrder(t *testing.T) {
+	src := `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+  - id: b
+    type: u1
+  - id: c
+    type: u1
+instances:
+  bad:
+    pos: 1
+    contents: [7]
+`
+	// The failed instance is yielded in order of offset, like the others.
+	tree := openSourceBytes(t, src, []byte{0, 1, 2})
+	var paths []string
+	for ann, err := range tree.Annotations(AnnotationOptions{Instances: true}) {
+		paths = append(paths, ann.Label.Attr.String())
+		if ann.Label.Attr.String() == "bad" {
+			assert.Error(t, err)
+			assert.Equal(t, Range{StartIndex: 1, EndIndex: 2}, ann.Range)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "bad", "c"}, paths)
+}
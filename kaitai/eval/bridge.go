@@ -21,6 +21,7 @@ func (t *Tree) evaluateExpr(scope *Node, e *expr.Expr) (*engine.ExprValue, error
 	}
 	defer func() { t.evalDepth-- }()
 
+	e = t.optimize(scope, e)
 	ctx := t.contextForNode(scope)
 	ctx.PushStack()
 	defer ctx.PopStack()
@@ -34,7 +35,9 @@ func (t *Tree) evaluateExpr(scope *Node, e *expr.Expr) (*engine.ExprValue, error
 
 // evaluateExprWithTemp evaluates an expression with a temporary value bound to "_".
 // Used for repeat-until conditions where "_" refers to the current element.
+// A negative index leaves "_index" unbound.
 func (t *Tree) evaluateExprWithTemp(scope *Node, e *expr.Expr, temp *Node, index int) (*engine.ExprValue, error) {
+	e = t.optimize(scope, e)
 	ctx := t.contextForNode(scope)
 	ctx.PushStack()
 	defer ctx.PopStack()
@@ -47,12 +50,73 @@ func (t *Tree) evaluateExprWithTemp(scope *Node, e *expr.Expr, temp *Node, index
 			newCtx = newCtx.WithTemporary(tmpVal)
 		}
 	}
-	newCtx = newCtx.WithIndex(engine.NewIntegerLiteralValue(big.NewInt(int64(index))))
+	if index >= 0 {
+		newCtx = newCtx.WithIndex(engine.NewIntegerLiteralValue(big.NewInt(int64(index))))
+	}
 	ctx.SetContext(newCtx)
 
 	return engine.Evaluate(ctx, e)
 }
 
+// optimizedKey identifies an expression of a struct type.
+type optimizedKey struct {
+	typeSym *engine.ExprValue
+	text    string
+}
+
+// optimize returns e simplified with engine.Optimize in the static scope of
+// the struct that scope belongs to, if t.Optimize is set.
+func (t *Tree) optimize(scope *Node, e *expr.Expr) *expr.Expr {
+	if !t.Optimize || e == nil {
+		return e
+	}
+	ctx := t.staticContext(scope)
+	if ctx == nil {
+		return e
+	}
+	key := optimizedKey{scopeStruct(scope).typeSym, e.Text}
+	if root, ok := t.optimized[key]; ok && e.Text != "" {
+		result := *e
+		result.Root = root
+		return &result
+	}
+	// Instances aren't inlined, since SetValue can override them.
+	result := engine.Optimize(ctx, e, engine.KeepInstances())
+	if e.Text != "" {
+		if t.optimized == nil {
+			t.optimized = make(map[optimizedKey]expr.Node)
+		}
+		t.optimized[key] = result.Root
+	}
+	return result
+}
+
+// scopeStruct returns the struct node whose members are in scope for
+// expressions evaluated at scope: its parent for a seq field, or scope
+// itself for a struct.
+func scopeStruct(scope *Node) *Node {
+	if scope.schema == nil && scope.parent != nil {
+		return scope.parent
+	}
+	return scope
+}
+
+// staticContext returns the type-level context of the struct scope belongs
+// to, or nil if its type isn't known.
+func (t *Tree) staticContext(scope *Node) *engine.Context {
+	structNode := scopeStruct(scope)
+	if structNode.typeSym == nil {
+		return nil
+	}
+	ctx := t.typeCtx.WithLocalRoot(structNode.typeSym)
+	if structNode.root != nil && structNode.root.typeSym != nil {
+		ctx = ctx.WithModuleRoot(structNode.root.typeSym)
+	}
+	ctx.Compat = t.Compat
+	ctx.Funcs = t.Funcs
+	return ctx
+}
+
 // contextForNode creates an EvalContext configured for expression evaluation
 // in the scope of the given node. The OnResolve callback lazily resolves
 // sibling nodes when the expression engine needs their values.
@@ -60,10 +124,7 @@ func (t *Tree) contextForNode(scope *Node) *engine.EvalContext {
 	// Find the struct node that is the scope for name resolution.
 	// For a seq field, that's its parent struct.
 	// For a struct node itself, that's itself.
-	structNode := scope
-	if scope.schema == nil && scope.parent != nil {
-		structNode = scope.parent
-	}
+	structNode := scopeStruct(scope)
 
 	// Build the type context with the correct local/module roots.
 	// We create value-level symbols and pre-populate them with resolved
@@ -112,6 +173,7 @@ func (t *Tree) contextForNode(scope *Node) *engine.EvalContext {
 
 	ctx := engine.NewEvalContext(typeCtx)
 	ctx.Compat = t.Compat
+	ctx.Funcs = t.Funcs
 
 	ctx.OnResolve = func(sym *engine.ExprValue) *engine.ExprValue {
 		// Prevent arbitrarily deep recursion
@@ -0,0 +1,183 @@
+package eval
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// Coverage describes which bytes of the root buffer the fields of a tree
+// account for. All ranges are in root buffer coordinates, sorted by start.
+type Coverage struct {
+	// Size is the size of the root buffer.
+	Size uint64
+
+	// Covered holds the bytes read by at least one field, with adjacent and
+	// overlapping ranges merged.
+	Covered []Range
+
+	// Uncovered holds the gaps between Covered, up to Size.
+	Uncovered []Range
+
+	// Overlaps holds the bytes claimed by more than one field.
+	Overlaps []Overlap
+}
+
+// Overlap is a range of bytes read by two different fields, which is usually
+// a mistake in the spec. Bit fields sharing a byte don't count.
+type Overlap struct {
+	Range Range
+
+	// A and B are the fields, with A starting first.
+	A, B *Node
+}
+
+// CoveredBytes returns the number of bytes read by at least one field.
+func (c *Coverage) CoveredBytes() uint64 {
+	var total uint64
+	for _, r := range c.Covered {
+		total += r.EndIndex - r.StartIndex
+	}
+	return total
+}
+
+// Coverage fully resolves the tree and reports the bytes its fields read.
+// Only leaf fields count: a struct that is larger than its fields leaves the
+// bytes in between uncovered. Fields read through `process:` count as
+// covering their raw bytes, and fields that fail to resolve don't count at
+// all; the first resolution error is returned along with the coverage of
+// the rest of the tree (see Tree.Recover).
+func (t *Tree) Coverage() (*Coverage, error) {
+	size, err := t.stream.Size()
+	if err != nil {
+		return nil, fmt.Errorf("getting stream size: %w", err)
+	}
+	w := &coverageWalk{visiting: make(map[structAt]bool)}
+	w.visitStruct(t.root)
+
+	sort.SliceStable(w.leaves, func(i, j int) bool {
+		return w.leaves[i].r.StartIndex < w.leaves[j].r.StartIndex
+	})
+	c := &Coverage{Size: uint64(size)}
+	c.Covered = mergeRanges(w.leaves)
+	c.Uncovered = gaps(c.Covered, c.Size)
+	c.Overlaps = overlaps(w.leaves)
+	return c, w.err
+}
+
+type coverageLeaf struct {
+	r Range
+	n *Node
+}
+
+type coverageWalk struct {
+	leaves []coverageLeaf
+	err    error
+
+	// visiting guards against instances that reread their own struct, as
+	// in NodesInRange.
+	visiting map[structAt]bool
+}
+
+func (w *coverageWalk) record(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *coverageWalk) visitStruct(n *Node) {
+	w.record(n.Resolve())
+	for _, child := range n.children {
+		w.visit(child)
+	}
+	for _, inst := range n.instances {
+		if inst.attr != nil && inst.attr.Value != nil {
+			continue
+		}
+		w.visit(inst)
+	}
+}
+
+func (w *coverageWalk) visit(n *Node) {
+	err := n.Resolve()
+	w.record(err)
+	processed := n.attr != nil && n.attr.Process != nil
+	switch {
+	case isArrayNode(n) && !processed:
+		w.record(n.eachItem(w.visit))
+	case n.schema != nil && !processed:
+		r, _ := n.ByteRange()
+		key := structAt{schema: n.schema, start: r.StartIndex}
+		if w.visiting[key] {
+			return
+		}
+		w.visiting[key] = true
+		w.visitStruct(n)
+		delete(w.visiting, key)
+	case err == nil:
+		if r := n.absRange(); r.EndIndex > r.StartIndex {
+			w.leaves = append(w.leaves, coverageLeaf{r: r, n: n})
+		}
+	}
+}
+
+// mergeRanges merges the ranges of leaves, which are sorted by start.
+func mergeRanges(leaves []coverageLeaf) []Range {
+	var merged []Range
+	for _, leaf := range leaves {
+		if last := len(merged) - 1; last >= 0 && leaf.r.StartIndex <= merged[last].EndIndex {
+			merged[last].EndIndex = max(merged[last].EndIndex, leaf.r.EndIndex)
+			continue
+		}
+		merged = append(merged, leaf.r)
+	}
+	return merged
+}
+
+// gaps returns the ranges of [0, size) not in covered, which is sorted and
+// merged.
+func gaps(covered []Range, size uint64) []Range {
+	var result []Range
+	var pos uint64
+	for _, r := range covered {
+		if r.StartIndex > pos {
+			result = append(result, Range{StartIndex: pos, EndIndex: min(r.StartIndex, size)})
+		}
+		pos = max(pos, r.EndIndex)
+	}
+	if pos < size {
+		result = append(result, Range{StartIndex: pos, EndIndex: size})
+	}
+	return result
+}
+
+// overlaps finds the pairs of leaves, which are sorted by start, that share
+// bytes.
+func overlaps(leaves []coverageLeaf) []Overlap {
+	var result []Overlap
+	var active []coverageLeaf
+	for _, leaf := range leaves {
+		kept := active[:0]
+		for _, prev := range active {
+			if prev.r.EndIndex <= leaf.r.StartIndex {
+				continue
+			}
+			kept = append(kept, prev)
+			if isBitField(prev.n) && isBitField(leaf.n) {
+				continue
+			}
+			result = append(result, Overlap{
+				Range: Range{StartIndex: leaf.r.StartIndex, EndIndex: min(prev.r.EndIndex, leaf.r.EndIndex)},
+				A:     prev.n,
+				B:     leaf.n,
+			})
+		}
+		active = append(kept, leaf)
+	}
+	return result
+}
+
+func isBitField(n *Node) bool {
+	return n.typeRef != nil && n.typeRef.Kind == types.Bits
+}
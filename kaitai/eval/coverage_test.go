@@ -0,0 +1,82 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoverage(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: magic
+    size: 2
+  - id: hdr
+    type: hdr
+    size: 6
+  - id: flags
+    type: b4
+  - id: mode
+    type: b4
+instances:
+  trailer:
+    pos: 12
+    type: u2le
+  alias:
+    pos: 3
+    type: u1
+  total:
+    value: magic.size + hdr.a
+types:
+  hdr:
+    seq:
+      - id: a
+        type: u1
+      - id: b
+        type: u2le
+`, make([]byte, 16))
+
+	c, err := tree.Coverage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), c.Size)
+	assert.Equal(t, []Range{{0, 5}, {8, 9}, {12, 14}}, c.Covered)
+	assert.Equal(t, []Range{{5, 8}, {9, 12}, {14, 16}}, c.Uncovered)
+	assert.Equal(t, uint64(8), c.CoveredBytes())
+
+	// flags and mode share a byte, which is fine for bit fields; alias
+	// rereads a byte of hdr.b, which is reported.
+	require.Len(t, c.Overlaps, 1)
+	assert.Equal(t, Range{3, 4}, c.Overlaps[0].Range)
+	assert.Equal(t, "hdr.b", c.Overlaps[0].A.Path().String())
+	assert.Equal(t, "alias", c.Overlaps[0].B.Path().String())
+}
+
+func TestCoverageErrors(t *testing.T) {
+	src := `
+meta:
+  id: main
+seq:
+  - id: a
+    size: 2
+  - id: b
+    contents: [1, 2]
+  - id: c
+    size: 2
+`
+	tree := openSourceBytes(t, src, []byte{0, 0, 9, 9, 0, 0, 0})
+	c, err := tree.Coverage()
+	require.Error(t, err)
+	assert.Equal(t, []Range{{0, 2}}, c.Covered)
+	assert.Equal(t, []Range{{2, 7}}, c.Uncovered)
+
+	// Fields after a failed one are still covered when reading can go on.
+	tree = openSourceBytes(t, src, []byte{0, 0, 9, 9, 0, 0, 0})
+	tree.Recover = true
+	c, err = tree.Coverage()
+	require.Error(t, err)
+	assert.Equal(t, []Range{{0, 2}, {4, 6}}, c.Covered)
+	assert.Equal(t, []Range{{2, 4}, {6, 7}}, c.Uncovered)
+}
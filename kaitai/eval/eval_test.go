@@ -1,11 +1,17 @@
 package eval
 
 import (
+	"errors"
+	"math/big"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/jchv/zanbato/kaitai/srcpos"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -394,3 +400,487 @@ func TestRuntime_LazyUserType(t *testing.T) {
 			"chunks[1].%s should remain lazy", f.Name())
 	}
 }
+
+func TestNodeExtensions(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: hdr
+    type: header
+    -webide-parse-mode: eager
+types:
+  header:
+    -webide-representation: "{len:dec}"
+    seq:
+      - id: len
+        type: u1
+        -orig-id: bLength
+`, []byte{3})
+
+	assert.Nil(t, tree.Root().Extensions())
+
+	hdr, err := tree.Root().Child("hdr")
+	require.NoError(t, err)
+	require.NoError(t, hdr.Resolve())
+	assert.Equal(t, map[string]any{
+		"-webide-parse-mode":     "eager",
+		"-webide-representation": "{len:dec}",
+	}, hdr.Extensions())
+
+	length, err := hdr.Child("len")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"-orig-id": "bLength"}, length.Extensions())
+}
+
+func TestStringEncodings(t *testing.T) {
+	data := []byte{0xed, 0xc9, 0xd2, 0, 'h', 0, 0, 0, 'i', 0, 0, 0, 0, 0, 0, 0, 'a', 'b'}
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+  encoding: KOI8-R
+seq:
+  - id: name
+    type: strz
+  - id: wide
+    type: strz
+    encoding: UTF-32LE
+  - id: rest
+    type: str
+    size: 2
+    encoding: x-unknown
+instances:
+  wide_len:
+    value: wide.length
+`, data)
+	root := tree.Root()
+
+	name, err := root.Child("name")
+	require.NoError(t, err)
+	v, err := name.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "Мир", v.Str)
+
+	wide, err := root.Child("wide")
+	require.NoError(t, err)
+	v, err = wide.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", v.Str)
+	r, err := wide.ByteRange()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), r.EndIndex)
+
+	wideLen, err := root.Child("wide_len")
+	require.NoError(t, err)
+	v, err = wideLen.Value()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), v.Int)
+
+	rest, err := root.Child("rest")
+	require.NoError(t, err)
+	_, err = rest.Value()
+	assert.ErrorContains(t, err, `unknown encoding "x-unknown"`)
+
+	require.NoError(t, name.SetValue(Value{Kind: KindStr, Str: "Привет"}))
+	assert.ErrorContains(t, name.SetValue(Value{Kind: KindStr, Str: "日本"}), "encoding KOI8-R")
+}
+
+func TestRecover(t *testing.T) {
+	src := `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: magic
+    contents: [0x7f]
+  - id: hdr
+    type: header
+  - id: body
+    size: 3
+    type: body
+  - id: items
+    type: u2
+    repeat: expr
+    repeat-expr: 3
+    valid:
+      max: 0x20
+  - id: name
+    type: strz
+    encoding: ASCII
+  - id: after
+    type: u1
+types:
+  header:
+    seq:
+      - id: a
+        type: u1
+      - id: b
+        type: u1
+  body:
+    seq:
+      - id: x
+        type: u4
+`
+	data := []byte{0, 1, 2, 9, 9, 9, 0x10, 0, 0x20, 0, 0x30, 0}
+	open := func(recoverErrors bool) *Tree {
+		tree := openSourceBytes(t, src, data)
+		tree.Recover = recoverErrors
+		return tree
+	}
+	child := func(n *Node, name string) *Node {
+		c, err := n.Child(name)
+		require.NoError(t, err)
+		require.NotNil(t, c)
+		return c
+	}
+
+	// Without recovery, everything after the bad magic fails.
+	root := open(false).Root()
+	assert.Error(t, child(root, "hdr").Resolve())
+
+	root = open(true).Root()
+	magic := child(root, "magic")
+	require.Error(t, magic.Resolve())
+	r, err := magic.ByteRange()
+	require.Error(t, err)
+	assert.Equal(t, Range{StartIndex: 0, EndIndex: 1}, r)
+
+	hdr := child(root, "hdr")
+	require.NoError(t, hdr.Resolve())
+	b, err := child(hdr, "b").Value()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), b.Uint)
+
+	// The body is sized, so its unreadable field doesn't stop its siblings.
+	body := child(root, "body")
+	require.NoError(t, body.Resolve())
+	assert.Error(t, child(body, "x").Resolve())
+
+	items, err := child(root, "items").Items()
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	v, err := items[1].Value()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x20), v.Uint)
+	r, err = items[2].ByteRange()
+	require.Error(t, err)
+	assert.Equal(t, Range{StartIndex: 10, EndIndex: 12}, r)
+
+	// A string that runs off the end has no known size, so nothing after it
+	// can be read.
+	name := child(root, "name")
+	require.Error(t, name.Resolve())
+	_, err = name.ByteRange()
+	require.Error(t, err)
+	assert.ErrorContains(t, child(root, "after").Resolve(), "resolving predecessor name")
+}
+
+func TestRecover_Truncated(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: head
+    size: 7
+  - id: recs
+    type: u2
+    repeat: expr
+    repeat-expr: 3
+  - id: tail
+    type: u4
+`, []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 3})
+	tree.Recover = true
+
+	// recs[2] would end past the end of the file, so it isn't skipped, and
+	// nothing after it is placed there either.
+	recs, err := tree.Root().Child("recs")
+	require.NoError(t, err)
+	items, err := recs.Items()
+	require.Error(t, err)
+	require.Len(t, items, 3)
+	_, err = items[2].ByteRange()
+	require.Error(t, err)
+	tail, err := tree.Root().Child("tail")
+	require.NoError(t, err)
+	assert.ErrorContains(t, tail.Resolve(), "resolving predecessor recs")
+	cov, err := tree.Coverage()
+	require.Error(t, err)
+	for _, r := range cov.Covered {
+		assert.LessOrEqual(t, r.EndIndex, uint64(12))
+	}
+}
+
+func TestRecover_SiblingOffsets(t *testing.T) {
+	src := `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: rec
+    type: rec
+    size: 8
+  - id: name
+    type: strz
+    encoding: ASCII
+  - id: crc
+    type: u2
+types:
+  rec:
+    seq:
+      - id: name
+        type: strz
+        encoding: ASCII
+      - id: flags
+        type: u1
+      - id: crc
+        type: u2
+`
+	data := []byte{'a', 'b', 'c', 'd', 'e', 7, 0x34, 0x12, 'x', 'y'}
+	tree := openSourceBytes(t, src, data)
+	tree.Recover = true
+	rec, err := tree.Root().Child("rec")
+	require.NoError(t, err)
+	require.NoError(t, rec.Resolve())
+
+	// The name runs off the end of the record, but the fields after it
+	// have known sizes and end the record, so they are read from its end.
+	name, err := rec.Child("name")
+	require.NoError(t, err)
+	require.Error(t, name.Resolve())
+	r, err := name.ByteRange()
+	require.Error(t, err)
+	assert.Equal(t, Range{StartIndex: 0, EndIndex: 5}, r)
+	flags, err := rec.Eval("flags")
+	require.NoError(t, err)
+	assert.Equal(t, Value{Kind: KindInt, Int: 7}, flags)
+	crc, err := rec.Eval("crc")
+	require.NoError(t, err)
+	assert.Equal(t, Value{Kind: KindInt, Int: 0x1234}, crc)
+
+	// The root stream may go on past the fields, so its end places nothing.
+	name, err = tree.Root().Child("name")
+	require.NoError(t, err)
+	require.Error(t, name.Resolve())
+	crcNode, err := tree.Root().Child("crc")
+	require.NoError(t, err)
+	assert.ErrorContains(t, crcNode.Resolve(), "resolving predecessor name")
+}
+
+func TestExprErrorPosition(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: items
+    type: u1
+    repeat: expr
+    repeat-expr: 2
+instances:
+  bad:
+    value: items[0] + items[5]
+`, []byte{1, 2})
+
+	bad, err := tree.Root().Child("bad")
+	require.NoError(t, err)
+	err = bad.Resolve()
+	require.ErrorContains(t, err, "out of bounds")
+	pos, ok := srcpos.Of(err)
+	require.True(t, ok)
+	assert.Equal(t, srcpos.Pos{File: "main.ksy", Line: 11, Column: 23}, pos)
+}
+
+func TestOptimize(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: items
+    type: u1
+    repeat: expr
+    repeat-expr: 1 + 1
+instances:
+  scale:
+    value: 2 * 4
+  total:
+    value: items[0] * scale + items[1] * 1
+  bad:
+    value: items[0] + items[2 + 3]
+`, []byte{3, 4})
+	tree.Optimize = true
+
+	val := evalExprOnTree(t, tree, tree.Root(), "total")
+	require.NotNil(t, val.Integer)
+	assert.Equal(t, int64(28), val.Integer.Value.Int64())
+
+	// Errors in folded expressions still point at the original source.
+	bad, err := tree.Root().Child("bad")
+	require.NoError(t, err)
+	err = bad.Resolve()
+	require.ErrorContains(t, err, "out of bounds")
+	pos, ok := srcpos.Of(err)
+	require.True(t, ok)
+	assert.Equal(t, srcpos.Pos{File: "main.ksy", Line: 15, Column: 23}, pos)
+}
+
+func TestOptimize_SetValue(t *testing.T) {
+	// An overridden instance gives the same result with or without
+	// optimization.
+	for _, optimize := range []bool{false, true} {
+		tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+instances:
+  scale:
+    value: 2 * 4
+`, []byte{3})
+		tree.Optimize = optimize
+		scale, err := tree.Root().Child("scale")
+		require.NoError(t, err)
+		require.NoError(t, scale.SetValue(Value{Kind: KindInt, Int: 1}))
+		v, err := tree.Root().Eval("a * scale")
+		require.NoError(t, err)
+		assert.Equal(t, Value{Kind: KindInt, Int: 3}, v, "optimize=%v", optimize)
+	}
+}
+
+func TestHostFuncs(t *testing.T) {
+	funcs := engine.NewFuncRegistry()
+	require.NoError(t, funcs.Register(engine.HostFunc{
+		Name:       "sum8",
+		Receiver:   engine.BytesReceiver,
+		ReturnType: engine.IntegerValueType,
+		Fn: func(this *engine.ExprValue, args []*engine.ExprValue) (*engine.ExprValue, error) {
+			var sum byte
+			for _, b := range this.ByteArray.Value {
+				sum += b
+			}
+			return engine.NewIntegerLiteralValue(big.NewInt(int64(sum))), nil
+		},
+	}))
+	require.NoError(t, funcs.Register(engine.HostFunc{
+		Name:       "scale",
+		Arguments:  []engine.ValueType{engine.IntegerValueType, engine.IntegerValueType},
+		ReturnType: engine.IntegerValueType,
+		Fn: func(this *engine.ExprValue, args []*engine.ExprValue) (*engine.ExprValue, error) {
+			return engine.NewIntegerLiteralValue(new(big.Int).Mul(args[0].Integer.Value, args[1].Integer.Value)), nil
+		},
+	}))
+	require.NoError(t, funcs.Register(engine.HostFunc{
+		Name:       "hex_tag",
+		Receiver:   engine.IntReceiver,
+		ReturnType: engine.StringValueType,
+		Fn: func(this *engine.ExprValue, args []*engine.ExprValue) (*engine.ExprValue, error) {
+			return engine.NewStringLiteralValue("0x" + this.Integer.Value.Text(16)), nil
+		},
+	}))
+	require.NoError(t, funcs.Register(engine.HostFunc{
+		Name:       "broken",
+		Receiver:   engine.BytesReceiver,
+		ReturnType: engine.IntegerValueType,
+		Fn: func(this *engine.ExprValue, args []*engine.ExprValue) (*engine.ExprValue, error) {
+			return nil, errors.New("boom")
+		},
+	}))
+
+	open := func(data []byte) *Tree {
+		tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: data
+    size: 4
+  - id: sum
+    type: u1
+    valid:
+      expr: _ == data.sum8
+instances:
+  scaled:
+    value: scale(sum, 3)
+  tagged:
+    value: sum.hex_tag
+  bad:
+    value: data.broken
+`, data)
+		tree.Funcs = funcs
+		return tree
+	}
+
+	tree := open([]byte{1, 2, 3, 4, 10})
+	require.NoError(t, tree.Root().Resolve())
+	scaled := evalExprOnTree(t, tree, tree.Root(), "scaled")
+	require.NotNil(t, scaled.Integer)
+	assert.Equal(t, int64(30), scaled.Integer.Value.Int64())
+	tagged := evalExprOnTree(t, tree, tree.Root(), "tagged")
+	require.NotNil(t, tagged.String)
+	assert.Equal(t, "0xa", tagged.String.Value)
+
+	bad, err := tree.Root().Child("bad")
+	require.NoError(t, err)
+	assert.ErrorContains(t, bad.Resolve(), "calling broken: boom")
+
+	tree = open([]byte{1, 2, 3, 4, 11})
+	sum, err := tree.Root().Child("sum")
+	require.NoError(t, err)
+	assert.Error(t, sum.Resolve())
+}
+
+func TestNodeEvalExpr(t *testing.T) {
+	tree := openCustomTree(t, "zb_inst_pos_repeat", "zb_inst_pos_repeat.bin", kaitai.ZanbatoNative)
+	entries, err := tree.Root().Child("entries")
+	require.NoError(t, err)
+	items, err := entries.Items()
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+
+	evalExpr := func(src string) (*engine.ExprValue, engine.InferredType) {
+		e, err := expr.ParseExpr(src)
+		require.NoError(t, err)
+		typ, errs := items[1].TypeOfExpr(e)
+		require.Empty(t, errs)
+		val, err := items[1].EvalExpr(e)
+		require.NoError(t, err)
+		return val, typ
+	}
+	val, typ := evalExpr("val * 2")
+	assert.Equal(t, int64(400), val.Integer.Value.Int64())
+	assert.Equal(t, "int", typ.String())
+	val, typ = evalExpr("_parent.count")
+	assert.Equal(t, int64(3), val.Integer.Value.Int64())
+	assert.Equal(t, "u4", typ.String())
+	val, typ = evalExpr("_.val + _index")
+	assert.Equal(t, int64(201), val.Integer.Value.Int64())
+	assert.Equal(t, "int", typ.String())
+
+	count, err := tree.Root().Child("count")
+	require.NoError(t, err)
+	v, err := count.Eval("_ * 2")
+	require.NoError(t, err)
+	assert.Equal(t, Value{Kind: KindInt, Int: 6}, v)
+
+	filter := MustCompile("val > 150 and _parent.count == 3")
+	assert.Equal(t, "val > 150 and _parent.count == 3", filter.String())
+	var matched []int
+	for i, item := range items {
+		v, err := filter.Eval(item)
+		require.NoError(t, err)
+		if v.Bool {
+			matched = append(matched, i)
+		}
+	}
+	assert.Equal(t, []int{1, 2}, matched)
+
+	_, err = Compile("val >")
+	assert.Error(t, err)
+}
+
+func TestPathText(t *testing.T) {
+	for _, text := range []string{"", "a", "a.b[3].c", "entries[0]"} {
+		var path Path
+		require.NoError(t, path.UnmarshalText([]byte(text)))
+		assert.Equal(t, text, path.String())
+	}
+}
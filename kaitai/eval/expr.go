@@ -0,0 +1,103 @@
+package eval
+
+import (
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
+)
+
+// Expr is a parsed KS expression that can be evaluated against any node,
+// in any tree. It is safe to share between goroutines, though the trees it is
+// evaluated against are not.
+type Expr struct {
+	e *expr.Expr
+}
+
+// Compile parses a KS expression for evaluation with Expr.Eval.
+func Compile(src string) (*Expr, error) {
+	e, err := expr.ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{e: e}, nil
+}
+
+// MustCompile is like Compile but panics if the expression can't be parsed.
+func MustCompile(src string) *Expr {
+	x, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// String returns the source text of the expression.
+func (x *Expr) String() string { return x.e.Text }
+
+// Eval evaluates the expression in the scope of n. See Node.Eval.
+func (x *Expr) Eval(n *Node) (Value, error) {
+	val, err := n.EvalExpr(x.e)
+	if err != nil {
+		return Value{}, err
+	}
+	return exprValueToValue(val), nil
+}
+
+// Eval parses and evaluates a KS expression in the scope of this node. To
+// evaluate the same expression against many nodes, use Compile instead.
+//
+// Names resolve as they would for a `valid: expr:` check on the node: to the
+// fields of the struct it belongs to, with `_` bound to the node itself and,
+// for array elements, `_index` bound to its index. For struct nodes, the
+// struct's own fields are in scope as well, so `flags & 0x4 != 0` and
+// `_.flags & 0x4 != 0` are equivalent. Struct and array results have no
+// Value representation and evaluate to a Value of KindNone; use EvalExpr to
+// inspect them.
+func (n *Node) Eval(src string) (Value, error) {
+	x, err := Compile(src)
+	if err != nil {
+		return Value{}, err
+	}
+	return x.Eval(n)
+}
+
+// EvalExpr evaluates a parsed expression in the scope of this node, as Eval
+// does, returning the expression engine's value. Triggers resolution of the
+// node and of whatever the expression refers to.
+func (n *Node) EvalExpr(e *expr.Expr) (*engine.ExprValue, error) {
+	if err := n.Resolve(); err != nil {
+		return nil, err
+	}
+	index, ok := n.arrayIndex()
+	if !ok {
+		index = -1
+	}
+	return n.tree.evaluateExprWithTemp(scopeStruct(n), e, n, index)
+}
+
+// TypeOfExpr infers the static type of e in the scope of this node, as
+// EvalExpr would evaluate it. Does not trigger resolution.
+func (n *Node) TypeOfExpr(e *expr.Expr) (engine.InferredType, []engine.TypeError) {
+	ctx := n.tree.staticContext(n)
+	if ctx == nil {
+		return engine.InferredType{}, nil
+	}
+	if n.attr != nil && n.parent != nil {
+		// Bind `_` the way the checker does for repeat-until conditions.
+		attr := &kaitai.Attr{ID: n.attr.ID, Type: n.attr.Type, Enum: n.attr.Enum}
+		if _, ok := n.arrayIndex(); !ok {
+			attr.Repeat = n.attr.Repeat
+		}
+		ctx = ctx.WithTemporary(&engine.ExprValue{Kind: engine.AttrKind, Parent: n.parent.typeSym, Attr: attr})
+	}
+	typed, errs := engine.InferExpr(ctx, e)
+	return typed.Type(), errs
+}
+
+// arrayIndex returns the index of an array element within its array.
+func (n *Node) arrayIndex() (int, bool) {
+	if len(n.path) == 0 || n.path[len(n.path)-1].Index == nil {
+		return 0, false
+	}
+	return *n.path[len(n.path)-1].Index, true
+}
@@ -1,6 +1,7 @@
 package eval
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
@@ -14,6 +15,8 @@ import (
 	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/kst"
 	"github.com/jchv/zanbato/kaitai/resolve"
+
+	kaitai_io "github.com/jchw-forks/kaitai_struct_go_runtime/kaitai"
 )
 
 var testSources = []struct {
@@ -114,6 +117,13 @@ func TestKST(t *testing.T) {
 					t.Fatalf("error resolving root: %v", err)
 				}
 
+				// Validation failures surface lazily, so walk the whole tree to
+				// trigger them.
+				if spec.Exception != nil && strings.HasPrefix(spec.Exception.Type, "Validation") {
+					runParseExpectError(t, root, spec.Exception)
+					return
+				}
+
 				// Run each assertion
 				for i, assert := range spec.Asserts {
 					switch a := assert.(type) {
@@ -214,6 +224,58 @@ func runExceptionAssertion(t *testing.T, tree *Tree, root *Node, idx int, a kst.
 	}
 }
 
+func runParseExpectError(t *testing.T, root *Node, exc *kst.ExpectedException) {
+	t.Helper()
+
+	err := resolveAll(root)
+	if err == nil {
+		t.Errorf("expected exception %q but parse succeeded", exc.Type)
+		return
+	}
+	base, _, _ := strings.Cut(exc.Type, "<")
+	if !isValidationError(err, base) {
+		t.Errorf("expected exception %q, got: %v", exc.Type, err)
+	}
+}
+
+// resolveAll resolves n and every descendant, returning the first error.
+func resolveAll(n *Node) error {
+	if err := n.Resolve(); err != nil {
+		return err
+	}
+	for _, item := range n.items {
+		if err := resolveAll(item); err != nil {
+			return err
+		}
+	}
+	for _, child := range n.Fields() {
+		if err := resolveAll(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isValidationError reports whether err wraps the kaitai runtime validation
+// error named by a KST exception type.
+func isValidationError(err error, name string) bool {
+	switch name {
+	case "ValidationNotEqualError":
+		return errors.As(err, new(kaitai_io.ValidationNotEqualError))
+	case "ValidationLessThanError":
+		return errors.As(err, new(kaitai_io.ValidationLessThanError))
+	case "ValidationGreaterThanError":
+		return errors.As(err, new(kaitai_io.ValidationGreaterThanError))
+	case "ValidationNotAnyOfError":
+		return errors.As(err, new(kaitai_io.ValidationNotAnyOfError))
+	case "ValidationNotInEnumError":
+		return errors.As(err, new(kaitai_io.ValidationNotInEnumError))
+	case "ValidationExprError":
+		return errors.As(err, new(kaitai_io.ValidationExprError))
+	}
+	return false
+}
+
 // evalExpectedExpr evaluates an expected expression. Expected values are
 // typically literals, so we can use the expression engine directly. For
 // complex expressions that reference fields, we evaluate against the tree.
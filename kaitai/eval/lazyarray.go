@@ -0,0 +1,369 @@
+package eval
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+const (
+	// lazyArrayThreshold is the most elements an array keeps as nodes when
+	// it is read. Larger arrays keep this many, if they have to be read to
+	// be sized at all, and read the rest again on demand.
+	lazyArrayThreshold = 1024
+
+	// lazyArrayStride is how many elements of a variable-size array there
+	// are between the offsets it keeps. Reading an element on demand reads
+	// up to this many elements before it.
+	lazyArrayStride = 64
+
+	// lazyArrayCacheSize is how many of the elements read on demand an
+	// array keeps, so that paging back and forth through it doesn't read
+	// them again.
+	lazyArrayCacheSize = 256
+)
+
+// lazyArray lets an array node read its elements on demand, so that huge
+// arrays don't need a node per element. Elements below len(n.items) are
+// kept as usual; the rest are read when asked for.
+//
+// Elements of the same known size are never read up front: element i is at
+// i times the size. Variable-size elements are read once while resolving the
+// array, to find where it ends, keeping the offset of every
+// lazyArrayStride'th element.
+type lazyArray struct {
+	// count is the number of elements.
+	count int
+
+	// read reads element i from pos in the array's stream.
+	read func(i int, pos int64) (*Node, error)
+
+	// size is the size of every element, or zero if they vary.
+	size int64
+
+	// offsets holds the start of element i*lazyArrayStride, for arrays of
+	// variable-size elements.
+	offsets []int64
+
+	// cache holds the elements past n.items read most recently.
+	cache elementCache
+}
+
+// elementCache holds up to lazyArrayCacheSize elements of a lazyArray,
+// dropping the least recently used.
+type elementCache struct {
+	elems map[int]*list.Element
+	order list.List // of cachedElement, most recently used first
+}
+
+type cachedElement struct {
+	index int
+	node  *Node
+}
+
+// get returns element i if it is cached, marking it as recently used.
+func (c *elementCache) get(i int) (*Node, bool) {
+	e, ok := c.elems[i]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(cachedElement).node, true
+}
+
+// peek returns element i if it is cached, leaving the order alone.
+func (c *elementCache) peek(i int) (*Node, bool) {
+	e, ok := c.elems[i]
+	if !ok {
+		return nil, false
+	}
+	return e.Value.(cachedElement).node, true
+}
+
+// add caches element i, returning the element it drops to make room, if
+// any.
+func (c *elementCache) add(i int, node *Node) *Node {
+	if c.elems == nil {
+		c.elems = make(map[int]*list.Element)
+	}
+	c.elems[i] = c.order.PushFront(cachedElement{index: i, node: node})
+	if c.order.Len() <= lazyArrayCacheSize {
+		return nil
+	}
+	return c.remove(c.order.Back().Value.(cachedElement).index)
+}
+
+// remove drops element i from the cache, returning it if it was cached.
+func (c *elementCache) remove(i int) *Node {
+	e, ok := c.elems[i]
+	if !ok {
+		return nil
+	}
+	delete(c.elems, i)
+	c.order.Remove(e)
+	return e.Value.(cachedElement).node
+}
+
+// nodes returns the cached elements.
+func (c *elementCache) nodes() []*Node {
+	nodes := make([]*Node, 0, c.order.Len())
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		nodes = append(nodes, e.Value.(cachedElement).node)
+	}
+	return nodes
+}
+
+// Len returns the number of elements of a repeated field. Triggers
+// resolution of the array, but large arrays don't resolve every element;
+// see ItemAt. If the array failed to resolve, the number of elements read
+// before the failure is returned along with the error.
+func (n *Node) Len() (int, error) {
+	err := n.Resolve()
+	return n.itemCount(), err
+}
+
+// ItemAt returns element i of a repeated field. Triggers resolution. Arrays
+// of more than a thousand or so elements read those past the first ones
+// only when they are asked for, so ItemAt and Len let UIs page through huge
+// arrays without a node per element, as Items would need.
+func (n *Node) ItemAt(i int) (*Node, error) {
+	resolveErr := n.Resolve()
+	if i < 0 || i >= n.itemCount() {
+		if resolveErr != nil {
+			return nil, resolveErr
+		}
+		return nil, fmt.Errorf("index %d out of range for %s with %d items", i, n.describe(), n.itemCount())
+	}
+	if i < len(n.items) {
+		return n.items[i], nil
+	}
+	return n.lazy.get(n, i)
+}
+
+// eachItem calls fn with each element of the array n in turn, reading them
+// through ItemAt, so that walking a huge array doesn't keep a node per
+// element as Items does. Returns the error the array failed with, if any, or
+// else the error that stopped an element being read at all.
+func (n *Node) eachItem(fn func(item *Node)) error {
+	count, err := n.Len()
+	for i := range count {
+		item, itemErr := n.ItemAt(i)
+		if item == nil {
+			if err == nil {
+				err = itemErr
+			}
+			break
+		}
+		fn(item)
+	}
+	return err
+}
+
+// itemCount returns the number of elements read so far.
+func (n *Node) itemCount() int {
+	if n.lazy != nil {
+		return n.lazy.count
+	}
+	return len(n.items)
+}
+
+// addItem adds an element to an array that is being read, keeping only an
+// index of the elements past lazyArrayThreshold if it has a lazyArray.
+func (n *Node) addItem(elem *Node) {
+	l := n.lazy
+	if l == nil {
+		n.items = append(n.items, elem)
+		return
+	}
+	if l.count%lazyArrayStride == 0 {
+		l.offsets = append(l.offsets, elem.startPos)
+	}
+	if l.count < lazyArrayThreshold {
+		n.items = append(n.items, elem)
+	} else {
+		n.tree.releaseTree(elem)
+	}
+	l.count++
+}
+
+// finishItems drops the lazyArray of an array that turned out small enough
+// to keep every element.
+func (n *Node) finishItems() {
+	if n.lazy != nil && n.lazy.count == len(n.items) {
+		n.lazy = nil
+	}
+}
+
+// get returns element i, which is past n.items. Elements that have dropped
+// out of the cache are read again, from the nearest known offset.
+func (l *lazyArray) get(n *Node, i int) (*Node, error) {
+	if elem, ok := l.cache.get(i); ok {
+		return elem, elem.err
+	}
+	pos, err := l.start(n, i)
+	if err != nil {
+		return nil, err
+	}
+	elem, err := l.read(i, pos)
+	if elem != nil {
+		n.tree.releaseTree(l.cache.add(i, elem))
+	}
+	return elem, err
+}
+
+// start returns where element i starts, reading the elements before it back
+// to the nearest known offset if their size varies.
+func (l *lazyArray) start(n *Node, i int) (int64, error) {
+	if l.size > 0 {
+		return n.startPos + int64(i)*l.size, nil
+	}
+	pos := l.offsets[i/lazyArrayStride]
+	for j := i / lazyArrayStride * lazyArrayStride; j < i; j++ {
+		elem, ok := l.cache.peek(j)
+		if !ok {
+			var err error
+			elem, err = l.read(j, pos)
+			n.tree.releaseTree(elem)
+			if elem == nil || err != nil && !elem.spanKnown {
+				return 0, err
+			}
+		}
+		pos = int64(elem.span.EndIndex)
+	}
+	return pos, nil
+}
+
+// materialize reads every element into n.items, as Items returns them. The
+// elements are read one after the other, so that each is read once. With
+// Tree.Recover set, a failed element is kept, and reading goes on after it
+// if its span is known.
+func (l *lazyArray) materialize(n *Node) error {
+	i := len(n.items)
+	if i < l.count {
+		pos, err := l.start(n, i)
+		if err != nil {
+			return err
+		}
+		for ; i < l.count; i++ {
+			elem := l.cache.remove(i)
+			if elem != nil {
+				err = elem.err
+			} else {
+				elem, err = l.read(i, pos)
+			}
+			if elem == nil || err != nil && !n.tree.Recover {
+				n.tree.releaseTree(elem)
+				return err
+			}
+			n.items = append(n.items, elem)
+			if err != nil && !elem.spanKnown {
+				return err
+			}
+			pos = int64(elem.span.EndIndex)
+		}
+	}
+	n.lazy = nil
+	return nil
+}
+
+// elementReader returns a function that reads element i of the array n from
+// pos, for a lazyArray.
+func (t *Tree) elementReader(n *Node, ref *types.TypeRef) func(int, int64) (*Node, error) {
+	return func(i int, pos int64) (*Node, error) {
+		if _, err := n.stream.Seek(pos, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seeking for element %d of %s: %w", i, n.path, err)
+		}
+		t.pushIndex(i)
+		defer t.popIndex()
+		return t.readArrayElement(n, ref, i)
+	}
+}
+
+// readUniformArray sets up the array n to read its elements on demand, if
+// they all have the same size, found without reading them, and there are
+// more than lazyArrayThreshold of them. Returns false if the array should be
+// read element by element instead, which is also how errors such as a
+// truncated last element are reported.
+func (t *Tree) readUniformArray(n *Node, ref *types.TypeRef) (bool, error) {
+	size, ok := t.uniformElementSize(n, ref)
+	if !ok {
+		return false, nil
+	}
+	streamSize, err := n.stream.Size()
+	if err != nil {
+		return false, nil
+	}
+	remaining := streamSize - n.startPos
+	var count int64
+	switch repeat := n.attr.Repeat.(type) {
+	case types.RepeatEOS:
+		if remaining%size != 0 {
+			return false, nil
+		}
+		count = remaining / size
+	case types.RepeatExpr:
+		count, err = t.evaluateExprInt(n.parent, repeat.CountExpr)
+		if err != nil || count > remaining/size {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+	if count <= lazyArrayThreshold {
+		return false, nil
+	}
+	if err := t.checkArrayLen(n, count); err != nil {
+		return true, err
+	}
+	end := n.startPos + count*size
+	if _, err := n.stream.Seek(end, io.SeekStart); err != nil {
+		return true, fmt.Errorf("seeking past %s: %w", n.path, err)
+	}
+	n.lazy = &lazyArray{count: int(count), read: t.elementReader(n, ref), size: size}
+	n.span = Range{StartIndex: uint64(n.startPos), EndIndex: uint64(end)}
+	n.state = stateResolved
+	return true, nil
+}
+
+// uniformElementSize returns the size of every element of the array n, if
+// they all have the same size that can be found without reading them, and
+// reading them can't fail validation.
+func (t *Tree) uniformElementSize(n *Node, ref *types.TypeRef) (int64, bool) {
+	a := n.attr
+	if a.Valid != nil || a.Contents != nil || a.Process != nil || a.SizeEos || ref.Kind == types.Bits {
+		return 0, false
+	}
+	if a.Terminator != nil && a.Size == nil {
+		return 0, false
+	}
+	sizes := []*expr.Expr{a.Size}
+	switch ref.Kind {
+	case types.Bytes:
+		if ref.Bytes.SizeEOS {
+			return 0, false
+		}
+		sizes = append(sizes, ref.Bytes.Size)
+	case types.String:
+		if ref.String.SizeEOS {
+			return 0, false
+		}
+		sizes = append(sizes, ref.String.Size)
+	case types.User:
+		sizes = append(sizes, ref.User.Size)
+	}
+	for _, e := range sizes {
+		if e != nil && (strings.Contains(e.Text, "_index") || strings.Contains(e.Text, "_io")) {
+			// The size may differ from element to element.
+			return 0, false
+		}
+	}
+	size, ok := t.fixedSize(n, ref)
+	if !ok || size <= 0 {
+		return 0, false
+	}
+	return size, true
+}
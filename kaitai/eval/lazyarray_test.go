@@ -0,0 +1,283 @@
+package eval
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazyArray_Fixed(t *testing.T) {
+	const count = 5000
+	data := make([]byte, 4*count)
+	for i := range count {
+		binary.LittleEndian.PutUint32(data[4*i:], uint32(i*3))
+	}
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: records
+    type: u4le
+    repeat: eos
+instances:
+  picked:
+    value: records[4000]
+  total:
+    value: records.size
+`, data)
+	records, err := tree.Root().Child("records")
+	require.NoError(t, err)
+
+	n, err := records.Len()
+	require.NoError(t, err)
+	assert.Equal(t, count, n)
+	assert.Empty(t, records.items, "elements of the same size aren't read up front")
+
+	item, err := records.ItemAt(4321)
+	require.NoError(t, err)
+	v, err := item.Value()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4321*3), v.Uint)
+	r, err := item.ByteRange()
+	require.NoError(t, err)
+	assert.Equal(t, Range{StartIndex: 4 * 4321, EndIndex: 4*4321 + 4}, r)
+	assert.Equal(t, "records[4321]", item.Path().String())
+	again, err := records.ItemAt(4321)
+	require.NoError(t, err)
+	assert.Same(t, item, again)
+
+	_, err = records.ItemAt(count)
+	assert.EqualError(t, err, "index 5000 out of range for records with 5000 items")
+
+	assert.Equal(t, []string{"records", "records[1234]"}, nodePaths(tree.NodesAt(4*1234+2)))
+	assert.NotNil(t, records.lazy, "finding a node by offset doesn't read every element")
+	assert.Equal(t, []string{"records[4999]"}, queryPaths(t, tree, "records[-1]"))
+
+	items, err := records.Items()
+	require.NoError(t, err)
+	require.Len(t, items, count)
+	assert.Same(t, item, items[4321])
+	v, err = items[17].Value()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(17*3), v.Uint)
+
+	picked, err := tree.Root().Eval("picked")
+	require.NoError(t, err)
+	assert.Equal(t, Value{Kind: KindInt, Int: 4000 * 3}, picked)
+	total, err := tree.Root().Eval("total")
+	require.NoError(t, err)
+	assert.Equal(t, Value{Kind: KindInt, Int: count}, total)
+}
+
+func TestLazyArray_FixedFallback(t *testing.T) {
+	// A truncated last element is reported as it is for small arrays.
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: records
+    type: u4le
+    repeat: eos
+`, make([]byte, 4*2000+2))
+	_, err := tree.Root().Child("records")
+	require.NoError(t, err)
+	require.Error(t, resolveChild(t, tree, "records"))
+
+	// So is an array longer than its stream.
+	tree = openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: records
+    type: u2le
+    repeat: expr
+    repeat-expr: 3000
+`, make([]byte, 4000))
+	require.Error(t, resolveChild(t, tree, "records"))
+}
+
+func TestLazyArray_Variable(t *testing.T) {
+	const count = 3000
+	var data []byte
+	for i := range count {
+		data = append(data, fmt.Sprintf("s%d", i)...)
+		data = append(data, 0)
+	}
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: names
+    type: strz
+    encoding: ASCII
+    repeat: eos
+`, data)
+	names, err := tree.Root().Child("names")
+	require.NoError(t, err)
+
+	n, err := names.Len()
+	require.NoError(t, err)
+	assert.Equal(t, count, n)
+	assert.Len(t, names.items, lazyArrayThreshold)
+	assert.Len(t, names.lazy.offsets, (count+lazyArrayStride-1)/lazyArrayStride)
+
+	for _, i := range []int{0, 1023, 1024, 2500, 2999} {
+		item, err := names.ItemAt(i)
+		require.NoError(t, err)
+		v, err := item.Value()
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("s%d", i), v.Str)
+	}
+	r, err := names.ByteRange()
+	require.NoError(t, err)
+	assert.Equal(t, Range{StartIndex: 0, EndIndex: uint64(len(data))}, r)
+
+	// Walking the tree reads the elements one at a time.
+	c, err := tree.Coverage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), c.CoveredBytes())
+	anns := collectAnnotations(t, tree, AnnotationOptions{})
+	assert.Len(t, anns, count)
+	assert.NotNil(t, names.lazy)
+
+	items, err := names.Items()
+	require.NoError(t, err)
+	require.Len(t, items, count)
+	v, err := items[2222].Value()
+	require.NoError(t, err)
+	assert.Equal(t, "s2222", v.Str)
+}
+
+func TestLazyArray_Switch(t *testing.T) {
+	const count = 2000
+	var data []byte
+	for i := range count {
+		if i%2 == 0 {
+			data = append(data, 1, byte(i))
+		} else {
+			data = append(data, 2, byte(i), byte(i>>8))
+		}
+	}
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: recs
+    type:
+      switch-on: _index % 2
+      cases:
+        0: short_rec
+        1: long_rec
+    repeat: expr
+    repeat-expr: 2000
+types:
+  short_rec:
+    seq:
+      - id: tag
+        type: u1
+      - id: v
+        type: u1
+  long_rec:
+    seq:
+      - id: tag
+        type: u1
+      - id: v
+        type: u2le
+`, data)
+	recs, err := tree.Root().Child("recs")
+	require.NoError(t, err)
+	n, err := recs.Len()
+	require.NoError(t, err)
+	assert.Equal(t, count, n)
+	require.NotNil(t, recs.lazy)
+
+	item, err := recs.ItemAt(1501)
+	require.NoError(t, err)
+	v, err := item.Eval("v")
+	require.NoError(t, err)
+	assert.Equal(t, Value{Kind: KindInt, Int: 1501}, v)
+	assert.Equal(t, "long_rec", item.TypeName())
+}
+
+func TestLazyArray_Error(t *testing.T) {
+	const count = 1500
+	var data []byte
+	for i := range count {
+		data = append(data, fmt.Sprintf("s%d", i)...)
+		data = append(data, 0)
+	}
+	data = append(data, "unterminated"...)
+	src := `
+meta:
+  id: main
+seq:
+  - id: names
+    type: strz
+    encoding: ASCII
+    repeat: eos
+`
+	tree := openSourceBytes(t, src, data)
+	names, err := tree.Root().Child("names")
+	require.NoError(t, err)
+	n, err := names.Len()
+	require.Error(t, err)
+	assert.Equal(t, count, n)
+	items, err := names.Items()
+	require.Error(t, err)
+	require.Len(t, items, count)
+	v, err := items[count-1].Value()
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("s%d", count-1), v.Str)
+
+	// With recovery, the element that failed is kept too.
+	tree = openSourceBytes(t, src, data)
+	tree.Recover = true
+	names, err = tree.Root().Child("names")
+	require.NoError(t, err)
+	items, err = names.Items()
+	require.Error(t, err)
+	require.Len(t, items, count+1)
+	assert.Error(t, items[count].Err())
+}
+
+func TestLazyArray_CacheBound(t *testing.T) {
+	const count = 20000
+	var data []byte
+	for i := range count {
+		data = append(data, fmt.Sprintf("s%d", i)...)
+		data = append(data, 0)
+	}
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: names
+    type: strz
+    encoding: ASCII
+    repeat: eos
+`, data)
+	names, err := tree.Root().Child("names")
+	require.NoError(t, err)
+	n, err := names.Len()
+	require.NoError(t, err)
+	require.Equal(t, count, n)
+
+	for i := range count {
+		_, err := names.ItemAt(i)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, lazyArrayCacheSize, names.lazy.cache.order.Len())
+	assert.Equal(t, lazyArrayThreshold+lazyArrayCacheSize+2, tree.nodeCount, "the root, the array, and the elements it keeps")
+
+	// Elements dropped from the cache are read again.
+	for _, i := range []int{5000, 19999, 1024, 7777} {
+		item, err := names.ItemAt(i)
+		require.NoError(t, err)
+		v, err := item.Value()
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("s%d", i), v.Str)
+	}
+}
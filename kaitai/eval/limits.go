@@ -0,0 +1,192 @@
+package eval
+
+import (
+	"fmt"
+)
+
+// Limits bounds the work a Tree does while resolving nodes, so that hostile
+// input can't exhaust memory or run forever. A zero field means no limit.
+type Limits struct {
+	// MaxDepth is the deepest a struct may be nested, counting the fields
+	// on its path from the root.
+	MaxDepth int
+
+	// MaxArrayElements is the most elements a single repeated field may
+	// have.
+	MaxArrayElements int
+
+	// MaxBytes is the most bytes that may be read into byte array and string
+	// values, in total across the nodes the tree holds. Nodes that are
+	// invalidated or dropped, such as elements of a large array that are
+	// read on demand, no longer count.
+	MaxBytes int64
+
+	// MaxProcessOutput is the most bytes a single `process:` may produce,
+	// which guards against compression bombs.
+	MaxProcessOutput int64
+
+	// MaxNodes is the most resolved nodes the tree may hold at once,
+	// including array elements.
+	MaxNodes int
+}
+
+// DefaultLimits are limits suitable for parsing untrusted input. They are
+// generous enough for most real files.
+var DefaultLimits = Limits{
+	MaxDepth:         256,
+	MaxArrayElements: 1 << 24,
+	MaxBytes:         1 << 30,
+	MaxProcessOutput: 256 << 20,
+	MaxNodes:         1 << 24,
+}
+
+// DepthLimitError is returned when a struct is nested more deeply than
+// Limits.MaxDepth.
+type DepthLimitError struct {
+	Path Path
+	Max  int
+}
+
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("%s: nesting depth exceeds limit of %d", e.Path, e.Max)
+}
+
+// ArrayLimitError is returned when a repeated field has more elements than
+// Limits.MaxArrayElements.
+type ArrayLimitError struct {
+	Path Path
+	Max  int
+}
+
+func (e *ArrayLimitError) Error() string {
+	return fmt.Sprintf("%s: array exceeds limit of %d elements", e.Path, e.Max)
+}
+
+// BytesLimitError is returned when reading a field would take the bytes read
+// by the tree past Limits.MaxBytes.
+type BytesLimitError struct {
+	Path Path
+	Max  int64
+}
+
+func (e *BytesLimitError) Error() string {
+	return fmt.Sprintf("%s: reading exceeds limit of %d bytes", e.Path, e.Max)
+}
+
+// ProcessLimitError is returned when a `process:` produces more than
+// Limits.MaxProcessOutput bytes.
+type ProcessLimitError struct {
+	Max int64
+}
+
+func (e *ProcessLimitError) Error() string {
+	return fmt.Sprintf("process output exceeds limit of %d bytes", e.Max)
+}
+
+// NodeLimitError is returned when resolving a node would take the number of
+// resolved nodes past Limits.MaxNodes.
+type NodeLimitError struct {
+	Path Path
+	Max  int
+}
+
+func (e *NodeLimitError) Error() string {
+	return fmt.Sprintf("%s: resolving exceeds limit of %d nodes", e.Path, e.Max)
+}
+
+// checkCancel returns the error of the tree's context once it is done.
+func (t *Tree) checkCancel() error {
+	if t.ctx == nil {
+		return nil
+	}
+	return t.ctx.Err()
+}
+
+// countNode counts n towards Limits.MaxNodes and checks for cancellation.
+// It is called whenever a node starts resolving.
+func (t *Tree) countNode(n *Node) error {
+	if err := t.checkCancel(); err != nil {
+		return err
+	}
+	if n.counted {
+		return nil
+	}
+	if t.limits.MaxNodes > 0 && t.nodeCount >= t.limits.MaxNodes {
+		return &NodeLimitError{Path: n.path, Max: t.limits.MaxNodes}
+	}
+	t.nodeCount++
+	n.counted = true
+	return nil
+}
+
+// checkDepth checks that a struct may be read at n.
+func (t *Tree) checkDepth(n *Node) error {
+	if t.limits.MaxDepth > 0 && len(n.path) > t.limits.MaxDepth {
+		return &DepthLimitError{Path: n.path, Max: t.limits.MaxDepth}
+	}
+	return nil
+}
+
+// checkArrayLen checks that the array n may have count elements.
+func (t *Tree) checkArrayLen(n *Node, count int64) error {
+	if t.limits.MaxArrayElements > 0 && count > int64(t.limits.MaxArrayElements) {
+		return &ArrayLimitError{Path: n.path, Max: t.limits.MaxArrayElements}
+	}
+	return nil
+}
+
+// reserveBytes counts size bytes read for n towards Limits.MaxBytes. Sized
+// reads call it before reading, so that a huge size fails before anything is
+// allocated. If the read then fails, refundBytes gives the bytes back.
+func (t *Tree) reserveBytes(n *Node, size int64) error {
+	if t.limits.MaxBytes > 0 && t.bytesRead+size > t.limits.MaxBytes {
+		return &BytesLimitError{Path: n.path, Max: t.limits.MaxBytes}
+	}
+	t.bytesRead += size
+	n.bytesCharged += size
+	return nil
+}
+
+// refundBytes gives back the bytes charged to n, which failed to read.
+func (t *Tree) refundBytes(n *Node) {
+	t.bytesRead -= n.bytesCharged
+	n.bytesCharged = 0
+}
+
+// release gives back what n itself was charged, ahead of it being read
+// again or dropped.
+func (t *Tree) release(n *Node) {
+	t.refundBytes(n)
+	if n.counted {
+		t.nodeCount--
+		n.counted = false
+	}
+}
+
+// releaseTree gives back what n and every node below it were charged, for
+// nodes that are being dropped.
+func (t *Tree) releaseTree(n *Node) {
+	if n == nil {
+		return
+	}
+	t.release(n)
+	for _, child := range n.children {
+		t.releaseTree(child)
+	}
+	for _, inst := range n.instances {
+		t.releaseTree(inst)
+	}
+	t.releaseItems(n)
+}
+
+// releaseItems gives back what the elements of the array n were charged.
+func (t *Tree) releaseItems(n *Node) {
+	for _, item := range n.items {
+		t.releaseTree(item)
+	}
+	if n.lazy != nil {
+		for _, elem := range n.lazy.cache.nodes() {
+			t.releaseTree(elem)
+		}
+	}
+}
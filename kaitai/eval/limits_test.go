@@ -0,0 +1,180 @@
+package eval
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resolveChild(t *testing.T, tree *Tree, name string) error {
+	t.Helper()
+	child, err := tree.Root().Child(name)
+	require.NoError(t, err)
+	require.NotNil(t, child)
+	return child.Resolve()
+}
+
+func TestLimits_ArrayElements(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: items
+    type: u1
+    repeat: expr
+    repeat-expr: 0xffffffff
+`, []byte{1, 2, 3}, WithLimits(Limits{MaxArrayElements: 10}))
+	var limitErr *ArrayLimitError
+	require.ErrorAs(t, resolveChild(t, tree, "items"), &limitErr)
+	assert.Equal(t, "items", limitErr.Path.String())
+
+	tree = openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: items
+    type: u1
+    repeat: eos
+`, []byte{1, 2, 3}, WithLimits(Limits{MaxArrayElements: 2}))
+	require.ErrorAs(t, resolveChild(t, tree, "items"), &limitErr)
+}
+
+func TestLimits_Bytes(t *testing.T) {
+	src := `
+meta:
+  id: main
+seq:
+  - id: a
+    size: 2
+  - id: b
+    size: 0x7fffffff
+`
+	tree := openSourceBytes(t, src, []byte{1, 2, 3}, WithLimits(Limits{MaxBytes: 1024}))
+	require.NoError(t, resolveChild(t, tree, "a"))
+	var limitErr *BytesLimitError
+	require.ErrorAs(t, resolveChild(t, tree, "b"), &limitErr)
+	assert.Equal(t, int64(1024), limitErr.Max)
+
+	// The budget is shared by the whole tree.
+	tree = openSourceBytes(t, src, []byte{1, 2, 3}, WithLimits(Limits{MaxBytes: 1}))
+	require.ErrorAs(t, resolveChild(t, tree, "a"), &limitErr)
+}
+
+func TestLimits_Depth(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: next
+    type: main
+`, nil, WithLimits(Limits{MaxDepth: 16}))
+	var limitErr *DepthLimitError
+	require.ErrorAs(t, resolveChild(t, tree, "next"), &limitErr)
+	assert.Len(t, limitErr.Path, 17)
+}
+
+func TestLimits_ProcessOutput(t *testing.T) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write(make([]byte, 1<<20))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	src := `
+meta:
+  id: main
+seq:
+  - id: body
+    size-eos: true
+    process: zlib
+`
+	tree := openSourceBytes(t, src, buf.Bytes(), WithLimits(Limits{MaxProcessOutput: 4096}))
+	var limitErr *ProcessLimitError
+	require.ErrorAs(t, resolveChild(t, tree, "body"), &limitErr)
+
+	tree = openSourceBytes(t, src, buf.Bytes())
+	require.NoError(t, resolveChild(t, tree, "body"))
+}
+
+func TestLimits_Nodes(t *testing.T) {
+	src := `
+meta:
+  id: main
+seq:
+  - id: items
+    type: u1
+    repeat: expr
+    repeat-expr: 10
+`
+	tree := openSourceBytes(t, src, make([]byte, 10), WithLimits(Limits{MaxNodes: 5}))
+	var limitErr *NodeLimitError
+	require.ErrorAs(t, resolveChild(t, tree, "items"), &limitErr)
+
+	// The root, the array and its elements. Invalidated nodes give back
+	// their count, so the tree can be read again and again.
+	tree = openSourceBytes(t, src, make([]byte, 10), WithLimits(Limits{MaxNodes: 12}))
+	for range 5 {
+		require.NoError(t, resolveChild(t, tree, "items"))
+		tree.Invalidate()
+		assert.Zero(t, tree.nodeCount)
+	}
+}
+
+func TestLimits_Reread(t *testing.T) {
+	var data []byte
+	for range 5000 {
+		data = append(data, "abcdefghi\x00"...)
+	}
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: items
+    type: strz
+    encoding: ASCII
+    repeat: eos
+`, data, WithLimits(Limits{MaxBytes: 200000}))
+	items, err := tree.Root().Child("items")
+	require.NoError(t, err)
+	for range 6 {
+		n, err := items.Len()
+		require.NoError(t, err)
+		require.Equal(t, 5000, n)
+		for i := range n {
+			_, err := items.ItemAt(i)
+			require.NoError(t, err)
+		}
+		items.Invalidate()
+	}
+	assert.Zero(t, tree.bytesRead)
+}
+
+func TestLimits_FailedReadNotCharged(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: a
+    size: 4
+`, []byte{1, 2}, WithLimits(Limits{MaxBytes: 4}))
+	require.Error(t, resolveChild(t, tree, "a"))
+	assert.Zero(t, tree.bytesRead)
+}
+
+func TestLimits_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+`, []byte{1}, WithContext(ctx))
+	cancel()
+	assert.True(t, errors.Is(resolveChild(t, tree, "a"), context.Canceled))
+}
@@ -0,0 +1,145 @@
+package eval
+
+import (
+	"sort"
+
+	"github.com/jchv/zanbato/kaitai"
+)
+
+// NodesAt returns the fields covering the byte at offset in the root buffer,
+// parents before their children, so that the innermost field comes last. It
+// is the reverse of Node.ByteRange. See NodesInRange.
+func (t *Tree) NodesAt(offset int64) []*Node {
+	if offset < 0 {
+		return nil
+	}
+	return t.NodesInRange(Range{StartIndex: uint64(offset), EndIndex: uint64(offset) + 1})
+}
+
+// NodesInRange returns the fields whose bytes overlap r, a range of the root
+// buffer, in tree order with parents before their children. The root itself
+// is not included.
+//
+// Only as much of the tree is resolved as is needed to find them: a struct
+// or array is only descended into if its own bytes overlap r, and seq fields
+// after r are not read. Instances that read from the stream are resolved for
+// every struct descended into, since positioned instances can lie anywhere;
+// where one overlaps other fields, nodes from both are returned. Fields that
+// fail to resolve are included if their bytes are known (see Tree.Recover).
+//
+// The contents of a field read through `process:` are in a decoded buffer
+// rather than the root buffer, so such fields are returned without their
+// children.
+func (t *Tree) NodesInRange(r Range) []*Node {
+	if r.EndIndex <= r.StartIndex {
+		return nil
+	}
+	l := &locator{r: r, visiting: make(map[structAt]bool)}
+	l.visitStruct(t.root)
+	return l.nodes
+}
+
+type locator struct {
+	r     Range
+	nodes []*Node
+
+	// visiting holds the structs being descended into, so that an instance
+	// that reads its own parent again isn't followed forever.
+	visiting map[structAt]bool
+}
+
+type structAt struct {
+	schema *kaitai.Struct
+	start  uint64
+}
+
+// visitStruct looks for fields overlapping the range in the struct n.
+func (l *locator) visitStruct(n *Node) {
+	if err := n.Resolve(); err != nil && len(n.children) == 0 {
+		return
+	}
+	for _, child := range n.children {
+		cr, ok := nodeRange(child)
+		if !ok {
+			// The fields after it can't be placed.
+			break
+		}
+		l.visit(child, cr)
+		// Seq fields are laid out in order, so once one reaches the end of
+		// the range, the rest are past it. Bit fields may share their last
+		// byte with the next field.
+		if cr.EndIndex > l.r.EndIndex || (cr.EndIndex == l.r.EndIndex && !isBitField(child)) {
+			break
+		}
+	}
+	for _, inst := range n.instances {
+		if inst.attr != nil && inst.attr.Value != nil {
+			continue
+		}
+		if cr, ok := nodeRange(inst); ok {
+			l.visit(inst, cr)
+		}
+	}
+}
+
+// visit adds n, whose bytes are nr, if it overlaps the range, then descends
+// into it.
+func (l *locator) visit(n *Node, nr Range) {
+	if nr.EndIndex <= l.r.StartIndex || nr.StartIndex >= l.r.EndIndex {
+		return
+	}
+	l.nodes = append(l.nodes, n)
+	if n.attr != nil && n.attr.Process != nil {
+		return
+	}
+	if isArrayNode(n) {
+		l.visitItems(n)
+		return
+	}
+	if n.schema == nil {
+		return
+	}
+	key := structAt{schema: n.schema, start: nr.StartIndex}
+	if l.visiting[key] {
+		return
+	}
+	l.visiting[key] = true
+	l.visitStruct(n)
+	delete(l.visiting, key)
+}
+
+// visitItems visits the elements of the array n that overlap the range. They
+// are laid out in order, so the first is found by binary search, which
+// avoids reading every element of a large array.
+func (l *locator) visitItems(n *Node) {
+	count, _ := n.Len()
+	first := sort.Search(count, func(i int) bool {
+		item, _ := n.ItemAt(i)
+		if item == nil {
+			return true
+		}
+		ir, ok := nodeRange(item)
+		return !ok || ir.EndIndex > l.r.StartIndex
+	})
+	for i := first; i < count; i++ {
+		item, _ := n.ItemAt(i)
+		if item == nil {
+			return
+		}
+		ir, ok := nodeRange(item)
+		if !ok || ir.StartIndex >= l.r.EndIndex {
+			return
+		}
+		l.visit(item, ir)
+	}
+}
+
+// nodeRange returns the bytes of n in the root buffer, resolving it, if
+// they are known.
+func nodeRange(n *Node) (Range, bool) {
+	r, err := n.ByteRange()
+	if err != nil && !n.spanKnown {
+		return Range{}, false
+	}
+	return r, true
+}
@@ -0,0 +1,107 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nodePaths(nodes []*Node) []string {
+	paths := []string{}
+	for _, n := range nodes {
+		paths = append(paths, n.Path().String())
+	}
+	return paths
+}
+
+const locateSource = `
+meta:
+  id: main
+seq:
+  - id: magic
+    size: 2
+  - id: body
+    type: body
+    size: 4
+  - id: items
+    type: u1
+    repeat: expr
+    repeat-expr: 2
+  - id: tail
+    size: 2
+instances:
+  trailer:
+    pos: 2
+    type: u2le
+  sum:
+    value: magic.size + 1
+types:
+  body:
+    seq:
+      - id: a
+        type: u1
+      - id: b
+        type: u2le
+    instances:
+      again:
+        pos: 0
+        type: body
+`
+
+func TestNodesAt(t *testing.T) {
+	data := []byte{'M', 'Z', 1, 2, 3, 4, 5, 6, 7, 8}
+	tree := openSourceBytes(t, locateSource, data)
+
+	// A struct read from a sub-stream reports its bytes in the root buffer,
+	// as its fields do.
+	body, err := tree.Root().Child("body")
+	require.NoError(t, err)
+	r, err := body.ByteRange()
+	require.NoError(t, err)
+	assert.Equal(t, Range{StartIndex: 2, EndIndex: 6}, r)
+
+	assert.Equal(t, []string{"magic"}, nodePaths(tree.NodesAt(1)))
+	assert.Equal(t, []string{"items", "items[1]"}, nodePaths(tree.NodesAt(7)))
+	assert.Equal(t, []string{"tail"}, nodePaths(tree.NodesAt(9)))
+	assert.Equal(t, []string{}, nodePaths(tree.NodesAt(10)))
+	assert.Equal(t, []string{}, nodePaths(tree.NodesAt(-1)))
+
+	// Offsets inside a sub-stream are translated back to the root buffer,
+	// and positioned instances are found wherever they point. An instance
+	// rereading its own struct isn't descended into again.
+	assert.Equal(t,
+		[]string{"body", "body.b", "body.again", "trailer"},
+		nodePaths(tree.NodesAt(3)))
+}
+
+func TestNodesAtLazy(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+  - id: b
+    type: u1
+  - id: c
+    type: u1
+`, []byte{1, 2, 3})
+	assert.Equal(t, []string{"b"}, nodePaths(tree.NodesAt(1)))
+	c, err := tree.Root().Child("c")
+	assert.NoError(t, err)
+	assert.Equal(t, stateUnresolved, c.state)
+}
+
+func TestNodesInRange(t *testing.T) {
+	data := []byte{'M', 'Z', 1, 2, 3, 4, 5, 6, 7, 8}
+	tree := openSourceBytes(t, locateSource, data)
+
+	assert.Equal(t,
+		[]string{"magic", "body", "body.a", "body.again", "trailer"},
+		nodePaths(tree.NodesInRange(Range{StartIndex: 1, EndIndex: 3})))
+	assert.Equal(t,
+		[]string{"items", "items[0]", "items[1]", "tail"},
+		nodePaths(tree.NodesInRange(Range{StartIndex: 6, EndIndex: 20})))
+	assert.Empty(t, tree.NodesInRange(Range{StartIndex: 3, EndIndex: 3}))
+}
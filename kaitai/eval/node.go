@@ -3,8 +3,10 @@ package eval
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/charset"
 	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/types"
 )
@@ -45,6 +47,20 @@ type Node struct {
 	err     error
 	span    Range // byte range [start, end)
 
+	// warnings holds validation failures recorded instead of returned when
+	// Tree.ValidationWarnings is set.
+	warnings []error
+
+	// spanKnown is set when a node failed to resolve but span still holds
+	// the bytes it occupies, so that reading can continue after it when
+	// Tree.Recover is set.
+	spanKnown bool
+
+	// counted and bytesCharged are what this node was charged towards
+	// Tree limits, given back when it is invalidated or dropped.
+	counted      bool
+	bytesCharged int64
+
 	// Stream binding
 	stream   *Stream
 	startPos int64 // byte offset within `stream`; -1 if not yet determined
@@ -55,6 +71,14 @@ type Node struct {
 	// spans back to original-buffer coordinates so the hex editor lights
 	// up the right bytes.
 	streamOffset int64
+	// subStreamPos is where the sub-stream a user type's fields are read
+	// from begins within the stream its own span is in. readUserType moves
+	// `stream` and streamOffset into the sub-stream, but the span stays in
+	// the enclosing stream so that siblings can be placed after it.
+	subStreamPos int64
+	// ownStream is set on a user type read from a sub-stream of its own,
+	// such as one given by `size:`, which its fields end at.
+	ownStream bool
 
 	// Positioning
 	seqIndex int // index in parent.children; -1 for instances and root
@@ -63,8 +87,10 @@ type Node struct {
 	endian    types.EndianKind
 	bitEndian types.BitEndianKind
 
-	// Repeat elements (for array-typed nodes)
+	// Repeat elements (for array-typed nodes). Large arrays keep only the
+	// first elements in items, and read the rest on demand through lazy.
 	items []*Node
+	lazy  *lazyArray
 
 	// Params (evaluated constructor arguments for user types)
 	params map[string]*engine.ExprValue
@@ -103,6 +129,68 @@ func (n *Node) StructSchema() *kaitai.Struct { return n.schema }
 // (e.g. type switch not yet resolved).
 func (n *Node) TypeRef() *types.TypeRef { return n.typeRef }
 
+// TypeName returns the KSY name of the type this node was read as, such as
+// `u4le`, `b3`, `strz` or the id of a user type, with a `[]` suffix for
+// repeated fields. The type of a type switch is only known once the node is
+// resolved. Returns "" for value instances.
+func (n *Node) TypeName() string {
+	var name string
+	switch {
+	case n.schema != nil:
+		name = string(n.schema.ID)
+	case n.typeRef != nil:
+		name = typeRefName(n.typeRef)
+	default:
+		return ""
+	}
+	if isArrayNode(n) {
+		name += "[]"
+	}
+	return name
+}
+
+func typeRefName(ref *types.TypeRef) string {
+	switch ref.Kind {
+	case types.Bits:
+		return fmt.Sprintf("b%d", ref.Bits.Width)
+	case types.Bytes:
+		return "bytes"
+	case types.String:
+		if ref.String.Terminator == 0 && ref.String.Size == nil && !ref.String.SizeEOS {
+			return "strz"
+		}
+		return "str"
+	case types.User:
+		return ref.User.Name
+	default:
+		return strings.ToLower(ref.Kind.String())
+	}
+}
+
+// Extensions returns the vendor extension keys (such as
+// `-webide-representation`) that apply to this node: those of its struct
+// type, overridden by those of its attr. Keys include their leading dash.
+// The struct type of a type switch is only known once the node is resolved.
+// Returns nil if there are none.
+func (n *Node) Extensions() map[string]any {
+	var result map[string]any
+	add := func(ext map[string]any) {
+		for k, v := range ext {
+			if result == nil {
+				result = make(map[string]any)
+			}
+			result[k] = v
+		}
+	}
+	if n.schema != nil {
+		add(n.schema.Extensions)
+	}
+	if n.attr != nil {
+		add(n.attr.Extensions)
+	}
+	return result
+}
+
 // IsInstance returns true if this is an instance field (not a seq field).
 func (n *Node) IsInstance() bool { return n.seqIndex < 0 && n.parent != nil }
 
@@ -150,27 +238,78 @@ func (n *Node) Child(name string) (*Node, error) {
 	return child, nil
 }
 
-// Items returns the array elements for a repeated field. Triggers resolution.
+// Lookup finds the node at a path relative to this one, resolving the nodes
+// along the way. An index in the path selects an element of an array.
+func (n *Node) Lookup(p Path) (*Node, error) {
+	node := n
+	for _, item := range p {
+		resolveErr := node.Resolve()
+		child, _ := node.Child(item.Name)
+		if child == nil {
+			if resolveErr != nil {
+				return nil, resolveErr
+			}
+			return nil, fmt.Errorf("%s has no field %q", node.describe(), item.Name)
+		}
+		node = child
+		if item.Index == nil {
+			continue
+		}
+		elem, err := node.ItemAt(*item.Index)
+		if elem == nil {
+			return nil, err
+		}
+		node = elem
+	}
+	return node, nil
+}
+
+// describe names the node in error messages.
+func (n *Node) describe() string {
+	if len(n.path) == 0 {
+		return "root"
+	}
+	return n.path.String()
+}
+
+// Items returns the array elements for a repeated field. Triggers resolution
+// of the array and every element; for large arrays, Len and ItemAt avoid
+// that. If the array failed to resolve, the elements read before the failure
+// are returned along with the error; with Tree.Recover set, these include the
+// element that failed.
 func (n *Node) Items() ([]*Node, error) {
-	if err := n.Resolve(); err != nil {
-		return nil, err
+	err := n.Resolve()
+	if n.lazy != nil {
+		if lazyErr := n.lazy.materialize(n); err == nil {
+			err = lazyErr
+		}
 	}
-	return n.items, nil
+	return n.items, err
 }
 
 // ByteRange returns the byte offset range [start, end) this field occupies
 // in the root buffer's coordinate system. Nodes resolved inside sub-streams
 // (e.g., size-bound user types) have their stream-local spans translated
-// back via `streamOffset`. Triggers resolution.
+// back via `streamOffset`. Triggers resolution. If the node failed to
+// resolve but the bytes it occupies are still known (see Tree.Recover), its
+// range is returned along with the error.
 func (n *Node) ByteRange() (Range, error) {
 	if err := n.Resolve(); err != nil {
-		return Range{}, err
+		if !n.spanKnown {
+			return Range{}, err
+		}
+		return n.absRange(), err
 	}
-	off := uint64(n.streamOffset)
+	return n.absRange(), nil
+}
+
+// absRange translates n.span to the root buffer's coordinate system.
+func (n *Node) absRange() Range {
+	off := uint64(n.streamOffset - n.subStreamPos)
 	return Range{
 		StartIndex: n.span.StartIndex + off,
 		EndIndex:   n.span.EndIndex + off,
-	}, nil
+	}
 }
 
 // IsResolved returns true if this node has been successfully resolved.
@@ -179,18 +318,28 @@ func (n *Node) IsResolved() bool { return n.state == stateResolved }
 // Err returns the cached error from resolution, or nil.
 func (n *Node) Err() error { return n.err }
 
+// Warnings returns the validation failures recorded for this node while
+// Tree.ValidationWarnings is set. For repeated fields, failures are recorded
+// on the individual elements. Does not trigger resolution.
+func (n *Node) Warnings() []error { return n.warnings }
+
 // # Mutation
 
 // Invalidate clears this node's cached state and all descendants,
 // allowing re-evaluation against (potentially changed) data.
 func (n *Node) Invalidate() {
+	n.tree.release(n)
+	n.tree.releaseItems(n)
 	n.state = stateUnresolved
 	n.value = Value{}
 	n.exprVal = nil
 	n.err = nil
+	n.warnings = nil
+	n.spanKnown = false
 	n.span = Range{}
 	n.startPos = -1
 	n.items = nil
+	n.lazy = nil
 	n.params = nil
 	n.deps = nil
 	n.rdeps = nil
@@ -221,13 +370,19 @@ func (n *Node) MarkDirty() {
 // marked Unresolved.
 //
 // Restricted to primitive Value kinds today: Int, Uint, Float, Bool, Bytes,
-// Str, Enum. Edits on struct/array nodes are not yet supported.
+// Str, Enum. Edits on struct/array nodes are not yet supported. A string
+// must be representable in the node's encoding.
 func (n *Node) SetValue(v Value) error {
 	switch v.Kind {
 	case KindInt, KindUint, KindFloat, KindBool, KindBytes, KindStr, KindEnum:
 	default:
 		return fmt.Errorf("SetValue only supports primitive value kinds, got %s", v.Kind)
 	}
+	if v.Kind == KindStr && n.typeRef != nil && n.typeRef.Kind == types.String {
+		if _, err := charset.Encode(v.Str, n.typeRef.String.Encoding); err != nil {
+			return fmt.Errorf("setting %s: %w", n.path, err)
+		}
+	}
 	// Dirty dependents before we lose the rdep edges via re-resolution. The
 	// node itself does not transition - we're writing its new authoritative
 	// value, not invalidating it.
@@ -250,12 +405,17 @@ func (n *Node) markDirty(visited map[*Node]struct{}) {
 	}
 	visited[n] = struct{}{}
 
+	n.tree.release(n)
+	n.tree.releaseItems(n)
 	n.state = stateUnresolved
 	n.value = Value{}
 	n.exprVal = nil
 	n.err = nil
+	n.warnings = nil
+	n.spanKnown = false
 	n.span = Range{}
 	n.items = nil
+	n.lazy = nil
 	n.params = nil
 
 	// Snapshot rdeps before clearing forward edges - clearing deps below
@@ -104,7 +104,7 @@ func (t *Tree) applyProcess(
 	case expr.IdentNode:
 		switch node.Identifier {
 		case "zlib":
-			return processZlib(data)
+			return processZlib(data, t.limits.MaxProcessOutput)
 		}
 		// Bare identifier: custom process with no args.
 		return t.dispatchCustom(node.Identifier, nil, data, evalInt, evalExpr)
@@ -203,7 +203,11 @@ func (t *Tree) dispatchCustom(name string, args []expr.Node, data []byte, evalIn
 	for i, a := range args {
 		call.args[i] = ProcessArg{node: a, evalInt: evalInt, evalExpr: evalExpr}
 	}
-	return fn(call)
+	out, err := fn(call)
+	if err == nil && t.limits.MaxProcessOutput > 0 && int64(len(out)) > t.limits.MaxProcessOutput {
+		return nil, &ProcessLimitError{Max: t.limits.MaxProcessOutput}
+	}
+	return out, err
 }
 
 func processXorMulti(data []byte, key []byte) []byte {
@@ -243,11 +247,23 @@ func processRotateRight(data []byte, count int) []byte {
 	return result
 }
 
-func processZlib(data []byte) ([]byte, error) {
+// processZlib decompresses data, producing no more than limit bytes if limit
+// is positive.
+func processZlib(data []byte, limit int64) ([]byte, error) {
 	r, err := zlib.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = r.Close() }()
-	return io.ReadAll(r)
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, &ProcessLimitError{Max: limit}
+	}
+	return out, nil
 }
@@ -0,0 +1,353 @@
+package eval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// Query selects nodes from a tree. Queries extend paths with:
+//
+//   - `*` in place of a field name, matching every field of a struct;
+//   - `..name`, matching fields called name at any depth below, where name
+//     may also be `*`;
+//   - `[*]`, matching every element of an array;
+//   - `[?(expr)]`, matching the elements of an array for which the KS
+//     expression expr is true, evaluated as Node.Eval would, so `_` is the
+//     element. Applied to a node that isn't an array, it filters the node
+//     itself.
+//
+// For example, `entries[*].name`, `..header` and `entries[?(_.size > 100)]`.
+// An index may be negative to count from the end of an array. The empty
+// query selects the node it is applied to.
+//
+// Queries are lazy: only the nodes a query passes through, and the nodes its
+// predicates refer to, are resolved.
+type Query struct {
+	src   string
+	steps []queryStep
+}
+
+type queryStepKind int
+
+const (
+	stepField queryStepKind = iota
+	stepDescend
+	stepIndex
+	stepAll
+	stepFilter
+)
+
+type queryStep struct {
+	kind   queryStepKind
+	name   string // for stepField and stepDescend; "*" matches any field
+	index  int    // for stepIndex
+	filter *Expr  // for stepFilter
+}
+
+// ParseQuery parses a query.
+func ParseQuery(src string) (*Query, error) {
+	q := &Query{src: src}
+	rest := src
+	first := true
+	for rest != "" {
+		var step queryStep
+		var err error
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.kind = stepDescend
+			step.name, rest, err = parseQueryName(rest[2:])
+		case strings.HasPrefix(rest, "."):
+			if first {
+				return nil, fmt.Errorf("query %q: unexpected '.' at start", src)
+			}
+			step.kind = stepField
+			step.name, rest, err = parseQueryName(rest[1:])
+		case strings.HasPrefix(rest, "["):
+			step, rest, err = parseQuerySubscript(rest[1:])
+		default:
+			if !first {
+				return nil, fmt.Errorf("query %q: expected '.' or '[' at %q", src, rest)
+			}
+			step.kind = stepField
+			step.name, rest, err = parseQueryName(rest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", src, err)
+		}
+		q.steps = append(q.steps, step)
+		first = false
+	}
+	return q, nil
+}
+
+// MustParseQuery is like ParseQuery but panics if the query can't be parsed.
+func MustParseQuery(src string) *Query {
+	q, err := ParseQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the source text of the query.
+func (q *Query) String() string { return q.src }
+
+func parseQueryName(s string) (string, string, error) {
+	if strings.HasPrefix(s, "*") {
+		return "*", s[1:], nil
+	}
+	end := 0
+	for end < len(s) && isQueryNameByte(s[end]) {
+		end++
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("expected a field name at %q", s)
+	}
+	return s[:end], s[end:], nil
+}
+
+func isQueryNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseQuerySubscript parses the inside of a [...] subscript, which has had
+// its opening bracket removed.
+func parseQuerySubscript(s string) (queryStep, string, error) {
+	if strings.HasPrefix(s, "*]") {
+		return queryStep{kind: stepAll}, s[2:], nil
+	}
+	if strings.HasPrefix(s, "?(") {
+		end, err := matchParen(s[1:])
+		if err != nil {
+			return queryStep{}, "", err
+		}
+		src := s[2 : end+1]
+		rest := s[end+2:]
+		if !strings.HasPrefix(rest, "]") {
+			return queryStep{}, "", fmt.Errorf("expected ']' after predicate at %q", rest)
+		}
+		filter, err := Compile(src)
+		if err != nil {
+			return queryStep{}, "", fmt.Errorf("predicate: %w", err)
+		}
+		return queryStep{kind: stepFilter, filter: filter}, rest[1:], nil
+	}
+	index, rest, ok := strings.Cut(s, "]")
+	if !ok {
+		return queryStep{}, "", fmt.Errorf("unterminated subscript at %q", s)
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(index))
+	if err != nil {
+		return queryStep{}, "", fmt.Errorf("invalid index %q", index)
+	}
+	return queryStep{kind: stepIndex, index: i}, rest, nil
+}
+
+// matchParen returns the index of the parenthesis closing the one s starts
+// with, skipping over string literals.
+func matchParen(s string) (int, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		case '"', '\'':
+			for i++; i < len(s) && s[i] != c; i++ {
+				if c == '"' && s[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated predicate at %q", s)
+}
+
+// Query parses a query and selects the nodes it matches from the root. See
+// Query.Select.
+func (t *Tree) Query(src string) ([]*Node, error) {
+	q, err := ParseQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	return q.Select(t.root)
+}
+
+// Select returns the nodes the query matches, starting from n, in tree
+// order. If a node the query needs to look inside fails to resolve, the
+// nodes matched despite it are returned along with the first such error.
+// Predicates that fail to evaluate count as false.
+func (q *Query) Select(n *Node) ([]*Node, error) {
+	s := &querySelection{nodes: []*Node{n}, visiting: make(map[structAt]bool)}
+	for _, step := range q.steps {
+		s.apply(step)
+	}
+	return s.nodes, s.err
+}
+
+type querySelection struct {
+	nodes []*Node
+	err   error
+
+	// visiting guards recursive descent against instances that reread
+	// their own struct, as in locate.go.
+	visiting map[structAt]bool
+}
+
+func (s *querySelection) apply(step queryStep) {
+	var next []*Node
+	seen := make(map[*Node]struct{})
+	add := func(n *Node) {
+		if _, ok := seen[n]; !ok {
+			seen[n] = struct{}{}
+			next = append(next, n)
+		}
+	}
+	for _, n := range s.nodes {
+		switch step.kind {
+		case stepField:
+			for _, field := range s.fields(n) {
+				if step.name == "*" || field.name == step.name {
+					add(field)
+				}
+			}
+		case stepDescend:
+			s.descend(n, step.name, add)
+		case stepIndex:
+			if item := s.item(n, step.index); item != nil {
+				add(item)
+			}
+		case stepAll:
+			for _, item := range s.items(n) {
+				add(item)
+			}
+		case stepFilter:
+			candidates := []*Node{n}
+			if isArrayNode(n) {
+				candidates = s.items(n)
+			}
+			for _, c := range candidates {
+				if v, err := step.filter.Eval(c); err == nil && v.Kind == KindBool && v.Bool {
+					add(c)
+				}
+			}
+		}
+	}
+	s.nodes = next
+}
+
+// descend calls add for every field below n called name, depth first.
+func (s *querySelection) descend(n *Node, name string, add func(*Node)) {
+	if isArrayNode(n) {
+		for _, item := range s.items(n) {
+			s.descend(item, name, add)
+		}
+		return
+	}
+	fields := s.fields(n)
+	if n.schema != nil {
+		r, _ := n.ByteRange()
+		key := structAt{schema: n.schema, start: r.StartIndex}
+		if s.visiting[key] {
+			return
+		}
+		s.visiting[key] = true
+		defer delete(s.visiting, key)
+	}
+	for _, field := range fields {
+		if name == "*" || field.name == name {
+			add(field)
+		}
+		s.descend(field, name, add)
+	}
+}
+
+// fields returns the present fields of a struct node, resolving it only if
+// it may be a struct. Fields skipped by their `if:` are left out.
+func (s *querySelection) fields(n *Node) []*Node {
+	if !mayBeStruct(n) {
+		return nil
+	}
+	s.record(n.Resolve())
+	var fields []*Node
+	for _, field := range n.Fields() {
+		if field.attr != nil && field.attr.If != nil {
+			present, err := n.tree.evaluateExprBool(field.parent, field.attr.If)
+			if err != nil || !present {
+				continue
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// items returns the elements of an array node.
+func (s *querySelection) items(n *Node) []*Node {
+	if !isArrayNode(n) {
+		return nil
+	}
+	items, err := n.Items()
+	s.record(err)
+	return items
+}
+
+// item returns element i of an array node, counting from the end if i is
+// negative, without reading the other elements of large arrays.
+func (s *querySelection) item(n *Node, i int) *Node {
+	if !isArrayNode(n) {
+		return nil
+	}
+	count, err := n.Len()
+	s.record(err)
+	if i < 0 {
+		i += count
+	}
+	if i < 0 || i >= count {
+		return nil
+	}
+	item, err := n.ItemAt(i)
+	if item == nil {
+		s.record(err)
+	}
+	return item
+}
+
+func (s *querySelection) record(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// isArrayNode reports whether n is a repeated field, as opposed to one of its
+// elements.
+func isArrayNode(n *Node) bool {
+	if n.attr == nil || n.attr.Repeat == nil {
+		return false
+	}
+	_, isElem := n.arrayIndex()
+	return !isElem
+}
+
+// mayBeStruct reports whether n is, or may turn out to be, a struct, without
+// resolving it.
+func mayBeStruct(n *Node) bool {
+	if n.attr == nil || n.schema != nil {
+		return true
+	}
+	if isArrayNode(n) {
+		return false
+	}
+	if n.typeRef != nil {
+		return n.typeRef.Kind == types.User
+	}
+	typ := n.attr.Type
+	return typ.TypeSwitch != nil || (typ.TypeRef != nil && typ.TypeRef.Kind == types.User)
+}
@@ -0,0 +1,148 @@
+package eval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const querySource = `
+meta:
+  id: main
+seq:
+  - id: header
+    type: header
+  - id: count
+    type: u1
+  - id: entries
+    type: entry
+    repeat: expr
+    repeat-expr: count
+types:
+  header:
+    seq:
+      - id: magic
+        type: u1
+  entry:
+    seq:
+      - id: size
+        type: u1
+      - id: header
+        type: header
+        if: size > 100
+`
+
+var queryData = []byte{
+	0x7f,      // header.magic
+	3,         // count
+	10,        // entries[0].size
+	200, 0x01, // entries[1].size, entries[1].header.magic
+	150, 0x02, // entries[2].size, entries[2].header.magic
+}
+
+func queryPaths(t *testing.T, tree *Tree, src string) []string {
+	t.Helper()
+	nodes, err := tree.Query(src)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, n := range nodes {
+		paths = append(paths, n.Path().String())
+	}
+	return paths
+}
+
+func TestLookup(t *testing.T) {
+	tree := openSourceBytes(t, querySource, queryData)
+
+	var path Path
+	require.NoError(t, path.UnmarshalText([]byte("entries[2].header.magic")))
+	n, err := tree.Lookup(path)
+	require.NoError(t, err)
+	v, err := n.Value()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), v.Uint)
+
+	n, err = tree.Lookup(nil)
+	require.NoError(t, err)
+	assert.Same(t, tree.Root(), n)
+
+	require.NoError(t, path.UnmarshalText([]byte("entries[3]")))
+	_, err = tree.Lookup(path)
+	assert.EqualError(t, err, "index 3 out of range for entries with 3 items")
+
+	require.NoError(t, path.UnmarshalText([]byte("header.nope")))
+	_, err = tree.Lookup(path)
+	assert.EqualError(t, err, `header has no field "nope"`)
+}
+
+func TestQuery(t *testing.T) {
+	tree := openSourceBytes(t, querySource, queryData)
+
+	assert.Equal(t, []string{"count"}, queryPaths(t, tree, "count"))
+	assert.Equal(t, []string{"entries[1]"}, queryPaths(t, tree, "entries[1]"))
+	assert.Equal(t, []string{"entries[2]"}, queryPaths(t, tree, "entries[-1]"))
+	assert.Equal(t, []string{}, queryPaths(t, tree, "entries[5]"))
+	assert.Equal(t, []string{}, queryPaths(t, tree, "missing.field"))
+	assert.Equal(t, []string{"header", "count", "entries"}, queryPaths(t, tree, "*"))
+	assert.Equal(t,
+		[]string{"entries[0].size", "entries[1].size", "entries[2].size"},
+		queryPaths(t, tree, "entries[*].size"))
+	assert.Equal(t,
+		[]string{"header", "entries[1].header", "entries[2].header"},
+		queryPaths(t, tree, "..header"))
+	assert.Equal(t,
+		[]string{"header.magic", "entries[1].header.magic", "entries[2].header.magic"},
+		queryPaths(t, tree, "..header.magic"))
+	assert.Equal(t,
+		[]string{"entries[1]", "entries[2]"},
+		queryPaths(t, tree, "entries[?(_.size > 100)]"))
+	assert.Equal(t,
+		[]string{"entries[2].header"},
+		queryPaths(t, tree, "entries[?(_.size > 100)].header[?(_.magic == 2)]"))
+	assert.Equal(t,
+		[]string{"entries[0]"},
+		queryPaths(t, tree, `entries[?(_.size.to_s == "10")]`))
+}
+
+func TestQueryLazy(t *testing.T) {
+	tree := openSourceBytes(t, querySource, queryData)
+	assert.Equal(t, []string{"header.magic"}, queryPaths(t, tree, "header.magic"))
+	entries, err := tree.Root().Child("entries")
+	require.NoError(t, err)
+	assert.Equal(t, stateUnresolved, entries.state)
+}
+
+func TestQueryDescendCycle(t *testing.T) {
+	tree := openSourceBytes(t, `
+meta:
+  id: main
+seq:
+  - id: ofs
+    type: u1
+instances:
+  next:
+    pos: ofs
+    type: main
+`, []byte{0})
+
+	assert.Equal(t, []string{"ofs"}, queryPaths(t, tree, "..ofs"))
+	assert.Equal(t, []string{"next"}, queryPaths(t, tree, "..next"))
+	assert.Equal(t, []string{}, queryPaths(t, tree, "..nosuch"))
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, src := range []string{
+		".a",
+		"a..",
+		"a[",
+		"a[x]",
+		"a[?(_.b > 1]",
+		"a[?(_.b >)]",
+		"a b",
+	} {
+		_, err := ParseQuery(src)
+		assert.Error(t, err, src)
+	}
+	assert.Equal(t, "a[*].b", MustParseQuery("a[*].b").String())
+}
@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"strings"
 
 	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/charset"
 	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/types"
@@ -215,8 +215,11 @@ func (t *Tree) readSingle(n *Node, ref *types.TypeRef) error {
 			}
 		}
 		// Decode encoding
-		enc := ref.String.Encoding
-		n.value = Value{Kind: KindStr, Str: decodeString(data, enc)}
+		str, err := charset.Decode(data, ref.String.Encoding)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", n.path, err)
+		}
+		n.value = Value{Kind: KindStr, Str: str}
 
 	case types.User:
 		err := t.readUserType(n, ref)
@@ -242,6 +245,12 @@ func (t *Tree) readSingle(n *Node, ref *types.TypeRef) error {
 	endPos, _ := stream.Pos()
 	n.span = Range{StartIndex: uint64(startPos), EndIndex: uint64(endPos)}
 	n.state = stateResolved
+	if err := t.validate(n); err != nil {
+		// The value was read, so its bytes are known even though it's
+		// invalid.
+		n.spanKnown = true
+		return err
+	}
 	return nil
 }
 
@@ -264,6 +273,9 @@ func (t *Tree) readBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := t.reserveBytes(n, size); err != nil {
+			return nil, err
+		}
 		if err := n.seekToStart(); err != nil {
 			return nil, err
 		}
@@ -282,6 +294,9 @@ func (t *Tree) readBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := t.reserveBytes(n, int64(len(data))); err != nil {
+			return nil, err
+		}
 		return stripBytes(data, ref.Bytes.Terminator, ref.Bytes.PadRight, ref.Bytes.Include), nil
 	}
 
@@ -294,12 +309,21 @@ func (t *Tree) readBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		return data, nil
+		return data, t.reserveBytes(n, int64(len(data)))
 	}
 
 	return nil, fmt.Errorf("unsupported bytes type for %s", n.path)
 }
 
+// stringUnit returns the code unit size of a string type's encoding, which
+// is also the size of its terminator.
+func stringUnit(ref *types.TypeRef) int {
+	if cs, err := charset.Lookup(ref.String.Encoding); err == nil {
+		return cs.Unit
+	}
+	return 1
+}
+
 // readStringBytes reads the raw bytes for a string field.
 func (t *Tree) readStringBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 	stream := n.stream
@@ -319,6 +343,9 @@ func (t *Tree) readStringBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := t.reserveBytes(n, size); err != nil {
+			return nil, err
+		}
 		if err := n.seekToStart(); err != nil {
 			return nil, err
 		}
@@ -328,17 +355,16 @@ func (t *Tree) readStringBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 		}
 		term := ref.String.Terminator
 		padRight := ref.String.PadRight
-		enc := strings.ToUpper(strings.ReplaceAll(ref.String.Encoding, "-", ""))
-		isUTF16 := enc == "UTF16LE" || enc == "UTF16BE"
-		if isUTF16 {
-			// KS semantics on a fixed-size UTF-16 string: pad-right is stripped
-			// first (as a multi-byte aligned pad, matching the encoding's
-			// code-unit width), then the terminator is looked up.
+		unit := stringUnit(ref)
+		if unit > 1 {
+			// KS semantics on a fixed-size UTF-16 or UTF-32 string: pad-right
+			// is stripped first (as a multi-byte aligned pad, matching the
+			// encoding's code-unit width), then the terminator is looked up.
 			if padRight >= 0 {
 				data = stripPadRightMulti(data, byte(padRight))
 			}
 			if term >= 0 {
-				data = stripBytesMulti(data, byte(term), ref.String.Include)
+				data = stripBytesMulti(data, byte(term), unit, ref.String.Include)
 			}
 		} else {
 			data = stripBytes(data, term, padRight, ref.String.Include)
@@ -354,12 +380,13 @@ func (t *Tree) readStringBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := t.reserveBytes(n, int64(len(data))); err != nil {
+			return nil, err
+		}
 		term := ref.String.Terminator
 		padRight := ref.String.PadRight
-		enc := strings.ToUpper(strings.ReplaceAll(ref.String.Encoding, "-", ""))
-		isUTF16 := enc == "UTF16LE" || enc == "UTF16BE"
-		if isUTF16 && term >= 0 {
-			data = stripBytesMulti(data, byte(term), ref.String.Include)
+		if unit := stringUnit(ref); unit > 1 && term >= 0 {
+			data = stripBytesMulti(data, byte(term), unit, ref.String.Include)
 		} else {
 			data = stripBytes(data, term, padRight, ref.String.Include)
 		}
@@ -367,23 +394,22 @@ func (t *Tree) readStringBytes(n *Node, ref *types.TypeRef) ([]byte, error) {
 	}
 
 	if ref.String.Terminator != -1 {
-		enc := strings.ToUpper(strings.ReplaceAll(ref.String.Encoding, "-", ""))
-		isUTF16 := enc == "UTF16LE" || enc == "UTF16BE"
-		if isUTF16 {
-			// Multi-byte terminator for UTF-16: read 2 bytes at a time
-			term := []byte{byte(ref.String.Terminator), byte(ref.String.Terminator)}
+		if unit := stringUnit(ref); unit > 1 {
+			// Multi-byte terminator for UTF-16 and UTF-32: read a code unit
+			// at a time
+			term := bytes.Repeat([]byte{byte(ref.String.Terminator)}, unit)
 			data, err := readBytesTermMulti(stream, term, ref.String.Include, ref.String.Consume)
 			if err != nil {
 				return nil, err
 			}
-			return data, nil
+			return data, t.reserveBytes(n, int64(len(data)))
 		}
 		data, err := stream.ReadBytesTerm(byte(ref.String.Terminator),
 			ref.String.Include, ref.String.Consume, ref.String.EosError)
 		if err != nil {
 			return nil, err
 		}
-		return data, nil
+		return data, t.reserveBytes(n, int64(len(data)))
 	}
 
 	return nil, fmt.Errorf("unsupported string type for %s", n.path)
@@ -410,6 +436,10 @@ func (t *Tree) readUserType(n *Node, ref *types.TypeRef) error {
 		return fmt.Errorf("unresolved user type: %s", ref.User.Name)
 	}
 
+	if err := t.checkDepth(n); err != nil {
+		return err
+	}
+
 	// Evaluate params (constructor arguments) in the parent's scope
 	structSchema := typeSym.Struct.Type
 	var paramValues map[string]*engine.ExprValue
@@ -464,6 +494,9 @@ func (t *Tree) readUserType(n *Node, ref *types.TypeRef) error {
 		hasProcess := n.attr != nil && n.attr.Process != nil
 		if term >= 0 || padRight >= 0 || hasProcess {
 			// Read raw bytes, strip, process, create sub-stream from result
+			if err := t.reserveBytes(n, size); err != nil {
+				return err
+			}
 			if _, err := n.stream.Seek(startPos, io.SeekStart); err != nil {
 				return err
 			}
@@ -506,6 +539,9 @@ func (t *Tree) readUserType(n *Node, ref *types.TypeRef) error {
 		if err != nil {
 			return fmt.Errorf("reading terminated bytes for %s: %w", n.path, err)
 		}
+		if err := t.reserveBytes(n, int64(len(data))); err != nil {
+			return err
+		}
 		// Apply process to the terminated bytes before wrapping in a stream.
 		if n.attr.Process != nil {
 			data, err = t.applyProcess(n.attr.Process, data, func(e *expr.Expr) (int64, error) {
@@ -530,6 +566,9 @@ func (t *Tree) readUserType(n *Node, ref *types.TypeRef) error {
 		}
 		// Read raw sized bytes, apply attr-level pad/term stripping, then create sub-stream.
 		// Re-seek to startPos because expression eval may have moved the stream.
+		if err := t.reserveBytes(n, size); err != nil {
+			return err
+		}
 		if _, err := n.stream.Seek(startPos, io.SeekStart); err != nil {
 			return err
 		}
@@ -611,14 +650,22 @@ func (t *Tree) readUserType(n *Node, ref *types.TypeRef) error {
 		}
 	}
 
-	// Copy children/instances into n
+	// Copy children/instances into n, dropping those of an earlier read
+	for _, child := range n.children {
+		t.releaseTree(child)
+	}
+	for _, inst := range n.instances {
+		t.releaseTree(inst)
+	}
 	n.schema = structSchema
 	n.typeSym = typeSym
 	n.children = childNode.children
 	n.childMap = childNode.childMap
 	n.instances = childNode.instances
+	n.ownStream = stream != n.stream
 	n.stream = stream
 	n.startPos = startPos // relative to the (possibly sub-)stream
+	n.subStreamPos = streamOffset - n.streamOffset
 	n.streamOffset = streamOffset
 	n.value = Value{Kind: KindStruct}
 	n.params = paramValues
@@ -716,14 +763,20 @@ func (t *Tree) fullyResolveUserType(n *Node, stream *Stream, startPos int64) err
 	// startPos and reads from there; the parent stream advances naturally
 	// to the end of the last seq field.
 	for _, child := range n.children {
-		if err := t.resolve(child); err != nil {
+		if err := t.resolve(child); err != nil && !t.canSkip(child) {
 			return fmt.Errorf("resolving child %s: %w", child.path, err)
 		}
 	}
 
 	// Capture end-of-seq position BEFORE any instance resolution (which
-	// would re-position the stream).
+	// would re-position the stream). A failed last child may have left the
+	// stream anywhere, but its span says where it ends.
 	endPos, _ := stream.Pos()
+	if len(n.children) > 0 {
+		if last := n.children[len(n.children)-1]; last.state == stateError {
+			endPos = last.endPos()
+		}
+	}
 	n.span = Range{StartIndex: uint64(startPos), EndIndex: uint64(endPos)}
 	return nil
 }
@@ -740,6 +793,15 @@ func (t *Tree) readRepeated(n *Node, ref *types.TypeRef) error {
 		return fmt.Errorf("seeking for repeat at %s: %w", n.path, err)
 	}
 
+	// Huge arrays read their elements on demand, without reading them at
+	// all if they have the same size.
+	if lazy, err := t.readUniformArray(n, ref); lazy || err != nil {
+		return err
+	}
+	if ref.Kind != types.Bits {
+		n.lazy = &lazyArray{read: t.elementReader(n, ref)}
+	}
+
 	// Track the position the next element should read from. Expression
 	// evaluation between elements (until-expr, instance access) may move the
 	// stream, so we restore it before each readArrayElement.
@@ -764,10 +826,9 @@ func (t *Tree) readRepeated(n *Node, ref *types.TypeRef) error {
 			t.pushIndex(i)
 			elem, err := t.readArrayElement(n, ref, i)
 			t.popIndex()
-			if err != nil {
+			if err := t.appendItem(n, elem, err); err != nil {
 				return err
 			}
-			n.items = append(n.items, elem)
 			nextPos = int64(elem.span.EndIndex)
 			i++
 		}
@@ -777,6 +838,9 @@ func (t *Tree) readRepeated(n *Node, ref *types.TypeRef) error {
 		if err != nil {
 			return fmt.Errorf("evaluating repeat-expr for %s: %w", n.path, err)
 		}
+		if err := t.checkArrayLen(n, count); err != nil {
+			return err
+		}
 		// Re-seek after count evaluation - it may have resolved instances
 		// that moved the stream position.
 		if _, err := n.stream.Seek(nextPos, io.SeekStart); err != nil {
@@ -789,10 +853,9 @@ func (t *Tree) readRepeated(n *Node, ref *types.TypeRef) error {
 			t.pushIndex(i)
 			elem, err := t.readArrayElement(n, ref, i)
 			t.popIndex()
-			if err != nil {
+			if err := t.appendItem(n, elem, err); err != nil {
 				return err
 			}
-			n.items = append(n.items, elem)
 			nextPos = int64(elem.span.EndIndex)
 		}
 
@@ -804,14 +867,18 @@ func (t *Tree) readRepeated(n *Node, ref *types.TypeRef) error {
 			}
 			t.pushIndex(i)
 			elem, err := t.readArrayElement(n, ref, i)
-			if err != nil {
+			if err := t.appendItem(n, elem, err); err != nil {
 				t.popIndex()
 				return err
 			}
-			n.items = append(n.items, elem)
 			nextPos = int64(elem.span.EndIndex)
 
 			i++
+			if elem.state == stateError {
+				// A skipped element can't end the array.
+				t.popIndex()
+				continue
+			}
 			done, err := t.evaluateExprWithTemp(n.parent, repeat.UntilExpr, elem, i)
 			t.popIndex()
 			if err != nil {
@@ -832,10 +899,11 @@ func (t *Tree) readRepeated(n *Node, ref *types.TypeRef) error {
 	}
 
 	// Record overall span
+	n.finishItems()
 	if len(n.items) > 0 {
 		n.span = Range{
 			StartIndex: n.items[0].span.StartIndex,
-			EndIndex:   n.items[len(n.items)-1].span.EndIndex,
+			EndIndex:   uint64(nextPos),
 		}
 	} else {
 		pos, _ := n.stream.Pos()
@@ -867,6 +935,10 @@ func (t *Tree) readArrayElement(arrayNode *Node, ref *types.TypeRef, index int)
 			PathItem{Name: arrayNode.name, Index: &index}),
 	}
 
+	if err := t.countNode(elem); err != nil {
+		return nil, err
+	}
+
 	// Set start position from current stream position
 	pos, err := arrayNode.stream.Pos()
 	if err != nil {
@@ -875,7 +947,13 @@ func (t *Tree) readArrayElement(arrayNode *Node, ref *types.TypeRef, index int)
 	elem.startPos = pos
 
 	if err := t.readSingle(elem, ref); err != nil {
-		return nil, fmt.Errorf("reading element %d of %s: %w", index, arrayNode.path, err)
+		t.refundBytes(elem)
+		elem.err = fmt.Errorf("reading element %d of %s: %w", index, arrayNode.path, err)
+		elem.state = stateError
+		if t.Recover && !elem.spanKnown {
+			t.recoverSpan(elem, ref)
+		}
+		return elem, elem.err
 	}
 
 	return elem, nil
@@ -893,8 +971,8 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 	}
 
 	nextPos := n.startPos
-	readOne := func(i int) (*Node, error) {
-		if _, err := n.stream.Seek(nextPos, io.SeekStart); err != nil {
+	readOne := func(i int, pos int64) (*Node, error) {
+		if _, err := n.stream.Seek(pos, io.SeekStart); err != nil {
 			return nil, fmt.Errorf("seeking for repeat-switch element %d at %s: %w", i, n.path, err)
 		}
 		t.pushIndex(i)
@@ -909,7 +987,7 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 		}
 		// Re-seek after expression evaluation (expression eval may have
 		// resolved instances that moved the stream).
-		if _, err := n.stream.Seek(nextPos, io.SeekStart); err != nil {
+		if _, err := n.stream.Seek(pos, io.SeekStart); err != nil {
 			return nil, err
 		}
 
@@ -938,7 +1016,7 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 			}
 		}
 		// Re-seek before reading (case-expr evaluation may have moved the stream).
-		if _, err := n.stream.Seek(nextPos, io.SeekStart); err != nil {
+		if _, err := n.stream.Seek(pos, io.SeekStart); err != nil {
 			return nil, err
 		}
 		// No case matched: if the array attr has an element-level `size:`,
@@ -961,6 +1039,9 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 		}
 		return t.readArrayElement(n, caseRef, i)
 	}
+	if !switchHasBits(ts) {
+		n.lazy = &lazyArray{read: readOne}
+	}
 
 	switch repeat := n.attr.Repeat.(type) {
 	case types.RepeatEOS:
@@ -973,11 +1054,10 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 			if err != nil || eof {
 				break
 			}
-			elem, err := readOne(i)
-			if err != nil {
+			elem, err := readOne(i, nextPos)
+			if err := t.appendItem(n, elem, err); err != nil {
 				return err
 			}
-			n.items = append(n.items, elem)
 			nextPos = int64(elem.span.EndIndex)
 			i++
 		}
@@ -986,24 +1066,28 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 		if err != nil {
 			return fmt.Errorf("evaluating repeat-expr for %s: %w", n.path, err)
 		}
+		if err := t.checkArrayLen(n, count); err != nil {
+			return err
+		}
 		for i := 0; i < int(count); i++ {
-			elem, err := readOne(i)
-			if err != nil {
+			elem, err := readOne(i, nextPos)
+			if err := t.appendItem(n, elem, err); err != nil {
 				return err
 			}
-			n.items = append(n.items, elem)
 			nextPos = int64(elem.span.EndIndex)
 		}
 	case types.RepeatUntil:
 		i := 0
 		for {
-			elem, err := readOne(i)
-			if err != nil {
+			elem, err := readOne(i, nextPos)
+			if err := t.appendItem(n, elem, err); err != nil {
 				return err
 			}
-			n.items = append(n.items, elem)
 			nextPos = int64(elem.span.EndIndex)
 			i++
+			if elem.state == stateError {
+				continue
+			}
 			done, err := t.evaluateExprWithTemp(n.parent, repeat.UntilExpr, elem, i)
 			if err != nil {
 				return fmt.Errorf("evaluating repeat-until for %s: %w", n.path, err)
@@ -1019,10 +1103,11 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 		return err
 	}
 
+	n.finishItems()
 	if len(n.items) > 0 {
 		n.span = Range{
 			StartIndex: n.items[0].span.StartIndex,
-			EndIndex:   n.items[len(n.items)-1].span.EndIndex,
+			EndIndex:   uint64(nextPos),
 		}
 	} else {
 		n.span = Range{StartIndex: uint64(n.startPos), EndIndex: uint64(n.startPos)}
@@ -1030,6 +1115,16 @@ func (t *Tree) readRepeatedSwitch(n *Node, ts *types.TypeSwitch) error {
 	return nil
 }
 
+// switchHasBits reports whether any case of a type switch is a bit field.
+func switchHasBits(ts *types.TypeSwitch) bool {
+	for _, ref := range ts.Cases {
+		if ref.Kind == types.Bits {
+			return true
+		}
+	}
+	return false
+}
+
 // readTypeSwitch resolves a type switch and reads the matching type.
 func (t *Tree) readTypeSwitch(n *Node, ts *types.TypeSwitch) error {
 	switchVal, err := t.evaluateExpr(n.parent, ts.SwitchOn)
@@ -1076,12 +1171,20 @@ func (t *Tree) readTypeSwitch(n *Node, ts *types.TypeSwitch) error {
 		if n.attr.Size != nil {
 			size, sizeErr := t.evaluateExprInt(n.parent, n.attr.Size)
 			if sizeErr == nil {
+				if err := t.reserveBytes(n, size); err != nil {
+					return err
+				}
 				data, err = n.stream.ReadBytes(int(size))
 			} else {
 				err = sizeErr
 			}
 		} else {
 			data, err = n.stream.ReadBytesFull()
+			if err == nil {
+				if err := t.reserveBytes(n, int64(len(data))); err != nil {
+					return err
+				}
+			}
 		}
 		if err == nil {
 			n.value = Value{Kind: KindBytes, Bytes: data}
@@ -0,0 +1,203 @@
+package eval
+
+import (
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// canSkip reports whether reading can continue past n, which may have failed
+// to resolve: either it didn't fail, or Tree.Recover is set and the bytes it
+// occupies are known.
+func (t *Tree) canSkip(n *Node) bool {
+	if n.state != stateError {
+		return true
+	}
+	return t.Recover && n.spanKnown
+}
+
+// appendItem adds an element to an array node, checking the array against
+// Limits.MaxArrayElements. Without Tree.Recover, a read error ends the array.
+// With it, the failed element is kept, and reading goes on after it if its
+// span is known.
+func (t *Tree) appendItem(n *Node, elem *Node, err error) error {
+	if elem != nil {
+		if err := t.checkArrayLen(n, int64(n.itemCount()+1)); err != nil {
+			t.releaseTree(elem)
+			return err
+		}
+	}
+	if err == nil {
+		n.addItem(elem)
+		return nil
+	}
+	if !t.Recover || elem == nil {
+		t.releaseTree(elem)
+		return err
+	}
+	n.addItem(elem)
+	if !elem.spanKnown {
+		return err
+	}
+	return nil
+}
+
+// recoverSpan works out the bytes occupied by a seq field or array element
+// that failed to read, from its size alone or, for a seq field, from the
+// offset of the fields after it (see sizeFromSiblings). ref is the type that
+// was read. On success it sets n.span and n.spanKnown; fields whose extent
+// depends on their contents (terminators, repeat-eos, variable-size structs)
+// or that would run past the end of the stream are left unknown.
+func (t *Tree) recoverSpan(n *Node, ref *types.TypeRef) {
+	if n.startPos < 0 || n.attr == nil {
+		return
+	}
+	elem := n.seqIndex < 0
+	if !elem && n.attr.If != nil {
+		present, err := t.evaluateExprBool(n.parent, n.attr.If)
+		if err != nil || !present {
+			return
+		}
+	}
+	size, ok := t.fixedSize(n, ref)
+	if ok && !elem && n.attr.Repeat != nil {
+		var count int64
+		count, ok = t.repeatCount(n)
+		size *= count
+	}
+	if !ok && !elem {
+		size, ok = t.sizeFromSiblings(n)
+	}
+	if !ok || size <= 0 {
+		return
+	}
+	// A span past the end of the stream is a truncated read, not something
+	// to skip over.
+	if end, err := n.stream.Size(); err != nil || n.startPos+size > end {
+		return
+	}
+	n.span = Range{StartIndex: uint64(n.startPos), EndIndex: uint64(n.startPos + size)}
+	n.spanKnown = true
+}
+
+// repeatCount returns the number of elements of the repeated seq field n, if
+// it is known without reading them.
+func (t *Tree) repeatCount(n *Node) (int64, bool) {
+	repeat, isExpr := n.attr.Repeat.(types.RepeatExpr)
+	if !isExpr {
+		return 0, false
+	}
+	count, err := t.evaluateExprInt(n.parent, repeat.CountExpr)
+	return count, err == nil && count >= 0
+}
+
+// sizeFromSiblings returns the size of the seq field n from the offset of
+// the field after it. In a struct read from a sub-stream of its own, the
+// fields after n end the stream, so if each of them has a size known without
+// reading it, n ends where they start.
+func (t *Tree) sizeFromSiblings(n *Node) (int64, bool) {
+	p := n.parent
+	if p == nil || !p.ownStream {
+		return 0, false
+	}
+	end, err := n.stream.Size()
+	if err != nil {
+		return 0, false
+	}
+	for _, s := range p.children[n.seqIndex+1:] {
+		size, ok := t.siblingSize(s)
+		if !ok {
+			return 0, false
+		}
+		end -= size
+	}
+	return end - n.startPos, end >= n.startPos
+}
+
+// siblingSize returns the size of the seq field s, which hasn't been read,
+// if it can be found without reading it or the fields before it.
+func (t *Tree) siblingSize(s *Node) (int64, bool) {
+	a := s.attr
+	if a.If != nil {
+		present, err := t.evaluateExprBool(s.parent, a.If)
+		if err != nil {
+			return 0, false
+		}
+		if !present {
+			return 0, true
+		}
+	}
+	ref := a.Type.FoldEndian(s.endian).TypeRef
+	if a.SizeEos {
+		return 0, false
+	}
+	if a.Size == nil && (ref == nil || ref.Kind == types.Bits ||
+		ref.Kind == types.Bytes && ref.Bytes.SizeEOS ||
+		ref.Kind == types.String && ref.String.SizeEOS) {
+		return 0, false
+	}
+	size, ok := t.fixedSize(s, ref)
+	if !ok || a.Repeat == nil {
+		return size, ok
+	}
+	count, ok := t.repeatCount(s)
+	return size * count, ok
+}
+
+// fixedSize returns the size in bytes of a single value of n, if it can be
+// found without reading it.
+func (t *Tree) fixedSize(n *Node, ref *types.TypeRef) (int64, bool) {
+	if n.attr.Size != nil {
+		return t.sizeOf(n, n.attr.Size)
+	}
+	if n.attr.SizeEos {
+		return t.remaining(n)
+	}
+	if ref == nil {
+		return 0, false
+	}
+	switch ref.Kind {
+	case types.Bytes:
+		if ref.Bytes.Size != nil {
+			return t.sizeOf(n, ref.Bytes.Size)
+		}
+		if ref.Bytes.SizeEOS {
+			return t.remaining(n)
+		}
+	case types.String:
+		if ref.String.Size != nil {
+			return t.sizeOf(n, ref.String.Size)
+		}
+		if ref.String.SizeEOS {
+			return t.remaining(n)
+		}
+	case types.User:
+		if ref.User.Size != nil {
+			return t.sizeOf(n, ref.User.Size)
+		}
+		typeSym := t.resolveTypeInScope(n, ref.User.Name)
+		if typeSym == nil || typeSym.Struct == nil {
+			return 0, false
+		}
+		if size := engine.ComputeStructSizeStatic(typeSym.Struct.Type); size >= 0 {
+			return size, true
+		}
+		return 0, false
+	}
+	if size := engine.ComputeTypeRefSize(ref); size >= 0 {
+		return size, true
+	}
+	return 0, false
+}
+
+// sizeOf evaluates a size expression for n.
+func (t *Tree) sizeOf(n *Node, e *expr.Expr) (int64, bool) {
+	size, err := t.evaluateExprInt(n.parent, e)
+	return size, err == nil
+}
+
+// remaining returns the number of bytes from n to the end of its stream.
+func (t *Tree) remaining(n *Node) (int64, bool) {
+	size, err := n.stream.Size()
+	return size - n.startPos, err == nil
+}
@@ -24,6 +24,12 @@ func (t *Tree) resolve(n *Node) error {
 		return n.err
 	}
 
+	if err := t.countNode(n); err != nil {
+		n.err = err
+		n.state = stateError
+		return err
+	}
+
 	// Track this node on the resolving stack for dependency edge recording.
 	t.pushResolving(n)
 	defer t.popResolving()
@@ -38,8 +44,12 @@ func (t *Tree) resolve(n *Node) error {
 	}
 
 	if err != nil {
+		t.refundBytes(n)
 		n.err = err
 		n.state = stateError
+		if t.Recover && n.seqIndex >= 0 && !n.spanKnown {
+			t.recoverSpan(n, n.attr.Type.FoldEndian(n.endian).TypeRef)
+		}
 	}
 	return err
 }
@@ -89,9 +99,11 @@ func (t *Tree) resolveSeqField(n *Node) error {
 	} else {
 		pred := parent.children[n.seqIndex-1]
 		if pred.state < stateSpanResolved {
-			if err := t.resolve(pred); err != nil {
+			if err := t.resolve(pred); err != nil && !t.canSkip(pred) {
 				return fmt.Errorf("resolving predecessor %s: %w", pred.path, err)
 			}
+		} else if pred.state == stateError && !t.canSkip(pred) {
+			return fmt.Errorf("resolving predecessor %s: %w", pred.path, pred.err)
 		}
 		n.startPos = pred.endPos()
 	}
@@ -204,7 +216,7 @@ func (t *Tree) resolveInstance(n *Node) error {
 		}
 		n.span = Range{} // no byte range for computed values
 		n.state = stateResolved
-		return nil
+		return t.validate(n)
 	}
 
 	// Determine stream. Default to whatever this node already pointed at
@@ -325,7 +337,7 @@ func (t *Tree) ensureSeqResolvedAndSeeked(s *Node) error {
 	}
 	for _, child := range s.children {
 		if child.state == stateUnresolved {
-			if err := t.resolve(child); err != nil {
+			if err := t.resolve(child); err != nil && !t.canSkip(child) {
 				return fmt.Errorf("resolving seq sibling %s: %w", child.path, err)
 			}
 		}
@@ -334,7 +346,7 @@ func (t *Tree) ensureSeqResolvedAndSeeked(s *Node) error {
 	// end-of-seq regardless of any instance reads that happened in between.
 	if len(s.children) > 0 && s.stream != nil {
 		last := s.children[len(s.children)-1]
-		if last.state == stateResolved || last.state == stateSpanResolved {
+		if last.state == stateResolved || last.state == stateSpanResolved || t.canSkip(last) {
 			endPos := int64(last.span.EndIndex)
 			if _, err := s.stream.Seek(endPos, io.SeekStart); err != nil {
 				return err
@@ -125,17 +125,20 @@ func (r nodeRef) LookupChild(name string) (*engine.ExprValue, bool) {
 func (r nodeRef) LookupIndex(i int) (*engine.ExprValue, bool) {
 	n := r.n
 
-	// Ensure the array is resolved so n.items is populated.
+	// Ensure the array is resolved so its length is known.
 	if n.state != stateResolved {
 		if err := n.tree.resolve(n); err != nil {
 			return nil, false
 		}
 	}
-	if i < 0 || i >= len(n.items) {
+	if i < 0 || i >= n.itemCount() {
 		return nil, false
 	}
 
-	item := n.items[i]
+	item, _ := n.ItemAt(i)
+	if item == nil {
+		return nil, true
+	}
 	n.tree.recordDep(item)
 
 	ev, err := nodeToExprValue(item)
@@ -207,5 +210,5 @@ func (r nodeRef) Len() (int, bool) {
 	if n.state != stateResolved {
 		return 0, false
 	}
-	return len(n.items), true
+	return n.itemCount(), true
 }
@@ -29,7 +29,7 @@ func stripBytes(data []byte, terminator int, padRight int, include bool) []byte
 	return data
 }
 
-// stripPadRightMulti strips trailing pad bytes from a UTF-16-encoded byte
+// stripPadRightMulti strips trailing pad bytes from a UTF-16 or UTF-32 byte
 // sequence. KS semantics for pad-right on multi-byte encodings is to strip
 // raw bytes from the right (not aligned code units), matching the
 // upstream Java/Python implementations.
@@ -40,14 +40,14 @@ func stripPadRightMulti(data []byte, pad byte) []byte {
 	return data
 }
 
-// stripBytesMulti strips a 2-byte terminator from data (for UTF-16).
-// Searches for aligned 2-byte sequences of [term, term].
-func stripBytesMulti(data []byte, term byte, include bool) []byte {
-	termSeq := []byte{term, term}
-	for i := 0; i+1 < len(data); i += 2 {
-		if data[i] == termSeq[0] && data[i+1] == termSeq[1] {
+// stripBytesMulti strips a multi-byte terminator from data (for UTF-16 and
+// UTF-32). Searches for aligned unit-byte sequences of [term, term, ...].
+func stripBytesMulti(data []byte, term byte, unit int, include bool) []byte {
+	termSeq := bytes.Repeat([]byte{term}, unit)
+	for i := 0; i+unit <= len(data); i += unit {
+		if bytes.Equal(data[i:i+unit], termSeq) {
 			if include {
-				return data[:i+2]
+				return data[:i+unit]
 			}
 			return data[:i]
 		}
@@ -56,7 +56,7 @@ func stripBytesMulti(data []byte, term byte, include bool) []byte {
 }
 
 // readBytesTermMulti reads bytes until a multi-byte terminator sequence is found.
-// Used for UTF-16 where the null terminator is 2 bytes.
+// Used for UTF-16 and UTF-32, where the null terminator is 2 or 4 bytes.
 func readBytesTermMulti(stream *Stream, term []byte, include bool, consume bool) ([]byte, error) {
 	termLen := len(term)
 	var result []byte
@@ -1,7 +1,10 @@
 package eval
 
 import (
+	"context"
+
 	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/expr/engine"
 	"github.com/jchv/zanbato/kaitai/resolve"
 	"github.com/jchv/zanbato/kaitai/types"
@@ -36,8 +39,68 @@ type Tree struct {
 	// RegisterProcess. nil until first use.
 	processes map[string]ProcessFunc
 
+	// validExprs caches parsed `valid:` expressions by source text.
+	validExprs map[string]*expr.Expr
+
+	// optimized caches the optimized roots of expressions, by struct type
+	// and source text. See Optimize.
+	optimized map[optimizedKey]expr.Node
+
+	// ValidationWarnings controls how `contents:` and `valid:` failures are
+	// handled. When false (the default), a failed check is a resolution
+	// error, matching generated parsers. When true, failures are recorded on
+	// the offending node (see Node.Warnings) and parsing continues.
+	ValidationWarnings bool
+
+	// Recover enables best-effort parsing of truncated or corrupt data. When
+	// a seq field or array element fails to read but the bytes it occupies
+	// can still be worked out - from a fixed size, a `size:` expression or
+	// a statically sized type, or for a seq field in a struct with a sized
+	// stream of its own, from the known offset of the fields after it - the
+	// failure is kept on that node and later siblings and elements are read
+	// after it. The nodes that failed remain in the tree, so a
+	// partially-read struct or array still lists them.
+	Recover bool
+
 	// Compat is the compatibility mode for expression evaluation.
 	Compat kaitai.Compatibility
+
+	// Funcs holds the host functions and methods expressions may call. It
+	// defaults to engine.DefaultFuncs.
+	Funcs *engine.FuncRegistry
+
+	// Optimize controls whether expressions are simplified with
+	// engine.Optimize before they are evaluated. Each expression is
+	// optimized once per struct type. Value instances are left in place,
+	// so overriding one with SetValue works the same either way.
+	Optimize bool
+
+	// limits bounds the resources used to resolve nodes. See WithLimits.
+	limits Limits
+
+	// ctx, if set, is checked as nodes are resolved. See WithContext.
+	ctx context.Context
+
+	// nodeCount and bytesRead are checked against limits. They count the
+	// nodes and bytes the tree holds: nodes give back what they were
+	// charged when they are invalidated or dropped.
+	nodeCount int
+	bytesRead int64
+}
+
+// Option configures a Tree in NewTree.
+type Option func(*Tree)
+
+// WithLimits bounds the resources used to resolve nodes. Without it, there
+// are no limits; use DefaultLimits for untrusted input.
+func WithLimits(limits Limits) Option {
+	return func(t *Tree) { t.limits = limits }
+}
+
+// WithContext makes resolution check ctx as nodes are resolved. Once it is
+// done, resolution fails with its error.
+func WithContext(ctx context.Context) Option {
+	return func(t *Tree) { t.ctx = ctx }
 }
 
 // pushIndex sets the current _index value for the duration of an array
@@ -95,8 +158,9 @@ func (t *Tree) recordDep(dep *Node) {
 
 // NewTree creates a new lazy evaluation tree from a KSY schema and binary
 // stream. No IO is performed; the tree is fully unresolved. Call Root() and
-// then drill down into nodes to trigger lazy reads.
-func NewTree(resolver resolve.Resolver, inputName string, schema *kaitai.Struct, stream *Stream) (*Tree, error) {
+// then drill down into nodes to trigger lazy reads. An error is returned if
+// any of the schema's imports can't be loaded.
+func NewTree(resolver resolve.Resolver, inputName string, schema *kaitai.Struct, stream *Stream, opts ...Option) (*Tree, error) {
 	t := &Tree{
 		stream:    stream,
 		resolver:  resolver,
@@ -104,10 +168,26 @@ func NewTree(resolver resolve.Resolver, inputName string, schema *kaitai.Struct,
 		schema:    schema,
 		typeCtx:   engine.NewContext(),
 		Compat:    engine.DefaultCompat,
+		Funcs:     engine.DefaultFuncs,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
 
-	// Resolve imports into the type context
-	t.resolveImports(inputName, schema)
+	// Register the types of every imported module.
+	graph := resolve.NewGraph(resolver, inputName, schema)
+	if err := graph.Err(); err != nil {
+		return nil, err
+	}
+	for _, m := range graph.Modules() {
+		id := string(m.Struct.ID)
+		if t.typeCtx.ResolveGlobalType(id) != nil {
+			continue
+		}
+		typeSym := engine.NewStructSymbol(m.Struct, nil)
+		t.typeCtx.AddGlobalType(id, typeSym)
+		t.typeCtx.AddModuleType(id, typeSym)
+	}
 
 	// Build the root type symbol and register it
 	typeSym := engine.NewStructSymbol(schema, nil)
@@ -124,6 +204,9 @@ func NewTree(resolver resolve.Resolver, inputName string, schema *kaitai.Struct,
 // Root returns the root node of the tree.
 func (t *Tree) Root() *Node { return t.root }
 
+// Lookup finds the node at a path from the root. See Node.Lookup.
+func (t *Tree) Lookup(p Path) (*Node, error) { return t.root.Lookup(p) }
+
 // Schema returns the KSY schema.
 func (t *Tree) Schema() *kaitai.Struct { return t.schema }
 
@@ -156,28 +239,6 @@ func (t *Tree) propagateStream(n *Node, stream *Stream) {
 	}
 }
 
-// resolveImports recursively imports types into the type context.
-func (t *Tree) resolveImports(inputName string, s *kaitai.Struct) {
-	id := string(s.ID)
-
-	// Cycle detection: if this type is already registered, skip
-	if t.typeCtx.ResolveGlobalType(id) != nil {
-		return
-	}
-
-	typeSym := engine.NewStructSymbol(s, nil)
-	t.typeCtx.AddGlobalType(id, typeSym)
-	t.typeCtx.AddModuleType(id, typeSym)
-
-	for _, importName := range s.Meta.Imports {
-		resolvedName, resolvedStruct, err := t.resolver.Resolve(inputName, importName)
-		if err != nil {
-			continue // skip unresolvable imports
-		}
-		t.resolveImports(resolvedName, resolvedStruct)
-	}
-}
-
 // newStructNode creates a Node for a struct type, populating child nodes for
 // seq fields and instances but NOT resolving any of them.
 func (t *Tree) newStructNode(parent *Node, attr *kaitai.Attr, schema *kaitai.Struct, typeSym *engine.ExprValue, stream *Stream, startPos int64, endian types.EndianKind, bitEndian types.BitEndianKind) *Node {
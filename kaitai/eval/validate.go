@@ -0,0 +1,165 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/expr/engine"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+
+	kaitai_io "github.com/jchw-forks/kaitai_struct_go_runtime/kaitai"
+)
+
+// validate checks a freshly-read node against its attribute's `contents:`
+// and `valid:` clauses, mirroring the checks the Go and C emitters generate.
+// Failures are returned as the kaitai runtime's Validation* error types, or
+// recorded as node warnings when Tree.ValidationWarnings is set.
+func (t *Tree) validate(n *Node) error {
+	if n.attr == nil || (n.attr.Contents == nil && n.attr.Valid == nil) {
+		return nil
+	}
+	// Skipped fields (if: false, unmatched switch) have nothing to check.
+	if n.value.Kind == KindNone && n.exprVal == nil {
+		return nil
+	}
+	err := t.checkValid(n)
+	if err == nil {
+		return nil
+	}
+	if t.ValidationWarnings {
+		n.warnings = append(n.warnings, err)
+		return nil
+	}
+	return err
+}
+
+// checkValid returns the first validation failure for n, if any.
+func (t *Tree) checkValid(n *Node) error {
+	srcPath := n.path.String()
+
+	if n.attr.Contents != nil {
+		if n.value.Kind != KindBytes || !bytes.Equal(n.value.Bytes, n.attr.Contents) {
+			return kaitai_io.NewValidationNotEqualError(n.attr.Contents, n.value.goValue(), n.stream, srcPath)
+		}
+	}
+
+	valid := n.attr.Valid
+	if valid == nil {
+		return nil
+	}
+
+	actual, err := nodeToExprValue(n)
+	if err != nil {
+		return fmt.Errorf("validating %s: %w", n.path, err)
+	}
+	if actual == nil {
+		return nil
+	}
+
+	if valid.Eq != "" {
+		expected, ok, err := t.compareValid(n, actual, valid.Eq, engine.CompareEqual)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return kaitai_io.NewValidationNotEqualError(expected, n.value.goValue(), n.stream, srcPath)
+		}
+	}
+	if valid.Min != "" {
+		minVal, ok, err := t.compareValid(n, actual, valid.Min, engine.CompareEqual|engine.CompareGreaterThan)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return kaitai_io.NewValidationLessThanError(minVal, n.value.goValue(), n.stream, srcPath)
+		}
+	}
+	if valid.Max != "" {
+		maxVal, ok, err := t.compareValid(n, actual, valid.Max, engine.CompareLessThan|engine.CompareEqual)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return kaitai_io.NewValidationGreaterThanError(maxVal, n.value.goValue(), n.stream, srcPath)
+		}
+	}
+	if len(valid.AnyOf) > 0 {
+		found := false
+		for _, item := range valid.AnyOf {
+			_, ok, err := t.compareValid(n, actual, item, engine.CompareEqual)
+			if err != nil {
+				return err
+			}
+			if ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return kaitai_io.NewValidationNotAnyOfError(n.value.goValue(), n.stream, srcPath)
+		}
+	}
+	if valid.Expr != "" {
+		e, err := t.validExpr(valid.Expr)
+		if err != nil {
+			return srcpos.Errorf(n.attr.Source, "parsing valid expr for %s: %w", n.path, err)
+		}
+		index := max(t.currentIndex(), 0)
+		result, err := t.evaluateExprWithTemp(n.parent, e, n, index)
+		if err != nil {
+			return fmt.Errorf("evaluating valid expr for %s: %w", n.path, err)
+		}
+		if result == nil || result.Kind != engine.BooleanKind || result.Boolean == nil {
+			return fmt.Errorf("valid expr for %s did not evaluate to a boolean", n.path)
+		}
+		if !result.Boolean.Value {
+			return kaitai_io.NewValidationExprError(n.value.goValue(), n.stream, srcPath)
+		}
+	}
+	if valid.InEnum && n.attr.Enum != "" {
+		if n.value.Kind != KindEnum || n.value.EnumLabel == "" {
+			return kaitai_io.NewValidationNotInEnumError(n.value.goValue(), n.stream, srcPath)
+		}
+	}
+	return nil
+}
+
+// compareValid evaluates a `valid:` bound in the scope of n's parent and
+// compares the node's value against it. It returns the evaluated bound (as a
+// plain Go value, for error reporting) and whether the comparison held.
+func (t *Tree) compareValid(n *Node, actual *engine.ExprValue, src string, mask engine.CompareMask) (any, bool, error) {
+	e, err := t.validExpr(src)
+	if err != nil {
+		return nil, false, srcpos.Errorf(n.attr.Source, "parsing valid expression %q for %s: %w", src, n.path, err)
+	}
+	bound, err := t.evaluateExpr(n.parent, e)
+	if err != nil {
+		return nil, false, fmt.Errorf("evaluating valid expression %q for %s: %w", src, n.path, err)
+	}
+	if bound == nil {
+		return nil, false, fmt.Errorf("valid expression %q for %s evaluated to nil", src, n.path)
+	}
+	ok, err := engine.Compare(actual, bound, mask)
+	if err != nil {
+		return nil, false, fmt.Errorf("comparing %s against %q: %w", n.path, src, err)
+	}
+	return exprValueToValue(bound).goValue(), ok, nil
+}
+
+// validExpr parses a `valid:` expression, caching the result so repeated
+// fields don't re-parse the same source for every element.
+func (t *Tree) validExpr(src string) (*expr.Expr, error) {
+	if e, ok := t.validExprs[src]; ok {
+		return e, nil
+	}
+	e, err := expr.ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	if t.validExprs == nil {
+		t.validExprs = make(map[string]*expr.Expr)
+	}
+	t.validExprs[src] = e
+	return e, nil
+}
@@ -0,0 +1,83 @@
+package eval
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jchv/zanbato/kaitai/resolve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kaitai_io "github.com/jchw-forks/kaitai_struct_go_runtime/kaitai"
+)
+
+// openCustomTreeBytes opens a tree from a custom testdata format over an
+// in-memory buffer.
+func openCustomTreeBytes(t *testing.T, ksyName string, data []byte) *Tree {
+	t.Helper()
+	resolver := resolve.NewOSResolverWithPaths([]string{customFormatsDir})
+	basename, struc, err := resolver.Resolve("", filepath.Join(customFormatsDir, ksyName+".ksy"))
+	require.NoError(t, err, "resolving KSY %s", ksyName)
+	tree, err := NewTree(resolver, basename, struc, NewStream(bytes.NewReader(data)))
+	require.NoError(t, err, "creating tree for %s", ksyName)
+	return tree
+}
+
+// openSourceBytes opens a tree from a single KSY source over an in-memory
+// buffer.
+func openSourceBytes(t *testing.T, src string, data []byte, opts ...Option) *Tree {
+	t.Helper()
+	resolver := resolve.NewFSResolver(fstest.MapFS{"main.ksy": &fstest.MapFile{Data: []byte(src)}})
+	basename, struc, err := resolver.Resolve("", "main.ksy")
+	require.NoError(t, err)
+	tree, err := NewTree(resolver, basename, struc, NewStream(bytes.NewReader(data)), opts...)
+	require.NoError(t, err)
+	return tree
+}
+
+func TestValidate_Contents(t *testing.T) {
+	tree := openCustomTreeBytes(t, "zb_valid_contents", []byte{0x89, 'P', 'N', 'X', 0x0d, 0x0a})
+	magic, err := tree.Root().Child("magic")
+	require.NoError(t, err)
+	require.NotNil(t, magic)
+
+	err = magic.Resolve()
+	require.Error(t, err)
+	assert.True(t, errors.As(err, new(kaitai_io.ValidationNotEqualError)), "got %v", err)
+}
+
+func TestValidate_Range(t *testing.T) {
+	tree := openCustomTreeBytes(t, "zb_valid_range", []byte{5})
+	val, err := tree.Root().Child("val")
+	require.NoError(t, err)
+	err = val.Resolve()
+	assert.True(t, errors.As(err, new(kaitai_io.ValidationLessThanError)), "got %v", err)
+
+	tree = openCustomTreeBytes(t, "zb_valid_range", []byte{50})
+	val, err = tree.Root().Child("val")
+	require.NoError(t, err)
+	v, err := val.Value()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(50), v.Uint)
+	assert.Empty(t, val.Warnings())
+}
+
+func TestValidate_Warnings(t *testing.T) {
+	tree := openCustomTreeBytes(t, "zb_valid_anyof", []byte{7})
+	tree.ValidationWarnings = true
+	val, err := tree.Root().Child("val")
+	require.NoError(t, err)
+
+	v, err := val.Value()
+	require.NoError(t, err, "validation failures should not stop the parse")
+	assert.Equal(t, uint64(7), v.Uint)
+	require.Len(t, val.Warnings(), 1)
+	assert.True(t, errors.As(val.Warnings()[0], new(kaitai_io.ValidationNotAnyOfError)))
+
+	// Invalidation drops recorded warnings along with the value.
+	tree.Root().Invalidate()
+	assert.Empty(t, val.Warnings())
+}
@@ -64,6 +64,32 @@ type Value struct {
 	EnumLabel string // symbolic label (e.g. "cat"), empty if unknown
 }
 
+// goValue returns the scalar held by v as a plain Go value, for use in error
+// messages and annotations. Returns nil for struct, array and empty values.
+func (v Value) goValue() any {
+	switch v.Kind {
+	case KindInt:
+		return v.Int
+	case KindUint:
+		return v.Uint
+	case KindFloat:
+		return v.Float
+	case KindBool:
+		return v.Bool
+	case KindBytes:
+		return v.Bytes
+	case KindStr:
+		return v.Str
+	case KindEnum:
+		if v.Int < 0 && v.Uint != 0 {
+			return v.Uint
+		}
+		return v.Int
+	default:
+		return nil
+	}
+}
+
 // nodeToExprValue converts a resolved Node's Value to an engine.ExprValue
 // for use in expression evaluation.
 func nodeToExprValue(n *Node) (*engine.ExprValue, error) {
@@ -174,9 +200,14 @@ func nodeToExprValue(n *Node) (*engine.ExprValue, error) {
 		}
 		return nil, fmt.Errorf("struct node %s has no type symbol", n.name)
 	case KindArray:
-		// Build array of ExprValues from items
-		items := make([]*engine.ExprValue, len(n.items))
-		for i, item := range n.items {
+		// Build array of ExprValues from items. Expressions can use any
+		// element, so a lazy array reads them all.
+		nodes, err := n.Items()
+		if err != nil {
+			return nil, err
+		}
+		items := make([]*engine.ExprValue, len(nodes))
+		for i, item := range nodes {
 			ev, err := nodeToExprValue(item)
 			if err != nil {
 				return nil, fmt.Errorf("array item %d: %w", i, err)
@@ -8,10 +8,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
-	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/encoding/unicode"
+	"github.com/jchv/zanbato/kaitai/charset"
 )
 
 func getBuiltin(builtin BuiltinMethod) MethodFn {
@@ -102,43 +99,11 @@ func builtinMethodByteArrayToString(this *ExprValue, args []*ExprValue) (*ExprVa
 		enc = args[0].String.Value
 	}
 
-	return NewStringLiteralValue(decodeString(data, enc)), nil
-}
-
-func getEncoding(name string) encoding.Encoding {
-	normalized := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(name, "-", ""), "_", ""))
-	switch normalized {
-	case "UTF8", "":
-		return nil // UTF-8 is native, no decoding needed
-	case "ASCII":
-		return nil // ASCII is a subset of UTF-8
-	case "UTF16LE":
-		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
-	case "UTF16BE":
-		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
-	case "SHIFTJIS", "SJIS":
-		return japanese.ShiftJIS
-	case "IBM437", "CP437":
-		return charmap.CodePage437
-	case "ISO88591", "LATIN1":
-		return charmap.ISO8859_1
-	case "WINDOWS1252", "CP1252":
-		return charmap.Windows1252
-	default:
-		return nil // unknown encoding, treat as raw bytes
-	}
-}
-
-func decodeString(data []byte, enc string) string {
-	e := getEncoding(enc)
-	if e == nil {
-		return string(data) // UTF-8 or unknown
-	}
-	decoded, err := e.NewDecoder().Bytes(data)
+	str, err := charset.Decode(data, enc)
 	if err != nil {
-		return string(data) // fallback to raw
+		return nil, err
 	}
-	return string(decoded)
+	return NewStringLiteralValue(str), nil
 }
 
 func builtinMethodStringLength(this *ExprValue, args []*ExprValue) (*ExprValue, error) {
@@ -84,6 +84,10 @@ const (
 
 	// MethodStreamPos returns the current position in the stream, in bytes.
 	MethodStreamPos
+
+	// MethodHost calls a function registered in a FuncRegistry. See
+	// MethodTypeData.Host.
+	MethodHost
 )
 
 // ValueType represents a concrete type, e.g. one that resolves into generated
@@ -171,6 +175,9 @@ type MethodTypeData struct {
 	Method     BuiltinMethod
 	Arguments  []ValueType
 	ReturnType ValueType
+
+	// Host is the host function called by MethodHost methods.
+	Host *HostFunc
 }
 
 // StructTypeData holds type-level information about a struct symbol.
@@ -1026,6 +1033,9 @@ type Context struct {
 	tmp    *ExprValue
 	index  *ExprValue
 	Compat kaitai.Compatibility
+
+	// Funcs holds the host functions and methods visible to expressions.
+	Funcs *FuncRegistry
 }
 
 // NewContext creates a new symbol context.
@@ -1036,6 +1046,7 @@ func NewContext() *Context {
 		local:  NewValueRoot(),
 		stream: NewStreamValue(),
 		Compat: DefaultCompat,
+		Funcs:  DefaultFuncs,
 	}
 }
 
@@ -1068,6 +1079,7 @@ func (context *Context) WithModuleRoot(symbol *ExprValue) *Context {
 		tmp:    context.tmp,
 		index:  context.index,
 		Compat: context.Compat,
+		Funcs:  context.Funcs,
 	}
 }
 
@@ -1080,6 +1092,7 @@ func (context *Context) WithLocalRoot(symbol *ExprValue) *Context {
 		tmp:    context.tmp,
 		index:  context.index,
 		Compat: context.Compat,
+		Funcs:  context.Funcs,
 	}
 }
 
@@ -1091,6 +1104,7 @@ func (context *Context) WithTemporary(symbol *ExprValue) *Context {
 		stream: context.stream,
 		tmp:    symbol,
 		Compat: context.Compat,
+		Funcs:  context.Funcs,
 	}
 }
 
@@ -1103,6 +1117,7 @@ func (context *Context) WithStream(stream *ExprValue) *Context {
 		tmp:    context.tmp,
 		index:  context.index,
 		Compat: context.Compat,
+		Funcs:  context.Funcs,
 	}
 }
 
@@ -1115,6 +1130,7 @@ func (context *Context) WithIndex(index *ExprValue) *Context {
 		tmp:    context.tmp,
 		index:  index,
 		Compat: context.Compat,
+		Funcs:  context.Funcs,
 	}
 }
 
@@ -1224,6 +1240,9 @@ func (context *Context) Resolve(name string) (*ExprValue, ResolutionScope) {
 	if sym := context.ResolveGlobal(name); sym != nil {
 		return sym, GlobalScope
 	}
+	if fn := context.Funcs.Lookup(NoReceiver, name); fn != nil {
+		return NewHostFuncValue(fn), GlobalScope
+	}
 
 	return nil, GlobalScope
 }
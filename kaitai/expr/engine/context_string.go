@@ -27,11 +27,12 @@ func _() {
 	_ = x[MethodStreamEOF-16]
 	_ = x[MethodStreamSize-17]
 	_ = x[MethodStreamPos-18]
+	_ = x[MethodHost-19]
 }
 
-const _BuiltinMethod_name = "InvalidMethodMethodIntToStringMethodFloatToIntMethodByteArrayLengthMethodByteArrayToStringMethodStringLengthMethodStringReverseMethodStringSubstringMethodStringToIntMethodEnumToIntMethodBoolToIntMethodArrayFirstMethodArrayLastMethodArraySizeMethodArrayMinMethodArrayMaxMethodStreamEOFMethodStreamSizeMethodStreamPos"
+const _BuiltinMethod_name = "InvalidMethodMethodIntToStringMethodFloatToIntMethodByteArrayLengthMethodByteArrayToStringMethodStringLengthMethodStringReverseMethodStringSubstringMethodStringToIntMethodEnumToIntMethodBoolToIntMethodArrayFirstMethodArrayLastMethodArraySizeMethodArrayMinMethodArrayMaxMethodStreamEOFMethodStreamSizeMethodStreamPosMethodHost"
 
-var _BuiltinMethod_index = [...]uint16{0, 13, 30, 46, 67, 90, 108, 127, 148, 165, 180, 195, 211, 226, 241, 255, 269, 284, 300, 315}
+var _BuiltinMethod_index = [...]uint16{0, 13, 30, 46, 67, 90, 108, 127, 148, 165, 180, 195, 211, 226, 241, 255, 269, 284, 300, 315, 325}
 
 func (i BuiltinMethod) String() string {
 	idx := int(i) - 0
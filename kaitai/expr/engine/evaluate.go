@@ -7,7 +7,9 @@ import (
 	"math/big"
 	"strings"
 
+	"github.com/jchv/zanbato/kaitai"
 	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/srcpos"
 	"github.com/jchv/zanbato/kaitai/types"
 )
 
@@ -19,14 +21,41 @@ const (
 	CompareGreaterThan
 )
 
-func Evaluate(context *EvalContext, expr *expr.Expr) (*ExprValue, error) {
-	val, err := evalNode(context, expr.Root)
+// Evaluate evaluates e. Errors are attributed to the position of the node
+// that failed, if e's position is known.
+func Evaluate(context *EvalContext, e *expr.Expr) (*ExprValue, error) {
+	val, err := evalNode(context, e.Root)
+	if err == nil {
+		val, err = runtimeVal(context, val)
+	}
 	if err != nil {
-		return nil, err
+		pos := e.Source
+		var nodeErr *nodeError
+		if errors.As(err, &nodeErr) && !nodeErr.reported {
+			nodeErr.reported = true
+			pos = e.Pos(nodeErr.node)
+			if member, ok := nodeErr.node.(expr.MemberNode); ok {
+				pos = e.OffsetPos(member.PropertySpan().Start)
+			}
+		}
+		return nil, srcpos.Wrap(pos, err)
 	}
-	return runtimeVal(context, val)
+	return val, nil
 }
 
+// nodeError is an error evaluating a node, which Evaluate attributes to the
+// node's position. Once it has been, an Evaluate further up (for an
+// expression that depends on the failing one) treats it as any other error.
+type nodeError struct {
+	node     expr.Node
+	err      error
+	reported bool
+}
+
+func (e *nodeError) Error() string { return e.err.Error() }
+
+func (e *nodeError) Unwrap() error { return e.err }
+
 func runtimeVal(context *EvalContext, value *ExprValue) (*ExprValue, error) {
 	switch value.Kind {
 	case StructParentKind, StructRootKind, StructKind:
@@ -57,7 +86,59 @@ func runtimeVal(context *EvalContext, value *ExprValue) (*ExprValue, error) {
 	}
 }
 
+// evalNode evaluates node, recording it on the error if it is the innermost
+// node that failed.
 func evalNode(context *EvalContext, node expr.Node) (*ExprValue, error) {
+	val, err := evalNodeValue(context, node)
+	if err != nil {
+		var nodeErr *nodeError
+		if !errors.As(err, &nodeErr) || nodeErr.reported {
+			err = &nodeError{node: node, err: err}
+		}
+		return nil, err
+	}
+	return val, nil
+}
+
+// evalArgs evaluates the arguments of a call.
+func evalArgs(context *EvalContext, nodes []expr.Node) ([]*ExprValue, error) {
+	args := make([]*ExprValue, len(nodes))
+	for i, arg := range nodes {
+		val, err := evalNode(context, arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i], err = runtimeVal(context, val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// callProperty calls a method accessed like a property, as in `.to_i` or
+// `.length`. It returns false if the method can't be called that way.
+func callProperty(method *MethodTypeData, this *ExprValue) (*ExprValue, bool, error) {
+	if method.Host != nil {
+		if len(method.Arguments) != 0 {
+			return nil, false, nil
+		}
+		result, err := callHost(method.Host, this, nil)
+		return result, true, err
+	}
+	// Skip methods that require 2+ arguments (like .substring(from, to)).
+	if len(method.Arguments) > 1 {
+		return nil, false, nil
+	}
+	fn := getBuiltin(method.Method)
+	if fn == nil {
+		return nil, false, nil
+	}
+	result, err := fn(this, nil)
+	return result, true, err
+}
+
+func evalNodeValue(context *EvalContext, node expr.Node) (*ExprValue, error) {
 	switch node := node.(type) {
 	case expr.IdentNode:
 		val, _ := context.Resolve(node.Identifier)
@@ -195,17 +276,18 @@ func evalNode(context *EvalContext, node expr.Node) (*ExprValue, error) {
 				member = rv
 			}
 		}
+		if member == nil {
+			member = context.Context.ResolveMethod(opVal, node.Property)
+		}
 		if member != nil {
 			// If the member is a property-style method, invoke it immediately.
 			// In KS, `.to_i`, `.to_s`, `.length`, etc. are called without parens.
-			// Skip auto-invoke for methods that require 2+ arguments (like .substring(from, to)).
-			if member.Kind == MethodKind && member.Method != nil && len(member.Method.Arguments) <= 1 {
-				fn := getBuiltin(member.Method.Method)
-				if fn != nil {
-					result, err := fn(opVal, nil)
-					if err != nil {
-						return nil, fmt.Errorf("calling %s: %w", node.Property, err)
-					}
+			if member.Kind == MethodKind && member.Method != nil {
+				result, ok, err := callProperty(member.Method, opVal)
+				if err != nil {
+					return nil, fmt.Errorf("calling %s: %w", node.Property, err)
+				}
+				if ok {
 					return result, nil
 				}
 			}
@@ -218,13 +300,12 @@ func evalNode(context *EvalContext, node expr.Node) (*ExprValue, error) {
 			if resolved != nil {
 				m := resolved.Child(node.Property)
 				if m != nil {
-					if m.Kind == MethodKind && m.Method != nil && len(m.Method.Arguments) <= 1 {
-						fn := getBuiltin(m.Method.Method)
-						if fn != nil {
-							result, err := fn(opVal, nil)
-							if err != nil {
-								return nil, fmt.Errorf("calling %s: %w", node.Property, err)
-							}
+					if m.Kind == MethodKind && m.Method != nil {
+						result, ok, err := callProperty(m.Method, opVal)
+						if err != nil {
+							return nil, fmt.Errorf("calling %s: %w", node.Property, err)
+						}
+						if ok {
 							return result, nil
 						}
 					}
@@ -298,24 +379,46 @@ func evalNode(context *EvalContext, node expr.Node) (*ExprValue, error) {
 			if methodSym == nil && baseVal.Kind == ArrayKind {
 				methodSym = ByteArraySymbolTable[member.Property]
 			}
+			if methodSym == nil {
+				methodSym = context.Context.ResolveMethod(baseVal, member.Property)
+			}
 			if methodSym != nil && methodSym.Kind == MethodKind && methodSym.Method != nil {
+				if host := methodSym.Method.Host; host != nil {
+					args, err := evalArgs(context, node.Args)
+					if err != nil {
+						return nil, err
+					}
+					result, err := callHost(host, baseVal, args)
+					if err != nil {
+						return nil, fmt.Errorf("calling %s: %w", member.Property, err)
+					}
+					return result, nil
+				}
 				fn := getBuiltin(methodSym.Method.Method)
 				if fn != nil {
-					args := make([]*ExprValue, len(node.Args))
-					for i, arg := range node.Args {
-						args[i], err = evalNode(context, arg)
-						if err != nil {
-							return nil, err
-						}
-						args[i], err = runtimeVal(context, args[i])
-						if err != nil {
-							return nil, err
-						}
+					args, err := evalArgs(context, node.Args)
+					if err != nil {
+						return nil, err
 					}
 					return fn(baseVal, args)
 				}
 			}
 		}
+		// Free functions registered by the host.
+		if ident, ok := node.Object.(expr.IdentNode); ok {
+			sym, _ := context.Resolve(ident.Identifier)
+			if sym != nil && sym.Kind == MethodKind && sym.Method != nil && sym.Method.Host != nil {
+				args, err := evalArgs(context, node.Args)
+				if err != nil {
+					return nil, err
+				}
+				result, err := callHost(sym.Method.Host, nil, args)
+				if err != nil {
+					return nil, fmt.Errorf("calling %s: %w", ident.Identifier, err)
+				}
+				return result, nil
+			}
+		}
 		// Fallback for non-MemberNode CallNode: evaluate the object directly
 		opVal, err := evalNode(context, node.Object)
 		if err != nil {
@@ -510,54 +613,63 @@ func evalBinary(context *EvalContext, node expr.BinaryNode) (*ExprValue, error)
 	if err != nil {
 		return nil, err
 	}
-	var result *ExprValue
-	switch node.Op {
+	result, err := applyBinary(node.Op, a, b)
+	if err != nil {
+		return nil, err
+	}
+	return truncateResult(context.Compat, result), nil
+}
+
+// applyBinary applies a binary operator to two operand values.
+func applyBinary(op expr.BinaryOp, a, b *ExprValue) (*ExprValue, error) {
+	switch op {
 	case expr.OpAdd:
-		result, err = evalAdd(a, b)
+		return evalAdd(a, b)
 	case expr.OpSub:
-		result, err = evalSub(a, b)
+		return evalSub(a, b)
 	case expr.OpMult:
-		result, err = evalMul(a, b)
+		return evalMul(a, b)
 	case expr.OpDiv:
-		result, err = evalDiv(a, b)
+		return evalDiv(a, b)
 	case expr.OpMod:
-		result, err = evalMod(a, b)
+		return evalMod(a, b)
 	case expr.OpLessThan:
-		result, err = evalCmp(a, b, CompareLessThan)
+		return evalCmp(a, b, CompareLessThan)
 	case expr.OpLessThanEqual:
-		result, err = evalCmp(a, b, CompareLessThan|CompareEqual)
+		return evalCmp(a, b, CompareLessThan|CompareEqual)
 	case expr.OpGreaterThan:
-		result, err = evalCmp(a, b, CompareGreaterThan)
+		return evalCmp(a, b, CompareGreaterThan)
 	case expr.OpGreaterThanEqual:
-		result, err = evalCmp(a, b, CompareGreaterThan|CompareEqual)
+		return evalCmp(a, b, CompareGreaterThan|CompareEqual)
 	case expr.OpEqual:
-		result, err = evalCmp(a, b, CompareEqual)
+		return evalCmp(a, b, CompareEqual)
 	case expr.OpNotEqual:
-		result, err = evalCmp(a, b, CompareLessThan|CompareGreaterThan)
+		return evalCmp(a, b, CompareLessThan|CompareGreaterThan)
 	case expr.OpShiftLeft:
-		result, err = evalShl(a, b)
+		return evalShl(a, b)
 	case expr.OpShiftRight:
-		result, err = evalShr(a, b)
+		return evalShr(a, b)
 	case expr.OpBitAnd:
-		result, err = evalBitAnd(a, b)
+		return evalBitAnd(a, b)
 	case expr.OpBitOr:
-		result, err = evalBitOr(a, b)
+		return evalBitOr(a, b)
 	case expr.OpBitXor:
-		result, err = evalBitXor(a, b)
+		return evalBitXor(a, b)
 	case expr.OpLogicalAnd:
-		result, err = evalAnd(a, b)
+		return evalAnd(a, b)
 	case expr.OpLogicalOr:
-		result, err = evalOr(a, b)
-	default:
-		return nil, fmt.Errorf("unhandled binary op: %s", node.Op.String())
-	}
-	if err != nil {
-		return nil, err
+		return evalOr(a, b)
 	}
-	if context.Compat.HasCalcIntTypeTruncationBug() && result.Kind == IntegerKind && result.Integer != nil {
-		return newSignedInt32IntegerValue(result.Integer.Value), nil
+	return nil, fmt.Errorf("unhandled binary op: %s", op.String())
+}
+
+// truncateResult applies the integer truncation rules of compat to the result
+// of a binary operator.
+func truncateResult(compat kaitai.Compatibility, result *ExprValue) *ExprValue {
+	if compat.HasCalcIntTypeTruncationBug() && result.Kind == IntegerKind && result.Integer != nil {
+		return newSignedInt32IntegerValue(result.Integer.Value)
 	}
-	return result, nil
+	return result
 }
 
 func evalAdd(a *ExprValue, b *ExprValue) (*ExprValue, error) {
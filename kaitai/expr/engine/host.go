@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// Receiver is the kind of value a host method is called on.
+type Receiver int
+
+const (
+	// NoReceiver marks a free function, called as name(args).
+	NoReceiver Receiver = iota
+
+	// IntReceiver marks a method on integers, called as value.name(args).
+	IntReceiver
+
+	// BytesReceiver marks a method on byte arrays.
+	BytesReceiver
+
+	// StringReceiver marks a method on strings.
+	StringReceiver
+
+	// ArrayReceiver marks a method on arrays of any element type.
+	ArrayReceiver
+)
+
+func (r Receiver) String() string {
+	switch r {
+	case NoReceiver:
+		return "function"
+	case IntReceiver:
+		return "int"
+	case BytesReceiver:
+		return "bytes"
+	case StringReceiver:
+		return "str"
+	case ArrayReceiver:
+		return "array"
+	}
+	return fmt.Sprintf("Receiver(%d)", int(r))
+}
+
+// HostFunc describes a method or free function provided by the host program
+// rather than by the expression language, such as a checksum used in a
+// `valid: expr:` check.
+type HostFunc struct {
+	// Name is the name the function is called by in expressions.
+	Name string
+
+	// Receiver is the kind of value the method is called on, or NoReceiver
+	// for a free function.
+	Receiver Receiver
+
+	// Arguments and ReturnType declare the signature of the function, so
+	// that expressions calling it can be type checked and compiled.
+	Arguments  []ValueType
+	ReturnType ValueType
+
+	// Fn implements the function for the evaluator. The this argument is
+	// the receiver, or nil for free functions. Fn may be nil when the
+	// function is only used for code generation.
+	Fn MethodFn
+}
+
+// FuncRegistry holds the host functions visible to expressions. It is safe
+// for concurrent use, though functions should be registered before any
+// expression that uses them is compiled or evaluated.
+type FuncRegistry struct {
+	mu    sync.RWMutex
+	funcs map[hostFuncKey]*HostFunc
+}
+
+type hostFuncKey struct {
+	receiver Receiver
+	name     string
+}
+
+// DefaultFuncs is the registry used when creating a new Context via
+// NewContext. It is empty by default.
+var DefaultFuncs = NewFuncRegistry()
+
+// NewFuncRegistry creates an empty registry.
+func NewFuncRegistry() *FuncRegistry {
+	return &FuncRegistry{funcs: make(map[hostFuncKey]*HostFunc)}
+}
+
+// RegisterFunc registers fn in DefaultFuncs.
+func RegisterFunc(fn HostFunc) error {
+	return DefaultFuncs.Register(fn)
+}
+
+// Register adds fn to the registry. It is an error to register a name twice
+// for the same receiver, or to shadow a built-in method.
+func (r *FuncRegistry) Register(fn HostFunc) error {
+	if !isHostFuncName(fn.Name) {
+		return fmt.Errorf("invalid host function name %q", fn.Name)
+	}
+	if fn.Receiver < NoReceiver || fn.Receiver > ArrayReceiver {
+		return fmt.Errorf("host function %s: invalid receiver %s", fn.Name, fn.Receiver)
+	}
+	if builtinMethods(fn.Receiver)[fn.Name] != nil {
+		return fmt.Errorf("host function %s: shadows built-in %s method", fn.Name, fn.Receiver)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := hostFuncKey{fn.Receiver, fn.Name}
+	if _, ok := r.funcs[key]; ok {
+		return fmt.Errorf("host function %s: already registered on %s", fn.Name, fn.Receiver)
+	}
+	fn.Arguments = slices.Clone(fn.Arguments)
+	r.funcs[key] = &fn
+	return nil
+}
+
+// Lookup returns the function registered under name for the given receiver,
+// or nil if there is none. A nil registry has no functions.
+func (r *FuncRegistry) Lookup(receiver Receiver, name string) *HostFunc {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.funcs[hostFuncKey{receiver, name}]
+}
+
+// Funcs returns every registered function, sorted by receiver and name.
+func (r *FuncRegistry) Funcs() []*HostFunc {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]*HostFunc, 0, len(r.funcs))
+	for _, fn := range r.funcs {
+		result = append(result, fn)
+	}
+	slices.SortFunc(result, func(a, b *HostFunc) int {
+		if a.Receiver != b.Receiver {
+			return int(a.Receiver - b.Receiver)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
+
+// isHostFuncName reports whether name is a valid KSY identifier that isn't
+// reserved by the expression language.
+func isHostFuncName(name string) bool {
+	if name == "" || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	switch name {
+	case "not", "and", "or", "true", "false", "sizeof", "bitsizeof":
+		return false
+	}
+	return true
+}
+
+// builtinMethods returns the built-in methods for a receiver.
+func builtinMethods(receiver Receiver) map[string]*ExprValue {
+	switch receiver {
+	case IntReceiver:
+		return IntegerSymbolTable
+	case BytesReceiver:
+		return ByteArraySymbolTable
+	case StringReceiver:
+		return StringSymbolTable
+	case ArrayReceiver:
+		return ArraySymbolTable(types.Type{})
+	}
+	return nil
+}
+
+// receiverOf returns the receiver kind of a value, as returned by NewValueOf
+// or the evaluator.
+func receiverOf(v *ExprValue) (Receiver, bool) {
+	switch v.Kind {
+	case IntegerKind:
+		return IntReceiver, true
+	case ByteArrayKind:
+		return BytesReceiver, true
+	case StringKind:
+		return StringReceiver, true
+	case ArrayKind:
+		return ArrayReceiver, true
+	}
+	return NoReceiver, false
+}
+
+// NewHostFuncValue creates an expression method symbol for a host function.
+func NewHostFuncValue(fn *HostFunc) *ExprValue {
+	return &ExprValue{
+		Kind: MethodKind,
+		Method: &MethodTypeData{
+			Method:     MethodHost,
+			Arguments:  fn.Arguments,
+			ReturnType: fn.ReturnType,
+			Host:       fn,
+		},
+	}
+}
+
+// ResolveMethod resolves a host method called on val, returning nil if
+// there is no such method. Built-in methods are found through val's
+// children instead.
+func (context *Context) ResolveMethod(val *ExprValue, name string) *ExprValue {
+	if val == nil {
+		return nil
+	}
+	receiver, ok := receiverOf(val)
+	if !ok {
+		return nil
+	}
+	if fn := context.Funcs.Lookup(receiver, name); fn != nil {
+		return NewHostFuncValue(fn)
+	}
+	return nil
+}
+
+// callHost calls a host function with evaluated arguments.
+func callHost(fn *HostFunc, this *ExprValue, args []*ExprValue) (*ExprValue, error) {
+	if len(args) != len(fn.Arguments) {
+		return nil, fmt.Errorf("expected %d arguments, got %d", len(fn.Arguments), len(args))
+	}
+	if fn.Fn == nil {
+		return nil, errors.New("no implementation registered")
+	}
+	result, err := fn.Fn(this, args)
+	if err == nil && result == nil {
+		err = errors.New("no result")
+	}
+	return result, err
+}
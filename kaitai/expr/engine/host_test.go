@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFuncs(t *testing.T) *FuncRegistry {
+	t.Helper()
+	funcs := NewFuncRegistry()
+	require.NoError(t, funcs.Register(HostFunc{
+		Name:       "sum",
+		Receiver:   BytesReceiver,
+		ReturnType: IntegerValueType,
+		Fn: func(this *ExprValue, args []*ExprValue) (*ExprValue, error) {
+			sum := int64(0)
+			for _, b := range this.ByteArray.Value {
+				sum += int64(b)
+			}
+			return NewIntegerLiteralValue(big.NewInt(sum)), nil
+		},
+	}))
+	require.NoError(t, funcs.Register(HostFunc{
+		Name:       "clamp",
+		Arguments:  []ValueType{IntegerValueType, IntegerValueType},
+		ReturnType: IntegerValueType,
+	}))
+	require.NoError(t, funcs.Register(HostFunc{
+		Name:       "tag",
+		Receiver:   IntReceiver,
+		Arguments:  []ValueType{StringValueType},
+		ReturnType: StringValueType,
+	}))
+	return funcs
+}
+
+func TestFuncRegistry(t *testing.T) {
+	funcs := testFuncs(t)
+
+	assert.ErrorContains(t, funcs.Register(HostFunc{Name: "sum", Receiver: BytesReceiver}), "already registered on bytes")
+	assert.ErrorContains(t, funcs.Register(HostFunc{Name: "length", Receiver: StringReceiver}), "shadows built-in str method")
+	assert.ErrorContains(t, funcs.Register(HostFunc{Name: "first", Receiver: ArrayReceiver}), "shadows built-in array method")
+	assert.ErrorContains(t, funcs.Register(HostFunc{Name: "_io"}), "invalid host function name")
+	assert.ErrorContains(t, funcs.Register(HostFunc{Name: "Sum"}), "invalid host function name")
+	assert.ErrorContains(t, funcs.Register(HostFunc{Name: "not"}), "invalid host function name")
+
+	// The same name may be used on another receiver.
+	require.NoError(t, funcs.Register(HostFunc{Name: "sum", Receiver: ArrayReceiver, ReturnType: IntegerValueType}))
+
+	var names []string
+	for _, fn := range funcs.Funcs() {
+		names = append(names, fn.Receiver.String()+" "+fn.Name)
+	}
+	assert.Equal(t, []string{"function clamp", "int tag", "bytes sum", "array sum"}, names)
+
+	assert.NotNil(t, funcs.Lookup(BytesReceiver, "sum"))
+	assert.Nil(t, funcs.Lookup(StringReceiver, "sum"))
+	assert.Nil(t, (*FuncRegistry)(nil).Lookup(NoReceiver, "clamp"))
+}
+
+func TestInferHostFuncs(t *testing.T) {
+	ctx, val := structContext(t, `
+meta:
+  id: main
+seq:
+  - id: data
+    size: 4
+  - id: n
+    type: u1
+instances:
+  total:
+    value: data.sum + 1
+  clamped:
+    value: clamp(n, 10)
+  tagged:
+    value: n.tag("x")
+  bad_count:
+    value: clamp(n)
+  bad_arg:
+    value: n.tag(data)
+`)
+	ctx.Funcs = testFuncs(t)
+	st := InferStruct(ctx, val)
+
+	got := map[string]string{}
+	for _, inst := range val.Struct.Type.Instances {
+		got[string(inst.ID)] = st.Expr(inst.Value).Type().String()
+	}
+	assert.Equal(t, "int", got["total"])
+	assert.Equal(t, "int", got["clamped"])
+	assert.Equal(t, "str", got["tagged"])
+
+	var msgs []string
+	for _, err := range st.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"main.ksy:17:12: wrong number of arguments in call to clamp: have 1, want 2",
+		"main.ksy:19:18: cannot use data (bytes) as str in argument to tag",
+	}, msgs)
+}
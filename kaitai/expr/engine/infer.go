@@ -0,0 +1,846 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// InferredType is the statically inferred type of an expression node. Arrays
+// are described by their element type with a non-nil Repeat.
+type InferredType struct {
+	ValueType
+
+	// Enum is the enum the value (or, for arrays, each element) belongs to.
+	Enum *kaitai.Enum
+
+	// Struct is the user type of the value (or, for arrays, each element).
+	Struct *kaitai.Struct
+}
+
+// IsKnown returns true if a type could be inferred.
+func (t InferredType) IsKnown() bool {
+	return t.Type.TypeRef != nil || t.Type.TypeSwitch != nil || t.Repeat != nil
+}
+
+// IsArray returns true if t is an array type.
+func (t InferredType) IsArray() bool {
+	return t.Repeat != nil
+}
+
+// TypeKind returns the primitive types.Kind of t, or 0 if t is an array or has
+// no concrete TypeRef.
+func (t InferredType) TypeKind() types.Kind {
+	if t.IsArray() || t.Type.TypeRef == nil {
+		return 0
+	}
+	return t.Type.TypeRef.Kind
+}
+
+// AssignableTo returns true if a value of type t may be used where a value of
+// type want is expected. Unknown types are assignable either way, integers
+// widen to floats and enums convert to and from their integer values.
+func (t InferredType) AssignableTo(want InferredType) bool {
+	have, w := classOf(t), classOf(want)
+	switch {
+	case have == classUnknown || w == classUnknown || have == w:
+		return true
+	case have == classInt && w == classFloat:
+		return true
+	case have == classEnum && w == classInt, have == classInt && w == classEnum:
+		return true
+	}
+	return false
+}
+
+// Comparable returns true if values of types a and b can be compared, such as
+// a switch-on value and its case keys. Unknown types compare with anything.
+func Comparable(a, b InferredType) bool {
+	ca, cb := classOf(a), classOf(b)
+	return ca == classUnknown || cb == classUnknown || canCompare(ca, cb)
+}
+
+// Elem returns the element type of an array type.
+func (t InferredType) Elem() InferredType {
+	t.Repeat = nil
+	return t
+}
+
+// String describes the type the way it is written in a .ksy file, e.g. u4le,
+// str, bytes or u2[]. Untyped literals are described as int, float and bool.
+func (t InferredType) String() string {
+	var s string
+	switch {
+	case t.Enum != nil:
+		s = "enum " + string(t.Enum.ID)
+	case t.Type.TypeSwitch != nil:
+		s = "switch"
+	case t.Type.TypeRef == nil:
+		s = "unknown"
+	default:
+		ref := t.Type.TypeRef
+		switch ref.Kind {
+		case types.Bits:
+			s = fmt.Sprintf("b%d", ref.Bits.Width)
+		case types.Bytes:
+			s = "bytes"
+		case types.String:
+			s = "str"
+		case types.User:
+			s = ref.User.Name
+		case types.UntypedInt:
+			s = "int"
+		case types.UntypedFloat:
+			s = "float"
+		case types.UntypedBool:
+			s = "bool"
+		default:
+			s = strings.ToLower(ref.Kind.String())
+		}
+	}
+	if t.Repeat != nil {
+		s += "[]"
+	}
+	return s
+}
+
+// TypedNode is an expression node annotated with its inferred type. Children
+// holds the typed operands of the node, in source order.
+type TypedNode struct {
+	Node     expr.Node
+	Type     InferredType
+	Children []*TypedNode
+}
+
+// TypedExpr is an expression annotated with the inferred type of every node.
+type TypedExpr struct {
+	Expr *expr.Expr
+	Root *TypedNode
+
+	// nodes indexes the typed nodes by position and kind, for TypeOf.
+	nodes map[typedKey]*TypedNode
+}
+
+// typedKey identifies a node of an expression. Nodes aren't all comparable,
+// so they're told apart by their span and their concrete type instead.
+type typedKey struct {
+	span expr.Span
+	kind reflect.Type
+}
+
+func newTypedExpr(e *expr.Expr, root *TypedNode) *TypedExpr {
+	t := &TypedExpr{Expr: e, Root: root, nodes: make(map[typedKey]*TypedNode)}
+	var index func(n *TypedNode)
+	index = func(n *TypedNode) {
+		// Synthesized nodes have no position to tell them apart.
+		key := typedKey{expr.SpanOf(n.Node), reflect.TypeOf(n.Node)}
+		if _, ok := t.nodes[key]; !ok && key.span != (expr.Span{}) {
+			t.nodes[key] = n
+		}
+		for _, child := range n.Children {
+			index(child)
+		}
+	}
+	if root != nil {
+		index(root)
+	}
+	return t
+}
+
+// Type returns the inferred type of the whole expression.
+func (t *TypedExpr) Type() InferredType {
+	if t == nil || t.Root == nil {
+		return InferredType{}
+	}
+	return t.Root.Type
+}
+
+// TypeOf returns the inferred type of node, a node of the expression.
+// Synthesized nodes, which have no position, aren't found.
+func (t *TypedExpr) TypeOf(node expr.Node) (InferredType, bool) {
+	if t == nil {
+		return InferredType{}, false
+	}
+	if found, ok := t.nodes[typedKey{expr.SpanOf(node), reflect.TypeOf(node)}]; ok {
+		return found.Type, true
+	}
+	return InferredType{}, false
+}
+
+// TypeError is a type error found while inferring the types of an expression.
+type TypeError struct {
+	Expr    *expr.Expr
+	Node    expr.Node
+	Message string
+}
+
+// Pos returns the position of the offending node.
+func (e TypeError) Pos() srcpos.Pos {
+	return e.Expr.Pos(e.Node)
+}
+
+func (e TypeError) Error() string {
+	if pos := e.Pos(); pos.IsValid() {
+		return pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+// StructTypes holds the typed expressions of a single struct.
+type StructTypes struct {
+	Struct *kaitai.Struct
+	Exprs  map[*expr.Expr]*TypedExpr
+	Errors []TypeError
+}
+
+// Expr returns the typed form of e, or nil if e isn't an expression of the
+// struct.
+func (s *StructTypes) Expr(e *expr.Expr) *TypedExpr {
+	return s.Exprs[e]
+}
+
+// InferStruct infers the type of every expression of a struct: the params of
+// user types, sizes, conditions, positions, repeat and switch expressions and
+// value instances. val is the struct's value symbol, linked to its parent the
+// way the emitters set up scopes, and context is the module context.
+//
+// Switch case keys are strings rather than expressions on the model, so they
+// are not included.
+func InferStruct(context *Context, val *ExprValue) *StructTypes {
+	ks := val.Struct.Type
+	inf := newInferrer()
+	result := &StructTypes{
+		Struct: ks,
+		Exprs:  make(map[*expr.Expr]*TypedExpr),
+	}
+	ctx := context.WithLocalRoot(val)
+	add := func(ctx *Context, e *expr.Expr) {
+		if e == nil || result.Exprs[e] != nil {
+			return
+		}
+		result.Exprs[e] = inf.expr(ctx, e)
+	}
+	addRef := func(ref *types.TypeRef) {
+		switch ref.Kind {
+		case types.Bytes:
+			add(ctx, ref.Bytes.Size)
+		case types.String:
+			add(ctx, ref.String.Size)
+		case types.User:
+			add(ctx, ref.User.Size)
+			for _, arg := range ref.User.Params {
+				add(ctx, arg)
+			}
+		}
+	}
+	for _, attr := range slices.Concat(ks.Seq, ks.Instances) {
+		add(ctx, attr.If)
+		add(ctx, attr.Pos)
+		add(ctx, attr.Size)
+		add(ctx, attr.IO)
+		add(ctx, attr.Value)
+		add(ctx, attr.Process)
+		if ref := attr.Type.TypeRef; ref != nil {
+			addRef(ref)
+		}
+		if sw := attr.Type.TypeSwitch; sw != nil {
+			add(ctx, sw.SwitchOn)
+			for _, ref := range sw.Cases {
+				addRef(&ref)
+			}
+		}
+		switch r := attr.Repeat.(type) {
+		case types.RepeatExpr:
+			add(ctx, r.CountExpr)
+		case types.RepeatUntil:
+			elem := &ExprValue{Kind: AttrKind, Parent: val, Attr: &kaitai.Attr{ID: attr.ID, Type: attr.Type, Enum: attr.Enum}}
+			add(ctx.WithTemporary(elem), r.UntilExpr)
+		}
+	}
+	result.Errors = inf.errors
+	return result
+}
+
+// InferExpr infers the type of every node of e in context.
+func InferExpr(context *Context, e *expr.Expr) (*TypedExpr, []TypeError) {
+	inf := newInferrer()
+	return inf.expr(context, e), inf.errors
+}
+
+// inferrer holds the state of a type inference pass.
+type inferrer struct {
+	errors []TypeError
+
+	// instances guards against value instances that refer to themselves.
+	instances map[*kaitai.Attr]bool
+
+	// quiet suppresses errors while inferring a value instance referenced
+	// from another expression; they are reported for the instance itself.
+	quiet int
+
+	cur *expr.Expr
+}
+
+func newInferrer() *inferrer {
+	return &inferrer{instances: make(map[*kaitai.Attr]bool)}
+}
+
+func (inf *inferrer) expr(ctx *Context, e *expr.Expr) *TypedExpr {
+	prev := inf.cur
+	inf.cur = e
+	defer func() { inf.cur = prev }()
+	return newTypedExpr(e, inf.node(ctx, e.Root))
+}
+
+func (inf *inferrer) errorf(node expr.Node, format string, args ...any) {
+	if inf.quiet > 0 || inf.cur == nil {
+		return
+	}
+	inf.errors = append(inf.errors, TypeError{
+		Expr:    inf.cur,
+		Node:    node,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// text returns the source text of node, for error messages.
+func (inf *inferrer) text(node expr.Node) string {
+	if inf.cur != nil {
+		if text := inf.cur.SpanText(expr.SpanOf(node)); text != "" {
+			return text
+		}
+	}
+	return node.String()
+}
+
+func (inf *inferrer) node(ctx *Context, node expr.Node) *TypedNode {
+	n := &TypedNode{Node: node}
+	child := func(c expr.Node) *TypedNode {
+		t := inf.node(ctx, c)
+		n.Children = append(n.Children, t)
+		return t
+	}
+
+	switch node := node.(type) {
+	case expr.ScopeNode:
+		n.Type = inf.scope(ctx, node)
+
+	case expr.MemberNode:
+		op := child(node.Operand)
+		switch node.Property {
+		case "first", "last", "min", "max":
+			// Keep the enum or struct of the elements, which the
+			// method's return type doesn't carry.
+			if op.Type.IsArray() {
+				n.Type = op.Type.Elem()
+				return n
+			}
+		}
+		n.Type = inf.value(ctx, ResultTypeOfNode(ctx, node))
+
+	case expr.CallNode:
+		obj := child(node.Object)
+		args := make([]*TypedNode, len(node.Args))
+		for i, arg := range node.Args {
+			args[i] = child(arg)
+		}
+		if callee := ResultTypeOfNode(ctx, node.Object); callee != nil && callee.Kind == MethodKind && callee.Method.Host != nil {
+			inf.hostArgs(node, callee.Method.Host, args)
+		}
+		// Methods are typed by their return type, with or without the call.
+		if obj.Type.IsKnown() {
+			n.Type = obj.Type
+		} else {
+			n.Type = inf.value(ctx, ResultTypeOfNode(ctx, node))
+		}
+
+	case expr.CastNode:
+		child(node.Operand)
+		n.Type = inf.value(ctx, ResultTypeOfNode(ctx, node))
+
+	case expr.FStringNode:
+		for _, part := range node.Parts {
+			if part.Expr != nil {
+				child(part.Expr)
+			}
+		}
+		n.Type = InferredType{ValueType: StringValueType}
+
+	case expr.SubscriptNode:
+		a, b := child(node.A), child(node.B)
+		switch {
+		case a.Type.IsArray():
+			n.Type = a.Type.Elem()
+		case isKind(a.Type, types.Bytes):
+			n.Type = InferredType{ValueType: ValueType{Type: types.Type{TypeRef: &types.TypeRef{Kind: types.U1}}}}
+		case a.Type.IsKnown():
+			inf.errorf(node, "cannot index %s of type %s", inf.text(node.A), a.Type)
+		}
+		if c := classOf(b.Type); c != classUnknown && c != classInt {
+			inf.errorf(node.B, "index %s is %s, expected an integer", inf.text(node.B), b.Type)
+		}
+
+	case expr.UnaryNode:
+		op := child(node.Operand)
+		c := classOf(op.Type)
+		switch node.Op {
+		case expr.OpLogicalNot:
+			if c != classUnknown && c != classBool {
+				inf.errorf(node, "invalid operation: %s (operator not defined on %s)", inf.text(node), op.Type)
+			}
+			n.Type = InferredType{ValueType: BooleanValueType}
+		case expr.OpInvert:
+			if c != classUnknown && c != classInt {
+				inf.errorf(node, "invalid operation: %s (operator not defined on %s)", inf.text(node), op.Type)
+			}
+			n.Type = op.Type
+		default:
+			if c != classUnknown && c != classInt && c != classFloat {
+				inf.errorf(node, "invalid operation: %s (operator not defined on %s)", inf.text(node), op.Type)
+			}
+			n.Type = op.Type
+		}
+
+	case expr.BinaryNode:
+		a, b := child(node.A), child(node.B)
+		n.Type = inf.binary(node, a.Type, b.Type)
+
+	case expr.TernaryNode:
+		cond, a, b := child(node.A), child(node.B), child(node.C)
+		if c := classOf(cond.Type); c != classUnknown && c != classBool {
+			inf.errorf(node.A, "condition %s is %s, expected a boolean", inf.text(node.A), cond.Type)
+		}
+		t, ok := unify(a.Type, b.Type)
+		if !ok {
+			inf.errorf(node, "mismatched types %s and %s in %s", a.Type, b.Type, inf.text(node))
+		}
+		n.Type = t
+
+	case expr.ArrayNode:
+		allInts := true
+		var elem InferredType
+		for i, item := range node.Items {
+			t := child(item).Type
+			if classOf(t) != classInt {
+				allInts = false
+			}
+			if i == 0 {
+				elem = t
+				continue
+			}
+			u, ok := unify(elem, t)
+			if !ok {
+				inf.errorf(item, "mismatched types %s and %s in array literal", elem, t)
+			}
+			elem = u
+		}
+		if allInts {
+			// Integer array literals are byte arrays.
+			n.Type = InferredType{ValueType: ByteArrayValueType}
+			return n
+		}
+		elem.Repeat = types.RepeatEOS{}
+		n.Type = elem
+
+	default:
+		n.Type = inf.value(ctx, ResultTypeOfNode(ctx, node))
+	}
+	return n
+}
+
+// hostArgs checks the arguments of a call to a host function against its
+// declared signature.
+func (inf *inferrer) hostArgs(node expr.CallNode, fn *HostFunc, args []*TypedNode) {
+	if len(args) != len(fn.Arguments) {
+		inf.errorf(node, "wrong number of arguments in call to %s: have %d, want %d", fn.Name, len(args), len(fn.Arguments))
+		return
+	}
+	for i, arg := range args {
+		want := InferredType{ValueType: fn.Arguments[i]}
+		if !arg.Type.AssignableTo(want) {
+			inf.errorf(arg.Node, "cannot use %s (%s) as %s in argument to %s", inf.text(arg.Node), arg.Type, want, fn.Name)
+		}
+	}
+}
+
+// scope resolves a scoped name like enum_name::value lexically, the way type
+// references are resolved.
+func (inf *inferrer) scope(ctx *Context, node expr.ScopeNode) InferredType {
+	name := qualifiedName(node.Operand)
+	if name == "" {
+		return InferredType{}
+	}
+	owner := ctx.ResolveQualifiedTypeInScope(name, ctx.local)
+	if owner == nil {
+		return InferredType{}
+	}
+	typ := owner.TypeChild(node.Type)
+	if typ == nil {
+		return InferredType{}
+	}
+	if typ.Constant != nil {
+		typ = typ.Constant
+	}
+	return inf.value(ctx, typ)
+}
+
+// qualifiedName converts a chain of identifiers and scopes back into a
+// qualified name like a::b.
+func qualifiedName(node expr.Node) string {
+	switch node := node.(type) {
+	case expr.IdentNode:
+		return node.Identifier
+	case expr.ScopeNode:
+		if op := qualifiedName(node.Operand); op != "" {
+			return op + "::" + node.Type
+		}
+	}
+	return ""
+}
+
+// binary infers the type of a binary operation on operands of types a and b.
+func (inf *inferrer) binary(node expr.BinaryNode, a, b InferredType) InferredType {
+	ca, cb := classOf(a), classOf(b)
+	known := ca != classUnknown && cb != classUnknown
+	mismatch := func() {
+		inf.errorf(node, "invalid operation: %s (mismatched types %s and %s)", inf.text(node), a, b)
+	}
+	undefined := func(t InferredType) {
+		inf.errorf(node, "invalid operation: %s (operator not defined on %s)", inf.text(node), t)
+	}
+
+	switch node.Op {
+	case expr.OpEqual, expr.OpNotEqual,
+		expr.OpLessThan, expr.OpLessThanEqual,
+		expr.OpGreaterThan, expr.OpGreaterThanEqual:
+		if !Comparable(a, b) {
+			mismatch()
+		}
+		return InferredType{ValueType: BooleanValueType}
+
+	case expr.OpLogicalAnd, expr.OpLogicalOr:
+		for _, t := range []InferredType{a, b} {
+			if c := classOf(t); c != classUnknown && c != classBool {
+				undefined(t)
+				break
+			}
+		}
+		return InferredType{ValueType: BooleanValueType}
+
+	case expr.OpShiftLeft, expr.OpShiftRight:
+		for _, t := range []InferredType{a, b} {
+			if c := classOf(t); c != classUnknown && c != classInt {
+				undefined(t)
+				break
+			}
+		}
+		return a
+
+	case expr.OpBitAnd, expr.OpBitOr, expr.OpBitXor:
+		if known && ca == classBool && cb == classBool {
+			return InferredType{ValueType: BooleanValueType}
+		}
+		for _, t := range []InferredType{a, b} {
+			if c := classOf(t); c != classUnknown && c != classInt {
+				undefined(t)
+				return InferredType{}
+			}
+		}
+
+	case expr.OpAdd:
+		if known && ca == cb && (ca == classString || ca == classBytes) {
+			return a
+		}
+		fallthrough
+
+	default:
+		for _, t := range []InferredType{a, b} {
+			if c := classOf(t); c != classUnknown && c != classInt && c != classFloat {
+				if known && ca != cb {
+					mismatch()
+				} else {
+					undefined(t)
+				}
+				return InferredType{}
+			}
+		}
+	}
+
+	if !known {
+		return InferredType{}
+	}
+	return promote(a, b)
+}
+
+// promote returns the type of arithmetic on numeric types a and b.
+func promote(a, b InferredType) InferredType {
+	ka, kb := numericKind(a), numericKind(b)
+	if ka == kb {
+		return a
+	}
+	if ka > kb {
+		ka, kb = kb, ka
+	}
+	return InferredType{ValueType: ValueType{Type: types.Type{TypeRef: &types.TypeRef{Kind: ka.Promote(kb)}}}}
+}
+
+// numericKind returns the kind of a numeric type for promotion. Bit-sized
+// integers are read into 64-bit unsigned integers.
+func numericKind(t InferredType) types.Kind {
+	k := t.Type.TypeRef.Kind
+	if k == types.Bits {
+		return types.U8
+	}
+	return k
+}
+
+// unify returns the type of a value that is either of type a or b, such as the
+// result of a ternary expression. It returns false if the types disagree.
+func unify(a, b InferredType) (InferredType, bool) {
+	ca, cb := classOf(a), classOf(b)
+	switch {
+	case ca == classUnknown:
+		return b, true
+	case cb == classUnknown:
+		return a, true
+	case (ca == classInt || ca == classFloat) && (cb == classInt || cb == classFloat):
+		if a.IsArray() || b.IsArray() {
+			return a, a.IsArray() == b.IsArray()
+		}
+		return promote(a, b), true
+	case ca == classEnum && cb == classInt:
+		return b, true
+	case ca == classInt && cb == classEnum:
+		return a, true
+	case ca != cb:
+		return a, false
+	case a.Enum != b.Enum, a.Struct != b.Struct:
+		// Different enums or user types: the common type is unknown.
+		return InferredType{}, true
+	}
+	return a, true
+}
+
+func isKind(t InferredType, kind types.Kind) bool {
+	return !t.IsArray() && t.Type.TypeRef != nil && t.Type.TypeRef.Kind == kind
+}
+
+// valueClass is a coarse classification of inferred types, used to decide
+// whether an operator applies.
+type valueClass int
+
+const (
+	classUnknown valueClass = iota
+	classInt
+	classFloat
+	classBool
+	classString
+	classBytes
+	classEnum
+	classStruct
+	classArray
+	classStream
+)
+
+func classOf(t InferredType) valueClass {
+	switch {
+	case t.IsArray():
+		return classArray
+	case t.Enum != nil:
+		return classEnum
+	case t.Type.TypeRef == nil:
+		return classUnknown
+	}
+	ref := t.Type.TypeRef
+	switch ref.Kind {
+	case types.U1, types.U2, types.U2le, types.U2be, types.U4,
+		types.U4le, types.U4be, types.U8, types.U8le, types.U8be,
+		types.S1, types.S2, types.S2le, types.S2be, types.S4,
+		types.S4le, types.S4be, types.S8, types.S8le, types.S8be,
+		types.UntypedInt:
+		return classInt
+	case types.Bits:
+		if ref.Bits != nil && ref.Bits.Width == 1 {
+			return classBool
+		}
+		return classInt
+	case types.F4, types.F4le, types.F4be,
+		types.F8, types.F8le, types.F8be,
+		types.UntypedFloat:
+		return classFloat
+	case types.UntypedBool:
+		return classBool
+	case types.String:
+		return classString
+	case types.Bytes:
+		return classBytes
+	case types.User:
+		switch ref.User.Name {
+		case "bool":
+			return classBool
+		case "io":
+			return classStream
+		case "struct":
+			return classStruct
+		case "any":
+			return classUnknown
+		}
+		if t.Struct != nil {
+			return classStruct
+		}
+	}
+	return classUnknown
+}
+
+// canCompare returns true if values of class a and b can be compared. Enums
+// compare against their integer values.
+func canCompare(a, b valueClass) bool {
+	switch {
+	case a == b:
+		return true
+	case (a == classInt || a == classFloat || a == classEnum) && (b == classInt || b == classFloat || b == classEnum):
+		return true
+	}
+	return false
+}
+
+// value infers the type of a value as returned by ResultTypeOfNode.
+func (inf *inferrer) value(ctx *Context, v *ExprValue) InferredType {
+	if v == nil {
+		return InferredType{}
+	}
+	switch v.Kind {
+	case IntegerKind:
+		// Enum constants (enum_name::value) are integers whose parent is
+		// the enum value.
+		if v.Parent != nil && v.Parent.Kind == EnumValueKind {
+			if enum := v.Parent.NearestEnum(); enum != nil {
+				return InferredType{ValueType: IntegerValueType, Enum: enum.Enum}
+			}
+		}
+		return InferredType{ValueType: IntegerValueType}
+	case FloatKind:
+		return InferredType{ValueType: FloatValueType}
+	case BooleanKind:
+		return InferredType{ValueType: BooleanValueType}
+	case StringKind:
+		return InferredType{ValueType: StringValueType}
+	case ByteArrayKind:
+		return InferredType{ValueType: ByteArrayValueType}
+	case EnumValueKind:
+		t := InferredType{ValueType: IntegerValueType}
+		if enum := v.NearestEnum(); enum != nil {
+			t.Enum = enum.Enum
+		}
+		return t
+	case ArrayKind:
+		var t InferredType
+		switch {
+		case v.Array != nil && v.Array.Elem != nil:
+			t = inf.value(ctx, v.Array.Elem)
+		case v.Elem != nil:
+			t = InferredType{ValueType: ValueType{Type: types.Type{TypeRef: v.Elem}}}
+		}
+		t.Repeat = types.RepeatEOS{}
+		return t
+	case StructKind, StructParentKind, StructRootKind:
+		if v.Struct == nil {
+			return InferredType{}
+		}
+		return userType(v.Struct.Type)
+	case StreamKind:
+		return InferredType{ValueType: ValueType{Type: types.Type{TypeRef: &types.TypeRef{Kind: types.User, User: &types.UserType{Name: "io"}}}}}
+	case ParamKind:
+		ref := v.Param.Type
+		t := InferredType{ValueType: ValueType{Type: types.Type{TypeRef: &ref}}}
+		if ref.IsArray {
+			ref.IsArray = false
+			t.Repeat = types.RepeatEOS{}
+		}
+		return inf.resolve(ctx, t, v.Param.Enum, v.Parent)
+	case AttrKind:
+		t := InferredType{ValueType: ValueType{Type: v.Attr.Type, Repeat: v.Attr.Repeat}}
+		return inf.resolve(ctx, t, v.Attr.Enum, v.Parent)
+	case InstanceKind:
+		inst := v.Instance
+		if inst.Value != nil {
+			if ref := inst.Type.TypeRef; ref == nil || ref.Kind == types.Bytes {
+				return inf.instance(ctx, v)
+			}
+		}
+		t := InferredType{ValueType: ValueType{Type: inst.Type, Repeat: inst.Repeat}}
+		return inf.resolve(ctx, t, inst.Enum, v.Parent)
+	case CastedValueKind:
+		return inf.resolve(ctx, InferredType{ValueType: v.Cast.ValueType}, "", v.Parent)
+	case AliasKind:
+		return inf.value(ctx, v.Alias.Ref).Elem()
+	case MethodKind:
+		if v.Method == nil {
+			return InferredType{}
+		}
+		return inf.resolve(ctx, InferredType{ValueType: v.Method.ReturnType}, "", nil)
+	}
+	return InferredType{}
+}
+
+// instance infers the type of a value instance from its value expression,
+// evaluated in the scope of the struct that declares it.
+func (inf *inferrer) instance(ctx *Context, v *ExprValue) InferredType {
+	if inf.instances[v.Instance] {
+		return InferredType{}
+	}
+	inf.instances[v.Instance] = true
+	inf.quiet++
+	defer func() {
+		delete(inf.instances, v.Instance)
+		inf.quiet--
+	}()
+	if v.Parent != nil {
+		ctx = ctx.WithLocalRoot(v.Parent)
+	}
+	prev := inf.cur
+	inf.cur = v.Instance.Value
+	defer func() { inf.cur = prev }()
+	return inf.node(ctx, v.Instance.Value.Root).Type
+}
+
+// resolve normalizes array type references and looks up the enum and user
+// type of t in scope.
+func (inf *inferrer) resolve(ctx *Context, t InferredType, enum string, scope *ExprValue) InferredType {
+	ref := t.Type.TypeRef
+	if ref == nil && t.Type.TypeSwitch != nil {
+		ref = uniformSwitchTypeRef(t.Type.TypeSwitch)
+	}
+	if ref != nil && ref.IsArray {
+		elem := *ref
+		elem.IsArray = false
+		t.Type = types.Type{TypeRef: &elem}
+		t.Repeat = types.RepeatEOS{}
+		ref = &elem
+	}
+	if scope == nil {
+		scope = ctx.local
+	}
+	if enum != "" {
+		if sym := ctx.ResolveQualifiedTypeInScope(enum, scope); sym != nil && sym.Kind == EnumKind {
+			t.Enum = sym.Enum
+		}
+	}
+	if ref != nil && ref.Kind == types.User {
+		if sym := ctx.ResolveQualifiedTypeInScope(ref.User.Name, scope); sym != nil && sym.Kind == StructKind && sym.Struct != nil {
+			t.Struct = sym.Struct.Type
+		}
+	}
+	return t
+}
+
+func userType(ks *kaitai.Struct) InferredType {
+	ref := &types.TypeRef{Kind: types.User, User: &types.UserType{Name: string(ks.ID)}}
+	return InferredType{ValueType: ValueType{Type: types.Type{TypeRef: ref}}, Struct: ks}
+}
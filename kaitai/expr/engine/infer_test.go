@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// structContext parses src and returns a context for its root struct, along
+// with the struct's value symbol.
+func structContext(t *testing.T, src string) (*Context, *ExprValue) {
+	t.Helper()
+	ks, err := kaitai.ParseStructFile(strings.NewReader(src), "main.ksy")
+	require.NoError(t, err)
+	typ := NewStructSymbol(ks, nil)
+	ctx := NewContext()
+	ctx.AddGlobalType(string(ks.ID), typ)
+	ctx.AddModuleType(string(ks.ID), typ)
+	val := NewStructValueSymbol(typ, nil)
+	return ctx.WithModuleRoot(val).WithLocalRoot(val), val
+}
+
+func inferSource(t *testing.T, src string) *StructTypes {
+	t.Helper()
+	ctx, val := structContext(t, src)
+	return InferStruct(ctx, val)
+}
+
+func instanceTypes(ks *kaitai.Struct, st *StructTypes) map[string]string {
+	result := map[string]string{}
+	for _, inst := range ks.Instances {
+		result[string(inst.ID)] = st.Expr(inst.Value).Type().String()
+	}
+	return result
+}
+
+func TestInferStruct(t *testing.T) {
+	st := inferSource(t, `
+meta:
+  id: main
+  endian: le
+seq:
+  - id: a
+    type: u1
+  - id: b
+    type: u4
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: items
+    type: u2
+    repeat: expr
+    repeat-expr: a
+  - id: hdrs
+    type: hdr
+    repeat: expr
+    repeat-expr: 2
+  - id: name
+    type: str
+    size: a + b
+    encoding: ASCII
+instances:
+  sum:
+    value: a + b
+  wide:
+    value: items[0] + b
+  lit:
+    value: a + 1.5
+  elem:
+    value: items[1]
+  count:
+    value: items.size
+  first_hdr:
+    value: hdrs.first
+  hdr_x:
+    value: hdrs[0].x
+  is_one:
+    value: kind == kinds::one
+  kind_val:
+    value: kind
+  chained:
+    value: sum * 2
+  label:
+    value: name + "!"
+  pick:
+    value: 'a > 1 ? a : b'
+types:
+  hdr:
+    seq:
+      - id: x
+        type: u1
+enums:
+  kinds:
+    1: one
+`)
+	assert.Empty(t, st.Errors)
+	assert.Equal(t, map[string]string{
+		"sum":       "u4",
+		"wide":      "u4",
+		"lit":       "float",
+		"elem":      "u2",
+		"count":     "int",
+		"first_hdr": "hdr",
+		"hdr_x":     "u1",
+		"is_one":    "bool",
+		"kind_val":  "enum kinds",
+		"chained":   "int",
+		"label":     "str",
+		"pick":      "u4",
+	}, instanceTypes(st.Struct, st))
+
+	first := st.Expr(st.Struct.Instances[5].Value).Type()
+	assert.Same(t, st.Struct.Structs[0], first.Struct)
+
+	size := st.Expr(st.Struct.Seq[5].Size)
+	require.NotNil(t, size)
+	a := size.Expr.Root.(expr.BinaryNode).A
+	typ, ok := size.TypeOf(a)
+	require.True(t, ok)
+	assert.Equal(t, "u1", typ.String())
+
+	// Nodes are told apart by kind as well as position.
+	_, ok = size.TypeOf(expr.IntNode{Span: expr.SpanOf(a)})
+	assert.False(t, ok)
+	_, ok = size.TypeOf(expr.IntNode{})
+	assert.False(t, ok)
+}
+
+func TestInferStructErrors(t *testing.T) {
+	st := inferSource(t, `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+  - id: s
+    type: str
+    size: 2
+    encoding: ASCII
+    if: a
+instances:
+  bad_sub:
+    value: s - 1
+  bad_cond:
+    value: 's ? 1 : 2'
+  bad_branches:
+    value: 'a > 1 ? a : s'
+  bad_index:
+    value: a[0]
+  bad_not:
+    value: not a
+  ok:
+    value: s + "x"
+`)
+	var msgs []string
+	for _, err := range st.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"main.ksy:14:12: invalid operation: s - 1 (mismatched types str and int)",
+		"main.ksy:16:13: condition s is str, expected a boolean",
+		"main.ksy:18:13: mismatched types u1 and str in a > 1 ? a : s",
+		"main.ksy:20:12: cannot index a of type u1",
+		"main.ksy:22:12: invalid operation: not a (operator not defined on u1)",
+	}, msgs)
+}
+
+func TestInferredTypeAssignable(t *testing.T) {
+	st := inferSource(t, `
+meta:
+  id: main
+seq:
+  - id: a
+    type: u1
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: s
+    type: str
+    size: 2
+    encoding: ASCII
+instances:
+  int_val:
+    value: a
+  enum_val:
+    value: kind
+  str_val:
+    value: s
+  float_val:
+    value: 1.5
+  unknown_val:
+    value: nope
+enums:
+  kinds:
+    1: one
+`)
+	typ := map[string]InferredType{}
+	for _, inst := range st.Struct.Instances {
+		typ[string(inst.ID)] = st.Expr(inst.Value).Type()
+	}
+	intType := InferredType{ValueType: IntegerValueType}
+
+	assert.True(t, typ["int_val"].AssignableTo(intType))
+	assert.True(t, typ["enum_val"].AssignableTo(intType))
+	assert.True(t, typ["int_val"].AssignableTo(typ["float_val"]))
+	assert.True(t, typ["unknown_val"].AssignableTo(intType))
+	assert.False(t, typ["str_val"].AssignableTo(intType))
+	assert.False(t, typ["float_val"].AssignableTo(intType))
+
+	assert.True(t, Comparable(typ["enum_val"], intType))
+	assert.True(t, Comparable(typ["float_val"], intType))
+	assert.True(t, Comparable(typ["unknown_val"], typ["str_val"]))
+	assert.False(t, Comparable(typ["str_val"], intType))
+}
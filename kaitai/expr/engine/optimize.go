@@ -0,0 +1,336 @@
+package engine
+
+import (
+	"math/big"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/types"
+)
+
+// Optimize returns a simplified form of e, or e itself if nothing could be
+// simplified. The result evaluates to the same value as e:
+//
+//   - Constant subexpressions (including enum constants) are folded with the
+//     same big.Int semantics and context.Compat truncation rules as Evaluate.
+//   - Boolean identities such as `x and true` or `not not x`, and repeated
+//     operands such as `x or x` or `c ? x : x`, are simplified.
+//   - References to pure value instances whose value is constant are inlined,
+//     unless KeepInstances is given.
+//   - Integer identities such as `x + 0` and `x * 1` are dropped when x is an
+//     integer.
+//
+// Folded nodes keep the span of the subexpression they replace, so positions
+// reported against the optimized expression still point into its text.
+//
+// Floating-point arithmetic is left alone, as the printed result would be
+// reparsed as an integer when it happens to be integral, and integer division
+// and modulus are only folded for non-negative operands, where the engine's
+// semantics agree with every target language.
+func Optimize(context *Context, e *expr.Expr, opts ...OptimizeOption) *expr.Expr {
+	if e == nil {
+		return nil
+	}
+	o := &optimizer{ctx: context, instances: make(map[*kaitai.Attr]bool)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	root, _ := o.node(e.Root)
+	if !o.changed {
+		return e
+	}
+	result := *e
+	result.Root = root
+	return &result
+}
+
+// OptimizeOption configures Optimize.
+type OptimizeOption func(*optimizer)
+
+// KeepInstances stops Optimize from inlining value instances, for callers
+// that let the value of an instance be overridden at run time.
+func KeepInstances() OptimizeOption {
+	return func(o *optimizer) { o.keepInstances = true }
+}
+
+// optimizer holds the state of an optimization pass.
+type optimizer struct {
+	ctx     *Context
+	changed bool
+
+	keepInstances bool
+
+	// instances guards against value instances that refer to themselves.
+	instances map[*kaitai.Attr]bool
+}
+
+// node optimizes node, returning the simplified node and its constant value,
+// if it has one.
+func (o *optimizer) node(node expr.Node) (expr.Node, *ExprValue) {
+	switch n := node.(type) {
+	case expr.IntNode:
+		return n, NewIntegerLiteralValue(n.Integer)
+	case expr.FloatNode:
+		return n, NewFloatLiteralValue(n.Float)
+	case expr.BoolNode:
+		return n, NewBooleanLiteralValue(n.Bool)
+	case expr.StringNode:
+		return n, NewStringLiteralValue(n.Str)
+
+	case expr.ScopeNode:
+		// Enum constants fold when compared or combined with other
+		// constants, but are otherwise kept for readability.
+		return n, o.enumConstant(n)
+
+	case expr.IdentNode:
+		if o.keepInstances {
+			return n, nil
+		}
+		if val := o.instanceConstant(n.Identifier); val != nil {
+			if lit := o.literal(val, n.Span); lit != nil {
+				return lit, val
+			}
+		}
+		return n, nil
+
+	case expr.UnaryNode:
+		operand, val := o.node(n.Operand)
+		if val != nil {
+			var result *ExprValue
+			var err error
+			switch n.Op {
+			case expr.OpLogicalNot:
+				result, err = evalLogicalNot(val)
+			case expr.OpNegate:
+				result, err = evalNegate(val)
+			case expr.OpInvert:
+				result, err = evalInvert(val)
+			}
+			if err == nil {
+				if lit := o.literal(result, n.Span); lit != nil {
+					return lit, result
+				}
+			}
+		}
+		if inner, ok := operand.(expr.UnaryNode); ok && n.Op == expr.OpLogicalNot && inner.Op == expr.OpLogicalNot {
+			o.changed = true
+			return inner.Operand, nil
+		}
+		n.Operand = operand
+		return n, nil
+
+	case expr.BinaryNode:
+		return o.binary(n)
+
+	case expr.TernaryNode:
+		cond, val := o.node(n.A)
+		b, bv := o.node(n.B)
+		c, cv := o.node(n.C)
+		if val != nil && val.Kind == BooleanKind {
+			o.changed = true
+			if val.Boolean.Value {
+				return b, bv
+			}
+			return c, cv
+		}
+		if b.String() == c.String() {
+			o.changed = true
+			return b, bv
+		}
+		n.A, n.B, n.C = cond, b, c
+		return n, nil
+
+	case expr.SubscriptNode:
+		n.B, _ = o.node(n.B)
+		return n, nil
+
+	case expr.CallNode:
+		args := make([]expr.Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i], _ = o.node(arg)
+		}
+		n.Args = args
+		return n, nil
+
+	case expr.ArrayNode:
+		items := make([]expr.Node, len(n.Items))
+		for i, item := range n.Items {
+			items[i], _ = o.node(item)
+		}
+		n.Items = items
+		return n, nil
+
+	case expr.FStringNode:
+		parts := make([]expr.FStringPart, len(n.Parts))
+		for i, part := range n.Parts {
+			if part.Expr != nil {
+				part.Expr, _ = o.node(part.Expr)
+			}
+			parts[i] = part
+		}
+		n.Parts = parts
+		return n, nil
+	}
+	// Member access, casts and sizeof are kept as written: their operands
+	// name things rather than compute values.
+	return node, nil
+}
+
+func (o *optimizer) binary(n expr.BinaryNode) (expr.Node, *ExprValue) {
+	a, av := o.node(n.A)
+	b, bv := o.node(n.B)
+	n.A, n.B = a, b
+
+	if av != nil && bv != nil && o.foldable(n.Op, av, bv) {
+		if result, err := applyBinary(n.Op, av, bv); err == nil {
+			result = truncateResult(o.ctx.Compat, result)
+			if lit := o.literal(result, n.Span); lit != nil {
+				return lit, result
+			}
+		}
+	}
+
+	switch n.Op {
+	case expr.OpLogicalAnd, expr.OpLogicalOr:
+		// x and true = x, x and false = false, x or false = x and
+		// x or true = true. Expressions have no side effects, so the
+		// other operand can always be dropped.
+		absorbing := n.Op == expr.OpLogicalOr
+		if isBool(av) {
+			o.changed = true
+			if av.Boolean.Value == absorbing {
+				return a, av
+			}
+			return b, bv
+		}
+		if isBool(bv) {
+			o.changed = true
+			if bv.Boolean.Value == absorbing {
+				return b, bv
+			}
+			return a, av
+		}
+		if a.String() == b.String() {
+			o.changed = true
+			return a, nil
+		}
+
+	case expr.OpAdd, expr.OpSub, expr.OpMult, expr.OpDiv:
+		if o.ctx.Compat.HasCalcIntTypeTruncationBug() {
+			// The operation truncates its operand to 32 bits.
+			break
+		}
+		identity := int64(0)
+		if n.Op == expr.OpMult || n.Op == expr.OpDiv {
+			identity = 1
+		}
+		if isInt(bv, identity) && o.integer(a) {
+			o.changed = true
+			return a, nil
+		}
+		if (n.Op == expr.OpAdd || n.Op == expr.OpMult) && isInt(av, identity) && o.integer(b) {
+			o.changed = true
+			return b, nil
+		}
+	}
+	return n, nil
+}
+
+// foldable reports whether op can be folded for constant operands a and b.
+func (o *optimizer) foldable(op expr.BinaryOp, a, b *ExprValue) bool {
+	switch op {
+	case expr.OpDiv, expr.OpMod:
+		// Targets differ on the rounding of negative quotients.
+		return a.Kind == IntegerKind && b.Kind == IntegerKind &&
+			a.Integer.Value.Sign() >= 0 && b.Integer.Value.Sign() > 0
+	case expr.OpShiftLeft, expr.OpShiftRight:
+		return b.Kind == IntegerKind && b.Integer.Value.IsInt64() &&
+			b.Integer.Value.Int64() >= 0 && b.Integer.Value.Int64() < 64
+	}
+	return true
+}
+
+// literal converts a constant value into a literal node with the given span.
+// It returns nil for values that can't be written back as a literal.
+func (o *optimizer) literal(val *ExprValue, span expr.Span) expr.Node {
+	if val == nil {
+		return nil
+	}
+	var lit expr.Node
+	switch val.Kind {
+	case IntegerKind:
+		if !val.Integer.Value.IsInt64() {
+			return nil
+		}
+		lit = expr.IntNode{Integer: val.Integer.Value, Span: span}
+	case BooleanKind:
+		lit = expr.BoolNode{Bool: val.Boolean.Value, Span: span}
+	case StringKind:
+		lit = expr.StringNode{Str: val.String.Value, Span: span}
+	default:
+		return nil
+	}
+	o.changed = true
+	return lit
+}
+
+// enumConstant returns the integer value of an enum constant like
+// enum_name::value.
+func (o *optimizer) enumConstant(n expr.ScopeNode) *ExprValue {
+	name := qualifiedName(n.Operand)
+	if name == "" {
+		return nil
+	}
+	owner := o.ctx.ResolveQualifiedTypeInScope(name, o.ctx.local)
+	if owner == nil || owner.Kind != EnumKind {
+		return nil
+	}
+	typ := owner.TypeChild(n.Type)
+	if typ == nil || typ.Constant == nil || typ.Constant.Integer == nil {
+		return nil
+	}
+	return NewIntegerLiteralValue(typ.Constant.Integer.Value)
+}
+
+// instanceConstant returns the constant value of a pure value instance: one
+// with only a value: key whose value folds to a constant.
+func (o *optimizer) instanceConstant(name string) *ExprValue {
+	sym, _ := o.ctx.Resolve(name)
+	if sym == nil || sym.Kind != InstanceKind {
+		return nil
+	}
+	inst := sym.Instance
+	if inst.Value == nil || inst.If != nil || inst.Enum != "" || inst.Repeat != nil {
+		return nil
+	}
+	if ref := inst.Type.TypeRef; (ref != nil && ref.Kind != types.Bytes) || inst.Type.TypeSwitch != nil {
+		return nil
+	}
+	if o.instances[inst] {
+		return nil
+	}
+	o.instances[inst] = true
+	defer delete(o.instances, inst)
+
+	ctx := o.ctx
+	if sym.Parent != nil {
+		ctx = ctx.WithLocalRoot(sym.Parent)
+	}
+	sub := &optimizer{ctx: ctx, instances: o.instances}
+	_, val := sub.node(inst.Value.Root)
+	return val
+}
+
+// integer reports whether node has an integer type, typed or not, so an
+// identity operation on it leaves its value alone.
+func (o *optimizer) integer(node expr.Node) bool {
+	return classOf(newInferrer().node(o.ctx, node).Type) == classInt
+}
+
+func isBool(v *ExprValue) bool {
+	return v != nil && v.Kind == BooleanKind
+}
+
+func isInt(v *ExprValue, want int64) bool {
+	return v != nil && v.Kind == IntegerKind && v.Integer.Value.Cmp(big.NewInt(want)) == 0
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const optimizeSpec = `
+meta:
+  id: main
+seq:
+  - id: len
+    type: u1
+  - id: kind
+    type: u1
+    enum: kinds
+  - id: flag
+    type: b1
+  - id: hdr
+    type: header
+  - id: ratio
+    type: f4le
+instances:
+  header_size:
+    value: 4 * 2
+  twice:
+    value: header_size * 2
+  cond:
+    value: len > 1
+    if: len != 0
+types:
+  header:
+    seq:
+      - id: len
+        type: u4le
+enums:
+  kinds:
+    1: one
+    2: two
+`
+
+func TestOptimize(t *testing.T) {
+	ctx, _ := structContext(t, optimizeSpec)
+	tests := []struct {
+		src, want string
+	}{
+		{"4 * 8 + len - 0", "(32) + (len)"},
+		{"len - 0", "len"},
+		{"hdr.len - 0", "hdr.len"},
+		{"1 * hdr.len", "hdr.len"},
+		{"ratio - 0", "(ratio) - (0)"},
+		{"kind + 0", "(kind) + (0)"},
+		{"1 + 2 * 3", "7"},
+		{"-(2 + 3)", "-5"},
+		{"~7", "-8"},
+		{"(1 + 2) << 3", "24"},
+		{"1 << 70", "(1) << (70)"},
+		{"7 / 2", "3"},
+		{"-7 / 2", "(-7) / (2)"},
+		{"1 / 0", "(1) / (0)"},
+		{"1.5 * 2", "(1.5) * (2)"},
+		{"1.5 < 2", "true"},
+		{`"a" + "b"`, `"ab"`},
+		{"kinds::one == kinds::two", "false"},
+		{"kinds::two == 2", "true"},
+		{"kind == kinds::one", "(kind) == (kinds::one)"},
+		{"flag and true", "flag"},
+		{"true and flag", "flag"},
+		{"flag and false", "false"},
+		{"flag or true", "true"},
+		{"false or flag", "flag"},
+		{"flag or flag", "flag"},
+		{"not not flag", "flag"},
+		{"not true", "false"},
+		{"1 < 2 ? len : 0", "len"},
+		{"flag ? len : len", "len"},
+		{"header_size + len", "(8) + (len)"},
+		{"twice", "16"},
+		{"cond", "cond"},
+		{"[1 + 1, len]", "[2, len]"},
+		{"[len, 2][0 + 1]", "[len, 2][1]"},
+		{"len.to_s", "len.to_s"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			e := expr.MustParseExpr(test.src)
+			opt := Optimize(ctx, e)
+			assert.Equal(t, test.want, opt.Root.String())
+			if test.want == e.Root.String() {
+				assert.Same(t, e, opt)
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepInstances(t *testing.T) {
+	ctx, _ := structContext(t, optimizeSpec)
+	assert.Equal(t, "twice", Optimize(ctx, expr.MustParseExpr("twice"), KeepInstances()).Root.String())
+	assert.Equal(t, "(header_size) + (len)", Optimize(ctx, expr.MustParseExpr("header_size + len"), KeepInstances()).Root.String())
+	assert.Equal(t, "len", Optimize(ctx, expr.MustParseExpr("len * (2 - 1)"), KeepInstances()).Root.String())
+}
+
+func TestOptimizeCompat(t *testing.T) {
+	ctx, _ := structContext(t, optimizeSpec)
+	ctx.Compat = kaitai.KaitaiStruct_0_11
+	assert.Equal(t, "-2147483648", Optimize(ctx, expr.MustParseExpr("0x7fffffff + 1")).Root.String())
+	assert.Equal(t, "(32) + (len)", Optimize(ctx, expr.MustParseExpr("4 * 8 + len")).Root.String())
+	assert.Equal(t, "((32) + (len)) - (0)", Optimize(ctx, expr.MustParseExpr("4 * 8 + len - 0")).Root.String())
+}
+
+func TestOptimizeSpans(t *testing.T) {
+	ctx, _ := structContext(t, optimizeSpec)
+	e, err := expr.ParseExpr("len + (2 * 3)")
+	require.NoError(t, err)
+	opt := Optimize(ctx, e)
+	folded := opt.Root.(expr.BinaryNode).B
+	assert.Equal(t, "2 * 3", e.Text[expr.SpanOf(folded).Start:expr.SpanOf(folded).End])
+	assert.Equal(t, e.Text, opt.Text)
+}
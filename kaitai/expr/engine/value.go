@@ -140,7 +140,10 @@ func ResultTypeOfNode(context *Context, node expr.Node) *ExprValue {
 		if val == nil {
 			return nil
 		}
-		return val.Child(node.Property)
+		if child := val.Child(node.Property); child != nil {
+			return child
+		}
+		return context.ResolveMethod(val, node.Property)
 
 	case expr.UnaryNode:
 		if node.Op == expr.OpInvert {
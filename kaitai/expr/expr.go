@@ -8,6 +8,8 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/jchv/zanbato/kaitai/srcpos"
 )
 
 //go:generate go run golang.org/x/tools/cmd/stringer -type=UnaryOp,BinaryOp -output expr_string.go
@@ -50,23 +52,176 @@ const (
 // Expr contains a parsed expression.
 type Expr struct {
 	Root Node
+
+	// Text is the source the expression was parsed from. Node spans are
+	// offsets into it, less Offset.
+	Text string
+
+	// Offset is the byte offset of Text in the string it was split out of,
+	// such as the type string holding the parameters of a user type. Node
+	// spans are relative to that string. It is zero for expressions parsed
+	// on their own.
+	Offset int
+
+	// Source is where the expression was written in the .ksy file, if known.
+	// If it has a column, it is the column Text starts at.
+	Source srcpos.Pos
+}
+
+// Pos returns the position of n, a node of e, in the .ksy file. If e's
+// position is unknown, so is the result.
+func (e *Expr) Pos(n Node) srcpos.Pos {
+	return e.OffsetPos(SpanOf(n).Start)
+}
+
+// OffsetPos returns the position of the byte offset off of e's text in the
+// .ksy file. Like node spans, off counts from the start of the string e was
+// split out of, if any.
+func (e *Expr) OffsetPos(off int) srcpos.Pos {
+	return offsetPos(e.Source, e.Text, off-e.Offset)
+}
+
+// SpanText returns the text of span, a span of one of e's nodes.
+func (e *Expr) SpanText(span Span) string {
+	start, end := span.Start-e.Offset, span.End-e.Offset
+	if start < 0 || end <= start || end > len(e.Text) {
+		return ""
+	}
+	return e.Text[start:end]
+}
+
+// offsetPos returns the position of the byte offset off into text, which
+// starts at pos. Past a line break the column isn't known, since the
+// indentation of the continuation line isn't recorded.
+func offsetPos(pos srcpos.Pos, text string, off int) srcpos.Pos {
+	if !pos.IsValid() || pos.Column == 0 || off < 0 || off > len(text) {
+		return pos
+	}
+	before := text[:off]
+	if n := strings.Count(before, "\n"); n > 0 {
+		pos.Line += n
+		pos.Column = 0
+		return pos
+	}
+	pos.Column += utf8.RuneCountInString(before)
+	return pos
 }
 
 // Node is a node in the AST.
 type Node interface {
 	fmt.Stringer
 	isnode()
+	span() Span
+}
+
+// Span is the extent of a node in the text it was parsed from, as byte
+// offsets [Start, End). Nodes built by hand have a zero span.
+type Span struct {
+	Start, End int
+}
+
+func (s Span) span() Span { return s }
+
+// SpanOf returns the span of n, or a zero span if n is nil.
+func SpanOf(n Node) Span {
+	if n == nil {
+		return Span{}
+	}
+	return n.span()
+}
+
+// SyntaxError is an error parsing an expression.
+type SyntaxError struct {
+	// Text is the expression being parsed.
+	Text string
+
+	// Offset is the byte offset into Text where parsing failed.
+	Offset int
+
+	// Expected describes what the parser expected at Offset, such as "')'"
+	// or "primary expression", if known.
+	Expected string
+
+	// Err describes the problem in more detail, if there is more to say
+	// than what was expected, such as for an invalid escape sequence.
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	msg := ""
+	switch {
+	case e.Err != nil:
+		msg = e.Err.Error()
+	case e.Expected != "":
+		msg = "expected " + e.Expected + ", found " + e.found()
+	default:
+		msg = "syntax error"
+	}
+	return fmt.Sprintf("error parsing expression at character %d: %s", utf8.RuneCountInString(e.Text[:e.Offset])+1, msg)
+}
+
+func (e *SyntaxError) Unwrap() error { return e.Err }
+
+// found describes the text at the error offset.
+func (e *SyntaxError) found() string {
+	if e.Offset >= len(e.Text) {
+		return "end of expression"
+	}
+	r, _ := utf8.DecodeRuneInString(e.Text[e.Offset:])
+	return strconv.QuoteRune(r)
+}
+
+// Caret renders the line of the expression where parsing failed, with a
+// caret under the offending character:
+//
+//	len + * 2
+//	      ^
+func (e *SyntaxError) Caret() string {
+	start := strings.LastIndexByte(e.Text[:e.Offset], '\n') + 1
+	end := len(e.Text)
+	if i := strings.IndexByte(e.Text[e.Offset:], '\n'); i >= 0 {
+		end = e.Offset + i
+	}
+	b := strings.Builder{}
+	b.WriteString(e.Text[start:end])
+	b.WriteByte('\n')
+	for _, r := range e.Text[start:e.Offset] {
+		// Keep tabs so the caret lines up however they are rendered.
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	return b.String()
+}
+
+// ErrorPos returns the position of a syntax error in an expression whose
+// text starts at pos. Other errors are attributed to pos itself.
+func ErrorPos(pos srcpos.Pos, err error) srcpos.Pos {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return offsetPos(pos, syntaxErr.Text, syntaxErr.Offset)
+	}
+	return pos
 }
 
 // IdentNode is an identifier.
-type IdentNode struct{ Identifier string }
+type IdentNode struct {
+	Identifier string
+	Span
+}
 
 func (IdentNode) isnode() {}
 
 func (i IdentNode) String() string { return i.Identifier }
 
 // StringNode is a string literal.
-type StringNode struct{ Str string }
+type StringNode struct {
+	Str string
+	Span
+}
 
 func (StringNode) isnode() {}
 
@@ -76,6 +231,7 @@ func (s StringNode) String() string { return fmt.Sprintf("%q", s.Str) }
 // Parts alternate between literal string segments and embedded expressions.
 type FStringNode struct {
 	Parts []FStringPart
+	Span
 }
 
 // FStringPart is either a literal string segment or an expression in an f-string.
@@ -103,21 +259,30 @@ func (f FStringNode) String() string {
 }
 
 // IntNode is an integer literal.
-type IntNode struct{ Integer *big.Int }
+type IntNode struct {
+	Integer *big.Int
+	Span
+}
 
 func (IntNode) isnode() {}
 
 func (i IntNode) String() string { return i.Integer.String() }
 
 // FloatNode is a floating point literal.
-type FloatNode struct{ Float *big.Float }
+type FloatNode struct {
+	Float *big.Float
+	Span
+}
 
 func (FloatNode) isnode() {}
 
 func (f FloatNode) String() string { return f.Float.String() }
 
 // BoolNode is a boolean literal.
-type BoolNode struct{ Bool bool }
+type BoolNode struct {
+	Bool bool
+	Span
+}
 
 func (BoolNode) isnode() {}
 
@@ -130,7 +295,10 @@ func (b BoolNode) String() string {
 }
 
 // ArrayNode is an array literal.
-type ArrayNode struct{ Items []Node }
+type ArrayNode struct {
+	Items []Node
+	Span
+}
 
 func (ArrayNode) isnode() {}
 
@@ -151,6 +319,7 @@ func (a ArrayNode) String() string {
 type CallNode struct {
 	Object Node   // The object being called on (MemberNode or IdentNode)
 	Args   []Node // Arguments
+	Span
 }
 
 func (CallNode) isnode() {}
@@ -173,6 +342,7 @@ func (c CallNode) String() string {
 type CastNode struct {
 	Operand  Node   // The expression being cast
 	TypeName string // The target type name
+	Span
 }
 
 func (CastNode) isnode() {}
@@ -184,6 +354,7 @@ func (c CastNode) String() string {
 // SizeofNode is a sizeof expression (e.g., sizeof<type>)
 type SizeofNode struct {
 	TypeName string
+	Span
 }
 
 func (SizeofNode) isnode() {}
@@ -196,6 +367,7 @@ func (s SizeofNode) String() string {
 // returns the size of the named type in bits.
 type BitSizeofNode struct {
 	TypeName string
+	Span
 }
 
 func (BitSizeofNode) isnode() {}
@@ -208,6 +380,7 @@ func (s BitSizeofNode) String() string {
 type UnaryNode struct {
 	Operand Node
 	Op      UnaryOp
+	Span
 }
 
 func (UnaryNode) isnode() {}
@@ -229,6 +402,7 @@ func (u UnaryNode) String() string {
 type BinaryNode struct {
 	A, B Node
 	Op   BinaryOp
+	Span
 }
 
 func (BinaryNode) isnode() {}
@@ -279,6 +453,7 @@ func (b BinaryNode) String() string {
 // TernaryNode is a ternary operation
 type TernaryNode struct {
 	A, B, C Node
+	Span
 }
 
 func (TernaryNode) isnode() {}
@@ -291,6 +466,7 @@ func (t TernaryNode) String() string {
 type ScopeNode struct {
 	Operand Node
 	Type    string
+	Span
 }
 
 func (ScopeNode) isnode() {}
@@ -303,6 +479,7 @@ func (s ScopeNode) String() string {
 type MemberNode struct {
 	Operand  Node
 	Property string
+	Span
 }
 
 func (MemberNode) isnode() {}
@@ -311,9 +488,15 @@ func (m MemberNode) String() string {
 	return m.Operand.String() + "." + m.Property
 }
 
+// PropertySpan returns the span of the property name.
+func (m MemberNode) PropertySpan() Span {
+	return Span{Start: m.End - len(m.Property), End: m.End}
+}
+
 // SubscriptNode is a subscript expression (a[b])
 type SubscriptNode struct {
 	A, B Node
+	Span
 }
 
 func (SubscriptNode) isnode() {}
@@ -323,26 +506,48 @@ func (m SubscriptNode) String() string {
 }
 
 // ParseExpr parses an expression into an AST.
+// Errors are returned as a *SyntaxError.
 func ParseExpr(src string) (result *Expr, err error) {
-	p := parser{[]rune(src), 0}
+	return ParseSubExpr(src, 0, len(src))
+}
+
+// ParseSubExpr parses the expression src[start:end], such as one of the
+// parameters in a user type string. Node spans and syntax error offsets are
+// relative to src, so they can be traced back to the whole string.
+func ParseSubExpr(src string, start, end int) (result *Expr, err error) {
+	if start == end {
+		return nil, nil
+	}
+	p := newParser(src, start, end)
 	defer func() {
 		if r := recover(); r != nil {
-			if rErr, ok := r.(error); ok {
-				err = fmt.Errorf("error parsing expression at character %d: %w", p.pos+1, rErr)
-			} else {
-				err = fmt.Errorf("error parsing expression at character %d: %v", p.pos+1, r)
-			}
-			result = nil
-		} else {
-			if len(p.s) > 0 {
-				err = fmt.Errorf("unparsed expression text: %q", string(p.s))
-			}
+			result, err = nil, p.syntaxError(r)
 		}
 	}()
-	if src == "" {
-		return nil, nil
+	root := p.expr(0)
+	if len(p.s) > 0 {
+		return nil, &SyntaxError{
+			Text:     src,
+			Offset:   p.offset(),
+			Expected: "end of expression",
+			Err:      fmt.Errorf("unparsed expression text: %q", string(p.s)),
+		}
 	}
-	return &Expr{Root: p.expr(0)}, nil
+	return &Expr{Root: root, Text: src[start:end], Offset: start}, nil
+}
+
+// ParseExprAt parses an expression that was read from the given position in
+// a .ksy file. The position is recorded on the result, and parse errors are
+// attributed to the position where parsing failed.
+func ParseExprAt(src string, pos srcpos.Pos) (*Expr, error) {
+	result, err := ParseExpr(src)
+	if err != nil {
+		return nil, srcpos.Wrap(ErrorPos(pos, err), err)
+	}
+	if result != nil {
+		result.Source = pos
+	}
+	return result, nil
 }
 
 // MustParseExpr parses an expression, and panics if an error occurs.
@@ -371,6 +576,61 @@ func iswhitespace(c rune) bool { return c == ' ' || c == '\n' }
 type parser struct {
 	s   []rune
 	pos int
+
+	src string
+	// offsets maps rune indices to byte offsets in src, with an extra entry
+	// for the end of the text being parsed.
+	offsets []int
+}
+
+// newParser returns a parser for src[start:end].
+func newParser(src string, start, end int) *parser {
+	p := &parser{s: []rune(src[start:end]), src: src}
+	for i := range src[start:end] {
+		p.offsets = append(p.offsets, start+i)
+	}
+	p.offsets = append(p.offsets, end)
+	return p
+}
+
+// offset returns the byte offset of the next rune.
+func (p *parser) offset() int {
+	return p.offsets[p.pos]
+}
+
+// span returns the span from start to the end of the last token, not
+// counting whitespace skipped after it.
+func (p *parser) span(start int) Span {
+	end := p.offset()
+	for end > start && iswhitespace(rune(p.src[end-1])) {
+		end--
+	}
+	return Span{Start: start, End: end}
+}
+
+// fail aborts parsing with a syntax error at the next rune.
+func (p *parser) fail(expected string) {
+	panic(&SyntaxError{Text: p.src, Offset: p.offset(), Expected: expected})
+}
+
+// expect consumes r, or fails if the next rune is something else.
+func (p *parser) expect(r rune) {
+	if p.peek() != r {
+		p.fail(strconv.QuoteRune(r))
+	}
+	p.advance(1)
+}
+
+// syntaxError converts a value recovered from a parse panic into an error.
+func (p *parser) syntaxError(r any) *SyntaxError {
+	if err, ok := r.(*SyntaxError); ok {
+		return err
+	}
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	return &SyntaxError{Text: p.src, Offset: p.offset(), Err: err}
 }
 
 func (p *parser) peek() rune {
@@ -516,6 +776,7 @@ func (p *parser) strescape(quote rune) []byte {
 
 // # Parsing
 func (p *parser) number() Node {
+	start := p.offset()
 	token := p.token(isnumber)
 
 	// Handle base prefixes: 0x (hex), 0o (octal), 0b (binary)
@@ -529,6 +790,7 @@ func (p *parser) number() Node {
 			if !ok {
 				panic(errors.New("invalid hex integer"))
 			}
+			i.Span = p.span(start)
 			return i
 		case 'o':
 			token += string(p.next())
@@ -538,6 +800,7 @@ func (p *parser) number() Node {
 			if !ok {
 				panic(errors.New("invalid octal integer"))
 			}
+			i.Span = p.span(start)
 			return i
 		case 'b':
 			token += string(p.next())
@@ -547,6 +810,7 @@ func (p *parser) number() Node {
 			if !ok {
 				panic(errors.New("invalid binary integer"))
 			}
+			i.Span = p.span(start)
 			return i
 		}
 	}
@@ -582,6 +846,7 @@ func (p *parser) number() Node {
 		if err != nil {
 			panic(err)
 		}
+		f.Span = p.span(start)
 		return f
 	}
 
@@ -590,10 +855,11 @@ func (p *parser) number() Node {
 	if !ok {
 		panic(errors.New("invalid integer"))
 	}
+	i.Span = p.span(start)
 	return i
 }
 
-func (p *parser) fstrlit() Node {
+func (p *parser) fstrlit(start int) Node {
 	// Called after 'f' has been consumed; the next char is the opening quote.
 	quote := p.s[0]
 	p.advance(1)
@@ -612,7 +878,7 @@ func (p *parser) fstrlit() Node {
 			if len(lit) > 0 {
 				parts = append(parts, FStringPart{Literal: string(lit)})
 			}
-			return FStringNode{Parts: parts}
+			return FStringNode{Parts: parts, Span: p.span(start)}
 		case '\\':
 			lit = append(lit, p.strescape(quote)...)
 		case '{':
@@ -623,9 +889,7 @@ func (p *parser) fstrlit() Node {
 			}
 			// Parse the expression until '}'
 			exprNode := p.expr(0)
-			if p.next() != '}' {
-				panic(fmt.Errorf("expected '}' in f-string"))
-			}
+			p.expect('}')
 			parts = append(parts, FStringPart{Expr: exprNode})
 		default:
 			lit = append(lit, string(c)...)
@@ -633,7 +897,8 @@ func (p *parser) fstrlit() Node {
 	}
 }
 
-func (p *parser) strlit() Node {
+func (p *parser) strlit() StringNode {
+	start := p.offset()
 	quote := p.s[0]
 	p.advance(1)
 	str := []byte{}
@@ -646,7 +911,7 @@ func (p *parser) strlit() Node {
 		p.advance(1)
 		switch c {
 		case quote:
-			return StringNode{string(str)}
+			return StringNode{Str: string(str), Span: p.span(start)}
 		case '\\':
 			if quote == '\'' {
 				str = append(str, string(c)...)
@@ -660,11 +925,13 @@ func (p *parser) strlit() Node {
 }
 
 func (p *parser) arraylit() Node {
+	start := p.offset()
 	p.advance(1)
 	p.skipwhitespace()
 	an := ArrayNode{}
 	if p.peek() == ']' {
 		p.advance(1)
+		an.Span = p.span(start)
 		return an
 	}
 	an.Items = []Node{p.expr(0)}
@@ -677,18 +944,52 @@ func (p *parser) arraylit() Node {
 			if p.peek() == ']' {
 				// Allow a trailing comma before the closing bracket.
 				p.advance(1)
+				an.Span = p.span(start)
 				return an
 			}
 			an.Items = append(an.Items, p.expr(0))
 		case ']':
 			p.advance(1)
+			an.Span = p.span(start)
 			return an
 		default:
-			panic(fmt.Errorf("expected ',' or ']'"))
+			p.fail("',' or ']'")
 		}
 	}
 }
 
+// typeName reads the type name of a .as<>, sizeof<> or bitsizeof<>
+// expression, after the opening '<'.
+func (p *parser) typeName() string {
+	typeName := ""
+	for p.peek() != '>' && p.peek() != 0 {
+		typeName += string(p.next())
+	}
+	p.expect('>')
+	return typeName
+}
+
+// ident reads an identifier, failing if there isn't one.
+func (p *parser) ident() string {
+	tok := p.token(isident)
+	if tok == "" {
+		p.fail("identifier")
+	}
+	return tok
+}
+
+// binary parses the right hand side of a binary operator, whose left hand
+// side a started at start.
+func (p *parser) binary(op BinaryOp, a Node, depth int, start int) Node {
+	b := p.expr(depth)
+	return BinaryNode{Op: op, A: a, B: b, Span: p.span(start)}
+}
+
+// compare is like binary, but for comparisons.
+func (p *parser) compare(op BinaryOp, a Node, start int) Node {
+	return p.binary(op, a, depthBitOrExpr, start)
+}
+
 const (
 	depthTernaryExpr = iota
 	depthOrExpr
@@ -717,6 +1018,7 @@ func (p *parser) expr(depth int) Node {
 	// potentially higher depth values.
 	var n Node
 	p.skipwhitespace()
+	start := p.offset()
 	c := p.peek()
 	switch {
 	case isidentstart(c):
@@ -725,45 +1027,39 @@ func (p *parser) expr(depth int) Node {
 		case "f":
 			// f-string: f"..." or f'...'
 			if p.peek() == '"' || p.peek() == '\'' {
-				n = p.fstrlit()
+				n = p.fstrlit(start)
 				break
 			}
-			n = IdentNode{Identifier: tok}
+			n = IdentNode{Identifier: tok, Span: p.span(start)}
 		case "not":
-			n = UnaryNode{Op: OpLogicalNot, Operand: p.expr(depthCompareExpr)}
+			operand := p.expr(depthCompareExpr)
+			n = UnaryNode{Op: OpLogicalNot, Operand: operand, Span: p.span(start)}
 		case "true":
-			n = BoolNode{Bool: true}
+			n = BoolNode{Bool: true, Span: p.span(start)}
 		case "false":
-			n = BoolNode{Bool: false}
+			n = BoolNode{Bool: false, Span: p.span(start)}
 		case "sizeof", "bitsizeof":
 			// sizeof<type> / bitsizeof<type> syntax
 			if p.peek() == '<' {
 				p.advance(1)
-				typeName := ""
-				for p.peek() != '>' && p.peek() != 0 {
-					typeName += string(p.next())
-				}
-				if p.next() != '>' {
-					panic(fmt.Errorf("expected '>'"))
-				}
+				typeName := p.typeName()
 				if tok == "bitsizeof" {
-					n = BitSizeofNode{TypeName: typeName}
+					n = BitSizeofNode{TypeName: typeName, Span: p.span(start)}
 				} else {
-					n = SizeofNode{TypeName: typeName}
+					n = SizeofNode{TypeName: typeName, Span: p.span(start)}
 				}
 			} else {
-				n = IdentNode{Identifier: tok}
+				n = IdentNode{Identifier: tok, Span: p.span(start)}
 			}
 		default:
-			n = IdentNode{Identifier: tok}
+			n = IdentNode{Identifier: tok, Span: p.span(start)}
 		}
 	case isnumber(c):
 		n = p.number()
 	case c == '.' && isdigit(p.peek2()):
 		n = p.number()
 	case c == '"' || c == '\'':
-		first := p.strlit().(StringNode)
-		combined := first.Str
+		combined := p.strlit().Str
 		for {
 			save := p.pos
 			saveS := p.s
@@ -774,31 +1070,28 @@ func (p *parser) expr(depth int) Node {
 				p.pos = save
 				break
 			}
-			next := p.strlit().(StringNode)
-			combined += next.Str
+			combined += p.strlit().Str
 		}
-		n = StringNode{Str: combined}
+		n = StringNode{Str: combined, Span: p.span(start)}
 	case c == '(':
 		p.next()
 		n = p.expr(0)
-		if p.next() != ')' {
-			panic(fmt.Errorf("expected ')'"))
-		}
+		p.expect(')')
 	case c == '[':
 		n = p.arraylit()
 	case c == '-':
 		p.advance(1)
 		operand := p.expr(depthMemberExpr)
-		n = UnaryNode{Op: OpNegate, Operand: operand}
+		n = UnaryNode{Op: OpNegate, Operand: operand, Span: p.span(start)}
 	case c == '+':
 		p.advance(1)
 		n = p.expr(depthMemberExpr)
 	case c == '~':
 		p.advance(1)
 		operand := p.expr(depthMemberExpr)
-		n = UnaryNode{Op: OpInvert, Operand: operand}
+		n = UnaryNode{Op: OpInvert, Operand: operand, Span: p.span(start)}
 	default:
-		panic(fmt.Errorf("expected primary expression"))
+		p.fail("primary expression")
 	}
 	if depth >= depthPrimaryExpr {
 		return n
@@ -813,26 +1106,19 @@ func (p *parser) expr(depth int) Node {
 				break
 			}
 			p.advance(2)
-			n = ScopeNode{Operand: n, Type: p.token(isident)}
+			n = ScopeNode{Operand: n, Type: p.ident(), Span: p.span(start)}
 			continue
 		case '.':
 			p.next()
-			prop := p.token(isident)
+			prop := p.ident()
 			// Handle .as<type> cast syntax
 			if prop == "as" && p.peek() == '<' {
 				p.advance(1) // skip '<'
-				// Read type name until '>'
-				typeName := ""
-				for p.peek() != '>' && p.peek() != 0 {
-					typeName += string(p.next())
-				}
-				if p.next() != '>' {
-					panic(fmt.Errorf("expected '>'"))
-				}
-				n = CastNode{Operand: n, TypeName: typeName}
+				typeName := p.typeName()
+				n = CastNode{Operand: n, TypeName: typeName, Span: p.span(start)}
 				continue
 			}
-			n = MemberNode{Operand: n, Property: prop}
+			n = MemberNode{Operand: n, Property: prop, Span: p.span(start)}
 			// Function call check is handled by the '(' case in the next iteration
 			continue
 		case '(':
@@ -853,17 +1139,14 @@ func (p *parser) expr(depth int) Node {
 				}
 			}
 			p.skipwhitespace()
-			if p.next() != ')' {
-				panic(fmt.Errorf("expected ')'"))
-			}
-			n = CallNode{Object: n, Args: args}
+			p.expect(')')
+			n = CallNode{Object: n, Args: args, Span: p.span(start)}
 			continue
 		case '[':
 			p.next()
-			n = SubscriptNode{A: n, B: p.expr(0)}
-			if p.next() != ']' {
-				panic(fmt.Errorf("expected ']'"))
-			}
+			index := p.expr(0)
+			p.expect(']')
+			n = SubscriptNode{A: n, B: index, Span: p.span(start)}
 			continue
 		case 0:
 			return n
@@ -874,15 +1157,15 @@ func (p *parser) expr(depth int) Node {
 		switch p.peek() {
 		case '*':
 			p.next()
-			n = BinaryNode{Op: OpMult, A: n, B: p.expr(depthMemberExpr)}
+			n = p.binary(OpMult, n, depthMemberExpr, start)
 			continue
 		case '/':
 			p.next()
-			n = BinaryNode{Op: OpDiv, A: n, B: p.expr(depthMemberExpr)}
+			n = p.binary(OpDiv, n, depthMemberExpr, start)
 			continue
 		case '%':
 			p.next()
-			n = BinaryNode{Op: OpMod, A: n, B: p.expr(depthMemberExpr)}
+			n = p.binary(OpMod, n, depthMemberExpr, start)
 			continue
 		}
 		if depth >= depthMultExpr {
@@ -891,11 +1174,11 @@ func (p *parser) expr(depth int) Node {
 		switch p.peek() {
 		case '+':
 			p.next()
-			n = BinaryNode{Op: OpAdd, A: n, B: p.expr(depthMultExpr)}
+			n = p.binary(OpAdd, n, depthMultExpr, start)
 			continue
 		case '-':
 			p.next()
-			n = BinaryNode{Op: OpSub, A: n, B: p.expr(depthMultExpr)}
+			n = p.binary(OpSub, n, depthMultExpr, start)
 			continue
 		}
 		if depth >= depthAddExpr {
@@ -908,7 +1191,7 @@ func (p *parser) expr(depth int) Node {
 			}
 			p.next()
 			p.next()
-			n = BinaryNode{Op: OpShiftLeft, A: n, B: p.expr(depthAddExpr)}
+			n = p.binary(OpShiftLeft, n, depthAddExpr, start)
 			continue
 		case '>':
 			if p.peek2() != '>' {
@@ -916,7 +1199,7 @@ func (p *parser) expr(depth int) Node {
 			}
 			p.next()
 			p.next()
-			n = BinaryNode{Op: OpShiftRight, A: n, B: p.expr(depthAddExpr)}
+			n = p.binary(OpShiftRight, n, depthAddExpr, start)
 			continue
 		}
 		if depth >= depthShiftExpr {
@@ -924,7 +1207,7 @@ func (p *parser) expr(depth int) Node {
 		}
 		if p.peek() == '&' {
 			p.next()
-			n = BinaryNode{Op: OpBitAnd, A: n, B: p.expr(depthShiftExpr)}
+			n = p.binary(OpBitAnd, n, depthShiftExpr, start)
 			continue
 		}
 		if depth >= depthBitAndExpr {
@@ -932,7 +1215,7 @@ func (p *parser) expr(depth int) Node {
 		}
 		if p.peek() == '^' {
 			p.next()
-			n = BinaryNode{Op: OpBitXor, A: n, B: p.expr(depthBitAndExpr)}
+			n = p.binary(OpBitXor, n, depthBitAndExpr, start)
 			continue
 		}
 		if depth >= depthBitXorExpr {
@@ -940,7 +1223,7 @@ func (p *parser) expr(depth int) Node {
 		}
 		if p.peek() == '|' {
 			p.next()
-			n = BinaryNode{Op: OpBitOr, A: n, B: p.expr(depthBitXorExpr)}
+			n = p.binary(OpBitOr, n, depthBitXorExpr, start)
 			continue
 		}
 		if depth >= depthBitOrExpr {
@@ -951,59 +1234,51 @@ func (p *parser) expr(depth int) Node {
 		if !sawCompare {
 			switch p.peek() {
 			case '=':
-				if p.peek2() != '=' {
-					panic(fmt.Errorf("expected '=', got %q", p.peek2()))
-				}
-				p.advance(2)
-				n = BinaryNode{Op: OpEqual, A: n, B: p.expr(depthBitOrExpr)}
+				p.advance(1)
+				p.expect('=')
+				n = p.compare(OpEqual, n, start)
 				sawCompare = true
 				continue
 			case '!':
-				if p.peek2() != '=' {
-					panic(fmt.Errorf("expected '=', got %q", p.peek2()))
-				}
-				p.advance(2)
-				n = BinaryNode{Op: OpNotEqual, A: n, B: p.expr(depthBitOrExpr)}
+				p.advance(1)
+				p.expect('=')
+				n = p.compare(OpNotEqual, n, start)
 				sawCompare = true
 				continue
 			case '<':
 				p.next()
 				if p.peek() == '=' {
 					p.next()
-					n = BinaryNode{Op: OpLessThanEqual, A: n, B: p.expr(depthBitOrExpr)}
-					sawCompare = true
-					continue
+					n = p.compare(OpLessThanEqual, n, start)
 				} else {
-					n = BinaryNode{Op: OpLessThan, A: n, B: p.expr(depthBitOrExpr)}
-					sawCompare = true
-					continue
+					n = p.compare(OpLessThan, n, start)
 				}
+				sawCompare = true
+				continue
 			case '>':
 				p.next()
 				if p.peek() == '=' {
 					p.next()
-					n = BinaryNode{Op: OpGreaterThanEqual, A: n, B: p.expr(depthBitOrExpr)}
-					sawCompare = true
-					continue
+					n = p.compare(OpGreaterThanEqual, n, start)
 				} else {
-					n = BinaryNode{Op: OpGreaterThan, A: n, B: p.expr(depthBitOrExpr)}
-					sawCompare = true
-					continue
+					n = p.compare(OpGreaterThan, n, start)
 				}
+				sawCompare = true
+				continue
 			}
 		}
 		if depth >= depthCompareExpr {
 			break
 		}
 		if p.consumeKeyword("and") {
-			n = BinaryNode{Op: OpLogicalAnd, A: n, B: p.expr(depthCompareExpr)}
+			n = p.binary(OpLogicalAnd, n, depthCompareExpr, start)
 			continue
 		}
 		if depth >= depthAndExpr {
 			break
 		}
 		if p.consumeKeyword("or") {
-			n = BinaryNode{Op: OpLogicalOr, A: n, B: p.expr(depthAndExpr)}
+			n = p.binary(OpLogicalOr, n, depthAndExpr, start)
 			continue
 		}
 		if depth >= depthOrExpr {
@@ -1014,11 +1289,9 @@ func (p *parser) expr(depth int) Node {
 			p.next()
 			a := n
 			b := p.expr(0)
-			if p.next() != ':' {
-				panic(fmt.Errorf("expected ':'"))
-			}
+			p.expect(':')
 			c := p.expr(0)
-			n = TernaryNode{a, b, c}
+			n = TernaryNode{A: a, B: b, C: c, Span: p.span(start)}
 		}
 		if depth >= depthTernaryExpr {
 			break
@@ -4,7 +4,9 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/jchv/zanbato/kaitai/srcpos"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func MustParseBigFloat(s string) *big.Float {
@@ -27,31 +29,33 @@ func TestParseExpr(t *testing.T) {
 		},
 		{
 			Source: "test",
-			Expr:   &Expr{Root: IdentNode{Identifier: "test"}},
+			Expr:   &Expr{Root: IdentNode{Identifier: "test", Span: Span{0, 4}}, Text: "test"},
 		},
 		{
 			Source: "1",
-			Expr:   &Expr{Root: IntNode{Integer: big.NewInt(1)}},
+			Expr:   &Expr{Root: IntNode{Integer: big.NewInt(1), Span: Span{0, 1}}, Text: "1"},
 		},
 		{
 			Source: "1.0",
-			Expr:   &Expr{Root: FloatNode{Float: MustParseBigFloat("1.0")}},
+			Expr:   &Expr{Root: FloatNode{Float: MustParseBigFloat("1.0"), Span: Span{0, 3}}, Text: "1.0"},
 		},
 		{
 			Source: `'ASCII\\x'`,
-			Expr:   &Expr{Root: StringNode{Str: `ASCII\\x`}},
+			Expr:   &Expr{Root: StringNode{Str: `ASCII\\x`, Span: Span{0, 10}}, Text: `'ASCII\\x'`},
 		},
 		{
 			Source: "1 == 1 ? 2 : 3",
 			Expr: &Expr{Root: TernaryNode{
 				A: BinaryNode{
-					Op: OpEqual,
-					A:  IntNode{Integer: big.NewInt(1)},
-					B:  IntNode{Integer: big.NewInt(1)},
+					Op:   OpEqual,
+					A:    IntNode{Integer: big.NewInt(1), Span: Span{0, 1}},
+					B:    IntNode{Integer: big.NewInt(1), Span: Span{5, 6}},
+					Span: Span{0, 6},
 				},
-				B: IntNode{Integer: big.NewInt(2)},
-				C: IntNode{Integer: big.NewInt(3)},
-			}},
+				B:    IntNode{Integer: big.NewInt(2), Span: Span{9, 10}},
+				C:    IntNode{Integer: big.NewInt(3), Span: Span{13, 14}},
+				Span: Span{0, 14},
+			}, Text: "1 == 1 ? 2 : 3"},
 		},
 	}
 
@@ -131,3 +135,131 @@ func TestParseExprError(t *testing.T) {
 		})
 	}
 }
+
+func TestParseExprSpans(t *testing.T) {
+	e, err := ParseExpr("foo.bar[i + 1] ? f'{ x }' : -baz ")
+	require.NoError(t, err)
+	text := func(n Node) string {
+		s := SpanOf(n)
+		return e.Text[s.Start:s.End]
+	}
+	ternary := e.Root.(TernaryNode)
+	assert.Equal(t, "foo.bar[i + 1] ? f'{ x }' : -baz", text(ternary))
+	subscript := ternary.A.(SubscriptNode)
+	assert.Equal(t, "foo.bar[i + 1]", text(subscript))
+	assert.Equal(t, "foo.bar", text(subscript.A))
+	assert.Equal(t, "foo", text(subscript.A.(MemberNode).Operand))
+	assert.Equal(t, "i + 1", text(subscript.B))
+	assert.Equal(t, "1", text(subscript.B.(BinaryNode).B))
+	fstr := ternary.B.(FStringNode)
+	assert.Equal(t, "f'{ x }'", text(fstr))
+	assert.Equal(t, "x", text(fstr.Parts[0].Expr))
+	assert.Equal(t, "-baz", text(ternary.C))
+	assert.Equal(t, "baz", text(ternary.C.(UnaryNode).Operand))
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Offset   int
+		Expected string
+		Message  string
+		Caret    string
+	}{
+		{
+			Source:   "len + * 2",
+			Offset:   6,
+			Expected: "primary expression",
+			Message:  "error parsing expression at character 7: expected primary expression, found '*'",
+			Caret:    "len + * 2\n      ^",
+		},
+		{
+			Source:   "(a + b",
+			Offset:   6,
+			Expected: "')'",
+			Message:  "error parsing expression at character 7: expected ')', found end of expression",
+			Caret:    "(a + b\n      ^",
+		},
+		{
+			Source:   "a.b.",
+			Offset:   4,
+			Expected: "identifier",
+		},
+		{
+			Source:   "a = b",
+			Offset:   3,
+			Expected: "'='",
+		},
+		{
+			Source:   "x.as<u4",
+			Offset:   7,
+			Expected: "'>'",
+		},
+		{
+			Source:   "[1, 2 3]",
+			Offset:   6,
+			Expected: "',' or ']'",
+		},
+		{
+			Source:   "1 anx 2",
+			Offset:   2,
+			Expected: "end of expression",
+			Message:  `error parsing expression at character 3: unparsed expression text: "anx 2"`,
+		},
+		{
+			// Offsets are in bytes; the message counts characters.
+			Source:   `"é" + `,
+			Offset:   7,
+			Expected: "primary expression",
+			Message:  "error parsing expression at character 7: expected primary expression, found end of expression",
+			Caret:    "\"é\" + \n      ^",
+		},
+		{
+			Source:   "a and\n  b +",
+			Offset:   11,
+			Expected: "primary expression",
+			Caret:    "  b +\n     ^",
+		},
+		{
+			Source:   "\"\t\" +",
+			Offset:   5,
+			Expected: "primary expression",
+			Caret:    "\"\t\" +\n \t   ^",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Source, func(t *testing.T) {
+			_, err := ParseExpr(test.Source)
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, test.Offset, syntaxErr.Offset)
+			assert.Equal(t, test.Expected, syntaxErr.Expected)
+			if test.Message != "" {
+				assert.EqualError(t, err, test.Message)
+			}
+			if test.Caret != "" {
+				assert.Equal(t, test.Caret, syntaxErr.Caret())
+			}
+		})
+	}
+}
+
+func TestExprPos(t *testing.T) {
+	at := srcpos.Pos{File: "a.ksy", Line: 4, Column: 11}
+	e, err := ParseExprAt("hdr.é.len + 1", at)
+	require.NoError(t, err)
+	member := e.Root.(BinaryNode).A.(MemberNode)
+	assert.Equal(t, at, e.Pos(member))
+	assert.Equal(t, srcpos.Pos{File: "a.ksy", Line: 4, Column: 23}, e.Pos(e.Root.(BinaryNode).B))
+
+	// Columns past a line break aren't known.
+	e, err = ParseExprAt("a and\n  b", at)
+	require.NoError(t, err)
+	assert.Equal(t, srcpos.Pos{File: "a.ksy", Line: 5}, e.Pos(e.Root.(BinaryNode).B))
+
+	_, err = ParseExprAt("a + (b", at)
+	pos, ok := srcpos.Of(err)
+	require.True(t, ok)
+	assert.Equal(t, srcpos.Pos{File: "a.ksy", Line: 4, Column: 17}, pos)
+}
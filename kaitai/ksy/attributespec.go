@@ -3,6 +3,8 @@ package ksy
 import (
 	"fmt"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 // ParentSpec represents the parent: key on an attribute.
@@ -27,6 +29,15 @@ func (p *ParentSpec) UnmarshalYAML(unmarshal func(any) error) error {
 	return fmt.Errorf("parent must be false or a string expression")
 }
 
+// MarshalYAML implements yaml.Marshaler
+func (p ParentSpec) MarshalYAML() (any, error) {
+	if p.Disabled {
+		return boolNode(false), nil
+	}
+	// Quote the expression if needed so it isn't read back as a boolean.
+	return strNode(p.Expr), nil
+}
+
 // AttributeSpec represents a KaitaiStruct attribute.
 // #/definitions/Attribute
 type AttributeSpec struct {
@@ -54,6 +65,72 @@ type AttributeSpec struct {
 	Value       string       `yaml:"value,omitempty"`
 	Valid       *ValidSpec   `yaml:"valid,omitempty"`
 	Parent      *ParentSpec  `yaml:"parent,omitempty"`
+
+	Extensions ExtensionsSpec `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (a *AttributeSpec) UnmarshalYAML(node *yaml.Node) error {
+	type plain AttributeSpec
+	if err := node.Decode((*plain)(a)); err != nil {
+		return err
+	}
+	extensions, err := decodeExtensions(node)
+	if err != nil {
+		return err
+	}
+	a.Extensions = extensions
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler. Keys are written in a fixed,
+// canonical order rather than in field order.
+func (a AttributeSpec) MarshalYAML() (any, error) {
+	m := newYAMLMap()
+	m.add("id", exprNode(string(a.ID)))
+	m.add("pos", exprNode(a.Pos))
+	m.add("io", exprNode(a.IO))
+	m.add("value", exprNode(a.Value))
+	if err := m.addMarshaler("contents", a.Contents); err != nil {
+		return nil, err
+	}
+	if err := m.addMarshaler("type", a.Type); err != nil {
+		return nil, err
+	}
+	m.add("size", exprNode(a.Size))
+	if a.SizeEos {
+		m.add("size-eos", boolNode(true))
+	}
+	m.add("terminator", intPtrNode(a.Terminator))
+	m.add("consume", boolPtrNode(a.Consume))
+	m.add("include", boolPtrNode(a.Include))
+	m.add("eos-error", boolPtrNode(a.EosError))
+	m.add("pad-right", intPtrNode(a.PadRight))
+	m.add("encoding", exprNode(a.Encoding))
+	m.add("enum", exprNode(a.Enum))
+	if a.Parent != nil {
+		if err := m.addMarshaler("parent", *a.Parent); err != nil {
+			return nil, err
+		}
+	}
+	m.add("process", exprNode(a.Process))
+	m.add("repeat", exprNode(string(a.Repeat)))
+	m.add("repeat-expr", exprNode(a.RepeatExpr))
+	m.add("repeat-until", exprNode(a.RepeatUntil))
+	m.add("if", exprNode(a.If))
+	if a.Valid != nil {
+		if err := m.addMarshaler("valid", *a.Valid); err != nil {
+			return nil, err
+		}
+	}
+	m.add("doc", strNode(a.Doc))
+	if err := m.addMarshaler("doc-ref", a.DocRef); err != nil {
+		return nil, err
+	}
+	if err := a.Extensions.addTo(m); err != nil {
+		return nil, err
+	}
+	return m.node, nil
 }
 
 // ValidSpec represents a validation constraint.
@@ -148,6 +225,35 @@ func (v *ValidSpec) UnmarshalYAML(unmarshal func(any) error) error {
 	return fmt.Errorf("cannot parse valid spec")
 }
 
+// MarshalYAML implements yaml.Marshaler. A plain equality check is written
+// in its short form, e.g. `valid: 0x10`.
+func (v ValidSpec) MarshalYAML() (any, error) {
+	if v.Eq != "" && v.Min == "" && v.Max == "" && len(v.AnyOf) == 0 && v.Expr == "" && !v.InEnum {
+		return exprNode(v.Eq), nil
+	}
+	m := newYAMLMap()
+	m.add("eq", exprNode(v.Eq))
+	m.add("min", exprNode(v.Min))
+	m.add("max", exprNode(v.Max))
+	if len(v.AnyOf) > 0 {
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v.AnyOf {
+			n.Content = append(n.Content, exprNode(item))
+		}
+		m.add("any-of", n)
+	}
+	if v.InEnum {
+		m.add("in-enum", boolNode(true))
+	}
+	m.add("expr", exprNode(v.Expr))
+	return m.node, nil
+}
+
 // AttributesSpec represents an attribute list.
 // #/definitions/Attributes
 type AttributesSpec []AttributeSpec
+
+// MarshalYAML implements yaml.Marshaler
+func (a AttributesSpec) MarshalYAML() (any, error) {
+	return seqNode(a)
+}
@@ -17,9 +17,12 @@ func (e *AttrTypeSpec) UnmarshalText(text []byte) error {
 }
 
 // MarshalYAML implements yaml.Marshaler
-func (e *AttrTypeSpec) MarshalYAML() (any, error) {
-	if e.Value != "" {
-		return e.Value, nil
+func (e AttrTypeSpec) MarshalYAML() (any, error) {
+	if e.SwitchOn == "" {
+		return exprNode(e.Value), nil
 	}
-	return e, nil
+	m := newYAMLMap()
+	m.add("switch-on", exprNode(e.SwitchOn))
+	m.add("cases", casesNode(e.Cases))
+	return m.node, nil
 }
@@ -12,9 +12,6 @@ func (e *BitEndianSpec) UnmarshalText(text []byte) error {
 }
 
 // MarshalYAML implements yaml.Marshaler
-func (e *BitEndianSpec) MarshalYAML() (any, error) {
-	if e.Value != "" {
-		return e.Value, nil
-	}
-	return e, nil
+func (e BitEndianSpec) MarshalYAML() (any, error) {
+	return exprNode(e.Value), nil
 }
@@ -99,12 +99,37 @@ func (b *ByteSpec) UnmarshalYAML(node *yaml.Node) error {
 	}
 }
 
-// MarshalYAML implements yaml.Marshaler
+// MarshalYAML implements yaml.Marshaler. Printable ASCII is written as
+// strings where that reads back unambiguously; everything else is written as
+// hex bytes.
 func (b ByteSpec) MarshalYAML() (any, error) {
+	if len(b) > 0 && printableRunLength(b) == len(b) {
+		return strNode(string(b)), nil
+	}
 	n := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
-	for _, i := range b {
+	for i := 0; i < len(b); {
+		if run := printableRunLength(b[i:]); run >= 2 {
+			// Strings inside an array that look like numbers are read back
+			// as single bytes, so those have to stay in hex.
+			if _, ok := strToByte(string(b[i : i+run])); !ok {
+				n.Content = append(n.Content, strNode(string(b[i:i+run])))
+				i += run
+				continue
+			}
+		}
 		n.Content = append(n.Content, &yaml.Node{
-			Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprintf("0x%02x", i)})
+			Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprintf("0x%02x", b[i])})
+		i++
 	}
 	return n, nil
 }
+
+// printableRunLength returns the length of the printable ASCII prefix of b.
+func printableRunLength(b []byte) int {
+	for i, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -7,9 +7,25 @@ import (
 // EnumValueSpec represents a single enum value spec.
 // #/definitions/EnumValueSpec
 type EnumValueSpec struct {
-	ID     Identifier
-	Doc    string
-	DocRef DocRefSpec
+	ID     Identifier `yaml:"id"`
+	Doc    string     `yaml:"doc,omitempty"`
+	DocRef DocRefSpec `yaml:"doc-ref,omitempty"`
+
+	Extensions ExtensionsSpec `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (e *EnumValueSpec) UnmarshalYAML(node *yaml.Node) error {
+	type plain EnumValueSpec
+	if err := node.Decode((*plain)(e)); err != nil {
+		return err
+	}
+	extensions, err := decodeExtensions(node)
+	if err != nil {
+		return err
+	}
+	e.Extensions = extensions
+	return nil
 }
 
 // EnumValuePairSpec represents a single enum value pair.
@@ -22,19 +38,38 @@ type EnumValuePairSpec struct {
 // EnumValuePairsSpec represents multiple EnumValueSpec encoded as a map in YAML.
 type EnumValuePairsSpec []EnumValuePairSpec
 
-func enumValuesToYAML(e EnumValuePairsSpec) *yaml.Node {
-	n := &yaml.Node{Kind: yaml.MappingNode}
+// MarshalYAML implements yaml.Marshaler. Values without documentation are
+// written in the short `value: id` form.
+func (e EnumValueSpec) MarshalYAML() (any, error) {
+	id := &yaml.Node{Kind: yaml.ScalarNode, Value: string(e.ID), Tag: "!!str"}
+	if e.Doc == "" && len(e.DocRef) == 0 && len(e.Extensions) == 0 {
+		return id, nil
+	}
+	m := newYAMLMap()
+	m.add("id", id)
+	m.add("doc", strNode(e.Doc))
+	if err := m.addMarshaler("doc-ref", e.DocRef); err != nil {
+		return nil, err
+	}
+	if err := e.Extensions.addTo(m); err != nil {
+		return nil, err
+	}
+	return m.node, nil
+}
+
+func enumValuesToYAML(e EnumValuePairsSpec) (*yaml.Node, error) {
+	m := newYAMLMap()
 	for _, i := range e {
-		n.Content = append(n.Content,
-			&yaml.Node{Kind: yaml.ScalarNode, Value: i.Value},
-			&yaml.Node{Kind: yaml.ScalarNode, Value: string(i.Spec.ID), Tag: "!!str"})
+		if err := m.addMarshaler(i.Value, i.Spec); err != nil {
+			return nil, err
+		}
 	}
-	return n
+	return m.node, nil
 }
 
 // MarshalYAML implements yaml.Marshaler
 func (e EnumValuePairsSpec) MarshalYAML() (any, error) {
-	return enumValuesToYAML(e), nil
+	return enumValuesToYAML(e)
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler
@@ -78,9 +113,13 @@ type EnumsSpec []EnumSpec
 func (e EnumsSpec) MarshalYAML() (any, error) {
 	n := &yaml.Node{Kind: yaml.MappingNode}
 	for _, i := range e {
+		values, err := enumValuesToYAML(i.Values)
+		if err != nil {
+			return nil, err
+		}
 		n.Content = append(n.Content,
 			&yaml.Node{Kind: yaml.ScalarNode, Value: string(i.ID)},
-			enumValuesToYAML(i.Values))
+			values)
 	}
 	return n, nil
 }
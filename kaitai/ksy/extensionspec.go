@@ -0,0 +1,56 @@
+package ksy
+
+import (
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExtensionsSpec holds the vendor extension keys of a type, attribute or
+// enum value: keys starting with `-`, such as `-webide-representation` or
+// `-orig-id`. Keys are stored as written, including the leading dash.
+type ExtensionsSpec map[string]any
+
+// IsExtensionKey returns true if key is a vendor extension key.
+func IsExtensionKey(key string) bool {
+	return strings.HasPrefix(key, "-")
+}
+
+// decodeExtensions collects the extension keys of a mapping node. It returns
+// nil if there are none.
+func decodeExtensions(node *yaml.Node) (ExtensionsSpec, error) {
+	var result ExtensionsSpec
+	err := yamlMapForEach(node, func(key *yaml.Node, value *yaml.Node) error {
+		if !IsExtensionKey(key.Value) {
+			return nil
+		}
+		var v any
+		if err := value.Decode(&v); err != nil {
+			return err
+		}
+		if result == nil {
+			result = ExtensionsSpec{}
+		}
+		result[key.Value] = v
+		return nil
+	})
+	return result, err
+}
+
+// addTo appends the extension keys to m, sorted by key.
+func (e ExtensionsSpec) addTo(m yamlMap) error {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		node := &yaml.Node{}
+		if err := node.Encode(e[k]); err != nil {
+			return err
+		}
+		m.add(k, node)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package ksy
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format rewrites a .ksy document into its canonical layout, as produced by
+// Marshal. Comments are carried over to the matching keys of the output
+// where possible.
+//
+// Format returns an error rather than silently dropping keys that the
+// TypeSpec model does not understand.
+func Format(src []byte) ([]byte, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		// Empty document.
+		return nil, nil
+	}
+	spec := TypeSpec{}
+	if err := doc.Decode(&spec); err != nil {
+		return nil, err
+	}
+	node, err := spec.MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	out := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node.(*yaml.Node)}}
+	if err := carryComments(out, &doc, nil); err != nil {
+		return nil, err
+	}
+	return encodeYAML(out)
+}
+
+// carryComments copies the comments of src onto dst, matching mapping keys
+// by name and sequence items by index. It fails if a key in src has no
+// counterpart in dst, since that means formatting would lose data. path holds
+// the keys leading to src.
+func carryComments(dst, src *yaml.Node, path []string) error {
+	copyComments(dst, src)
+	switch {
+	case dst.Kind == yaml.DocumentNode && src.Kind == yaml.DocumentNode:
+		for i := range min(len(dst.Content), len(src.Content)) {
+			if err := carryComments(dst.Content[i], src.Content[i], path); err != nil {
+				return err
+			}
+		}
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+			dstKey, dstValue := lookupKey(dst, key.Value)
+			if dstKey == nil {
+				if isRedundant(key, value, path) {
+					continue
+				}
+				if isNestedMetaID(key, path) {
+					return fmt.Errorf("%d:%d: meta/id %q does not match type key %q", value.Line, value.Column, value.Value, path[len(path)-2])
+				}
+				if key.Value == "meta" && value.Kind == yaml.MappingNode {
+					// Report the offending key inside meta rather than meta
+					// itself.
+					if err := carryComments(&yaml.Node{Kind: yaml.MappingNode}, value, append(path, key.Value)); err != nil {
+						return err
+					}
+				}
+				return fmt.Errorf("%d:%d: unsupported key %q", key.Line, key.Column, key.Value)
+			}
+			copyComments(dstKey, key)
+			if err := carryComments(dstValue, value, append(path, key.Value)); err != nil {
+				return err
+			}
+		}
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode:
+		for i := range min(len(dst.Content), len(src.Content)) {
+			if err := carryComments(dst.Content[i], src.Content[i], path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isRedundant returns true for keys that are dropped from the canonical
+// layout without losing information: keys set to their default (empty)
+// value, and the meta/id of nested types when it matches their key under
+// types, which is where their ID is written.
+func isRedundant(key, value *yaml.Node, path []string) bool {
+	if isNestedMetaID(key, path) {
+		return value.Kind == yaml.ScalarNode && value.Value == path[len(path)-2]
+	}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Value == "" || value.Tag == "!!null" || (value.Tag == "!!bool" && value.Value == "false" && key.Value != "parent")
+	case yaml.SequenceNode:
+		return len(value.Content) == 0
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if !isRedundant(value.Content[i], value.Content[i+1], append(path, key.Value)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isNestedMetaID returns true if key is the meta/id of a type under types.
+func isNestedMetaID(key *yaml.Node, path []string) bool {
+	n := len(path)
+	return key.Value == "id" && n >= 3 && path[n-1] == "meta" && path[n-3] == "types"
+}
+
+func copyComments(dst, src *yaml.Node) {
+	if dst.HeadComment == "" {
+		dst.HeadComment = src.HeadComment
+	}
+	if dst.LineComment == "" {
+		dst.LineComment = src.LineComment
+	}
+	if dst.FootComment == "" {
+		dst.FootComment = src.FootComment
+	}
+}
+
+func lookupKey(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,165 @@
+package ksy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFormat(t *testing.T) {
+	input := `# Header comment.
+meta:
+  endian: le
+  id: sample # the id
+  ks-version: 0.10
+  file-extension: [smp]
+seq:
+  # The magic.
+  - id: magic
+    contents: [0x50, 0x4b, 3, 4]
+  - size: 4
+    id: len
+    type: u4
+  - id: body
+    type:
+      switch-on: kind
+      cases:
+        _: other
+        0x10: big
+        2: small
+  - id: v
+    type: u1
+    valid:
+      eq: 3
+types:
+  child:
+    meta:
+      id: child
+    seq: []
+instances:
+  x:
+    value: 1 + 2
+enums:
+  kinds:
+    1: foo # foo!
+    2:
+      id: bar
+      doc: Bar.
+`
+	expected := `# Header comment.
+meta:
+  id: sample # the id
+  file-extension: smp
+  ks-version: "0.10"
+  endian: le
+seq:
+  # The magic.
+  - id: magic
+    contents: [PK, 0x03, 0x04]
+  - id: len
+    type: u4
+    size: 4
+  - id: body
+    type:
+      switch-on: kind
+      cases:
+        2: small
+        0x10: big
+        _: other
+  - id: v
+    type: u1
+    valid: 3
+instances:
+  x:
+    value: 1 + 2
+types:
+  child: {}
+enums:
+  kinds:
+    1: foo # foo!
+    2:
+      id: bar
+      doc: Bar.
+`
+	out, err := Format([]byte(input))
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+
+	again, err := Format(out)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(again))
+}
+
+func TestFormatUnsupportedKey(t *testing.T) {
+	_, err := Format([]byte("meta:\n  id: x\nseq:\n  - id: a\n    tpye: u1\n"))
+	assert.EqualError(t, err, `5:5: unsupported key "tpye"`)
+}
+
+func TestFormatNestedMetaIDMismatch(t *testing.T) {
+	_, err := Format([]byte("meta:\n  id: x\ntypes:\n  child:\n    meta:\n      id: other\n"))
+	assert.EqualError(t, err, `6:11: meta/id "other" does not match type key "child"`)
+
+	_, err = Format([]byte("meta:\n  id: x\ntypes:\n  child:\n    meta:\n      id: other\n      endian: le\n"))
+	assert.EqualError(t, err, `6:11: meta/id "other" does not match type key "child"`)
+}
+
+// TestFormatFormats formats every test format and makes sure the result
+// decodes to the same spec and is already canonical.
+func TestFormatFormats(t *testing.T) {
+	files, err := filepath.Glob("../../testdata/formats/*.ksy")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			require.NoError(t, err)
+			out, err := Format(src)
+			require.NoError(t, err)
+
+			expected, actual := TypeSpec{}, TypeSpec{}
+			require.NoError(t, yaml.Unmarshal(src, &expected))
+			require.NoError(t, yaml.Unmarshal(out, &actual))
+			assert.Equal(t, expected, actual)
+
+			again, err := Format(out)
+			require.NoError(t, err)
+			assert.Equal(t, string(out), string(again))
+		})
+	}
+}
+
+func TestFormatExtensions(t *testing.T) {
+	input := `meta:
+  id: ext
+-webide-representation: '{len:dec}'
+seq:
+  - -orig-id: bLength
+    id: len
+    type: u1
+enums:
+  kinds:
+    1:
+      -orig-id: KIND_ONE
+      id: one
+`
+	expected := `meta:
+  id: ext
+seq:
+  - id: len
+    type: u1
+    -orig-id: bLength
+enums:
+  kinds:
+    1:
+      id: one
+      -orig-id: KIND_ONE
+-webide-representation: '{len:dec}'
+`
+	out, err := Format([]byte(input))
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+}
@@ -1,10 +1,6 @@
 package ksy
 
 import (
-	"fmt"
-	"reflect"
-	"strconv"
-
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,21 +18,27 @@ type InstanceSpecItem struct {
 // #/definitions/InstancesSpec
 type InstancesSpec struct{ Instances []InstanceSpecItem }
 
+// UnmarshalYAML implements yaml.Unmarshaler
+func (i *InstanceSpec) UnmarshalYAML(node *yaml.Node) error {
+	return (*AttributeSpec)(i).UnmarshalYAML(node)
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (i InstanceSpec) MarshalYAML() (any, error) {
+	return AttributeSpec(i).MarshalYAML()
+}
+
 // MarshalYAML implements yaml.Marshaler
 func (m InstancesSpec) MarshalYAML() (any, error) {
-	fields := []reflect.StructField{}
-	for i, n := range m.Instances {
-		fields = append(fields, reflect.StructField{
-			Name: "Field" + strconv.Itoa(i),
-			Type: reflect.TypeOf(InstanceSpec{}),
-			Tag:  reflect.StructTag(fmt.Sprintf("yaml:%q", n.Key)),
-		})
-	}
-	v := reflect.New(reflect.StructOf(fields)).Elem()
-	for i, n := range m.Instances {
-		v.Field(i).Set(reflect.ValueOf(n.Value))
+	n := newYAMLMap()
+	for _, item := range m.Instances {
+		value, err := marshalerNode(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		n.add(item.Key, value)
 	}
-	return v.Interface(), nil
+	return n.node, nil
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler
@@ -1,5 +1,7 @@
 package ksy
 
+import "gopkg.in/yaml.v3"
+
 // XrefSpec represents the type of meta/xref.
 type XrefSpec map[string]any
 
@@ -20,3 +22,41 @@ type MetaSpec struct {
 	BitEndian     BitEndianSpec `yaml:"bit-endian,omitempty"`
 	Xref          XrefSpec      `yaml:"xref,omitempty"`
 }
+
+// MarshalYAML implements yaml.Marshaler
+func (m MetaSpec) MarshalYAML() (any, error) {
+	n := newYAMLMap()
+	n.add("id", exprNode(string(m.ID)))
+	n.add("title", strNode(m.Title))
+	if err := n.addMarshaler("application", m.Application); err != nil {
+		return nil, err
+	}
+	if err := n.addMarshaler("file-extension", m.FileExtension); err != nil {
+		return nil, err
+	}
+	if len(m.Xref) > 0 {
+		xref := &yaml.Node{}
+		if err := xref.Encode(map[string]any(m.Xref)); err != nil {
+			return nil, err
+		}
+		n.add("xref", xref)
+	}
+	n.add("license", strNode(m.License))
+	// Quoted, so that e.g. 0.10 isn't read as the float 0.1.
+	n.add("ks-version", strNode(m.KSVersion))
+	if m.KSDebug {
+		n.add("ks-debug", boolNode(true))
+	}
+	if m.KSOpaqueTypes {
+		n.add("ks-opaque-types", boolNode(true))
+	}
+	n.add("imports", strSeqNode(m.Imports))
+	n.add("encoding", exprNode(m.Encoding))
+	if err := n.addMarshaler("endian", m.Endian); err != nil {
+		return nil, err
+	}
+	if err := n.addMarshaler("bit-endian", m.BitEndian); err != nil {
+		return nil, err
+	}
+	return n.node, nil
+}
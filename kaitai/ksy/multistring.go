@@ -8,3 +8,12 @@ func (m *MultiString) UnmarshalText(text []byte) error {
 	*m = append(*m, string(text))
 	return nil
 }
+
+// MarshalYAML implements yaml.Marshaler. A single string is written as a
+// scalar.
+func (m MultiString) MarshalYAML() (any, error) {
+	if len(m) == 1 {
+		return strNode(m[0]), nil
+	}
+	return strSeqNode(m), nil
+}
@@ -10,6 +10,24 @@ type ParamSpec struct {
 	Enum   string     `yaml:"enum,omitempty"`
 }
 
+// MarshalYAML implements yaml.Marshaler
+func (p ParamSpec) MarshalYAML() (any, error) {
+	m := newYAMLMap()
+	m.add("id", exprNode(string(p.ID)))
+	m.add("type", exprNode(p.Type))
+	m.add("enum", exprNode(p.Enum))
+	m.add("doc", strNode(p.Doc))
+	if err := m.addMarshaler("doc-ref", p.DocRef); err != nil {
+		return nil, err
+	}
+	return m.node, nil
+}
+
 // ParamsSpec represents the parameter list.
 // #/definitions/ParamsSpec
 type ParamsSpec []ParamSpec
+
+// MarshalYAML implements yaml.Marshaler
+func (p ParamsSpec) MarshalYAML() (any, error) {
+	return seqNode(p)
+}
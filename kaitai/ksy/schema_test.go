@@ -530,6 +530,7 @@ func TestFullSchema(t *testing.T) {
 								Meta: MetaSpec{
 									ID: "program_header",
 								},
+								Extensions: ExtensionsSpec{"-webide-representation": "{type} - f:{flags_obj:flags} (o:{offset}, s:{filesz:dec})"},
 								Seq: AttributesSpec{
 									AttributeSpec{
 										ID: "type",
@@ -631,6 +632,7 @@ func TestFullSchema(t *testing.T) {
 										{
 											Key: "flags_obj",
 											Value: InstanceSpec{
+												Extensions: ExtensionsSpec{"-webide-parse-mode": "eager"},
 												Type: AttrTypeSpec{
 													Value: "phdr_type_flags(flags64|flags32)",
 												},
@@ -643,22 +645,29 @@ func TestFullSchema(t *testing.T) {
 								Meta: MetaSpec{
 									ID: "section_header",
 								},
+								Extensions: ExtensionsSpec{
+									"-orig-id":               "Elf(32|64)_Shdr",
+									"-webide-representation": "{name} ({type}) - f:{flags_obj:flags} (o:{offset}, s:{size:dec})",
+								},
 								Seq: AttributesSpec{
 									AttributeSpec{
-										ID: "ofs_name",
+										ID:         "ofs_name",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_name"},
 										Type: AttrTypeSpec{
 											Value: "u4",
 										},
 									},
 									AttributeSpec{
-										ID: "type",
+										ID:         "type",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_type"},
 										Type: AttrTypeSpec{
 											Value: "u4",
 										},
 										Enum: "sh_type",
 									},
 									AttributeSpec{
-										ID: "flags",
+										ID:         "flags",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_flags"},
 										Type: AttrTypeSpec{
 											SwitchOn: "_root.bits",
 											Cases: TypeCaseMapSpec{
@@ -668,7 +677,8 @@ func TestFullSchema(t *testing.T) {
 										},
 									},
 									AttributeSpec{
-										ID: "addr",
+										ID:         "addr",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_addr"},
 										Type: AttrTypeSpec{
 											SwitchOn: "_root.bits",
 											Cases: TypeCaseMapSpec{
@@ -678,7 +688,8 @@ func TestFullSchema(t *testing.T) {
 										},
 									},
 									AttributeSpec{
-										ID: "ofs_body",
+										ID:         "ofs_body",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_offset"},
 										Type: AttrTypeSpec{
 											SwitchOn: "_root.bits",
 											Cases: TypeCaseMapSpec{
@@ -688,7 +699,8 @@ func TestFullSchema(t *testing.T) {
 										},
 									},
 									AttributeSpec{
-										ID: "len_body",
+										ID:         "len_body",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_size"},
 										Type: AttrTypeSpec{
 											SwitchOn: "_root.bits",
 											Cases: TypeCaseMapSpec{
@@ -698,17 +710,20 @@ func TestFullSchema(t *testing.T) {
 										},
 									},
 									AttributeSpec{
-										ID: "linked_section_idx",
+										ID:         "linked_section_idx",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_link"},
 										Type: AttrTypeSpec{
 											Value: "u4",
 										},
 									},
 									AttributeSpec{
-										ID:   "info",
-										Size: "4",
+										ID:         "info",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_info"},
+										Size:       "4",
 									},
 									AttributeSpec{
-										ID: "align",
+										ID:         "align",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_addralign"},
 										Type: AttrTypeSpec{
 											SwitchOn: "_root.bits",
 											Cases: TypeCaseMapSpec{
@@ -718,7 +733,8 @@ func TestFullSchema(t *testing.T) {
 										},
 									},
 									AttributeSpec{
-										ID: "entry_size",
+										ID:         "entry_size",
+										Extensions: ExtensionsSpec{"-orig-id": "sh_entsize"},
 										Type: AttrTypeSpec{
 											SwitchOn: "_root.bits",
 											Cases: TypeCaseMapSpec{
@@ -751,6 +767,7 @@ func TestFullSchema(t *testing.T) {
 										{
 											Key: "name",
 											Value: InstanceSpec{
+												Extensions: ExtensionsSpec{"-webide-parse-mode": "eager"},
 												Type: AttrTypeSpec{
 													Value: "strz",
 												},
@@ -762,6 +779,7 @@ func TestFullSchema(t *testing.T) {
 										{
 											Key: "flags_obj",
 											Value: InstanceSpec{
+												Extensions: ExtensionsSpec{"-webide-parse-mode": "eager"},
 												Type: AttrTypeSpec{
 													Value: "section_header_flags(flags)",
 												},
@@ -803,6 +821,7 @@ func TestFullSchema(t *testing.T) {
 								Meta: MetaSpec{
 									ID: "dynamic_section_entry",
 								},
+								Extensions: ExtensionsSpec{"-webide-representation": "{tag_enum}: {value_or_ptr} {flag_1_values:flags}"},
 								Seq: AttributesSpec{
 									AttributeSpec{
 										ID: "tag",
@@ -838,6 +857,7 @@ func TestFullSchema(t *testing.T) {
 										{
 											Key: "flag_1_values",
 											Value: InstanceSpec{
+												Extensions: ExtensionsSpec{"-webide-parse-mode": "eager"},
 												Type: AttrTypeSpec{
 													Value: "dt_flag_1_values(value_or_ptr)",
 												},
@@ -1,9 +1,7 @@
 package ksy
 
 import (
-	"fmt"
-	"reflect"
-	"strconv"
+	"bytes"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,26 +17,98 @@ type TypeSpec struct {
 	Doc       string         `yaml:"doc,omitempty"`
 	DocRef    DocRefSpec     `yaml:"doc-ref,omitempty"`
 	ToString  string         `yaml:"to-string,omitempty"`
+
+	Extensions ExtensionsSpec `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (t *TypeSpec) UnmarshalYAML(node *yaml.Node) error {
+	type plain TypeSpec
+	if err := node.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	extensions, err := decodeExtensions(node)
+	if err != nil {
+		return err
+	}
+	t.Extensions = extensions
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler. Keys are written in the order
+// recommended by the KSY style guide: meta, doc, doc-ref, params, seq,
+// instances, types, enums.
+func (t TypeSpec) MarshalYAML() (any, error) {
+	m := newYAMLMap()
+	if err := m.addMarshaler("meta", t.Meta); err != nil {
+		return nil, err
+	}
+	m.add("doc", strNode(t.Doc))
+	if err := m.addMarshaler("doc-ref", t.DocRef); err != nil {
+		return nil, err
+	}
+	if err := m.addMarshaler("params", t.Params); err != nil {
+		return nil, err
+	}
+	if err := m.addMarshaler("seq", t.Seq); err != nil {
+		return nil, err
+	}
+	if err := m.addMarshaler("instances", t.Instances); err != nil {
+		return nil, err
+	}
+	if err := m.addMarshaler("types", t.Types); err != nil {
+		return nil, err
+	}
+	if err := m.addMarshaler("enums", t.Enums); err != nil {
+		return nil, err
+	}
+	m.add("to-string", exprNode(t.ToString))
+	if err := t.Extensions.addTo(m); err != nil {
+		return nil, err
+	}
+	return m.node, nil
+}
+
+// Marshal encodes a type spec as a canonical .ksy document, using two-space
+// indentation.
+func Marshal(t TypeSpec) ([]byte, error) {
+	node, err := t.MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	return encodeYAML(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node.(*yaml.Node)}})
+}
+
+func encodeYAML(node *yaml.Node) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // TypesSpec represents a list of KaitaiStruct types.
 type TypesSpec []TypeSpec
 
-// MarshalYAML implements yaml.Marshaler
+// MarshalYAML implements yaml.Marshaler. The ID of each type is written as
+// its key, not as meta/id.
 func (t TypesSpec) MarshalYAML() (any, error) {
-	fields := []reflect.StructField{}
-	for i, n := range t {
-		fields = append(fields, reflect.StructField{
-			Name: "Field" + strconv.Itoa(i),
-			Type: reflect.TypeOf(TypeSpec{}),
-			Tag:  reflect.StructTag(fmt.Sprintf("yaml:%q", n.Meta.ID)),
-		})
-	}
-	v := reflect.New(reflect.StructOf(fields)).Elem()
-	for i, n := range t {
-		v.Field(i).Set(reflect.ValueOf(n))
-	}
-	return v.Interface(), nil
+	m := newYAMLMap()
+	for _, n := range t {
+		id := n.Meta.ID
+		n.Meta.ID = ""
+		node, err := marshalerNode(n)
+		if err != nil {
+			return nil, err
+		}
+		m.add(string(id), node)
+	}
+	return m.node, nil
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler
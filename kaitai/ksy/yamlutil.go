@@ -2,6 +2,8 @@ package ksy
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 )
@@ -17,3 +19,168 @@ func yamlMapForEach(node *yaml.Node, fn func(key *yaml.Node, value *yaml.Node) e
 	}
 	return nil
 }
+
+// yamlMap builds a mapping node. Entries are appended in the order they are
+// added, which is what gives the marshalled output a stable key order.
+type yamlMap struct{ node *yaml.Node }
+
+func newYAMLMap() yamlMap {
+	return yamlMap{node: &yaml.Node{Kind: yaml.MappingNode}}
+}
+
+// add appends key: value. Nil values are skipped.
+func (m yamlMap) add(key string, value *yaml.Node) {
+	if value == nil {
+		return
+	}
+	m.node.Content = append(m.node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// addMarshaler appends key: value for a value implementing yaml.Marshaler.
+func (m yamlMap) addMarshaler(key string, value yaml.Marshaler) error {
+	node, err := marshalerNode(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if node == nil || isEmptyNode(node) {
+		return nil
+	}
+	m.add(key, node)
+	return nil
+}
+
+// marshalerNode marshals value to a node.
+func marshalerNode(value yaml.Marshaler) (*yaml.Node, error) {
+	v, err := value.MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	if node, ok := v.(*yaml.Node); ok {
+		return node, nil
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(v); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// seqNode marshals each item of a slice to a sequence node.
+func seqNode[T yaml.Marshaler](items []T) (*yaml.Node, error) {
+	n := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, item := range items {
+		node, err := marshalerNode(item)
+		if err != nil {
+			return nil, err
+		}
+		n.Content = append(n.Content, node)
+	}
+	return n, nil
+}
+
+func isEmptyNode(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		return len(node.Content) == 0
+	case yaml.ScalarNode:
+		return node.Tag == "!!null"
+	}
+	return false
+}
+
+// strNode returns a string scalar, or nil for an empty string. The scalar is
+// quoted if it would otherwise read back as something other than a string.
+func strNode(s string) *yaml.Node {
+	if s == "" {
+		return nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+// exprNode returns an untagged scalar, or nil for an empty string. It is
+// used for expressions and identifiers, which are read as strings no matter
+// how YAML would resolve them, so `size: 4` can stay unquoted.
+func exprNode(s string) *yaml.Node {
+	if s == "" {
+		return nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: s}
+}
+
+func boolNode(b bool) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(b)}
+}
+
+// boolPtrNode returns a bool scalar, or nil if b is nil.
+func boolPtrNode(b *bool) *yaml.Node {
+	if b == nil {
+		return nil
+	}
+	return boolNode(*b)
+}
+
+// intPtrNode returns an int scalar, or nil if i is nil.
+func intPtrNode(i *int) *yaml.Node {
+	if i == nil {
+		return nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(*i)}
+}
+
+// strSeqNode returns a sequence of string scalars, or nil if s is empty.
+func strSeqNode(s []string) *yaml.Node {
+	if len(s) == 0 {
+		return nil
+	}
+	n := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, v := range s {
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+	}
+	return n
+}
+
+// casesNode marshals a switch `cases:` map. Go maps are unordered, so keys
+// are sorted: integers numerically first, then everything else
+// lexicographically, with the `_` default case last.
+func casesNode(cases map[string]string) *yaml.Node {
+	if len(cases) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(cases))
+	for k := range cases {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, compareCaseKeys)
+	m := newYAMLMap()
+	for _, k := range keys {
+		m.add(k, exprNode(cases[k]))
+	}
+	return m.node
+}
+
+func compareCaseKeys(a, b string) int {
+	rank := func(s string) (int, int64) {
+		if s == "_" {
+			return 2, 0
+		}
+		if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return 0, n
+		}
+		return 1, 0
+	}
+	ra, na := rank(a)
+	rb, nb := rank(b)
+	switch {
+	case ra != rb:
+		return ra - rb
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package kaitai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckLicense returns an error if license (a `meta/license` value) isn't
+// an SPDX license expression, such as `MIT` or
+// `(GPL-2.0-or-later WITH Classpath-exception-2.0) OR Apache-2.0`. The
+// license is copied into comments in generated code, so anything else is
+// rejected. An empty license is accepted.
+func CheckLicense(license string) error {
+	if license == "" {
+		return nil
+	}
+	p := licenseParser{tokens: licenseTokens(license)}
+	if err := p.or(); err != nil {
+		return fmt.Errorf("invalid license %q: %w", license, err)
+	}
+	if tok, ok := p.peek(); ok {
+		return fmt.Errorf("invalid license %q: unexpected %q", license, tok)
+	}
+	return nil
+}
+
+// licenseTokens splits an SPDX license expression into parentheses and
+// space-separated words.
+func licenseTokens(license string) []string {
+	license = strings.ReplaceAll(license, "(", " ( ")
+	license = strings.ReplaceAll(license, ")", " ) ")
+	return strings.Split(strings.Join(strings.Fields(license), " "), " ")
+}
+
+type licenseParser struct {
+	tokens []string
+}
+
+func (p *licenseParser) peek() (string, bool) {
+	if len(p.tokens) == 0 {
+		return "", false
+	}
+	return p.tokens[0], true
+}
+
+func (p *licenseParser) next() (string, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	p.tokens = p.tokens[1:]
+	return tok, nil
+}
+
+// accept consumes the next token if it's the operator op, in either upper or
+// lower case.
+func (p *licenseParser) accept(op string) bool {
+	tok, ok := p.peek()
+	if !ok || (tok != op && tok != strings.ToLower(op)) {
+		return false
+	}
+	p.tokens = p.tokens[1:]
+	return true
+}
+
+func (p *licenseParser) or() error {
+	if err := p.and(); err != nil {
+		return err
+	}
+	for p.accept("OR") {
+		if err := p.and(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *licenseParser) and() error {
+	if err := p.with(); err != nil {
+		return err
+	}
+	for p.accept("AND") {
+		if err := p.with(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *licenseParser) with() error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok == "(" {
+		if err := p.or(); err != nil {
+			return err
+		}
+		if tok, err := p.next(); err != nil {
+			return err
+		} else if tok != ")" {
+			return fmt.Errorf("unexpected %q", tok)
+		}
+		return nil
+	}
+	if !licenseID(strings.TrimSuffix(tok, "+"), true) {
+		return fmt.Errorf("unexpected %q", tok)
+	}
+	if p.accept("WITH") {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if !licenseID(tok, false) {
+			return fmt.Errorf("unexpected %q", tok)
+		}
+	}
+	return nil
+}
+
+// licenseID reports whether id is an SPDX license or exception identifier.
+// If ref is set, `DocumentRef-x:LicenseRef-y` references are accepted too.
+func licenseID(id string, ref bool) bool {
+	if ref {
+		if doc, lic, ok := strings.Cut(id, ":"); ok {
+			return strings.HasPrefix(doc, "DocumentRef-") && strings.HasPrefix(lic, "LicenseRef-") &&
+				licenseID(doc, false) && licenseID(lic, false)
+		}
+	}
+	switch id {
+	case "", "AND", "OR", "WITH", "and", "or", "with":
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,13 +1,16 @@
 package kaitai
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/jchv/zanbato/kaitai/expr"
 	"github.com/jchv/zanbato/kaitai/ksy"
+	"github.com/jchv/zanbato/kaitai/srcpos"
 	"github.com/jchv/zanbato/kaitai/types"
 
 	"gopkg.in/yaml.v3"
@@ -15,33 +18,218 @@ import (
 
 // ParseStruct parses a struct from YAML into a kaitai.Struct.
 func ParseStruct(r io.Reader) (*Struct, error) {
+	return ParseStructFile(r, "")
+}
+
+// ParseStructFile parses a struct from YAML into a kaitai.Struct, recording
+// filename in the source position of every struct, attr, param, enum and
+// expression.
+func ParseStructFile(r io.Reader, filename string) (*Struct, error) {
+	doc := yaml.Node{}
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
 	root := ksy.TypeSpec{}
-	if err := yaml.NewDecoder(r).Decode(&root); err != nil {
+	if err := doc.Decode(&root); err != nil {
 		return nil, err
 	}
-	return translateTypeSpec("", root)
+	node := &doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	return sourceMap{filename}.translateTypeSpec("", root, node)
+}
+
+// sourceMap maps the YAML nodes of a .ksy document back to positions. The
+// ksy specs don't carry positions themselves, so translation walks the
+// document node alongside them.
+type sourceMap struct {
+	file string
+}
+
+// pos returns the position of node, or an unknown position if node is nil.
+func (m sourceMap) pos(node *yaml.Node) srcpos.Pos {
+	if node == nil {
+		return srcpos.Pos{File: m.file}
+	}
+	return srcpos.Pos{File: m.file, Line: node.Line, Column: node.Column}
+}
+
+// keyPos returns the position of the value for key in mapping node, falling
+// back to the position of the mapping itself.
+func (m sourceMap) keyPos(node *yaml.Node, key string) srcpos.Pos {
+	if value := yamlLookup(node, key); value != nil {
+		return m.pos(value)
+	}
+	return m.pos(node)
+}
+
+// textPos returns where the text of scalar node starts, so that offsets into
+// an expression parsed from it can be turned into columns. Quoted scalars
+// start after the quote; block scalars start on the next line, at an
+// indentation that isn't recorded. Escape sequences in quoted scalars aren't
+// accounted for.
+func (m sourceMap) textPos(node *yaml.Node) srcpos.Pos {
+	pos := m.pos(node)
+	if node == nil || node.Kind != yaml.ScalarNode || !pos.IsValid() {
+		return pos
+	}
+	switch {
+	case node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0:
+		pos.Column++
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		pos.Line++
+		pos.Column = 0
+	}
+	return pos
+}
+
+// keyTextPos is like keyPos, but returns where the value's text starts.
+func (m sourceMap) keyTextPos(node *yaml.Node, key string) srcpos.Pos {
+	if value := yamlLookup(node, key); value != nil {
+		return m.textPos(value)
+	}
+	return m.pos(node)
+}
+
+// yamlLookup returns the value for key in a mapping node, or nil.
+func yamlLookup(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlLookupKey returns the key node for key in a mapping node, or nil.
+func yamlLookupKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// yamlIndex returns the i-th item of a sequence node, or nil.
+func yamlIndex(node *yaml.Node, i int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil
+	}
+	return node.Content[i]
+}
+
+// setParamSources records positions on the parameter expressions of a user
+// type, which were split out of the type string held by node.
+func (m sourceMap) setParamSources(params []*expr.Expr, node *yaml.Node) {
+	pos := m.textPos(node)
+	if node == nil || pos.Column == 0 {
+		for _, p := range params {
+			setSource(p, pos)
+		}
+		return
+	}
+	typestr := node.Value
+	for _, p := range params {
+		if p == nil {
+			continue
+		}
+		if p.Offset > len(typestr) {
+			setSource(p, m.pos(node))
+			continue
+		}
+		paramPos := pos
+		paramPos.Column += utf8.RuneCountInString(typestr[:p.Offset])
+		setSource(p, paramPos)
+	}
+}
+
+// typeErrorPos returns the position of an error parsing the type of the attr
+// with mapping node. Syntax errors are traced back to the size, switch-on or
+// type parameter they occurred in; other errors are attributed to attrPos.
+func (m sourceMap) typeErrorPos(attrPos srcpos.Pos, node *yaml.Node, err error) srcpos.Pos {
+	var syntaxErr *expr.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return attrPos
+	}
+	typeNode := yamlLookup(node, "type")
+	candidates := []*yaml.Node{yamlLookup(node, "size"), yamlLookup(typeNode, "switch-on"), typeNode}
+	if cases := yamlLookup(typeNode, "cases"); cases != nil && cases.Kind == yaml.MappingNode {
+		for i := 1; i < len(cases.Content); i += 2 {
+			candidates = append(candidates, cases.Content[i])
+		}
+	}
+	for _, candidate := range candidates {
+		if candidate == nil || candidate.Kind != yaml.ScalarNode {
+			continue
+		}
+		i := strings.Index(candidate.Value, syntaxErr.Text)
+		if i < 0 {
+			continue
+		}
+		pos := m.textPos(candidate)
+		if pos.Column > 0 {
+			pos.Column += utf8.RuneCountInString(candidate.Value[:i])
+		}
+		return expr.ErrorPos(pos, err)
+	}
+	return attrPos
 }
 
-func translateTypeSpec(id Identifier, typ ksy.TypeSpec) (*Struct, error) {
+// setSource records pos on e if it doesn't already have a position.
+func setSource(e *expr.Expr, pos srcpos.Pos) {
+	if e != nil && !e.Source.IsValid() {
+		e.Source = pos
+	}
+}
+
+func (m sourceMap) translateTypeSpec(id Identifier, typ ksy.TypeSpec, node *yaml.Node) (*Struct, error) {
 	result := &Struct{}
+	result.Source = m.pos(node)
 	result.Doc = typ.Doc
+	result.DocRef = typ.DocRef
 	if id == "" {
 		result.ID = Identifier(typ.Meta.ID)
 	} else {
 		result.ID = id
 	}
 
+	if err := CheckKSVersion(typ.Meta.KSVersion); err != nil {
+		return nil, srcpos.Wrap(m.keyPos(yamlLookup(node, "meta"), "ks-version"), err)
+	}
+
 	result.Meta.Imports = typ.Meta.Imports
+	importsNode := yamlLookup(yamlLookup(node, "meta"), "imports")
+	for i := range typ.Meta.Imports {
+		result.Meta.ImportSources = append(result.Meta.ImportSources, m.pos(yamlIndex(importsNode, i)))
+	}
 	result.Meta.Encoding = typ.Meta.Encoding
 	result.Meta.OpaqueTypes = typ.Meta.KSOpaqueTypes
 	result.Meta.Debug = typ.Meta.KSDebug
+	result.Meta.Title = typ.Meta.Title
+	result.Meta.Application = typ.Meta.Application
+	result.Meta.FileExtension = typ.Meta.FileExtension
+	if err := CheckLicense(typ.Meta.License); err != nil {
+		return nil, srcpos.Wrap(m.keyPos(yamlLookup(node, "meta"), "license"), err)
+	}
+	result.Meta.License = typ.Meta.License
+	result.Meta.KSVersion = typ.Meta.KSVersion
+	result.Meta.Xref = typ.Meta.Xref
 
 	if typ.Meta.Endian.Value == "le" {
 		result.Meta.Endian.Kind = types.LittleEndian
 	} else if typ.Meta.Endian.Value == "be" {
 		result.Meta.Endian.Kind = types.BigEndian
 	} else if len(typ.Meta.Endian.Cases) > 0 || typ.Meta.Endian.SwitchOn != "" {
-		switchOn, err := expr.ParseExpr(typ.Meta.Endian.SwitchOn)
+		endianNode := yamlLookup(yamlLookup(node, "meta"), "endian")
+		switchOn, err := expr.ParseExprAt(typ.Meta.Endian.SwitchOn, m.keyTextPos(endianNode, "switch-on"))
 		if err != nil {
 			return nil, err
 		}
@@ -55,7 +243,7 @@ func translateTypeSpec(id Identifier, typ ksy.TypeSpec) (*Struct, error) {
 			case "be":
 				result.Meta.Endian.Cases[key] = types.BigEndian
 			default:
-				return nil, fmt.Errorf("unknown endian value %s", value)
+				return nil, srcpos.Errorf(m.keyPos(yamlLookup(endianNode, "cases"), key), "unknown endian value %s", value)
 			}
 		}
 	}
@@ -67,20 +255,22 @@ func translateTypeSpec(id Identifier, typ ksy.TypeSpec) (*Struct, error) {
 		case "be":
 			result.Meta.BitEndian.Kind = types.BigBitEndian
 		default:
-			return nil, fmt.Errorf("unknown bit endian value %s", typ.Meta.BitEndian.Value)
+			return nil, srcpos.Errorf(m.keyPos(yamlLookup(node, "meta"), "bit-endian"), "unknown bit endian value %s", typ.Meta.BitEndian.Value)
 		}
 	}
 
-	for _, spec := range typ.Params {
-		param, err := translateParamSpec(spec)
+	paramsNode := yamlLookup(node, "params")
+	for i, spec := range typ.Params {
+		param, err := m.translateParamSpec(spec, yamlIndex(paramsNode, i))
 		if err != nil {
 			return nil, err
 		}
 		result.Params = append(result.Params, param)
 	}
 	anonIdx := 0
-	for _, spec := range typ.Seq {
-		attr, err := translateAttrSpec(spec, typ.Meta.Encoding)
+	seqNode := yamlLookup(node, "seq")
+	for i, spec := range typ.Seq {
+		attr, err := m.translateAttrSpec(spec, typ.Meta.Encoding, yamlIndex(seqNode, i))
 		if err != nil {
 			return nil, err
 		}
@@ -90,71 +280,105 @@ func translateTypeSpec(id Identifier, typ ksy.TypeSpec) (*Struct, error) {
 		}
 		result.Seq = append(result.Seq, attr)
 	}
+	typesNode := yamlLookup(node, "types")
 	for _, spec := range typ.Types {
 		// Inherit meta encoding from parent if child doesn't have its own
 		childSpec := spec
 		if childSpec.Meta.Encoding == "" && typ.Meta.Encoding != "" {
 			childSpec.Meta.Encoding = typ.Meta.Encoding
 		}
-		child, err := translateTypeSpec(Identifier(childSpec.Meta.ID), childSpec)
+		childNode := yamlLookup(typesNode, string(childSpec.Meta.ID))
+		child, err := m.translateTypeSpec(Identifier(childSpec.Meta.ID), childSpec, childNode)
 		if err != nil {
 			return nil, err
 		}
+		if key := yamlLookupKey(typesNode, string(childSpec.Meta.ID)); key != nil {
+			child.Source = m.pos(key)
+		}
 		result.Structs = append(result.Structs, child)
 	}
+	enumsNode := yamlLookup(node, "enums")
 	for _, spec := range typ.Enums {
-		enum, err := translateEnumSpec(Identifier(spec.ID), spec)
+		enum, err := m.translateEnumSpec(Identifier(spec.ID), spec, yamlLookupKey(enumsNode, string(spec.ID)), yamlLookup(enumsNode, string(spec.ID)))
 		if err != nil {
 			return nil, err
 		}
 		result.Enums = append(result.Enums, enum)
 	}
+	instancesNode := yamlLookup(node, "instances")
 	for _, spec := range typ.Instances.Instances {
-		instance, err := translateInstanceSpec(spec, typ.Meta.Encoding)
+		instance, err := m.translateInstanceSpec(spec, typ.Meta.Encoding, yamlLookupKey(instancesNode, spec.Key), yamlLookup(instancesNode, spec.Key))
 		if err != nil {
 			return nil, err
 		}
 		result.Instances = append(result.Instances, instance)
 	}
 	result.ToString = typ.ToString
+	result.Extensions = typ.Extensions
 	return result, nil
 }
 
-func translateParamSpec(param ksy.ParamSpec) (*Param, error) {
+func (m sourceMap) translateParamSpec(param ksy.ParamSpec, node *yaml.Node) (*Param, error) {
 	typ, err := types.ParseTypeRef(param.Type)
 	if err != nil {
-		return nil, err
+		return nil, srcpos.Wrap(m.keyPos(node, "type"), err)
 	}
+	m.setParamSources(userParams(typ), yamlLookup(node, "type"))
 	return &Param{
-		ID:   Identifier(param.ID),
-		Doc:  param.Doc,
-		Type: typ,
-		Enum: param.Enum,
+		ID:     Identifier(param.ID),
+		Doc:    param.Doc,
+		DocRef: param.DocRef,
+		Type:   typ,
+		Enum:   param.Enum,
+		Source: m.pos(node),
 	}, nil
 }
 
-func translateAttrSpec(attr ksy.AttributeSpec, defaultEncoding string) (*Attr, error) {
-	return buildAttr(Identifier(attr.ID), attr, defaultEncoding, false)
+func (m sourceMap) translateAttrSpec(attr ksy.AttributeSpec, defaultEncoding string, node *yaml.Node) (*Attr, error) {
+	return m.buildAttr(Identifier(attr.ID), attr, defaultEncoding, false, node, node)
 }
 
-func translateEnumSpec(id Identifier, typ ksy.EnumSpec) (*Enum, error) {
+func (m sourceMap) translateEnumSpec(id Identifier, typ ksy.EnumSpec, keyNode, node *yaml.Node) (*Enum, error) {
 	result := &Enum{}
 	result.ID = id
-	for _, val := range typ.Values {
+	result.Source = m.pos(keyNode)
+	for i, val := range typ.Values {
 		value := big.NewInt(0)
 		if _, ok := value.SetString(val.Value, 0); !ok {
-			return nil, fmt.Errorf("unable to parse %q as int in enum %q", val.Value, id)
+			var valueNode *yaml.Node
+			if node != nil && node.Kind == yaml.MappingNode && 2*i < len(node.Content) {
+				valueNode = node.Content[2*i]
+			}
+			return nil, srcpos.Errorf(m.pos(valueNode), "unable to parse %q as int in enum %q", val.Value, id)
 		}
-		result.Values = append(result.Values, EnumValue{value, Identifier(val.Spec.ID)})
+		result.Values = append(result.Values, EnumValue{
+			Value:      value,
+			ID:         Identifier(val.Spec.ID),
+			Doc:        val.Spec.Doc,
+			DocRef:     val.Spec.DocRef,
+			Extensions: val.Spec.Extensions,
+		})
 	}
 	return result, nil
 }
 
-func translateInstanceSpec(spec ksy.InstanceSpecItem, defaultEncoding string) (*Attr, error) {
-	return buildAttr(Identifier(spec.Key), ksy.AttributeSpec(spec.Value), defaultEncoding, true)
+func (m sourceMap) translateInstanceSpec(spec ksy.InstanceSpecItem, defaultEncoding string, keyNode, node *yaml.Node) (*Attr, error) {
+	return m.buildAttr(Identifier(spec.Key), ksy.AttributeSpec(spec.Value), defaultEncoding, true, keyNode, node)
 }
 
-func buildAttr(id Identifier, attr ksy.AttributeSpec, defaultEncoding string, instance bool) (*Attr, error) {
+// userParams returns the parameter expressions of a user typeref, if any.
+func userParams(typ types.TypeRef) []*expr.Expr {
+	if typ.Kind != types.User || typ.User == nil {
+		return nil
+	}
+	return typ.User.Params
+}
+
+// buildAttr translates an attr spec. keyNode is where the attr is declared
+// (the list item for seq attrs, the key for instances) and node is the
+// attr's mapping.
+func (m sourceMap) buildAttr(id Identifier, attr ksy.AttributeSpec, defaultEncoding string, instance bool, keyNode, node *yaml.Node) (*Attr, error) {
+	attrPos := m.pos(keyNode)
 	// Propagate meta-level encoding to string attrs that don't have their own.
 	if attr.Encoding == "" && defaultEncoding != "" {
 		typVal := strings.TrimSpace(attr.Type.Value)
@@ -164,19 +388,35 @@ func buildAttr(id Identifier, attr ksy.AttributeSpec, defaultEncoding string, in
 	}
 	typ, err := types.ParseAttrType(attr, instance)
 	if err != nil {
-		return nil, err
+		return nil, srcpos.Wrap(m.typeErrorPos(attrPos, node, err), err)
 	}
+	m.setTypeSources(typ, attr, node)
 
 	repeat, err := types.ParseRepeat(attr)
 	if err != nil {
-		return nil, fmt.Errorf("parsing repeat expression for attr %q: %w", id, err)
+		repeatPos := m.keyPos(node, "repeat")
+		switch attr.Repeat {
+		case ksy.ExprRepeatSpec:
+			repeatPos = expr.ErrorPos(m.keyTextPos(node, "repeat-expr"), err)
+		case ksy.UntilRepeatSpec:
+			repeatPos = expr.ErrorPos(m.keyTextPos(node, "repeat-until"), err)
+		}
+		return nil, srcpos.Errorf(repeatPos, "parsing repeat expression for attr %q: %w", id, err)
+	}
+	switch r := repeat.(type) {
+	case types.RepeatExpr:
+		setSource(r.CountExpr, m.keyTextPos(node, "repeat-expr"))
+	case types.RepeatUntil:
+		setSource(r.UntilExpr, m.keyTextPos(node, "repeat-until"))
 	}
 
 	parseOptionalExpr := func(name, src string) (*expr.Expr, error) {
+		exprPos := m.keyTextPos(node, name)
 		parsed, err := expr.ParseExpr(src)
 		if err != nil {
-			return nil, fmt.Errorf("parsing %s expression for attr %q: %w", name, id, err)
+			return nil, srcpos.Errorf(expr.ErrorPos(exprPos, err), "parsing %s expression for attr %q: %w", name, id, err)
 		}
+		setSource(parsed, exprPos)
 		return parsed, nil
 	}
 
@@ -208,6 +448,7 @@ func buildAttr(id Identifier, attr ksy.AttributeSpec, defaultEncoding string, in
 	return &Attr{
 		ID:         id,
 		Doc:        attr.Doc,
+		DocRef:     attr.DocRef,
 		Contents:   attr.Contents,
 		Type:       typ,
 		Repeat:     repeat,
@@ -226,5 +467,38 @@ func buildAttr(id Identifier, attr ksy.AttributeSpec, defaultEncoding string, in
 		Consume:    attr.Consume,
 		Include:    attr.Include,
 		EosError:   attr.EosError,
+		Extensions: attr.Extensions,
+		Source:     attrPos,
 	}, nil
 }
+
+// setTypeSources records positions on the expressions embedded in an attr's
+// parsed type.
+func (m sourceMap) setTypeSources(typ types.Type, attr ksy.AttributeSpec, node *yaml.Node) {
+	typeNode := yamlLookup(node, "type")
+	if ts := typ.TypeSwitch; ts != nil {
+		setSource(ts.SwitchOn, m.keyTextPos(typeNode, "switch-on"))
+		casesNode := yamlLookup(typeNode, "cases")
+		for key, ref := range ts.Cases {
+			m.setParamSources(userParams(ref), yamlLookup(casesNode, key))
+		}
+		return
+	}
+	ref := typ.TypeRef
+	if ref == nil {
+		return
+	}
+	sizePos := m.keyTextPos(node, "size")
+	if attr.Contents != nil {
+		sizePos = m.keyPos(node, "contents")
+	}
+	switch {
+	case ref.Bytes != nil:
+		setSource(ref.Bytes.Size, sizePos)
+	case ref.String != nil:
+		setSource(ref.String.Size, sizePos)
+	case ref.User != nil:
+		setSource(ref.User.Size, sizePos)
+		m.setParamSources(ref.User.Params, typeNode)
+	}
+}
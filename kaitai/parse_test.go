@@ -6,10 +6,17 @@ import (
 	"testing"
 
 	"github.com/jchv/zanbato/kaitai/expr"
+	"github.com/jchv/zanbato/kaitai/srcpos"
 	"github.com/jchv/zanbato/kaitai/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// at returns a position in an unnamed source file.
+func at(line, column int) srcpos.Pos {
+	return srcpos.Pos{Line: line, Column: column}
+}
+
 func TestParse(t *testing.T) {
 	tests := []struct {
 		Name   string
@@ -19,7 +26,7 @@ func TestParse(t *testing.T) {
 		{
 			Name:   "EmptyStruct",
 			Source: `{meta: {id: empty}}`,
-			Struct: &Struct{ID: "empty"},
+			Struct: &Struct{ID: "empty", Source: at(1, 1)},
 		},
 		{
 			Name:   "NestedEmpty",
@@ -27,9 +34,10 @@ func TestParse(t *testing.T) {
 			Struct: &Struct{
 				ID: "nested_empty",
 				Structs: []*Struct{
-					{ID: "subtype_a", Doc: "Nested A"},
-					{ID: "subtype_b", Doc: "Nested B"},
+					{ID: "subtype_a", Doc: "Nested A", Source: at(1, 36)},
+					{ID: "subtype_b", Doc: "Nested B", Source: at(1, 64)},
 				},
+				Source: at(1, 1),
 			},
 		},
 		{
@@ -38,9 +46,10 @@ func TestParse(t *testing.T) {
 			Struct: &Struct{
 				ID: "enums",
 				Enums: []*Enum{
-					{ID: "enum_a", Values: []EnumValue{{big.NewInt(1), "value1"}, {big.NewInt(2), "value2"}}},
-					{ID: "enum_b", Values: []EnumValue{{big.NewInt(1), "b0"}, {big.NewInt(2), "b1"}}},
+					{ID: "enum_a", Values: []EnumValue{{Value: big.NewInt(1), ID: "value1"}, {Value: big.NewInt(2), ID: "value2"}}, Source: at(1, 29)},
+					{ID: "enum_b", Values: []EnumValue{{Value: big.NewInt(1), ID: "b0"}, {Value: big.NewInt(2), ID: "b1"}}, Source: at(1, 61)},
 				},
+				Source: at(1, 1),
 			},
 		},
 		{
@@ -59,14 +68,16 @@ func TestParse(t *testing.T) {
 									EosError:   true,
 									PadRight:   -1,
 									Terminator: -1,
-									Size:       &expr.Expr{Root: expr.IntNode{Integer: big.NewInt(4)}},
+									Size:       &expr.Expr{Root: expr.IntNode{Integer: big.NewInt(4)}, Source: at(1, 58)},
 								},
 							},
 						},
 						Contents: []byte{0x7f, 'E', 'L', 'F'},
-						Size:     &expr.Expr{Root: expr.IntNode{Integer: big.NewInt(4)}},
+						Size:     &expr.Expr{Root: expr.IntNode{Integer: big.NewInt(4), Span: expr.Span{Start: 0, End: 1}}, Text: "4", Source: at(1, 45)},
+						Source:   at(1, 27),
 					},
 				},
+				Source: at(1, 1),
 			},
 		},
 	}
@@ -110,3 +121,275 @@ func TestParseInvalidExpressions(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSourcePositions(t *testing.T) {
+	source := `meta:
+  id: positions
+params:
+  - id: count
+    type: u1
+seq:
+  - id: len
+    type: u2
+  - id: body
+    size: len
+    repeat: expr
+    repeat-expr: count
+types:
+  sub:
+    seq:
+      - id: x
+        type: u1
+enums:
+  kind:
+    1: one
+instances:
+  doubled:
+    value: len * 2
+`
+	s, err := ParseStructFile(bytes.NewBufferString(source), "positions.ksy")
+	assert.NoError(t, err)
+
+	pos := func(line, column int) srcpos.Pos {
+		return srcpos.Pos{File: "positions.ksy", Line: line, Column: column}
+	}
+	assert.Equal(t, pos(1, 1), s.Source)
+	assert.Equal(t, pos(4, 5), s.Params[0].Source)
+	assert.Equal(t, pos(7, 5), s.Seq[0].Source)
+	assert.Equal(t, pos(9, 5), s.Seq[1].Source)
+	assert.Equal(t, pos(10, 11), s.Seq[1].Size.Source)
+	assert.Equal(t, pos(10, 11), s.Seq[1].Type.TypeRef.Bytes.Size.Source)
+	assert.Equal(t, pos(12, 18), s.Seq[1].Repeat.(types.RepeatExpr).CountExpr.Source)
+	assert.Equal(t, pos(14, 3), s.Structs[0].Source)
+	assert.Equal(t, pos(16, 9), s.Structs[0].Seq[0].Source)
+	assert.Equal(t, pos(19, 3), s.Enums[0].Source)
+	assert.Equal(t, pos(22, 3), s.Instances[0].Source)
+	assert.Equal(t, pos(23, 12), s.Instances[0].Value.Source)
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	source := `meta:
+  id: bad
+seq:
+  - id: x
+    type: u1
+    if: 1 anx 2
+`
+	_, err := ParseStructFile(bytes.NewBufferString(source), "bad.ksy")
+	assert.ErrorContains(t, err, `bad.ksy:6:11: parsing if expression for attr "x"`)
+	pos, ok := srcpos.Of(err)
+	assert.True(t, ok)
+	assert.Equal(t, srcpos.Pos{File: "bad.ksy", Line: 6, Column: 11}, pos)
+
+	source = `meta:
+  id: bad
+seq:
+  - id: x
+    type: u1
+    repeat: until
+    repeat-until: "_ == (1 + 2"
+`
+	_, err = ParseStructFile(bytes.NewBufferString(source), "bad.ksy")
+	assert.ErrorContains(t, err, "expected ')', found end of expression")
+	pos, _ = srcpos.Of(err)
+	assert.Equal(t, srcpos.Pos{File: "bad.ksy", Line: 7, Column: 31}, pos)
+
+	source = `meta:
+  id: bad
+seq:
+  - id: x
+    type:
+      switch-on: a.
+      cases:
+        1: u1
+`
+	_, err = ParseStructFile(bytes.NewBufferString(source), "bad.ksy")
+	assert.ErrorContains(t, err, "expected identifier")
+	pos, _ = srcpos.Of(err)
+	assert.Equal(t, srcpos.Pos{File: "bad.ksy", Line: 6, Column: 20}, pos)
+
+	source = `meta:
+  id: bad
+seq:
+  - id: x
+    type: 'block(1, 2 +)'
+`
+	_, err = ParseStructFile(bytes.NewBufferString(source), "bad.ksy")
+	assert.ErrorContains(t, err, "in parameter 2 of block")
+	pos, _ = srcpos.Of(err)
+	assert.Equal(t, srcpos.Pos{File: "bad.ksy", Line: 5, Column: 24}, pos)
+}
+
+func TestParseExprPositions(t *testing.T) {
+	source := `meta:
+  id: fmt
+seq:
+  - id: len
+    type: u1
+  - id: body
+    type: block(len, 'a' + "b")
+    if: "len > 0"
+  - id: quoted
+    type: "block(len, 'c')"
+types:
+  block:
+    params:
+      - id: n
+        type: u1
+      - id: s
+        type: str
+`
+	s, err := ParseStructFile(bytes.NewBufferString(source), "fmt.ksy")
+	require.NoError(t, err)
+	pos := func(line, column int) srcpos.Pos {
+		return srcpos.Pos{File: "fmt.ksy", Line: line, Column: column}
+	}
+
+	params := s.Seq[1].Type.TypeRef.User.Params
+	assert.Equal(t, pos(7, 17), params[0].Source)
+	assert.Equal(t, pos(7, 21), params[1].Source)
+	add := params[1].Root.(expr.BinaryNode)
+	assert.Equal(t, pos(7, 22), params[1].Pos(add))
+	assert.Equal(t, pos(7, 28), params[1].Pos(add.B))
+
+	ifExpr := s.Seq[1].If
+	assert.Equal(t, pos(8, 10), ifExpr.Source)
+	assert.Equal(t, pos(8, 16), ifExpr.Pos(ifExpr.Root.(expr.BinaryNode).B))
+
+	// Quoted type strings start after the quote.
+	params = s.Seq[2].Type.TypeRef.User.Params
+	assert.Equal(t, pos(10, 18), params[0].Source)
+	assert.Equal(t, pos(10, 23), params[1].Pos(params[1].Root))
+}
+
+func TestParseMeta(t *testing.T) {
+	source := `meta:
+  id: fmt
+  title: Some Format
+  application: [tool a, tool b]
+  file-extension: fmt
+  license: CC0-1.0
+  ks-version: 0.9
+  xref:
+    wikidata: Q1234
+`
+	s, err := ParseStruct(bytes.NewBufferString(source))
+	assert.NoError(t, err)
+	assert.Equal(t, "Some Format", s.Meta.Title)
+	assert.Equal(t, []string{"tool a", "tool b"}, s.Meta.Application)
+	assert.Equal(t, []string{"fmt"}, s.Meta.FileExtension)
+	assert.Equal(t, "CC0-1.0", s.Meta.License)
+	assert.Equal(t, "0.9", s.Meta.KSVersion)
+	assert.Equal(t, map[string]any{"wikidata": "Q1234"}, s.Meta.Xref)
+}
+
+func TestParseKSVersion(t *testing.T) {
+	for _, version := range []string{"0.8", "0.10", "0.11", "0.11-SNAPSHOT", "0.11.0"} {
+		assert.NoError(t, CheckKSVersion(version), version)
+	}
+	for _, version := range []string{"0.12", "1.0", "0.11.1"} {
+		assert.ErrorContains(t, CheckKSVersion(version), "requires Kaitai Struct "+version, version)
+	}
+	assert.ErrorContains(t, CheckKSVersion("latest"), "invalid ks-version")
+
+	source := `meta:
+  id: future
+  ks-version: 9.0
+`
+	_, err := ParseStructFile(bytes.NewBufferString(source), "future.ksy")
+	assert.ErrorContains(t, err, "future.ksy:3:15: spec requires Kaitai Struct 9.0")
+}
+
+func TestParseLicense(t *testing.T) {
+	for _, license := range []string{
+		"MIT",
+		"GPL-2.0+",
+		"MIT OR Apache-2.0",
+		"(GPL-2.0-or-later WITH Classpath-exception-2.0) or CC0-1.0 AND Zlib",
+		"DocumentRef-spdx-tool-1.2:LicenseRef-MIT-Style-2",
+	} {
+		assert.NoError(t, CheckLicense(license), license)
+	}
+	for _, license := range []string{
+		"MIT\npackage evil",
+		"MIT */ int evil; /*",
+		"MIT OR",
+		"(MIT",
+		"MIT Apache-2.0",
+		"AND",
+		" ",
+	} {
+		assert.ErrorContains(t, CheckLicense(license), "invalid license", license)
+	}
+
+	source := `meta:
+  id: evil
+  license: "MIT */ int evil; /*"
+`
+	_, err := ParseStructFile(bytes.NewBufferString(source), "evil.ksy")
+	assert.ErrorContains(t, err, "evil.ksy:3:12: invalid license")
+}
+
+func TestParseDocs(t *testing.T) {
+	source := `meta:
+  id: docs
+doc: Top-level type.
+doc-ref: https://example.com/spec Spec
+seq:
+  - id: kind
+    type: u1
+    enum: kinds
+    doc: The kind.
+    doc-ref:
+      - Section 2
+      - https://example.com/kinds
+enums:
+  kinds:
+    1:
+      id: one
+      doc: The first kind.
+      doc-ref: Table 3
+    2: two
+`
+	s, err := ParseStruct(bytes.NewBufferString(source))
+	require.NoError(t, err)
+	assert.Equal(t, "Top-level type.", s.Doc)
+	assert.Equal(t, []string{"https://example.com/spec Spec"}, s.DocRef)
+	require.Len(t, s.Seq, 1)
+	assert.Equal(t, "The kind.", s.Seq[0].Doc)
+	assert.Equal(t, []string{"Section 2", "https://example.com/kinds"}, s.Seq[0].DocRef)
+	require.Len(t, s.Enums, 1)
+	require.Len(t, s.Enums[0].Values, 2)
+	assert.Equal(t, "The first kind.", s.Enums[0].Values[0].Doc)
+	assert.Equal(t, []string{"Table 3"}, s.Enums[0].Values[0].DocRef)
+	assert.Empty(t, s.Enums[0].Values[1].Doc)
+}
+
+func TestParseExtensions(t *testing.T) {
+	source := `meta:
+  id: ext
+-webide-representation: "{kind}"
+seq:
+  - id: kind
+    type: u1
+    enum: kinds
+    -orig-id: bKind
+instances:
+  twice:
+    value: kind.to_i * 2
+    -webide-parse-mode: eager
+enums:
+  kinds:
+    1:
+      id: one
+      -orig-id: KIND_ONE
+    2: two
+`
+	s, err := ParseStruct(bytes.NewBufferString(source))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"-webide-representation": "{kind}"}, s.Extensions)
+	assert.Equal(t, map[string]any{"-orig-id": "bKind"}, s.Seq[0].Extensions)
+	assert.Equal(t, map[string]any{"-webide-parse-mode": "eager"}, s.Instances[0].Extensions)
+	assert.Equal(t, map[string]any{"-orig-id": "KIND_ONE"}, s.Enums[0].Values[0].Extensions)
+	assert.Nil(t, s.Enums[0].Values[1].Extensions)
+}
@@ -0,0 +1,141 @@
+package resolve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// IsArchive reports whether name has the extension of an archive format
+// NewArchiveFS understands: .zip, .tar, .tar.gz or .tgz.
+func IsArchive(name string) bool {
+	return archiveKind(name) != ""
+}
+
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz"
+	}
+	return ""
+}
+
+// NewArchiveResolver creates a resolver that reads .ksy files from the .zip
+// or tar archive at name. The archive is read into memory once; paths inside
+// it are resolved like NewFSResolver does. To use a subdirectory of the
+// archive as the root, pass fs.Sub of NewArchiveFS to NewFSResolver instead.
+func NewArchiveResolver(name string) (Resolver, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := NewArchiveFS(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewFSResolver(fsys), nil
+}
+
+// NewArchiveFS returns the files of an in-memory archive as an fs.FS. The
+// format is chosen by the extension of name, as with IsArchive.
+func NewArchiveFS(name string, data []byte) (fs.FS, error) {
+	switch archiveKind(name) {
+	case "zip":
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %w", name, err)
+		}
+		return r, nil
+	case "tar":
+		return readTar(name, bytes.NewReader(data))
+	case "tgz":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %w", name, err)
+		}
+		return readTar(name, gz)
+	}
+	return nil, fmt.Errorf("%q is not a .zip or tar archive", name)
+}
+
+func readTar(name string, r io.Reader) (fs.FS, error) {
+	fsys := memFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %w", name, err)
+		}
+		fileName := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		fsys[fileName] = &memFile{
+			name:    path.Base(fileName),
+			data:    data,
+			modTime: hdr.ModTime,
+		}
+	}
+}
+
+// memFS is a flat, read-only fs.FS of in-memory files. It doesn't support
+// listing directories.
+type memFS map[string]*memFile
+
+func (fsys memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := fsys[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.Open(), nil
+}
+
+// memFile is an in-memory file. It is also its own fs.FileInfo.
+type memFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+func (f *memFile) Open() fs.File {
+	return &openMemFile{memFile: f, Reader: bytes.NewReader(f.data)}
+}
+
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode  { return 0o444 }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return false }
+func (f *memFile) Sys() any           { return nil }
+
+type openMemFile struct {
+	*memFile
+	*bytes.Reader
+}
+
+func (f *openMemFile) Stat() (fs.FileInfo, error) { return f.memFile, nil }
+func (f *openMemFile) Close() error               { return nil }
@@ -0,0 +1,53 @@
+package resolve
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/jchv/zanbato/kaitai"
+)
+
+type chainResolver struct {
+	resolvers []Resolver
+}
+
+// NewChainResolver creates a resolver that tries each of resolvers in turn,
+// moving on to the next one only when an import is not found. The first
+// resolver that finds the file wins, even if the file fails to parse.
+func NewChainResolver(resolvers ...Resolver) Resolver {
+	return &chainResolver{resolvers: resolvers}
+}
+
+func (resolver *chainResolver) Resolve(from, to string) (string, *kaitai.Struct, error) {
+	var errs []error
+	for _, r := range resolver.resolvers {
+		name, struc, err := r.Resolve(from, to)
+		if err == nil {
+			return name, struc, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", nil, err
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return "", nil, &notFoundError{from: from, to: to}
+	}
+	return "", nil, errors.Join(errs...)
+}
+
+// ReadSource implements SourceReader, reading the file from the first
+// resolver that has it.
+func (resolver *chainResolver) ReadSource(file string) ([]byte, error) {
+	for _, r := range resolver.resolvers {
+		sr, ok := r.(SourceReader)
+		if !ok {
+			continue
+		}
+		data, err := sr.ReadSource(file)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: file, Err: fs.ErrNotExist}
+}
@@ -26,9 +26,9 @@ func (f *ImportPathsFlag) Set(s string) error {
 // RegisterImportPathsFlag binds a repeatable -I flag on fs that gathers
 // additional import-search paths. Pass flag.CommandLine when using the
 // default flag set. The returned pointer is populated by flag.Parse and
-// can be passed directly to NewOSResolverWithPaths.
+// can be passed directly to NewImportPathsResolver.
 func RegisterImportPathsFlag(fs *flag.FlagSet) *ImportPathsFlag {
 	paths := &ImportPathsFlag{}
-	fs.Var(paths, "I", "Additional import search path (repeatable)")
+	fs.Var(paths, "I", "Additional import search path or .zip/tar archive (repeatable)")
 	return paths
 }
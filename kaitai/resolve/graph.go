@@ -0,0 +1,218 @@
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/srcpos"
+)
+
+// Module is a single .ksy file in an import graph.
+type Module struct {
+	// Name is the name the module was resolved as. Relative imports in the
+	// module are resolved against it.
+	Name   string
+	Struct *kaitai.Struct
+
+	// Imports are the modules this module imports, in declaration order.
+	// Imports that failed to load are left out; see Graph.Failed.
+	Imports []*Module
+}
+
+// ImportError describes an import that could not be loaded.
+type ImportError struct {
+	// From is the name of the importing module.
+	From string
+	// Import is the import as written in the importing module.
+	Import string
+	// Pos is where the import is declared.
+	Pos srcpos.Pos
+	Err error
+}
+
+func (e *ImportError) Error() string {
+	msg := fmt.Sprintf("cannot import %q: %v", e.Import, e.Err)
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + msg
+	}
+	return e.From + ": " + msg
+}
+
+func (e *ImportError) Unwrap() error { return e.Err }
+
+// Cycle is a chain of modules that import each other: each module imports
+// the next one, and the last one imports the first.
+type Cycle []*Module
+
+// String formats the cycle as "a -> b -> a".
+func (c Cycle) String() string {
+	names := make([]string, 0, len(c)+1)
+	for _, m := range c {
+		names = append(names, m.Name)
+	}
+	if len(c) > 0 {
+		names = append(names, c[0].Name)
+	}
+	return strings.Join(names, " -> ")
+}
+
+type importKey struct {
+	from, name string
+}
+
+// Graph is a root .ksy file together with everything it imports,
+// transitively.
+type Graph struct {
+	Root *Module
+
+	// Failed lists the imports that could not be loaded, in the order they
+	// were encountered.
+	Failed []*ImportError
+
+	// Cycles lists the import cycles in the graph. Cycles are not errors;
+	// they are reported for tools that want to warn about them.
+	Cycles []Cycle
+
+	resolver Resolver
+	modules  map[string]*Module
+	imports  map[importKey]*Module
+	failed   map[importKey]*ImportError
+	order    []*Module
+}
+
+// NewGraph loads the import closure of root, which was resolved as
+// inputName. Failed imports are recorded in the graph rather than returned;
+// use Err to check for them.
+func NewGraph(resolver Resolver, inputName string, root *kaitai.Struct) *Graph {
+	g := &Graph{
+		resolver: resolver,
+		modules:  make(map[string]*Module),
+		imports:  make(map[importKey]*Module),
+		failed:   make(map[importKey]*ImportError),
+	}
+	g.Root = g.load(inputName, root)
+	g.sort()
+	return g
+}
+
+// LoadGraph resolves the root .ksy file name and loads its import closure.
+// It only returns an error if the root itself can't be loaded.
+func LoadGraph(resolver Resolver, name string) (*Graph, error) {
+	inputName, root, err := resolver.Resolve("", name)
+	if err != nil {
+		return nil, err
+	}
+	return NewGraph(resolver, inputName, root), nil
+}
+
+// Err returns an error describing every failed import, or nil if all
+// imports were loaded.
+func (g *Graph) Err() error {
+	errs := make([]error, 0, len(g.Failed))
+	for _, err := range g.Failed {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Modules returns every module in the graph in dependency order: each module
+// comes after the modules it imports, and the root comes last. Within an
+// import cycle, the order follows declaration order.
+func (g *Graph) Modules() []*Module {
+	return g.order
+}
+
+// Module returns the module that was resolved as name, or nil.
+func (g *Graph) Module(name string) *Module {
+	return g.modules[name]
+}
+
+// Import returns the module that from imports as name. If the import
+// failed, the returned error is its *ImportError.
+func (g *Graph) Import(from, name string) (*Module, error) {
+	if m, ok := g.imports[importKey{from, name}]; ok {
+		return m, nil
+	}
+	if err, ok := g.failed[importKey{from, name}]; ok {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s does not import %q", from, name)
+}
+
+func (g *Graph) load(name string, s *kaitai.Struct) *Module {
+	if m, ok := g.modules[name]; ok {
+		return m
+	}
+	m := &Module{Name: name, Struct: s}
+	g.modules[name] = m
+	forEachImport(s, func(imp string, pos srcpos.Pos) {
+		key := importKey{name, imp}
+		if _, ok := g.imports[key]; ok {
+			return
+		}
+		if _, ok := g.failed[key]; ok {
+			return
+		}
+		resolvedName, imported, err := g.resolver.Resolve(name, imp)
+		if err != nil {
+			importErr := &ImportError{From: name, Import: imp, Pos: pos, Err: err}
+			g.failed[key] = importErr
+			g.Failed = append(g.Failed, importErr)
+			return
+		}
+		child := g.load(resolvedName, imported)
+		g.imports[key] = child
+		m.Imports = append(m.Imports, child)
+	})
+	return m
+}
+
+// forEachImport calls fn for each import of s and its nested types.
+func forEachImport(s *kaitai.Struct, fn func(imp string, pos srcpos.Pos)) {
+	for i, imp := range s.Meta.Imports {
+		pos := s.Source
+		if i < len(s.Meta.ImportSources) {
+			pos = s.Meta.ImportSources[i]
+		}
+		fn(imp, pos)
+	}
+	for _, child := range s.Structs {
+		forEachImport(child, fn)
+	}
+}
+
+// sort computes the dependency order with a depth-first walk, recording a
+// cycle whenever an import leads back to a module still being walked.
+func (g *Graph) sort() {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[*Module]int)
+	var stack []*Module
+	var visit func(m *Module)
+	visit = func(m *Module) {
+		state[m] = visiting
+		stack = append(stack, m)
+		for _, imp := range m.Imports {
+			switch state[imp] {
+			case unvisited:
+				visit(imp)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == imp {
+						g.Cycles = append(g.Cycles, append(Cycle(nil), stack[i:]...))
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[m] = done
+		g.order = append(g.order, m)
+	}
+	visit(g.Root)
+}
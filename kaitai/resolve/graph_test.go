@@ -0,0 +1,131 @@
+package resolve
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestGraph(t *testing.T, files map[string]string) *Graph {
+	t.Helper()
+	fsys := fstest.MapFS{}
+	for name, src := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(src)}
+	}
+	graph, err := LoadGraph(NewFSResolver(fsys), "main.ksy")
+	require.NoError(t, err)
+	return graph
+}
+
+func moduleIDs(modules []*Module) []string {
+	result := []string{}
+	for _, m := range modules {
+		result = append(result, string(m.Struct.ID))
+	}
+	return result
+}
+
+func TestGraphOrder(t *testing.T) {
+	graph := loadTestGraph(t, map[string]string{
+		"main.ksy": `
+meta:
+  id: main
+  imports:
+    - left
+    - right
+`,
+		"left.ksy": `
+meta:
+  id: left
+  imports:
+    - common
+`,
+		"right.ksy": `
+meta:
+  id: right
+  imports:
+    - common
+`,
+		"common.ksy": `
+meta:
+  id: common
+`,
+	})
+	require.NoError(t, graph.Err())
+	assert.Empty(t, graph.Cycles)
+	assert.Equal(t, []string{"common", "left", "right", "main"}, moduleIDs(graph.Modules()))
+	assert.Equal(t, "main", string(graph.Root.Struct.ID))
+	assert.Equal(t, []string{"left", "right"}, moduleIDs(graph.Root.Imports))
+
+	left, err := graph.Import(graph.Root.Name, "left")
+	require.NoError(t, err)
+	common, err := graph.Import(left.Name, "common")
+	require.NoError(t, err)
+	assert.Same(t, graph.Module(common.Name), common)
+	assert.Same(t, common, graph.Module(graph.Modules()[0].Name))
+
+	_, err = graph.Import(graph.Root.Name, "common")
+	assert.Error(t, err)
+}
+
+func TestGraphFailedImport(t *testing.T) {
+	graph := loadTestGraph(t, map[string]string{
+		"main.ksy": `
+meta:
+  id: main
+  imports:
+    - dep
+    - missing
+`,
+		"dep.ksy": `
+meta:
+  id: dep
+`,
+	})
+	require.Len(t, graph.Failed, 1)
+	failed := graph.Failed[0]
+	assert.Equal(t, graph.Root.Name, failed.From)
+	assert.Equal(t, "missing", failed.Import)
+	assert.Equal(t, "main.ksy", failed.Pos.File)
+	assert.Equal(t, 6, failed.Pos.Line)
+	assert.Equal(t, []string{"dep", "main"}, moduleIDs(graph.Modules()))
+
+	err := graph.Err()
+	require.Error(t, err)
+	var importErr *ImportError
+	require.True(t, errors.As(err, &importErr))
+	assert.Same(t, failed, importErr)
+
+	_, err = graph.Import(graph.Root.Name, "missing")
+	assert.Same(t, failed, err)
+}
+
+func TestGraphCycle(t *testing.T) {
+	graph := loadTestGraph(t, map[string]string{
+		"main.ksy": `
+meta:
+  id: main
+  imports:
+    - a
+`,
+		"a.ksy": `
+meta:
+  id: a
+  imports:
+    - b
+`,
+		"b.ksy": `
+meta:
+  id: b
+  imports:
+    - a
+`,
+	})
+	require.NoError(t, graph.Err())
+	require.Len(t, graph.Cycles, 1)
+	assert.Equal(t, "a -> b -> a", graph.Cycles[0].String())
+	assert.Equal(t, []string{"b", "a", "main"}, moduleIDs(graph.Modules()))
+}
@@ -0,0 +1,78 @@
+package resolve
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jchv/zanbato/kaitai"
+)
+
+// OverlayResolver serves in-memory files, such as unsaved editor buffers, on
+// top of another resolver. Imports are first looked up among the overlay's
+// files, named the same way the base resolver would open them (for example
+// "formats/foo.ksy"), and fall through to the base resolver when no overlay
+// file matches.
+type OverlayResolver struct {
+	files *fileResolver
+	chain Resolver
+
+	mu      sync.RWMutex
+	buffers map[string]*memFile
+}
+
+// NewOverlayResolver creates an overlay on top of base. importPaths are
+// searched for absolute imports among the overlay's files and should match
+// the ones base was created with.
+func NewOverlayResolver(base Resolver, importPaths []string) *OverlayResolver {
+	o := &OverlayResolver{buffers: make(map[string]*memFile)}
+	o.files = &fileResolver{
+		cache:       make(map[string]*cacheEntry),
+		importPaths: importPaths,
+		open:        o.open,
+	}
+	o.chain = NewChainResolver(o.files, base)
+	return o
+}
+
+// SetFile sets the contents of the overlay file name, replacing any previous
+// contents.
+func (o *OverlayResolver) SetFile(name string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buffers[path.Clean(name)] = &memFile{
+		name:    path.Base(name),
+		data:    bytes.Clone(data),
+		modTime: time.Now(),
+	}
+}
+
+// RemoveFile removes the overlay file name, so that the base resolver's copy
+// is used again.
+func (o *OverlayResolver) RemoveFile(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.buffers, path.Clean(name))
+}
+
+// Resolve implements Resolver.
+func (o *OverlayResolver) Resolve(from, to string) (string, *kaitai.Struct, error) {
+	return o.chain.Resolve(from, to)
+}
+
+// ReadSource implements SourceReader.
+func (o *OverlayResolver) ReadSource(file string) ([]byte, error) {
+	return o.chain.(SourceReader).ReadSource(file)
+}
+
+func (o *OverlayResolver) open(name string) (fs.File, error) {
+	o.mu.RLock()
+	f, ok := o.buffers[path.Clean(name)]
+	o.mu.RUnlock()
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.Open(), nil
+}
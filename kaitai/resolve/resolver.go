@@ -1,31 +1,78 @@
 package resolve
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jchv/zanbato/kaitai"
+	"github.com/jchv/zanbato/kaitai/srcpos"
 )
 
+// Resolver loads .ksy files by import name.
+//
+// Resolve resolves the import to as written in the module that was resolved
+// as from (or "" for a root file), returning the name the imported module was
+// resolved as and its parsed struct. Resolvers are safe for concurrent use.
 type Resolver interface {
 	Resolve(from, to string) (string, *kaitai.Struct, error)
 }
 
+// SourceReader is implemented by resolvers that can return the contents of
+// the files they load. file is a file name as recorded in the positions of
+// the loaded structs, such as Struct.Source.File.
+type SourceReader interface {
+	ReadSource(file string) ([]byte, error)
+}
+
+// ErrNotFound is matched (via errors.Is) by the error a resolver returns when
+// no file exists for an import. Other errors, such as a file that fails to
+// parse, mean the file was found.
+var ErrNotFound = errors.New("not found")
+
+type notFoundError struct {
+	from, to   string
+	candidates []string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("failed to load struct %s from %s (checked %v)", e.to, e.from, e.candidates)
+}
+
+func (e *notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// cacheEntry is a parsed file along with what is needed to tell whether the
+// file has changed since.
+type cacheEntry struct {
+	struc   *kaitai.Struct
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
 type fileResolver struct {
-	cache       map[string]*kaitai.Struct
-	open        func(name string) (io.ReadCloser, error)
+	mu          sync.Mutex
+	cache       map[string]*cacheEntry // keyed by file name
+	open        func(name string) (fs.File, error)
 	importPaths []string // directories to search for absolute imports (starting with /)
 }
 
+func openOS(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
 func NewOSResolver() Resolver {
 	return &fileResolver{
-		cache: make(map[string]*kaitai.Struct),
-		open: func(name string) (io.ReadCloser, error) {
-			return os.Open(name)
-		},
+		cache: make(map[string]*cacheEntry),
+		open:  openOS,
 	}
 }
 
@@ -33,24 +80,49 @@ func NewOSResolver() Resolver {
 // directories for absolute imports (import paths starting with /).
 func NewOSResolverWithPaths(importPaths []string) Resolver {
 	return &fileResolver{
-		cache:       make(map[string]*kaitai.Struct),
+		cache:       make(map[string]*cacheEntry),
 		importPaths: importPaths,
-		open: func(name string) (io.ReadCloser, error) {
-			return os.Open(name)
-		},
+		open:        openOS,
 	}
 }
 
 func NewFSResolver(fs fs.FS) Resolver {
 	return &fileResolver{
-		cache:       make(map[string]*kaitai.Struct),
+		cache:       make(map[string]*cacheEntry),
 		importPaths: []string{"."},
-		open: func(name string) (io.ReadCloser, error) {
-			return fs.Open(name)
-		},
+		open:        fs.Open,
 	}
 }
 
+// NewImportPathsResolver creates a resolver for the given import paths, as
+// gathered by RegisterImportPathsFlag. Directories are searched like
+// NewOSResolverWithPaths does; paths naming a .zip or tar archive are opened
+// with NewArchiveResolver and searched after the filesystem, in order.
+func NewImportPathsResolver(importPaths []string) (Resolver, error) {
+	var dirs []string
+	var archives []Resolver
+	for _, p := range importPaths {
+		if !IsArchive(p) {
+			dirs = append(dirs, p)
+			continue
+		}
+		archive, err := NewArchiveResolver(p)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	resolver := NewOSResolverWithPaths(dirs)
+	if len(archives) == 0 {
+		return resolver, nil
+	}
+	return NewChainResolver(append([]Resolver{resolver}, archives...)...), nil
+}
+
+// Resolve implements Resolver. Parsed files are cached; a cached file is
+// reused as long as its modification time and size are unchanged, or, when
+// those differ or the file system doesn't report a modification time, as long
+// as its contents hash the same.
 func (resolver *fileResolver) Resolve(from, to string) (string, *kaitai.Struct, error) {
 	basename := to
 	isAbsolute := len(to) > 0 && to[0] == '/'
@@ -60,9 +132,6 @@ func (resolver *fileResolver) Resolve(from, to string) (string, *kaitai.Struct,
 	} else if from != "" {
 		basename = path.Join(path.Dir(from), to)
 	}
-	if cachedStruct, ok := resolver.cache[basename]; ok {
-		return basename, cachedStruct, nil
-	}
 
 	var candidates []string
 	if isAbsolute {
@@ -76,23 +145,113 @@ func (resolver *fileResolver) Resolve(from, to string) (string, *kaitai.Struct,
 	}
 
 	for _, name := range candidates {
-		file, err := resolver.open(name)
+		// Use the full path as basename so relative imports from this file
+		// resolve correctly relative to its actual filesystem location.
+		resolvedBasename := strings.TrimSuffix(name, ".ksy")
+		if cachedStruct := resolver.cached(name); cachedStruct != nil {
+			return resolvedBasename, cachedStruct, nil
+		}
+		data, info, err := resolver.read(name)
 		if err != nil {
 			continue
 		}
-		defer func() { _ = file.Close() }()
-		struc, err := kaitai.ParseStruct(file)
+		struc, err := kaitai.ParseStructFile(bytes.NewReader(data), name)
 		if err != nil {
+			// Errors with a source position already name the file.
+			if _, ok := srcpos.Of(err); ok {
+				return "", nil, err
+			}
 			return "", nil, fmt.Errorf("error loading %q: %w", name, err)
 		}
-		// Use the full path as basename so relative imports from this file
-		// resolve correctly relative to its actual filesystem location.
-		resolvedBasename := name
-		if path.Ext(resolvedBasename) == ".ksy" {
-			resolvedBasename = resolvedBasename[:len(resolvedBasename)-4]
+		resolver.mu.Lock()
+		resolver.cache[name] = &cacheEntry{
+			struc:   struc,
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			sum:     sha256.Sum256(data),
 		}
-		resolver.cache[resolvedBasename] = struc
+		resolver.mu.Unlock()
 		return resolvedBasename, struc, nil
 	}
-	return "", nil, fmt.Errorf("failed to load struct %s from %s (checked %v)", to, from, candidates)
+	return "", nil, &notFoundError{from: from, to: to, candidates: candidates}
+}
+
+// ReadSource implements SourceReader.
+func (resolver *fileResolver) ReadSource(file string) ([]byte, error) {
+	data, _, err := resolver.read(file)
+	return data, err
+}
+
+// read returns the contents of the file name, along with its FileInfo.
+func (resolver *fileResolver) read(name string) ([]byte, fs.FileInfo, error) {
+	file, err := resolver.open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is a directory", name)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// cached returns the cached struct for the file name if it is still up to
+// date, or nil. Stale entries are dropped.
+func (resolver *fileResolver) cached(name string) *kaitai.Struct {
+	resolver.mu.Lock()
+	entry := resolver.cache[name]
+	resolver.mu.Unlock()
+	if entry == nil {
+		return nil
+	}
+
+	valid, modTime := resolver.validate(name, entry)
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	if current := resolver.cache[name]; current != entry {
+		// Another goroutine reloaded or dropped the entry meanwhile.
+		if current != nil {
+			return current.struc
+		}
+		return nil
+	}
+	if !valid {
+		delete(resolver.cache, name)
+		return nil
+	}
+	entry.modTime = modTime
+	return entry.struc
+}
+
+// validate reports whether the file name still matches entry, and its
+// current modification time.
+func (resolver *fileResolver) validate(name string, entry *cacheEntry) (bool, time.Time) {
+	file, err := resolver.open(name)
+	if err != nil {
+		return false, time.Time{}
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return false, time.Time{}
+	}
+	if info.Size() != entry.size {
+		return false, time.Time{}
+	}
+	if !info.ModTime().IsZero() && info.ModTime().Equal(entry.modTime) {
+		return true, entry.modTime
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return false, time.Time{}
+	}
+	return bytes.Equal(h.Sum(nil), entry.sum[:]), info.ModTime()
 }
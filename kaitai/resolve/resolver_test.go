@@ -0,0 +1,198 @@
+package resolve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ksyFile(id string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte("meta:\n  id: " + id + "\n")}
+}
+
+func TestFSResolverInvalidation(t *testing.T) {
+	fsys := fstest.MapFS{"main.ksy": ksyFile("first")}
+	resolver := NewFSResolver(fsys)
+
+	name, first, err := resolver.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "main", name)
+	assert.Equal(t, "first", string(first.ID))
+
+	// Unchanged contents keep the cached struct.
+	_, again, err := resolver.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Same(t, first, again)
+
+	fsys["main.ksy"] = ksyFile("second")
+	_, second, err := resolver.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(second.ID))
+
+	delete(fsys, "main.ksy")
+	_, _, err = resolver.Resolve("", "main")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOSResolverInvalidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	file := "main.ksy"
+	require.NoError(t, os.WriteFile(file, []byte("meta:\n  id: aaaa\n"), 0o644))
+	resolver := NewOSResolver()
+
+	_, first, err := resolver.Resolve("", file)
+	require.NoError(t, err)
+	assert.Equal(t, "aaaa", string(first.ID))
+
+	// Same size, so only the modification time gives the change away.
+	require.NoError(t, os.WriteFile(file, []byte("meta:\n  id: bbbb\n"), 0o644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, later, later))
+	_, second, err := resolver.Resolve("", file)
+	require.NoError(t, err)
+	assert.Equal(t, "bbbb", string(second.ID))
+
+	// Touching the file without changing it keeps the cached struct.
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, later, later))
+	_, third, err := resolver.Resolve("", file)
+	require.NoError(t, err)
+	assert.Same(t, second, third)
+}
+
+func TestResolverConcurrent(t *testing.T) {
+	resolver := NewFSResolver(fstest.MapFS{
+		"a.ksy": ksyFile("a"),
+		"b.ksy": ksyFile("b"),
+	})
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Go(func() {
+			for range 10 {
+				name := []string{"a", "b"}[i%2]
+				_, struc, err := resolver.Resolve("", name)
+				assert.NoError(t, err)
+				assert.Equal(t, name, string(struc.ID))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestChainResolver(t *testing.T) {
+	first := NewFSResolver(fstest.MapFS{
+		"a.ksy":      ksyFile("a_first"),
+		"broken.ksy": &fstest.MapFile{Data: []byte("meta: [")},
+	})
+	second := NewFSResolver(fstest.MapFS{
+		"a.ksy":      ksyFile("a_second"),
+		"b.ksy":      ksyFile("b_second"),
+		"broken.ksy": ksyFile("broken"),
+	})
+	resolver := NewChainResolver(first, second)
+
+	_, a, err := resolver.Resolve("", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a_first", string(a.ID))
+
+	_, b, err := resolver.Resolve("", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "b_second", string(b.ID))
+
+	// Parse errors don't fall through to later resolvers.
+	_, _, err = resolver.Resolve("", "broken")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+
+	_, _, err = resolver.Resolve("", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOverlayResolver(t *testing.T) {
+	base := NewFSResolver(fstest.MapFS{
+		"main.ksy":    ksyFile("disk_main"),
+		"lib/dep.ksy": ksyFile("disk_dep"),
+	})
+	overlay := NewOverlayResolver(base, []string{"."})
+
+	_, main, err := overlay.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "disk_main", string(main.ID))
+
+	overlay.SetFile("main.ksy", []byte("meta:\n  id: buffer_main\n"))
+	overlay.SetFile("lib/dep.ksy", []byte("meta:\n  id: buffer_dep\n"))
+	_, main, err = overlay.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "buffer_main", string(main.ID))
+	_, dep, err := overlay.Resolve("", "/lib/dep")
+	require.NoError(t, err)
+	assert.Equal(t, "buffer_dep", string(dep.ID))
+
+	overlay.SetFile("main.ksy", []byte("meta:\n  id: edited_main\n"))
+	_, main, err = overlay.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "edited_main", string(main.ID))
+
+	overlay.RemoveFile("main.ksy")
+	_, main, err = overlay.Resolve("", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "disk_main", string(main.ID))
+}
+
+func TestArchiveFS(t *testing.T) {
+	files := map[string]string{
+		"main.ksy":    "meta:\n  id: main\n  imports:\n    - lib/dep\n",
+		"lib/dep.ksy": "meta:\n  id: dep\n",
+	}
+
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	for name, src := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(src))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	var tarData bytes.Buffer
+	tw := tar.NewWriter(&tarData)
+	for name, src := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     "./" + name,
+			Mode:     0o644,
+			Size:     int64(len(src)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(src))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	for name, data := range map[string][]byte{
+		"formats.zip": zipData.Bytes(),
+		"formats.tar": tarData.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, IsArchive(name))
+			fsys, err := NewArchiveFS(name, data)
+			require.NoError(t, err)
+			graph, err := LoadGraph(NewFSResolver(fsys), "main")
+			require.NoError(t, err)
+			require.NoError(t, graph.Err())
+			assert.Equal(t, []string{"dep", "main"}, moduleIDs(graph.Modules()))
+		})
+	}
+
+	_, err := NewArchiveFS("formats.ksy", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+// Package srcpos describes positions inside .ksy source files.
+//
+// Positions are captured from the YAML document when a schema is parsed and
+// carried on the schema model so that later stages (type resolution, code
+// generation, runtime evaluation) can point back at the offending line.
+package srcpos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Pos is a position in a .ksy file. Line and Column are 1-based; the zero
+// value means the position is unknown.
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// IsValid returns true if the position refers to an actual location.
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+// String formats the position as file:line:column. The file is omitted if
+// unknown, and an unknown position formats as "-".
+func (p Pos) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+		return "-"
+	}
+	s := strconv.Itoa(p.Line)
+	if p.Column > 0 {
+		s += ":" + strconv.Itoa(p.Column)
+	}
+	if p.File != "" {
+		s = p.File + ":" + s
+	}
+	return s
+}
+
+// WithFile returns a copy of p with the file name set.
+func (p Pos) WithFile(file string) Pos {
+	p.File = file
+	return p
+}
+
+// Error is an error attributed to a position in a .ksy file.
+type Error struct {
+	Pos Pos
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap attributes err to pos. If pos is unknown, or err already carries a
+// position (which is assumed to be more precise), err is returned unchanged.
+func Wrap(pos Pos, err error) error {
+	if err == nil || !pos.IsValid() {
+		return err
+	}
+	var existing *Error
+	if errors.As(err, &existing) {
+		return err
+	}
+	return &Error{Pos: pos, Err: err}
+}
+
+// Errorf formats an error and attributes it to pos.
+func Errorf(pos Pos, format string, args ...any) error {
+	return Wrap(pos, fmt.Errorf(format, args...))
+}
+
+// Of returns the position attached to err, if any.
+func Of(err error) (Pos, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Pos, true
+	}
+	return Pos{}, false
+}
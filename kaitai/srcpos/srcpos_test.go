@@ -0,0 +1,35 @@
+package srcpos
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPosString(t *testing.T) {
+	assert.Equal(t, "-", Pos{}.String())
+	assert.Equal(t, "a.ksy", Pos{File: "a.ksy"}.String())
+	assert.Equal(t, "3:7", Pos{Line: 3, Column: 7}.String())
+	assert.Equal(t, "a.ksy:3:7", Pos{File: "a.ksy", Line: 3, Column: 7}.String())
+}
+
+func TestWrap(t *testing.T) {
+	base := errors.New("boom")
+	inner := Pos{File: "a.ksy", Line: 5, Column: 3}
+	outer := Pos{File: "a.ksy", Line: 1, Column: 1}
+
+	assert.Nil(t, Wrap(inner, nil))
+	assert.Same(t, base, Wrap(Pos{}, base))
+
+	err := Wrap(inner, base)
+	assert.EqualError(t, err, "a.ksy:5:3: boom")
+	assert.ErrorIs(t, err, base)
+
+	// The innermost position is kept.
+	err = Wrap(outer, err)
+	assert.EqualError(t, err, "a.ksy:5:3: boom")
+	pos, ok := Of(err)
+	assert.True(t, ok)
+	assert.Equal(t, inner, pos)
+}
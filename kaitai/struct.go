@@ -1,15 +1,22 @@
 package kaitai
 
-import "github.com/jchv/zanbato/kaitai/types"
+import (
+	"github.com/jchv/zanbato/kaitai/srcpos"
+	"github.com/jchv/zanbato/kaitai/types"
+)
 
 type Identifier = types.Identifier
 
 // Param specifies a parameter to a struct.
 type Param struct {
-	Doc  string
-	ID   Identifier
-	Type types.TypeRef
-	Enum string
+	Doc    string
+	DocRef []string
+	ID     Identifier
+	Type   types.TypeRef
+	Enum   string
+
+	// Source is where the parameter is declared in the .ksy file.
+	Source srcpos.Pos
 }
 
 // Meta contains the relevant metadata information.
@@ -20,11 +27,24 @@ type Meta struct {
 	Encoding    string
 	OpaqueTypes bool
 	Debug       bool
+
+	// ImportSources holds the position of each entry of Imports.
+	ImportSources []srcpos.Pos
+
+	// Descriptive metadata. These don't affect parsing, but are useful for
+	// tooling and are carried through to generated code where appropriate.
+	Title         string
+	Application   []string
+	FileExtension []string
+	License       string
+	KSVersion     string
+	Xref          map[string]any
 }
 
 // Struct contains a Kaitai struct.
 type Struct struct {
 	Doc       string
+	DocRef    []string
 	Meta      Meta
 	ID        Identifier
 	Params    []*Param
@@ -33,4 +53,11 @@ type Struct struct {
 	Structs   []*Struct
 	Enums     []*Enum
 	ToString  string
+
+	// Extensions holds the struct's vendor extension keys, such as
+	// `-webide-representation`, keyed with their leading dash.
+	Extensions map[string]any
+
+	// Source is where the struct is declared in the .ksy file.
+	Source srcpos.Pos
 }
@@ -564,11 +564,12 @@ func parseUserType(typestr string) (TypeRef, error) {
 			return TypeRef{}, errors.New("missing ) in type params")
 		}
 		result.Name = typestr[:i]
-		params := splitParamsAware(typestr[i+1 : j])
-		for i, src := range params {
-			param, err := expr.ParseExpr(src)
+		// Params are parsed in place, so their spans are offsets into the
+		// whole type string.
+		for n, span := range splitParamsAware(typestr[i+1 : j]) {
+			param, err := expr.ParseSubExpr(typestr, i+1+span.Start, i+1+span.End)
 			if err != nil {
-				return TypeRef{}, fmt.Errorf("in parameter %d of %s: %w", i+1, result.Name, err)
+				return TypeRef{}, fmt.Errorf("in parameter %d of %s: %w", n+1, result.Name, err)
 			}
 			result.Params = append(result.Params, param)
 		}
@@ -577,13 +578,14 @@ func parseUserType(typestr string) (TypeRef, error) {
 }
 
 // splitParamsAware splits a param string on commas, but respects nested
-// brackets and parentheses so that expressions like [a, b] aren't split.
-func splitParamsAware(s string) []string {
+// brackets and parentheses so that expressions like [a, b] aren't split. It
+// returns the extent of each param in s.
+func splitParamsAware(s string) []expr.Span {
 	if strings.TrimSpace(s) == "" {
 		return nil
 	}
 
-	var result []string
+	var result []expr.Span
 	depth := 0
 	start := 0
 	var quote rune
@@ -613,12 +615,12 @@ func splitParamsAware(s string) []string {
 			depth--
 		case ',':
 			if depth == 0 {
-				result = append(result, s[start:i])
+				result = append(result, expr.Span{Start: start, End: i})
 				start = i + 1
 			}
 		}
 	}
-	result = append(result, s[start:])
+	result = append(result, expr.Span{Start: start, End: len(s)})
 	return result
 }
 
@@ -27,8 +27,8 @@ func TestParseTypeRefUserParamsWithQuotedCommas(t *testing.T) {
 			typeSpec: `child("a,b", 3)`,
 			wantName: "child",
 			wantParams: []expr.Node{
-				expr.StringNode{Str: "a,b"},
-				expr.IntNode{Integer: big.NewInt(3)},
+				expr.StringNode{Str: "a,b", Span: expr.Span{Start: 6, End: 11}},
+				expr.IntNode{Integer: big.NewInt(3), Span: expr.Span{Start: 13, End: 14}},
 			},
 		},
 		{
@@ -36,8 +36,8 @@ func TestParseTypeRefUserParamsWithQuotedCommas(t *testing.T) {
 			typeSpec: `child('a,b', 3)`,
 			wantName: "child",
 			wantParams: []expr.Node{
-				expr.StringNode{Str: "a,b"},
-				expr.IntNode{Integer: big.NewInt(3)},
+				expr.StringNode{Str: "a,b", Span: expr.Span{Start: 6, End: 11}},
+				expr.IntNode{Integer: big.NewInt(3), Span: expr.Span{Start: 13, End: 14}},
 			},
 		},
 		{
@@ -45,8 +45,8 @@ func TestParseTypeRefUserParamsWithQuotedCommas(t *testing.T) {
 			typeSpec: `child("a\",b", 3)`,
 			wantName: "child",
 			wantParams: []expr.Node{
-				expr.StringNode{Str: `a",b`},
-				expr.IntNode{Integer: big.NewInt(3)},
+				expr.StringNode{Str: `a",b`, Span: expr.Span{Start: 6, End: 13}},
+				expr.IntNode{Integer: big.NewInt(3), Span: expr.Span{Start: 15, End: 16}},
 			},
 		},
 		{
@@ -55,11 +55,11 @@ func TestParseTypeRefUserParamsWithQuotedCommas(t *testing.T) {
 			wantName: "child",
 			wantParams: []expr.Node{
 				expr.ArrayNode{Items: []expr.Node{
-					expr.IntNode{Integer: big.NewInt(1)},
-					expr.IntNode{Integer: big.NewInt(2)},
-					expr.IntNode{Integer: big.NewInt(3)},
-				}},
-				expr.StringNode{Str: "x,y"},
+					expr.IntNode{Integer: big.NewInt(1), Span: expr.Span{Start: 7, End: 8}},
+					expr.IntNode{Integer: big.NewInt(2), Span: expr.Span{Start: 10, End: 11}},
+					expr.IntNode{Integer: big.NewInt(3), Span: expr.Span{Start: 13, End: 14}},
+				}, Span: expr.Span{Start: 6, End: 15}},
+				expr.StringNode{Str: "x,y", Span: expr.Span{Start: 17, End: 22}},
 			},
 		},
 		{
@@ -67,8 +67,8 @@ func TestParseTypeRefUserParamsWithQuotedCommas(t *testing.T) {
 			typeSpec: `child("a),b", 3)`,
 			wantName: "child",
 			wantParams: []expr.Node{
-				expr.StringNode{Str: "a),b"},
-				expr.IntNode{Integer: big.NewInt(3)},
+				expr.StringNode{Str: "a),b", Span: expr.Span{Start: 6, End: 12}},
+				expr.IntNode{Integer: big.NewInt(3), Span: expr.Span{Start: 14, End: 15}},
 			},
 		},
 	}
@@ -84,6 +84,9 @@ func TestParseTypeRefUserParamsWithQuotedCommas(t *testing.T) {
 
 			for i, want := range test.wantParams {
 				assert.Equal(t, want, got.User.Params[i].Root)
+				// Spans are offsets into the whole type string.
+				span := expr.SpanOf(want)
+				assert.Equal(t, test.typeSpec[span.Start:span.End], got.User.Params[i].SpanText(span))
 			}
 		})
 	}
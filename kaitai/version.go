@@ -0,0 +1,58 @@
+package kaitai
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KSVersion is the newest Kaitai Struct language version Zanbato supports.
+// Specs that declare a newer `ks-version` are rejected.
+const KSVersion = "0.11"
+
+// CheckKSVersion returns an error if version (a `ks-version` value) is
+// malformed or newer than KSVersion. An empty version is accepted.
+func CheckKSVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	want, err := parseKSVersion(version)
+	if err != nil {
+		return err
+	}
+	have, err := parseKSVersion(KSVersion)
+	if err != nil {
+		panic(err)
+	}
+	for i := range max(len(want), len(have)) {
+		var w, h int
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(have) {
+			h = have[i]
+		}
+		if w < h {
+			break
+		}
+		if w > h {
+			return fmt.Errorf("spec requires Kaitai Struct %s, but zanbato only supports up to %s", version, KSVersion)
+		}
+	}
+	return nil
+}
+
+// parseKSVersion splits a version like 0.10 or 0.11-SNAPSHOT into its
+// numeric components. Pre-release suffixes are ignored.
+func parseKSVersion(version string) ([]int, error) {
+	numeric, _, _ := strings.Cut(version, "-")
+	var result []int
+	for part := range strings.SplitSeq(numeric, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid ks-version %q", version)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
@@ -46,13 +46,13 @@ func copyFileForTest(src, dst string) error {
 }
 
 func resolveImports(resolver resolve.Resolver, resolvedStructs map[string]*kaitai.Struct, inputname string, s *kaitai.Struct) {
-	for _, imp := range s.Meta.Imports {
-		impName, impStruc, err := resolver.Resolve(inputname, imp)
-		if err == nil && impStruc != nil {
-			if _, exists := resolvedStructs[string(impStruc.ID)]; !exists {
-				resolvedStructs[string(impStruc.ID)] = impStruc
-				resolveImports(resolver, resolvedStructs, impName, impStruc)
-			}
+	graph := resolve.NewGraph(resolver, inputname, s)
+	for _, m := range graph.Modules() {
+		if m == graph.Root {
+			continue
+		}
+		if _, exists := resolvedStructs[string(m.Struct.ID)]; !exists {
+			resolvedStructs[string(m.Struct.ID)] = m.Struct
 		}
 	}
 }
@@ -1,15 +1,27 @@
 import { useEffect, useMemo, useRef, useState } from "react";
 
-import { type TreeNode, ancestorsOf, formatValue } from "./parseTree";
+import {
+  type TreeNode,
+  ancestorsOf,
+  formatValue,
+  remainingItems,
+} from "./parseTree";
 import "./tree.css";
 
 export interface TreeViewProps {
   root: TreeNode;
   selectedPath: string | null;
   onSelect: (path: string) => void;
+  /** Fetches more elements of the array at `path`; see `remainingItems`. */
+  onLoadMore: (path: string) => void;
 }
 
-export function TreeView({ root, selectedPath, onSelect }: TreeViewProps) {
+export function TreeView({
+  root,
+  selectedPath,
+  onSelect,
+  onLoadMore,
+}: TreeViewProps) {
   const [userExpanded, setUserExpanded] = useState<Set<string>>(
     () => new Set([root.path]),
   );
@@ -50,6 +62,7 @@ export function TreeView({ root, selectedPath, onSelect }: TreeViewProps) {
         selectedPath={selectedPath}
         onSelect={onSelect}
         onToggle={onToggle}
+        onLoadMore={onLoadMore}
         selectedRef={selectedRef}
       />
     </div>
@@ -63,6 +76,7 @@ interface TreeRowsProps {
   selectedPath: string | null;
   onSelect: (path: string) => void;
   onToggle: (path: string) => void;
+  onLoadMore: (path: string) => void;
   selectedRef: React.RefObject<HTMLDivElement | null>;
 }
 
@@ -73,9 +87,12 @@ function TreeRows({
   selectedPath,
   onSelect,
   onToggle,
+  onLoadMore,
   selectedRef,
 }: TreeRowsProps) {
-  const hasChildren = !!(node.children && node.children.length > 0);
+  const remaining = remainingItems(node);
+  const hasChildren =
+    !!(node.children && node.children.length > 0) || remaining > 0;
   const isExpanded = expanded.has(node.path);
   const isSelected = selectedPath === node.path;
   const value = formatValue(node);
@@ -127,9 +144,26 @@ function TreeRows({
               selectedPath={selectedPath}
               onSelect={onSelect}
               onToggle={onToggle}
+              onLoadMore={onLoadMore}
               selectedRef={selectedRef}
             />
           ))}
+          {remaining > 0 && (
+            <div
+              className="tree-row"
+              role="treeitem"
+              style={{ paddingLeft: `${(depth + 1) * 14 + 4}px` }}
+            >
+              <span className="tree-disclosure tree-disclosure--leaf" />
+              <button
+                type="button"
+                className="tree-more"
+                onClick={() => onLoadMore(node.path)}
+              >
+                {`…${remaining} more`}
+              </button>
+            </div>
+          )}
         </>
       )}
     </>
@@ -3,10 +3,13 @@ import { describe, expect, it } from "vitest";
 import {
   type TreeNode,
   ancestorsOf,
+  appendItems,
   findDeepestNodeAtOffset,
   findNodeByPath,
+  formatRepresentation,
   formatValue,
   parseTreeJson,
+  remainingItems,
 } from "./parseTree";
 
 function sampleTree(): TreeNode {
@@ -225,6 +228,18 @@ describe("formatValue", () => {
     ).toBe("[2 items]");
   });
 
+  it("counts the elements of large arrays that weren't sent", () => {
+    expect(
+      formatValue({
+        name: "x",
+        path: "x",
+        kind: "array",
+        length: 5000,
+        children: [{ name: "x", path: "x[0]" }],
+      }),
+    ).toBe("[5000 items]");
+  });
+
   it("renders error labels", () => {
     expect(formatValue({ name: "x", path: "x", error: "out of range" })).toBe(
       "error: out of range",
@@ -257,3 +272,90 @@ describe("formatValue", () => {
     ).toBe("17 (no match in marker)");
   });
 });
+
+describe("formatRepresentation", () => {
+  function header(): TreeNode {
+    return {
+      name: "hdr",
+      path: "hdr",
+      kind: "struct",
+      extensions: {
+        "-webide-representation": "{name} s:{size:dec} f:{flags:flags} {nope}",
+      },
+      children: [
+        { name: "name", path: "hdr.name", kind: "str", value: ".text" },
+        { name: "size", path: "hdr.size", kind: "uint", value: 4096 },
+        {
+          name: "flags",
+          path: "hdr.flags",
+          kind: "struct",
+          children: [
+            { name: "read", path: "hdr.flags.read", kind: "bool", value: true },
+            {
+              name: "write",
+              path: "hdr.flags.write",
+              kind: "bool",
+              value: false,
+            },
+            { name: "exec", path: "hdr.flags.exec", kind: "bool", value: true },
+          ],
+        },
+      ],
+    };
+  }
+
+  it("substitutes fields and formats", () => {
+    const node = header();
+    const template = node.extensions!["-webide-representation"] as string;
+    expect(formatRepresentation(node, template)).toBe(
+      ".text s:4096 f:read|exec {nope}",
+    );
+  });
+
+  it("is used by formatValue for structs", () => {
+    expect(formatValue(header())).toBe(".text s:4096 f:read|exec {nope}");
+  });
+
+  it("supports hex and dotted paths", () => {
+    expect(formatRepresentation(header(), "{size:hex} {flags.read}")).toBe(
+      "0x1000 true",
+    );
+  });
+});
+
+describe("remainingItems", () => {
+  it("counts the elements not yet fetched", () => {
+    const node: TreeNode = {
+      name: "x",
+      path: "x",
+      kind: "array",
+      length: 3,
+      children: [{ name: "x", path: "x[0]" }],
+    };
+    expect(remainingItems(node)).toBe(2);
+  });
+
+  it("is zero for nodes without a length", () => {
+    expect(remainingItems(sampleTree())).toBe(0);
+  });
+});
+
+describe("appendItems", () => {
+  it("appends to the array at the path and shares the rest", () => {
+    const root = sampleTree();
+    const next = appendItems(root, "smoke.items", [
+      { name: "items[4]", path: "smoke.items[4]", kind: "uint", value: 5 },
+    ]);
+    expect(next).not.toBe(root);
+    expect(findNodeByPath(next, "smoke.items")?.children).toHaveLength(
+      (findNodeByPath(root, "smoke.items")?.children?.length ?? 0) + 1,
+    );
+    expect(findNodeByPath(next, "smoke.items[4]")?.value).toBe(5);
+    expect(next.children?.[0]).toBe(root.children?.[0]);
+  });
+
+  it("returns the tree unchanged for unknown paths", () => {
+    const root = sampleTree();
+    expect(appendItems(root, "smoke.nope", [])).toBe(root);
+  });
+});
@@ -11,6 +11,11 @@ export interface TreeNode {
   value?: unknown;
   range?: TreeRange;
   error?: string;
+  /** Vendor keys from the schema, e.g. `-webide-representation`. */
+  extensions?: Record<string, unknown>;
+  /** For arrays, the number of elements. `children` holds only the first
+   *  of them for large arrays; see `remainingItems`. */
+  length?: number;
   children?: TreeNode[];
 }
 
@@ -22,6 +27,38 @@ export function parseTreeJson(json: string): TreeNode | null {
   }
 }
 
+/**
+ * Returns how many elements of an array node have not been fetched yet.
+ * The worker sends only the first elements of a large array; the rest are
+ * fetched a page at a time with `EvalClient.items`.
+ */
+export function remainingItems(node: TreeNode): number {
+  if (node.length === undefined) return 0;
+  return Math.max(0, node.length - (node.children?.length ?? 0));
+}
+
+/**
+ * Returns a copy of `root` with `items` appended to the children of the
+ * array node at `path`. Nodes off the path to it are shared.
+ */
+export function appendItems(
+  root: TreeNode,
+  path: string,
+  items: TreeNode[],
+): TreeNode {
+  if (root.path === path) {
+    return { ...root, children: [...(root.children ?? []), ...items] };
+  }
+  if (!root.children) return root;
+  let changed = false;
+  const children = root.children.map((child) => {
+    const next = appendItems(child, path, items);
+    if (next !== child) changed = true;
+    return next;
+  });
+  return changed ? { ...root, children } : root;
+}
+
 /**
  * Depth-first search for a node by its `path`. Returns null if no match.
  */
@@ -78,10 +115,13 @@ export function ancestorsOf(root: TreeNode, targetPath: string): string[] {
 export function formatValue(node: TreeNode): string {
   if (node.error) return `error: ${node.error}`;
   if (node.kind === "array") {
-    const count = node.children?.length ?? 0;
+    const count = node.length ?? node.children?.length ?? 0;
     return `[${count} item${count === 1 ? "" : "s"}]`;
   }
-  if (node.kind === "struct") return "";
+  if (node.kind === "struct") {
+    const repr = node.extensions?.["-webide-representation"];
+    return typeof repr === "string" ? formatRepresentation(node, repr) : "";
+  }
   if (node.value === undefined) return "";
   switch (node.kind) {
     case "int":
@@ -126,3 +166,64 @@ export function formatValue(node: TreeNode): string {
       return String(node.value);
   }
 }
+
+/**
+ * Renders a `-webide-representation` template such as
+ * `{name} (o:{offset}, s:{size:dec})` against a struct node's fields.
+ * Placeholders that don't name a field are left as-is.
+ */
+export function formatRepresentation(
+  node: TreeNode,
+  template: string,
+): string {
+  return template.replace(
+    /\{([^{}:]+)(?::([^{}]*))?\}/g,
+    (match, path: string, format: string | undefined) => {
+      const field = findField(node, path.trim());
+      return field ? formatField(field, format?.trim()) : match;
+    },
+  );
+}
+
+function findField(node: TreeNode, path: string): TreeNode | null {
+  let cur: TreeNode | undefined = node;
+  for (const name of path.split(".")) {
+    cur = cur.children?.find((c) => c.name === name);
+    if (!cur) return null;
+  }
+  return cur;
+}
+
+function formatField(node: TreeNode, format: string | undefined): string {
+  if (node.error) return "?";
+  const isInt = node.kind === "int" || node.kind === "uint";
+  switch (format) {
+    case "dec":
+      if (isInt) return String(node.value);
+      break;
+    case "hex":
+      if (isInt && typeof node.value === "number") {
+        const n = node.value;
+        return `${n < 0 ? "-" : ""}0x${Math.abs(n).toString(16).toUpperCase()}`;
+      }
+      break;
+    case "flags":
+      // A struct of boolean instances, rendered as the names of the set ones.
+      if (node.kind === "struct") {
+        return (node.children ?? [])
+          .filter((c) => c.kind === "bool" && c.value === true)
+          .map((c) => c.name)
+          .join("|");
+      }
+      break;
+  }
+  switch (node.kind) {
+    case "str":
+      return String(node.value);
+    case "enum": {
+      const v = node.value as { int: number; label: string };
+      return v.label || String(v.int);
+    }
+  }
+  return formatValue(node);
+}
@@ -10,6 +10,7 @@ export function TreePanel(_props: IDockviewPanelProps) {
     loading,
     selectedTreePath,
     selectTreeNode,
+    loadMoreItems,
     reloadWorker,
   } = useWorkspace();
 
@@ -38,6 +39,7 @@ export function TreePanel(_props: IDockviewPanelProps) {
       root={tree}
       selectedPath={selectedTreePath}
       onSelect={selectTreeNode}
+      onLoadMore={(path) => void loadMoreItems(path)}
     />
   );
 }
@@ -105,6 +105,22 @@ describe("EvalClient", () => {
       await expect(p3).resolves.toBe("{tree3}");
     });
 
+    it("sends items requests and returns the page", async () => {
+      const { client, worker } = makeClient();
+      const p = client.items("records", 1000, 500);
+      expect(worker.sent[0]!.request).toEqual({
+        type: "items",
+        path: "records",
+        start: 1000,
+        count: 500,
+      });
+      worker.emit({
+        id: worker.sent[0]!.id,
+        response: { ok: true, items: "[]" },
+      });
+      await expect(p).resolves.toBe("[]");
+    });
+
     it("ignores stale or duplicate responses", async () => {
       const { client, worker } = makeClient();
       const p = client.loadKsys([{ name: "foo", source: "src" }]);
@@ -151,6 +167,13 @@ describe("EvalClient", () => {
       worker.emit({ id: worker.sent[0]!.id, response: { ok: true } });
       await expect(p).rejects.toThrow(/missing tree/);
     });
+
+    it("rejects items with a clear error if response omits items", async () => {
+      const { client, worker } = makeClient();
+      const p = client.items("x", 0, 10);
+      worker.emit({ id: worker.sent[0]!.id, response: { ok: true } });
+      await expect(p).rejects.toThrow(/missing items/);
+    });
   });
 
   describe("termination", () => {
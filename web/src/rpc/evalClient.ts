@@ -64,6 +64,18 @@ export class EvalClient {
     return r.tree;
   }
 
+  /** Fetches up to `count` elements of the array at `path` from `start`,
+   *  as a JSON array of tree nodes, for arrays that `parse` returned only
+   *  the first elements of. */
+  async items(path: string, start: number, count: number): Promise<string> {
+    const r = await this.call({ type: "items", path, start, count });
+    if (!r.ok) throw new Error(r.error);
+    if (r.items === undefined) {
+      throw new Error("items: response missing items");
+    }
+    return r.items;
+  }
+
   terminate(): void {
     if (this.terminated) return;
     this.terminated = true;
@@ -16,10 +16,19 @@ export interface ParseRequest {
   data: Uint8Array;
 }
 
-export type EvalRequest = LoadKsysRequest | ParseRequest;
+/** Fetches up to `count` elements of the array at `path`, from `start`, in
+ *  the tree built by the last parse. */
+export interface ItemsRequest {
+  type: "items";
+  path: string;
+  start: number;
+  count: number;
+}
+
+export type EvalRequest = LoadKsysRequest | ParseRequest | ItemsRequest;
 
 export type EvalResponse =
-  | { ok: true; tree?: string }
+  | { ok: true; tree?: string; items?: string }
   | { ok: false; error: string };
 
 export interface RpcRequest {
@@ -13,6 +13,7 @@ import { PieceTable } from "../buffer/pieceTable";
 import type { SelectionState } from "../components/hex/selection";
 import {
   type TreeNode,
+  appendItems,
   findDeepestNodeAtOffset,
   findNodeByPath,
   parseTreeJson,
@@ -27,6 +28,9 @@ const log = createLogger("workspace");
 
 const DEBOUNCE_MS = 250;
 
+/** How many array elements `loadMoreItems` fetches at a time. */
+const ITEMS_PAGE = 1000;
+
 export interface WorkspaceState {
   client: EvalClient;
   project: Project;
@@ -77,6 +81,9 @@ export interface WorkspaceState {
   setHexSelection: (s: SelectionState) => void;
   setHexSelectionFromPointer: (s: SelectionState) => void;
   selectTreeNode: (path: string) => void;
+  /** Fetch the next page of elements of the array at `path`, for arrays
+   *  the parse returned only the first elements of. */
+  loadMoreItems: (path: string) => Promise<void>;
 
   /** Tear down the current EvalClient (kills the worker) and spin up a
    *  fresh one. Use when the Go side has panicked or the worker has
@@ -368,6 +375,30 @@ export function WorkspaceProvider({
     [tree],
   );
 
+  const loadMoreItems = useCallback(
+    async (path: string) => {
+      if (!tree) return;
+      const node = findNodeByPath(tree, path);
+      if (!node) return;
+      const start = node.children?.length ?? 0;
+      try {
+        const json = await clientRef.current!.items(path, start, ITEMS_PAGE);
+        const items = JSON.parse(json) as TreeNode[];
+        setTree((prev) => {
+          // Drop the page if the tree was reparsed or paged meanwhile.
+          const current = prev && findNodeByPath(prev, path);
+          if (!prev || (current?.children?.length ?? 0) !== start) {
+            return prev;
+          }
+          return appendItems(prev, path, items);
+        });
+      } catch (err) {
+        log.error("loading items failed:", err);
+      }
+    },
+    [tree],
+  );
+
   const value = useMemo<WorkspaceState>(
     () => ({
       client: clientRef.current!,
@@ -392,6 +423,7 @@ export function WorkspaceProvider({
       setHexSelection,
       setHexSelectionFromPointer,
       selectTreeNode,
+      loadMoreItems,
       reloadWorker,
     }),
     [
@@ -416,6 +448,7 @@ export function WorkspaceProvider({
       setHexSelection,
       setHexSelectionFromPointer,
       selectTreeNode,
+      loadMoreItems,
       reloadWorker,
     ],
   );
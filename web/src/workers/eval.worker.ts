@@ -12,6 +12,7 @@ const log = createLogger("worker.boot");
 interface ZanbatoAPI {
   loadKsys: (files: KsyFile[]) => EvalResponse;
   parse: (rootName: string, data: Uint8Array) => EvalResponse;
+  items: (path: string, start: number, count: number) => EvalResponse;
 }
 
 declare const self: DedicatedWorkerGlobalScope;
@@ -48,6 +49,8 @@ function dispatch(req: EvalRequest): EvalResponse {
       return api.loadKsys(req.files);
     case "parse":
       return api.parse(req.rootName, req.data);
+    case "items":
+      return api.items(req.path, req.start, req.count);
   }
 }
 